@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// shutdown phases, lower ones stop first. Components of one phase stop
+// side by side, the next phase starts once all of them returned.
+const (
+	// stop taking requests and finish the ones in flight
+	phaseHTTP = iota
+	// publish or deliver what requests left in the outbox
+	phaseRelay
+	// sweepers, monitors and other loops
+	phaseWorkers
+	// pools and clients, nothing may use them anymore
+	phaseClose
+)
+
+type stopper struct {
+	phase int
+	name  string
+	stop  func(ctx context.Context) error
+}
+
+// lifecycle stops the components of the service in phase order within one
+// deadline
+type lifecycle struct {
+	ctx context.Context
+	log *slog.Logger
+
+	mu       sync.Mutex
+	stoppers []stopper
+}
+
+// newLifecycle runs workers on contexts derived from ctx
+func newLifecycle(ctx context.Context, log *slog.Logger) *lifecycle {
+	return &lifecycle{ctx: ctx, log: log}
+}
+
+// onStop registers stop to run in phase
+func (l *lifecycle) onStop(phase int, name string, stop func(ctx context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stoppers = append(l.stoppers, stopper{phase: phase, name: name, stop: stop})
+}
+
+// goWorker runs a blocking loop until its phase cancels it and waits for
+// it to return
+func (l *lifecycle) goWorker(phase int, name string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(l.ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+
+	l.onStop(phase, name, func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	})
+}
+
+// shutdown stops every registered component within timeout. When the
+// deadline passes it gives up and returns an error naming what did not stop.
+func (l *lifecycle) shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	l.mu.Lock()
+	stoppers := slices.Clone(l.stoppers)
+	l.mu.Unlock()
+	slices.SortStableFunc(stoppers, func(a, b stopper) int { return a.phase - b.phase })
+
+	var errs []error
+	for start := 0; start < len(stoppers); {
+		end := start
+		for end < len(stoppers) && stoppers[end].phase == stoppers[start].phase {
+			end++
+		}
+		pending, phaseErrs := l.stopPhase(ctx, stoppers[start:end])
+		errs = append(errs, phaseErrs...)
+
+		if ctx.Err() != nil {
+			for _, s := range stoppers[end:] {
+				pending = append(pending, s.name)
+			}
+			l.log.Error("shutdown deadline exceeded, exiting anyway",
+				"timeout", timeout.String(),
+				"not_stopped", pending)
+			return fmt.Errorf("shutdown did not finish within %s, not stopped: %v", timeout, pending)
+		}
+		start = end
+	}
+	return errors.Join(errs...)
+}
+
+// stopPhase returns the names of the components that did not return before
+// ctx expired and the errors of those that did
+func (l *lifecycle) stopPhase(ctx context.Context, phase []stopper) ([]string, []error) {
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(phase))
+	for _, s := range phase {
+		go func() {
+			start := time.Now()
+			err := s.stop(ctx)
+			l.log.Info("stopped", "component", s.name, "duration", time.Since(start).String(), "err", err)
+			results <- result{name: s.name, err: err}
+		}()
+	}
+
+	stopped := make(map[string]bool, len(phase))
+	var errs []error
+	for range phase {
+		select {
+		case r := <-results:
+			stopped[r.name] = true
+			if r.err != nil && !errors.Is(r.err, context.DeadlineExceeded) {
+				errs = append(errs, fmt.Errorf("stop %s: %w", r.name, r.err))
+			}
+		case <-ctx.Done():
+			var pending []string
+			for _, s := range phase {
+				if !stopped[s.name] {
+					pending = append(pending, s.name)
+				}
+			}
+			return pending, errs
+		}
+	}
+	return nil, errs
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// localDependencies backs the service with in-memory adapters so frontend
+// developers can run it without postgres, redis or migrations
+func localDependencies(logger *slog.Logger) (*dependencies, error) {
+	repo := memory.NewRepository()
+	kv := memory.NewKeyValueStore()
+
+	if err := seedDemoPayments(repo); err != nil {
+		return nil, fmt.Errorf("seed demo payments: %w", err)
+	}
+
+	logger.Warn("LOCAL MODE: in-memory storage, all payments are lost on exit; not for production use")
+
+	return &dependencies{
+		repo:        repo,
+		idempotency: kv,
+		nonces:      kv,
+		locker:      kv,
+	}, nil
+}
+
+func seedDemoPayments(repo domain.Repository) error {
+	demo := []struct {
+		orderID    string
+		customerID string
+		cents      int64
+		currency   string
+		// completed payments can be refunded locally
+		completed bool
+	}{
+		{"demo-order-1", "demo-customer-1", 1999, "EUR", false},
+		{"demo-order-2", "demo-customer-1", 4500, "USD", false},
+		{"demo-order-3", "demo-customer-2", 120000, "GBP", true},
+	}
+
+	for _, d := range demo {
+		amount, err := domain.NewMoney(d.cents, d.currency)
+		if err != nil {
+			return err
+		}
+		p, err := domain.New("", d.orderID, d.customerID, amount, "demo-"+d.orderID, "", "", domain.CaptureAutomatic, nil)
+		if err != nil {
+			return err
+		}
+		if err := repo.Save(context.Background(), p); err != nil {
+			return err
+		}
+		if !d.completed {
+			continue
+		}
+		if err := p.MarkProcessing("demo-ref-" + d.orderID); err != nil {
+			return err
+		}
+		if err := repo.Save(context.Background(), p); err != nil {
+			return err
+		}
+		if err := p.Complete(); err != nil {
+			return err
+		}
+		if err := repo.Save(context.Background(), p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/httpserver"
+)
+
+const providerSecret = "whsec_local_test"
+
+// localServer boots run in local mode on a free port and returns its base URL,
+// the server shuts down when the test ends
+func localServer(t *testing.T) string {
+	t.Helper()
+	// restored after the test, -local sets it for the process
+	t.Setenv("LOCAL_MODE", "false")
+	t.Setenv("ENV", "development")
+	t.Setenv("HTTP_ADDR", "127.0.0.1:0")
+	t.Setenv("HTTP_PRESTOP_DELAY", "0s")
+	t.Setenv("HTTP_SHUTDOWN_TIMEOUT", "5s")
+	t.Setenv("PROVIDER_WEBHOOK_SECRET", providerSecret)
+	t.Setenv("LOG_LEVEL", "warn")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addrs := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() { done <- run(ctx, []string{"-local"}, func(addr string) { addrs <- addr }) }()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("run: %v", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Error("run did not return after shutdown")
+		}
+	})
+
+	select {
+	case addr := <-addrs:
+		return "http://" + addr
+	case err := <-done:
+		t.Fatalf("run returned before serving: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("local mode did not start")
+	}
+	return ""
+}
+
+// call sends body as JSON and decodes the response into out when it is set
+func call(t *testing.T, method, url string, body any, header http.Header, out any) int {
+	t.Helper()
+	var payload io.Reader
+	if body != nil {
+		raw, ok := body.([]byte)
+		if !ok {
+			var err error
+			if raw, err = json.Marshal(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		payload = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, url, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil && len(raw) > 0 {
+		if err := json.Unmarshal(raw, out); err != nil {
+			t.Fatalf("%s %s answered %d with %q: %v", method, url, resp.StatusCode, raw, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// the payment lifecycle over real HTTP against the in-memory wiring: the mock
+// gateway authorizes, its webhook completes the payment, then a refund
+func TestLocalModeRoundTrip(t *testing.T) {
+	base := localServer(t)
+
+	var initiated struct {
+		PaymentID string `json:"payment_id"`
+		Status    string `json:"status"`
+	}
+	status := call(t, http.MethodPost, base+"/v1/payments", map[string]any{
+		"order_id":     "order-e2e",
+		"customer_id":  "cus-e2e",
+		"amount_cents": 2500,
+		"currency":     "EUR",
+	}, http.Header{"Idempotency-Key": {"idem-e2e"}}, &initiated)
+	if status != http.StatusCreated || initiated.Status != "PROCESSING" {
+		t.Fatalf("initiate answered %d with %+v, want 201 and PROCESSING", status, initiated)
+	}
+
+	// the gateway reports the charge, as its webhook would in production
+	event, err := json.Marshal(map[string]any{
+		"id":   "evt_e2e",
+		"type": "payment_intent.succeeded",
+		"data": map[string]any{"object": map[string]any{
+			"id":       "mock_" + initiated.PaymentID,
+			"metadata": map[string]string{"payment_id": initiated.PaymentID},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	signature := fmt.Sprintf("t=%d,v1=%s", now.Unix(), httpserver.SignProviderWebhook(providerSecret, event, now))
+	if status := call(t, http.MethodPost, base+"/v1/webhooks/provider", event,
+		http.Header{"Stripe-Signature": {signature}}, nil); status != http.StatusOK {
+		t.Fatalf("provider webhook answered %d", status)
+	}
+
+	var payment struct {
+		PaymentID   string `json:"payment_id"`
+		Status      string `json:"status"`
+		ProviderRef string `json:"provider_ref"`
+		AmountCents int64  `json:"amount_cents"`
+	}
+	if status := call(t, http.MethodGet, base+"/v1/payments/"+initiated.PaymentID, nil, nil, &payment); status != http.StatusOK {
+		t.Fatalf("get answered %d", status)
+	}
+	if payment.Status != "COMPLETED" || payment.ProviderRef != "mock_"+initiated.PaymentID || payment.AmountCents != 2500 {
+		t.Fatalf("payment = %+v, want COMPLETED for 2500 with the gateway's reference", payment)
+	}
+
+	var refund struct {
+		RefundID    string `json:"refund_id"`
+		PaymentID   string `json:"payment_id"`
+		AmountCents int64  `json:"amount_cents"`
+		Status      string `json:"status"`
+	}
+	status = call(t, http.MethodPost, base+"/v1/payments/"+initiated.PaymentID+"/refunds",
+		map[string]any{"amount_cents": 1000, "reason": "requested_by_customer"},
+		http.Header{"Idempotency-Key": {"refund-e2e"}}, &refund)
+	if status != http.StatusCreated || refund.PaymentID != initiated.PaymentID || refund.AmountCents != 1000 || refund.RefundID == "" {
+		t.Fatalf("refund answered %d with %+v, want 201 for 1000 of %s", status, refund, initiated.PaymentID)
+	}
+
+	var refunds struct {
+		Refunds []struct {
+			RefundID string `json:"refund_id"`
+		} `json:"refunds"`
+	}
+	if status := call(t, http.MethodGet, base+"/v1/payments/"+initiated.PaymentID+"/refunds", nil, nil, &refunds); status != http.StatusOK {
+		t.Fatalf("list refunds answered %d", status)
+	}
+	if len(refunds.Refunds) != 1 || refunds.Refunds[0].RefundID != refund.RefundID {
+		t.Fatalf("refunds = %+v, want %s alone", refunds, refund.RefundID)
+	}
+}
@@ -3,22 +3,29 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 
+	"github.com/ademajagon/gopay-service/internal/adapters/grpcserver"
 	"github.com/ademajagon/gopay-service/internal/adapters/httpserver"
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/adapters/outbox"
 	pgadapter "github.com/ademajagon/gopay-service/internal/adapters/postgres"
 	redisadapter "github.com/ademajagon/gopay-service/internal/adapters/redis"
+	"github.com/ademajagon/gopay-service/internal/adapters/stripe"
+	"github.com/ademajagon/gopay-service/internal/adapters/webhook"
 	"github.com/ademajagon/gopay-service/internal/app"
 	"github.com/ademajagon/gopay-service/internal/config"
+	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/logging"
 )
 
 var (
@@ -28,21 +35,36 @@ var (
 )
 
 func main() {
-	if err := run(); err != nil {
+	if err := run(context.Background(), os.Args[1:], nil); err != nil {
 		fmt.Fprintf(os.Stderr, "startup error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func run() error {
+// run serves until SIGINT or SIGTERM arrives or ctx is done, then shuts
+// down in order. ready, when set, gets the HTTP address once it is bound.
+func run(ctx context.Context, args []string, ready func(addr string)) error {
+	flags := flag.NewFlagSet("gopay", flag.ExitOnError)
+	local := flags.Bool("local", false, "run with in-memory storage and no postgres or redis (non-production only)")
+	migrateOnly := flags.Bool("migrate-only", false, "apply database migrations and exit, same as MIGRATE_MODE=only")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
 	_ = godotenv.Load()
+	if *local {
+		_ = os.Setenv("LOCAL_MODE", "true")
+	}
+	if *migrateOnly {
+		_ = os.Setenv("MIGRATE_MODE", config.MigrateOnly)
+	}
 
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("load config: %w", err)
 	}
 
-	logger := newLogger(cfg.IsProd())
+	logger, logLevel := newLogger(cfg)
 	logger.Info("gopay service starting",
 		"version", version,
 		"commit", commitSHA,
@@ -50,58 +72,65 @@ func run() error {
 		"env", cfg.Env,
 	)
 
-	// NewPool() calls pool.Ping() before returning, if the DB is unreachable,
-	ctx := context.Background()
-	pool, err := pgadapter.NewPool(ctx, pgadapter.PoolConfig{
-		DSN:               cfg.Database.DSN,
-		MaxConns:          cfg.Database.MaxConns,
-		MinConns:          cfg.Database.MinConns,
-		MaxConnLifetime:   cfg.Database.MaxConnLifeTime,
-		MaxConnIdleTime:   cfg.Database.MaxConnIdleTime,
-		HealthCheckPeriod: cfg.Database.HealthPeriod,
-	})
-
-	if err != nil {
-		return fmt.Errorf("connect to postgres: %w", err)
+	if flags.Arg(0) == "migrate" {
+		return runMigrateCommand(cfg, flags.Args()[1:], logger)
 	}
-	defer pool.Close()
-	slog.Info("postgres connected", "max_conns", cfg.Database.MaxConns)
-
-	if err := runMigrations(cfg.Database.DSN, cfg.Database.MigrationsPath, logger); err != nil {
-		return fmt.Errorf("run migrations: %w", err)
+	if cfg.Database.MigrateMode == config.MigrateOnly {
+		// a job ahead of the rollout, replicas then start with MIGRATE_MODE=skip
+		return runMigrations(cfg.Database.DSN, cfg.Database.MigrationsPath, logger)
 	}
 
-	redisClient := redisadapter.NewClient(redisadapter.Config{
-		Addr:     cfg.Redis.Addr,
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
-	})
-	defer redisClient.Close()
+	// workers started by lc also stop when run returns early, the end of ctx
+	// only starts the ordered shutdown below
+	workerCtx, stopWorkers := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopWorkers()
+	lc := newLifecycle(workerCtx, logger)
 
-	if err := redisadapter.Ping(ctx, redisClient); err != nil {
-		return fmt.Errorf("connect to redis: %w", err)
+	var deps *dependencies
+	if cfg.Local {
+		deps, err = localDependencies(logger)
+	} else {
+		deps, err = connectDependencies(ctx, lc, cfg, logger)
 	}
-	slog.Info("redis connected", "addr", cfg.Redis.Addr)
-
-	repo := pgadapter.NewRepository(pool)
-	idempotencyStore := redisadapter.NewIdempotencyStore(redisClient, cfg.Redis.Namespace, logger)
+	if err != nil {
+		return err
+	}
+	defer deps.close()
 
 	// app service wire
+	provider, err := newProvider(cfg)
+	if err != nil {
+		return err
+	}
 	svc := app.NewPaymentService(
-		repo,
-		idempotencyStore,
-		repo,
+		deps.repo,
+		deps.idempotency,
+		cfg.Idempotency.TTL,
+		deps.locker,
+		provider,
+		app.RequestLimits{
+			Currencies:     cfg.Payments.AllowedCurrencies,
+			MaxAmountCents: cfg.Payments.MaxAmountCents,
+
+			ClientReferenceNonSemantic: cfg.Payments.ClientReferenceNonSemantic,
+		},
+		app.DefaultMetrics,
 		logger,
 	)
 
-	// http handler and server
-	handler := httpserver.NewHandler(svc, logger)
-
-	checks := []httpserver.ReadinessCheck{
-		func(ctx context.Context) error { return pool.Ping(ctx) },
-		func(ctx context.Context) error { return redisadapter.Ping(ctx, redisClient) },
+	if cfg.Payments.ExpireAfter > 0 {
+		sweeper := app.NewExpirySweeper(deps.repo, app.ExpiryConfig{
+			After:     cfg.Payments.ExpireAfter,
+			Interval:  cfg.Payments.ExpirySweepInterval,
+			BatchSize: cfg.Payments.ExpiryBatchSize,
+		}, app.DefaultMetrics, logger)
+		lc.goWorker(phaseWorkers, "expiry-sweeper", sweeper.Run)
 	}
 
+	// http handler and server
+	deps.admin.LogLevel = logLevel
+	handler := httpserver.NewHandler(svc, deps.admin, deps.webhooks, cfg.HTTP.MaxBodyBytes, logger)
+
 	server := httpserver.NewServer(
 		httpserver.ServerConfig{
 			Addr:            cfg.HTTP.Addr,
@@ -109,77 +138,457 @@ func run() error {
 			WriteTimeout:    cfg.HTTP.WriteTimeout,
 			IdleTimeout:     cfg.HTTP.IdleTimeout,
 			ShutdownTimeout: cfg.HTTP.ShutdownTimeout,
+			AdminToken:      cfg.Admin.Token,
+			Signing: httpserver.SigningConfig{
+				Callers: cfg.Auth.SigningCallers,
+				Scopes:  cfg.Auth.SigningScopes,
+				Window:  cfg.Auth.SigningWindow,
+				Nonces:  deps.nonces,
+			},
+			ProviderWebhookSecret:    cfg.Provider.WebhookSecret,
+			ProviderWebhookTolerance: cfg.Provider.WebhookTolerance,
+
+			ReadHeaderTimeout:           cfg.HTTP.ReadHeaderTimeout,
+			MaxHeaderBytes:              cfg.HTTP.MaxHeaderBytes,
+			H2C:                         cfg.HTTP.H2CEnabled,
+			MaxConcurrentStreams:        cfg.HTTP.MaxConcurrentStreams,
+			DisableKeepAlivesOnShutdown: cfg.HTTP.DisableKeepAlivesOnShutdown,
+			PreStopDelay:                cfg.HTTP.PreStopDelay,
+			MetricsAddr:                 cfg.HTTP.MetricsAddr,
+			CORS: httpserver.CORSConfig{
+				AllowedOrigins: cfg.CORS.AllowedOrigins,
+				AllowedMethods: cfg.CORS.AllowedMethods,
+				AllowedHeaders: cfg.CORS.AllowedHeaders,
+				MaxAge:         cfg.CORS.MaxAge,
+			},
+			RequestTimeout:      cfg.HTTP.RequestTimeout,
+			PaymentsTimeout:     cfg.HTTP.PaymentsTimeout,
+			Compression:         cfg.HTTP.CompressionEnabled,
+			CompressionMinBytes: cfg.HTTP.CompressionMinBytes,
+			QuietPaths:          cfg.HTTP.QuietPaths,
+			DebugEndpoints:      cfg.HTTP.DebugEndpointsEnabled,
+			ReadinessTimeout:    cfg.Health.CheckTimeout,
+			ReadinessCacheTTL:   cfg.Health.CacheTTL,
+			Build: httpserver.BuildInfo{
+				Version:   version,
+				Commit:    commitSHA,
+				BuildTime: buildTime,
+			},
 		},
 		handler,
-		checks,
+		deps.checks,
 		logger,
 	)
 
-	errCh := make(chan error, 1)
+	httpAddr, err := server.Listen()
+	if err != nil {
+		return err
+	}
+	lc.onStop(phaseHTTP, "http", server.Shutdown)
+	var grpcServer *grpcserver.Server
+	if cfg.GRPC.Addr != "" {
+		grpcServer = grpcserver.NewServer(grpcserver.Config{
+			Addr:    cfg.GRPC.Addr,
+			APIKeys: deps.admin.APIKeys,
+		}, svc, logger)
+		// drains side by side with HTTP, before the relay and the pools
+		lc.onStop(phaseHTTP, "grpc", grpcServer.Shutdown)
+	}
+	// from here on the pools close on shutdown, after everything using them
+	deps.closeOn(lc)
+
+	errCh := make(chan error, 2)
 	go func() {
 		if err := server.Start(); err != nil {
 			errCh <- err
 		}
 	}()
+	if grpcServer != nil {
+		go func() {
+			if err := grpcServer.Start(); err != nil {
+				errCh <- err
+			}
+		}()
+	}
+
+	metricsAddr := cfg.HTTP.MetricsAddr
+	if metricsAddr == "" {
+		metricsAddr = httpAddr.String()
+	}
 
 	logger.Info("gopay service ready",
-		"addr", cfg.HTTP.Addr,
-		"metrics", cfg.HTTP.Addr+"/metrics",
-		"health", cfg.HTTP.Addr+"/healthz/ready")
+		"addr", httpAddr.String(),
+		"metrics", metricsAddr+"/metrics",
+		"health", httpAddr.String()+"/healthz/ready",
+		"grpc", cfg.GRPC.Addr)
+	if ready != nil {
+		ready(httpAddr.String())
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
 
+	var fatal error
 	select {
 	case sig := <-quit:
 		logger.Info("shutdown signal received", "signal", sig.String())
-	case err := <-errCh:
-		logger.Error("fatal server error", "err", err)
-		return err
+	case <-ctx.Done():
+		logger.Info("shutdown requested")
+	case fatal = <-errCh:
+		logger.Error("fatal server error", "err", fatal)
 	}
 
-	if err := server.Shutdown(context.Background()); err != nil {
+	// HTTP and gRPC, then the relay and dispatcher, then the other workers, then the
+	// pools, all within HTTP_SHUTDOWN_TIMEOUT
+	if err := lc.shutdown(cfg.HTTP.ShutdownTimeout); err != nil {
 		logger.Error("graceful shutdown error", "err", err)
-		return err
+		return errors.Join(fatal, err)
+	}
+	if fatal != nil {
+		return fatal
 	}
 
 	logger.Info("gopay service stopped")
 	return nil
 }
 
-func newLogger(prod bool) *slog.Logger {
-	opts := &slog.HandlerOptions{
-		AddSource: prod,
+// dependencies are the storage-backed adapters the service is built on
+type dependencies struct {
+	repo        domain.Repository
+	idempotency app.IdempotencyStore
+	locker      app.PaymentLocker // nil without redis
+	nonces      httpserver.NonceStore
+	admin       httpserver.AdminServices
+	webhooks    *app.WebhookService
+	checks      []httpserver.ReadinessCheck
+
+	closers []closer
+}
+
+type closer struct {
+	name  string
+	close func()
+}
+
+func (d *dependencies) addCloser(name string, close func()) {
+	d.closers = append(d.closers, closer{name: name, close: close})
+}
+
+// close releases resources in reverse order of acquisition
+func (d *dependencies) close() {
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		d.closers[i].close()
 	}
+	d.closers = nil
+}
 
-	var handler slog.Handler
-	if prod {
-		handler = slog.NewJSONHandler(os.Stdout, opts)
+// closeOn hands the closers to lc, they then run in its last phase and
+// close no longer does anything
+func (d *dependencies) closeOn(lc *lifecycle) {
+	for _, c := range d.closers {
+		lc.onStop(phaseClose, c.name, func(context.Context) error {
+			c.close()
+			return nil
+		})
+	}
+	d.closers = nil
+}
+
+// connectDependencies wires postgres and redis, runs migrations and starts
+// the storage-bound background workers on lc
+func connectDependencies(ctx context.Context, lc *lifecycle, cfg *config.Config, logger *slog.Logger) (deps *dependencies, err error) {
+	deps = &dependencies{}
+	defer func() {
+		if err != nil {
+			deps.close()
+		}
+	}()
+
+	// NewPool() calls pool.Ping() before returning, if the DB is unreachable,
+	pool, err := pgadapter.NewPool(ctx, pgadapter.PoolConfig{
+		DSN:               cfg.Database.DSN,
+		ApplicationName:   cfg.Database.ApplicationName,
+		MaxConns:          cfg.Database.MaxConns,
+		MinConns:          cfg.Database.MinConns,
+		MaxConnLifetime:   cfg.Database.MaxConnLifeTime,
+		MaxConnIdleTime:   cfg.Database.MaxConnIdleTime,
+		HealthCheckPeriod: cfg.Database.HealthPeriod,
+
+		SlowQueryThreshold: cfg.Database.SlowQueryThreshold,
+		Logger:             logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+	deps.addCloser("postgres", pool.Close)
+	slog.Info("postgres connected", "max_conns", cfg.Database.MaxConns)
+
+	// without a replica the repository reads from the primary
+	var readPool *pgxpool.Pool
+	if cfg.Database.ReadDSN != "" {
+		readPool, err = pgadapter.NewPool(ctx, pgadapter.PoolConfig{
+			DSN:               cfg.Database.ReadDSN,
+			ApplicationName:   cfg.Database.ApplicationName,
+			MaxConns:          cfg.Database.MaxConns,
+			MinConns:          cfg.Database.MinConns,
+			MaxConnLifetime:   cfg.Database.MaxConnLifeTime,
+			MaxConnIdleTime:   cfg.Database.MaxConnIdleTime,
+			HealthCheckPeriod: cfg.Database.HealthPeriod,
+
+			SlowQueryThreshold: cfg.Database.SlowQueryThreshold,
+			Logger:             logger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("connect to postgres replica: %w", err)
+		}
+		deps.addCloser("postgres-replica", readPool.Close)
+		slog.Info("postgres replica connected", "max_conns", cfg.Database.MaxConns)
+	}
+
+	if cfg.Database.MigrateMode == config.MigrateAuto {
+		err = runMigrations(cfg.Database.DSN, cfg.Database.MigrationsPath, logger)
 	} else {
-		opts.Level = slog.LevelDebug
-		handler = slog.NewTextHandler(os.Stdout, opts)
+		err = checkMigrationsClean(cfg.Database.DSN, cfg.Database.MigrationsPath, logger)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("run migrations: %w", err)
 	}
 
-	logger := slog.New(handler)
-	slog.SetDefault(logger)
-	return logger
+	failover := pgadapter.NewFailoverDetector(pool, pgadapter.FailoverConfig{
+		Threshold: cfg.Database.FailoverThreshold,
+		Window:    cfg.Database.FailoverWindow,
+		Cooldown:  cfg.Database.FailoverCooldown,
+	}, logger)
+
+	repo := pgadapter.NewRepository(pool, readPool, failover, pgadapter.QueryTimeouts{
+		Read:  cfg.Database.QueryTimeout,
+		Write: cfg.Database.WriteTimeout,
+	})
+
+	severity := func(dependency string) httpserver.Severity {
+		if cfg.Health.IsDegradedOnly(dependency) {
+			return httpserver.SeverityDegraded
+		}
+		return httpserver.SeverityCritical
+	}
+
+	// without redis the payment row answers every replay
+	var idempotencyStore memory.IdempotencyStore = app.NoIdempotencyCache{}
+	if cfg.Redis.Enabled {
+		redisClient, err := redisadapter.NewClient(redisadapter.Config{
+			Mode:         cfg.Redis.Mode,
+			Addrs:        cfg.Redis.Addresses(),
+			MasterName:   cfg.Redis.MasterName,
+			Password:     cfg.Redis.Password,
+			DB:           cfg.Redis.DB,
+			TLS:          cfg.Redis.TLSEnabled,
+			TLSCAFile:    cfg.Redis.TLSCAFile,
+			TLSInsecure:  cfg.Redis.TLSInsecureSkipVerify,
+			PoolSize:     cfg.Redis.PoolSize,
+			MinIdleConns: cfg.Redis.MinIdleConns,
+			MaxRetries:   cfg.Redis.MaxRetries,
+			DialTimeout:  cfg.Redis.DialTimeout,
+			ReadTimeout:  cfg.Redis.ReadTimeout,
+			WriteTimeout: cfg.Redis.WriteTimeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create redis client: %w", err)
+		}
+		deps.addCloser("redis", func() { _ = redisClient.Close() })
+
+		if err := redisadapter.Ping(ctx, redisClient); err != nil {
+			return nil, fmt.Errorf("connect to redis: %w", err)
+		}
+		slog.Info("redis connected", "mode", cfg.Redis.Mode, "addrs", cfg.Redis.Addresses())
+
+		breaker := redisadapter.NewBreaker(redisadapter.BreakerConfig{
+			Threshold: cfg.Redis.BreakerThreshold,
+			Cooldown:  cfg.Redis.BreakerCooldown,
+		}, logger)
+		idempotencyStore = redisadapter.NewIdempotencyStore(redisClient, cfg.Redis.Namespace, breaker, logger)
+		if cfg.Redis.LocalCacheSize > 0 {
+			idempotencyStore = memory.NewLRUIdempotencyStore(idempotencyStore, cfg.Redis.LocalCacheSize, cfg.Redis.LocalCacheTTL)
+		}
+
+		deps.nonces = redisadapter.NewNonceStore(redisClient, cfg.Redis.Namespace)
+		deps.locker = redisadapter.NewLocker(redisClient, cfg.Redis.Namespace, breaker)
+		deps.checks = append(deps.checks, httpserver.ReadinessCheck{
+			Name:     "redis",
+			Severity: severity("redis"),
+			Check:    func(ctx context.Context) error { return redisadapter.Ping(ctx, redisClient) },
+		})
+	} else {
+		slog.Warn("redis disabled, idempotency replays are answered from postgres")
+	}
+
+	watchdog := pgadapter.NewWatchdog(pool, repo, pgadapter.WatchdogConfig{
+		Interval:      cfg.Database.WatchdogInterval,
+		WarnAfter:     cfg.Database.TxWarnAfter,
+		CancelAfter:   cfg.Database.TxCancelAfter,
+		IdleInTxAfter: cfg.Database.IdleInTxAfter,
+	}, logger)
+	lc.goWorker(phaseWorkers, "watchdog", watchdog.Run)
+
+	var erasure *app.ErasureService
+	if cfg.Privacy.ErasureKey != "" {
+		erasure = app.NewErasureService(repo, idempotencyStore, cfg.Privacy.ErasureKey, cfg.Privacy.PIIMetadataKeys, cfg.Privacy.ErasureBatchSize, logger)
+	}
+
+	backfill := app.NewBackfillService(repo, cfg.Backfill.BatchSize, cfg.Backfill.Rate, logger)
+	lc.goWorker(phaseWorkers, "backfill", backfill.Run)
+
+	outboxMonitor := pgadapter.NewOutboxMonitor(pool, pgadapter.OutboxMonitorConfig{
+		Interval:     cfg.Outbox.MonitorInterval,
+		LagThreshold: cfg.Outbox.LagThreshold,
+	}, logger)
+	lc.goWorker(phaseWorkers, "outbox-monitor", outboxMonitor.Run)
+
+	if cfg.Outbox.Retention > 0 {
+		retention := pgadapter.NewOutboxRetention(pool, pgadapter.OutboxRetentionConfig{
+			Retention: cfg.Outbox.Retention,
+			Interval:  cfg.Outbox.RetentionInterval,
+			BatchSize: cfg.Outbox.RetentionBatchSize,
+			Pause:     cfg.Outbox.RetentionPause,
+		}, logger)
+		lc.goWorker(phaseWorkers, "outbox-retention", retention.Run)
+	}
+
+	if cfg.Outbox.RelayEnabled {
+		publisher, topic, err := newPublisher(cfg, deps)
+		if err != nil {
+			return nil, err
+		}
+		relayCfg := outbox.RelayConfig{
+			Topic:        topic,
+			BatchSize:    cfg.Outbox.BatchSize,
+			PollInterval: cfg.Outbox.PollInterval,
+			MaxBackoff:   cfg.Outbox.MaxBackoff,
+			MaxAttempts:  cfg.Outbox.MaxAttempts,
+		}
+		if cfg.Outbox.NotifyEnabled {
+			relayCfg.NotifyChannel = pgadapter.OutboxNotifyChannel
+		}
+		relay := outbox.NewRelay(pool, publisher, relayCfg, logger)
+
+		lc.goWorker(phaseRelay, "outbox-relay", relay.Run)
+		logger.Info("outbox relay started",
+			"publisher", cfg.Outbox.Publisher,
+			"topic", topic,
+			"batch_size", cfg.Outbox.BatchSize)
+	}
+
+	if cfg.Webhooks.DeliveryEnabled {
+		dispatcher := webhook.NewDispatcher(pool, webhook.Config{
+			BatchSize:    cfg.Webhooks.BatchSize,
+			PollInterval: cfg.Webhooks.PollInterval,
+			Timeout:      cfg.Webhooks.Timeout,
+			MaxAttempts:  cfg.Webhooks.MaxAttempts,
+			MinBackoff:   cfg.Webhooks.MinBackoff,
+			MaxBackoff:   cfg.Webhooks.MaxBackoff,
+		}, logger)
+
+		lc.goWorker(phaseRelay, "webhook-dispatcher", dispatcher.Run)
+	}
+
+	deps.repo = repo
+	deps.idempotency = idempotencyStore
+	deps.webhooks = app.NewWebhookService(repo, cfg.Webhooks.AllowHTTP, logger)
+	settlement := app.NewSettlementService(repo, map[string]app.SettlementParser{
+		"generic": app.NewGenericSettlementParser(),
+	}, logger)
+
+	deps.admin = httpserver.AdminServices{
+		Erasure:    erasure,
+		Backfill:   backfill,
+		Settlement: settlement,
+	}
+	if cfg.Auth.APIKeysEnabled {
+		deps.admin.APIKeys = app.NewAPIKeyService(repo, logger)
+	}
+	deps.checks = append([]httpserver.ReadinessCheck{
+		{
+			Name:     "postgres",
+			Severity: severity("postgres"),
+			Check: func(ctx context.Context) error {
+				if err := failover.Ready(ctx); err != nil {
+					return err
+				}
+				return pool.Ping(ctx)
+			},
+		},
+		{
+			Name:     "outbox",
+			Severity: severity("outbox"),
+			Check:    outboxMonitor.Ready,
+		},
+	}, deps.checks...)
+	if readPool != nil {
+		deps.checks = append(deps.checks, httpserver.ReadinessCheck{
+			Name:     "postgres-replica",
+			Severity: severity("postgres-replica"),
+			Check:    readPool.Ping,
+		})
+	}
+	return deps, nil
 }
 
-func runMigrations(dsn, migrationsPath string, log *slog.Logger) error {
-	log.Info("running database migrations", "path", migrationsPath, "dsn", dsn)
+// newPublisher builds the broker adapter named by OUTBOX_PUBLISHER and the
+// topic it publishes to
+func newPublisher(cfg *config.Config, deps *dependencies) (outbox.Publisher, string, error) {
+	switch cfg.Outbox.Publisher {
+	case "nats":
+		publisher, err := outbox.NewNATSPublisher(cfg.NATS.URL, cfg.Database.ApplicationName)
+		if err != nil {
+			return nil, "", err
+		}
+		deps.addCloser("publisher", publisher.Close)
+		return publisher, cfg.NATS.Subject, nil
+	default:
+		publisher, err := outbox.NewKafkaPublisher(cfg.Kafka.Brokers, cfg.Database.ApplicationName, cfg.Kafka.PublishTimeout)
+		if err != nil {
+			return nil, "", fmt.Errorf("create kafka publisher: %w", err)
+		}
+		deps.addCloser("publisher", publisher.Close)
+		return publisher, cfg.Kafka.Topic, nil
+	}
+}
 
-	m, err := migrate.New(migrationsPath, dsn)
-	if err != nil {
-		return fmt.Errorf("init migrate: %w", err)
+// newProvider builds the payment gateway adapter named by PAYMENT_PROVIDER
+func newProvider(cfg *config.Config) (app.PaymentProvider, error) {
+	if cfg.Provider.Name == "stripe" {
+		client, err := stripe.NewClient(stripe.Config{
+			BaseURL:    cfg.Provider.Stripe.BaseURL,
+			APIKey:     cfg.Provider.Stripe.APIKey,
+			Timeout:    cfg.Provider.Stripe.Timeout,
+			MaxRetries: cfg.Provider.Stripe.MaxRetries,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create stripe client: %w", err)
+		}
+		return stripe.NewProvider(client), nil
 	}
-	defer func() {
-		srcErr, dbErr := m.Close()
-		log.Info("migrate closed", "source_err", srcErr, "db_err", dbErr)
-	}()
 
-	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		return fmt.Errorf("migrate up: %w", err)
+	return mockprovider.New(cfg.Provider.MockTimeout), nil
+}
+
+// newLogger also returns the level so it can be changed while running
+func newLogger(cfg *config.Config) (*slog.Logger, *slog.LevelVar) {
+	level := new(slog.LevelVar)
+	level.Set(cfg.LogLevel())
+	opts := &slog.HandlerOptions{
+		AddSource: cfg.IsProd(),
+		Level:     level,
 	}
 
-	return nil
+	var handler slog.Handler
+	if cfg.LogFormat() == config.LogFormatJSON {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+
+	logger := slog.New(logging.NewContextHandler(handler))
+	slog.SetDefault(logger)
+	return logger, level
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+
+	"github.com/ademajagon/gopay-service/internal/config"
+	"github.com/ademajagon/gopay-service/migrations"
+)
+
+const migrateUsage = "usage: migrate version | up [n] | down [n] | force <version>"
+
+// runMigrations reads migrationsPath when set and the embedded migrations
+// otherwise. A dirty database stops the start, someone has to look at the
+// half-applied migration and force its version first.
+func runMigrations(dsn, migrationsPath string, log *slog.Logger) error {
+	m, err := newMigrate(dsn, migrationsPath, log)
+	if err != nil {
+		return fmt.Errorf("init migrate: %w", err)
+	}
+	defer closeMigrate(m, log)
+
+	if err := checkClean(m); err != nil {
+		return err
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("migrate up: %w", err)
+	}
+
+	return nil
+}
+
+// checkMigrationsClean keeps the API from starting on a dirty database when
+// migrations are left to a job
+func checkMigrationsClean(dsn, migrationsPath string, log *slog.Logger) error {
+	m, err := newMigrate(dsn, migrationsPath, log)
+	if err != nil {
+		return fmt.Errorf("init migrate: %w", err)
+	}
+	defer closeMigrate(m, log)
+
+	return checkClean(m)
+}
+
+func checkClean(m *migrate.Migrate) error {
+	version, dirty, err := m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		return nil
+	case err != nil:
+		return fmt.Errorf("read migration version: %w", err)
+	case dirty:
+		return fmt.Errorf("database is dirty at migration %d, repair it and run `migrate force <version>`", version)
+	default:
+		return nil
+	}
+}
+
+// runMigrateCommand runs `migrate <command>` against the configured
+// database and prints the resulting version
+func runMigrateCommand(cfg *config.Config, args []string, log *slog.Logger) error {
+	if cfg.Local {
+		return errors.New("migrate needs postgres, it cannot run in local mode")
+	}
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	m, err := newMigrate(cfg.Database.DSN, cfg.Database.MigrationsPath, log)
+	if err != nil {
+		return fmt.Errorf("init migrate: %w", err)
+	}
+	defer closeMigrate(m, log)
+
+	switch cmd, rest := args[0], args[1:]; cmd {
+	case "version":
+		if len(rest) != 0 {
+			return errors.New(migrateUsage)
+		}
+	case "up":
+		n, err := stepsArg(rest, 0)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			err = m.Up()
+		} else {
+			err = m.Steps(n)
+		}
+		if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("migrate up: %w", err)
+		}
+	case "down":
+		// one step unless told otherwise, rolling everything back is never a default
+		n, err := stepsArg(rest, 1)
+		if err != nil {
+			return err
+		}
+		if err := m.Steps(-n); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("migrate down: %w", err)
+		}
+	case "force":
+		if len(rest) != 1 {
+			return errors.New(migrateUsage)
+		}
+		v, err := strconv.Atoi(rest[0])
+		if err != nil || v < -1 {
+			return fmt.Errorf("force needs a migration version, got %q", rest[0])
+		}
+		if err := m.Force(v); err != nil {
+			return fmt.Errorf("migrate force: %w", err)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return printVersion(m)
+}
+
+func stepsArg(args []string, fallback int) (int, error) {
+	switch len(args) {
+	case 0:
+		return fallback, nil
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("steps must be a positive number, got %q", args[0])
+		}
+		return n, nil
+	default:
+		return 0, errors.New(migrateUsage)
+	}
+}
+
+func printVersion(m *migrate.Migrate) error {
+	version, dirty, err := m.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		fmt.Println("no migration applied")
+		return nil
+	case err != nil:
+		return fmt.Errorf("read migration version: %w", err)
+	}
+
+	fmt.Printf("version %d\n", version)
+	if dirty {
+		fmt.Fprintf(os.Stderr, "WARNING: migration %d is dirty, it failed half-way. Repair the schema, then run `migrate force <version>` with the version it is really at.\n", version)
+		return fmt.Errorf("database is dirty at migration %d", version)
+	}
+	return nil
+}
+
+func newMigrate(dsn, migrationsPath string, log *slog.Logger) (*migrate.Migrate, error) {
+	if migrationsPath != "" {
+		log.Info("loading database migrations", "path", migrationsPath)
+		return migrate.New(migrationsPath, dsn)
+	}
+
+	log.Info("loading database migrations", "path", "embedded")
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewWithSourceInstance("iofs", src, dsn)
+}
+
+func closeMigrate(m *migrate.Migrate, log *slog.Logger) {
+	srcErr, dbErr := m.Close()
+	log.Info("migrate closed", "source_err", srcErr, "db_err", dbErr)
+}
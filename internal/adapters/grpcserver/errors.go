@@ -0,0 +1,74 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// toStatus is mapError of the HTTP handler in gRPC codes. Validation errors
+// carry a BadRequest detail naming every rejected field.
+func (s *paymentServer) toStatus(ctx context.Context, err error) error {
+	var (
+		verr  *app.ValidationError
+		stale *domain.VersionConflictError
+	)
+	switch {
+	case errors.As(err, &verr):
+		violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(verr.Fields))
+		for _, f := range verr.Fields {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: f.Field, Description: f.Message})
+		}
+		return withDetails(codes.InvalidArgument, "validation failed", &errdetails.BadRequest{FieldViolations: violations})
+	case errors.Is(err, app.ErrInvalidRequest):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, domain.ErrInvalidCursor):
+		return status.Error(codes.InvalidArgument, "page_token is invalid, restart from the first page")
+	case errors.Is(err, domain.ErrNotFound):
+		return status.Error(codes.NotFound, "payment not found")
+	case errors.As(err, &stale):
+		return status.Error(codes.Aborted, fmt.Sprintf("payment is at version %d, not %d, fetch it and decide again", stale.Current, stale.Expected))
+	case errors.Is(err, domain.ErrVersionConflict):
+		return status.Error(codes.Aborted, "concurrent modification, please retry")
+	case errors.Is(err, app.ErrIdempotencyKeyInFlight):
+		return status.Error(codes.Aborted, "a request with this idempotency key is in progress, please retry")
+	case errors.Is(err, app.ErrIdempotencyKeyReused):
+		return status.Error(codes.FailedPrecondition, "idempotency key was already used with a different request")
+	case errors.Is(err, domain.ErrAlreadyCaptured):
+		return status.Error(codes.FailedPrecondition, "payment was already captured with a different idempotency key")
+	case errors.Is(err, app.ErrProviderDeclined):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, domain.ErrInvalidTransition):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, app.ErrProviderUnavailable):
+		return status.Error(codes.Unavailable, "payment provider unavailable, retry with the same idempotency key")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "the request timed out, retry with the same idempotency key")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "the request was cancelled")
+	default:
+		s.log.ErrorContext(ctx, "unhandled error in gRPC handler", "err", err)
+		return status.Error(codes.Internal, "an unexpected error occurred")
+	}
+}
+
+func invalidArgument(field, message string) error {
+	return withDetails(codes.InvalidArgument, "validation failed", &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: field, Description: message}},
+	})
+}
+
+func withDetails(code codes.Code, message string, details *errdetails.BadRequest) error {
+	st, err := status.New(code, message).WithDetails(details)
+	if err != nil {
+		return status.Error(code, message)
+	}
+	return st.Err()
+}
@@ -0,0 +1,131 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	gopayv1 "github.com/ademajagon/gopay-service/api/gopay/v1"
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/logging"
+)
+
+// metadata keys, lower case as gRPC delivers them
+const (
+	mdIdempotencyKey      = "idempotency-key"
+	mdIdempotencyReplayed = "idempotency-replayed"
+	mdRequestID           = "x-request-id"
+	mdAPIKey              = "x-api-key"
+	mdAuthorization       = "authorization"
+)
+
+// methodScopes is the scope each PaymentService method needs, methods of
+// the health and reflection services need none
+var methodScopes = map[string]string{
+	gopayv1.PaymentService_InitiatePayment_FullMethodName: app.ScopePaymentsWrite,
+	gopayv1.PaymentService_GetPayment_FullMethodName:      app.ScopePaymentsRead,
+	gopayv1.PaymentService_ListPayments_FullMethodName:    app.ScopePaymentsRead,
+	gopayv1.PaymentService_CapturePayment_FullMethodName:  app.ScopePaymentsWrite,
+	gopayv1.PaymentService_CancelPayment_FullMethodName:   app.ScopePaymentsWrite,
+}
+
+func incoming(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// recoverer turns a handler panic into INTERNAL and logs the stack
+func recoverer(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.ErrorContext(ctx, "handler panicked",
+					"panic", fmt.Sprint(rec),
+					"method", info.FullMethod,
+					"stack", string(debug.Stack()))
+				err = status.Error(codes.Internal, "an unexpected error occurred")
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// requestLogger adopts the caller's x-request-id or makes one up, so log
+// records of the call carry it as they do for HTTP requests
+func requestLogger(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		requestID := incoming(ctx, mdRequestID)
+		if requestID == "" {
+			requestID = uuid.NewString()
+		}
+		ctx = logging.WithRequestID(ctx, requestID)
+
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+		// health checks poll, only failures are worth an info record
+		level := slog.LevelInfo
+		if _, api := methodScopes[info.FullMethod]; !api && code == codes.OK {
+			level = slog.LevelDebug
+		}
+		log.Log(ctx, level, "grpc request",
+			"method", info.FullMethod,
+			"code", code.String(),
+			"duration", time.Since(start).Milliseconds())
+		return resp, err
+	}
+}
+
+// authenticate checks the API key of PaymentService calls and enforces the
+// method's scope, like authenticate and requireScope of the HTTP API. There
+// is no unauthenticated mode, without keys every call is turned away.
+func authenticate(keys *app.APIKeyService, log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		scope, api := methodScopes[info.FullMethod]
+		if !api {
+			return handler(ctx, req)
+		}
+
+		key := presentedAPIKey(ctx)
+		if keys == nil || key == "" {
+			return nil, status.Error(codes.Unauthenticated, "request is not authenticated")
+		}
+		principal, err := keys.Authenticate(ctx, key)
+		if errors.Is(err, app.ErrInvalidAPIKey) {
+			return nil, status.Error(codes.Unauthenticated, "invalid api key")
+		}
+		if err != nil {
+			log.ErrorContext(ctx, "cannot verify api key", "err", err)
+			return nil, status.Error(codes.Unavailable, "cannot verify api key, please retry")
+		}
+		if !principal.HasScope(scope) {
+			return nil, status.Error(codes.PermissionDenied, "missing scope "+scope)
+		}
+		return handler(app.WithPrincipal(ctx, principal), req)
+	}
+}
+
+// presentedAPIKey reads the key from x-api-key or an authorization bearer
+func presentedAPIKey(ctx context.Context) string {
+	if key := incoming(ctx, mdAPIKey); key != "" {
+		return key
+	}
+	scheme, token, ok := strings.Cut(incoming(ctx, mdAuthorization), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
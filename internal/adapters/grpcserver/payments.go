@@ -0,0 +1,137 @@
+package grpcserver
+
+import (
+	"context"
+	"log/slog"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	gopayv1 "github.com/ademajagon/gopay-service/api/gopay/v1"
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// paymentServer maps gopay.v1 messages onto the application service, the
+// way the HTTP handlers map JSON bodies
+type paymentServer struct {
+	gopayv1.UnimplementedPaymentServiceServer
+
+	svc *app.PaymentService
+	log *slog.Logger
+}
+
+func (s *paymentServer) InitiatePayment(ctx context.Context, req *gopayv1.InitiatePaymentRequest) (*gopayv1.InitiatePaymentResponse, error) {
+	// a malformed correlation id is dropped as over HTTP, a fresh one is generated
+	correlationID := req.GetCorrelationId()
+	if domain.ValidateCorrelationID(correlationID) != nil {
+		correlationID = ""
+	}
+
+	result, err := s.svc.InitiatePayment(ctx, app.InitiatePaymentRequest{
+		OrderID:         req.GetOrderId(),
+		CustomerID:      req.GetCustomerId(),
+		AmountCents:     req.GetAmountCents(),
+		Currency:        req.GetCurrency(),
+		IdempotencyKey:  incoming(ctx, mdIdempotencyKey),
+		ClientReference: req.GetClientReference(),
+		CorrelationID:   correlationID,
+		CaptureMethod:   req.GetCaptureMethod(),
+		Metadata:        req.GetMetadata(),
+	})
+	if err != nil {
+		return nil, s.toStatus(ctx, err)
+	}
+
+	if result.Replayed {
+		_ = grpc.SetHeader(ctx, metadata.Pairs(mdIdempotencyReplayed, "true"))
+	}
+	return &gopayv1.InitiatePaymentResponse{
+		PaymentId:     result.PaymentID,
+		Status:        result.Status,
+		CorrelationId: result.CorrelationID,
+	}, nil
+}
+
+func (s *paymentServer) GetPayment(ctx context.Context, req *gopayv1.GetPaymentRequest) (*gopayv1.Payment, error) {
+	result, err := s.svc.GetPayment(ctx, req.GetPaymentId())
+	if err != nil {
+		return nil, s.toStatus(ctx, err)
+	}
+	return toPayment(result), nil
+}
+
+func (s *paymentServer) ListPayments(ctx context.Context, req *gopayv1.ListPaymentsRequest) (*gopayv1.ListPaymentsResponse, error) {
+	list := app.ListPaymentsRequest{
+		CustomerID: req.GetCustomerId(),
+		Status:     req.GetStatus(),
+		Currency:   req.GetCurrency(),
+		Cursor:     req.GetPageToken(),
+		Limit:      int(req.GetPageSize()),
+	}
+	if req.GetPageSize() < 0 {
+		return nil, invalidArgument("page_size", "must not be negative")
+	}
+	if req.CreatedAfter != nil {
+		list.CreatedAfter = req.GetCreatedAfter().AsTime()
+	}
+	if req.CreatedBefore != nil {
+		list.CreatedBefore = req.GetCreatedBefore().AsTime()
+	}
+
+	result, err := s.svc.ListPayments(ctx, list)
+	if err != nil {
+		return nil, s.toStatus(ctx, err)
+	}
+
+	resp := &gopayv1.ListPaymentsResponse{
+		Payments:      make([]*gopayv1.Payment, 0, len(result.Payments)),
+		NextPageToken: result.NextCursor,
+	}
+	for _, p := range result.Payments {
+		resp.Payments = append(resp.Payments, toPayment(p))
+	}
+	return resp, nil
+}
+
+func (s *paymentServer) CapturePayment(ctx context.Context, req *gopayv1.CapturePaymentRequest) (*gopayv1.CapturePaymentResponse, error) {
+	if req.GetExpectedVersion() < 0 {
+		return nil, invalidArgument("expected_version", "must be positive")
+	}
+	result, err := s.svc.CapturePayment(ctx, req.GetPaymentId(), incoming(ctx, mdIdempotencyKey), int(req.GetExpectedVersion()))
+	if err != nil {
+		return nil, s.toStatus(ctx, err)
+	}
+	return &gopayv1.CapturePaymentResponse{PaymentId: result.PaymentID, Status: result.Status}, nil
+}
+
+func (s *paymentServer) CancelPayment(ctx context.Context, req *gopayv1.CancelPaymentRequest) (*gopayv1.CancelPaymentResponse, error) {
+	if req.GetExpectedVersion() < 0 {
+		return nil, invalidArgument("expected_version", "must be positive")
+	}
+	result, err := s.svc.CancelPayment(ctx, req.GetPaymentId(), req.GetReason(), int(req.GetExpectedVersion()))
+	if err != nil {
+		return nil, s.toStatus(ctx, err)
+	}
+	return &gopayv1.CancelPaymentResponse{PaymentId: result.PaymentID, Status: result.Status}, nil
+}
+
+func toPayment(p app.PaymentDetails) *gopayv1.Payment {
+	return &gopayv1.Payment{
+		PaymentId:       p.PaymentID,
+		OrderId:         p.OrderID,
+		CustomerId:      p.CustomerID,
+		AmountCents:     p.AmountCents,
+		Currency:        p.Currency,
+		Status:          p.Status,
+		ProviderRef:     p.ProviderRef,
+		FailureReason:   p.FailureReason,
+		ClientReference: p.ClientReference,
+		CorrelationId:   p.CorrelationID,
+		Metadata:        p.Metadata,
+		CreatedAt:       timestamppb.New(p.CreatedAt),
+		UpdatedAt:       timestamppb.New(p.UpdatedAt),
+		Version:         int64(p.Version),
+	}
+}
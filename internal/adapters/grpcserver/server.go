@@ -0,0 +1,84 @@
+// Package grpcserver serves gopay.v1.PaymentService on the application
+// service the HTTP API uses, with the gRPC health and reflection services
+// next to it.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	gopayv1 "github.com/ademajagon/gopay-service/api/gopay/v1"
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+type Config struct {
+	Addr string
+	// APIKeys authenticates PaymentService calls, gRPC callers cannot sign
+	// requests so every call needs a key. Calls are all rejected when nil.
+	APIKeys *app.APIKeyService
+}
+
+// Server wraps *grpc.Server with the graceful shutdown of the HTTP server
+type Server struct {
+	addr   string
+	inner  *grpc.Server
+	health *health.Server
+	log    *slog.Logger
+}
+
+func NewServer(cfg Config, svc *app.PaymentService, log *slog.Logger) *Server {
+	inner := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		recoverer(log),
+		requestLogger(log),
+		authenticate(cfg.APIKeys, log),
+	))
+	gopayv1.RegisterPaymentServiceServer(inner, &paymentServer{svc: svc, log: log})
+
+	hs := health.NewServer()
+	hs.SetServingStatus(gopayv1.PaymentService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(inner, hs)
+	reflection.Register(inner)
+
+	return &Server{addr: cfg.Addr, inner: inner, health: hs, log: log}
+}
+
+// Start serves until Shutdown
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("grpc listener: %w", err)
+	}
+	s.log.Info("gRPC server listening", "addr", s.addr)
+	if err := s.inner.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return fmt.Errorf("grpc server: %w", err)
+	}
+	return nil
+}
+
+// Shutdown reports NOT_SERVING to health checks and waits for in-flight
+// calls. Calls still running when ctx expires are cancelled.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.health.Shutdown()
+	s.log.Info("gRPC server shutting down gracefully")
+
+	done := make(chan struct{})
+	go func() {
+		s.inner.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.inner.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,288 @@
+package grpcserver
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	gopayv1 "github.com/ademajagon/gopay-service/api/gopay/v1"
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// apiKeyStore keeps API keys in memory
+type apiKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]app.APIKey
+}
+
+func (s *apiKeyStore) CreateAPIKey(_ context.Context, k app.APIKey) (app.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k.ID, k.CreatedAt = domain.NewPaymentID().String(), time.Now()
+	s.keys[k.Prefix] = k
+	return k, nil
+}
+
+func (s *apiKeyStore) FindAPIKeyByPrefix(_ context.Context, prefix string) (app.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[prefix]
+	if !ok {
+		return app.APIKey{}, domain.ErrNotFound
+	}
+	return k, nil
+}
+
+func (s *apiKeyStore) ListAPIKeys(context.Context, string) ([]app.APIKey, error) { return nil, nil }
+
+func (s *apiKeyStore) RevokeAPIKey(context.Context, string) error { return nil }
+
+// serve runs a server on an in-memory listener and dials it
+func serve(t *testing.T, keys *app.APIKeyService) *grpc.ClientConn {
+	t.Helper()
+	log := slog.New(slog.DiscardHandler)
+	kv := memory.NewKeyValueStore()
+	svc := app.NewPaymentService(memory.NewRepository(), kv, time.Hour, kv, mockprovider.New(time.Second),
+		app.RequestLimits{Currencies: []string{"EUR"}, MaxAmountCents: 100000}, nil, log)
+
+	ln := bufconn.Listen(1 << 20)
+	s := NewServer(Config{APIKeys: keys}, svc, log)
+	go func() { _ = s.inner.Serve(ln) }()
+	t.Cleanup(s.inner.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func newKeys(t *testing.T) (*app.APIKeyService, func(merchantID string, scopes ...string) string) {
+	t.Helper()
+	keys := app.NewAPIKeyService(&apiKeyStore{keys: map[string]app.APIKey{}}, slog.New(slog.DiscardHandler))
+	issue := func(merchantID string, scopes ...string) string {
+		k, err := keys.Create(context.Background(), app.CreateAPIKeyRequest{MerchantID: merchantID, Scopes: scopes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k.Key
+	}
+	return keys, issue
+}
+
+// as carries the API key and the idempotency key of a call
+func as(key, idempotencyKey string) context.Context {
+	md := metadata.Pairs(mdAPIKey, key)
+	if idempotencyKey != "" {
+		md.Set(mdIdempotencyKey, idempotencyKey)
+	}
+	return metadata.NewOutgoingContext(context.Background(), md)
+}
+
+var initiateRequest = &gopayv1.InitiatePaymentRequest{
+	OrderId:     "order-1",
+	CustomerId:  "cus-1",
+	AmountCents: 1999,
+	Currency:    "EUR",
+}
+
+func TestAuthentication(t *testing.T) {
+	keys, issue := newKeys(t)
+	readOnly := issue("merchant-a", app.ScopePaymentsRead)
+	get := &gopayv1.GetPaymentRequest{PaymentId: domain.NewPaymentID().String()}
+
+	tests := []struct {
+		name string
+		keys *app.APIKeyService
+		call func(gopayv1.PaymentServiceClient) error
+		want codes.Code
+	}{
+		{
+			name: "no key",
+			keys: keys,
+			call: func(c gopayv1.PaymentServiceClient) error {
+				_, err := c.GetPayment(context.Background(), get)
+				return err
+			},
+			want: codes.Unauthenticated,
+		},
+		{
+			name: "unknown key",
+			keys: keys,
+			call: func(c gopayv1.PaymentServiceClient) error {
+				_, err := c.GetPayment(as("gpk_unknown_secret", ""), get)
+				return err
+			},
+			want: codes.Unauthenticated,
+		},
+		{
+			// like the HTTP API, there is no mode that skips auth
+			name: "api keys disabled",
+			call: func(c gopayv1.PaymentServiceClient) error {
+				_, err := c.GetPayment(as(readOnly, ""), get)
+				return err
+			},
+			want: codes.Unauthenticated,
+		},
+		{
+			name: "missing scope",
+			keys: keys,
+			call: func(c gopayv1.PaymentServiceClient) error {
+				_, err := c.InitiatePayment(as(readOnly, "idem-1"), initiateRequest)
+				return err
+			},
+			want: codes.PermissionDenied,
+		},
+		{
+			name: "scope granted",
+			keys: keys,
+			call: func(c gopayv1.PaymentServiceClient) error {
+				_, err := c.GetPayment(as(readOnly, ""), get)
+				return err
+			},
+			want: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := serve(t, tt.keys)
+			if err := tt.call(gopayv1.NewPaymentServiceClient(conn)); status.Code(err) != tt.want {
+				t.Fatalf("err = %v, want %s", err, tt.want)
+			}
+
+			// probes carry no key
+			resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+			if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+				t.Fatalf("health check = %v, %v", resp, err)
+			}
+		})
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	keys, issue := newKeys(t)
+	key := issue("merchant-a", app.APIKeyScopes...)
+	client := gopayv1.NewPaymentServiceClient(serve(t, keys))
+
+	created, err := client.InitiatePayment(as(key, "idem-1"), initiateRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := created.GetPaymentId()
+
+	tests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{
+			name: "unknown payment",
+			call: func() error {
+				_, err := client.GetPayment(as(key, ""), &gopayv1.GetPaymentRequest{PaymentId: domain.NewPaymentID().String()})
+				return err
+			},
+			want: codes.NotFound,
+		},
+		{
+			name: "another merchant's payment",
+			call: func() error {
+				other := issue("merchant-b", app.APIKeyScopes...)
+				_, err := client.GetPayment(as(other, ""), &gopayv1.GetPaymentRequest{PaymentId: id})
+				return err
+			},
+			want: codes.NotFound,
+		},
+		{
+			name: "malformed id",
+			call: func() error {
+				_, err := client.GetPayment(as(key, ""), &gopayv1.GetPaymentRequest{PaymentId: "not-an-id"})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "stale expected version",
+			call: func() error {
+				_, err := client.CancelPayment(as(key, ""), &gopayv1.CancelPaymentRequest{PaymentId: id, ExpectedVersion: 99})
+				return err
+			},
+			want: codes.Aborted,
+		},
+		{
+			name: "cancel",
+			call: func() error {
+				_, err := client.CancelPayment(as(key, ""), &gopayv1.CancelPaymentRequest{PaymentId: id})
+				return err
+			},
+			want: codes.OK,
+		},
+		{
+			name: "cancel a cancelled payment",
+			call: func() error {
+				_, err := client.CancelPayment(as(key, ""), &gopayv1.CancelPaymentRequest{PaymentId: id})
+				return err
+			},
+			want: codes.FailedPrecondition,
+		},
+	}
+
+	// in order, the cancels build on each other
+	for _, tt := range tests {
+		if err := tt.call(); status.Code(err) != tt.want {
+			t.Fatalf("%s: err = %v, want %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+// the idempotency key travels as metadata, a retry replays the payment and
+// says so in the response header
+func TestIdempotencyKeyMetadata(t *testing.T) {
+	keys, issue := newKeys(t)
+	key := issue("merchant-a", app.APIKeyScopes...)
+	client := gopayv1.NewPaymentServiceClient(serve(t, keys))
+
+	var first, retry metadata.MD
+	created, err := client.InitiatePayment(as(key, "idem-1"), initiateRequest, grpc.Header(&first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := client.InitiatePayment(as(key, "idem-1"), initiateRequest, grpc.Header(&retry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.GetPaymentId() != created.GetPaymentId() {
+		t.Fatalf("retry created payment %s, want %s", replayed.GetPaymentId(), created.GetPaymentId())
+	}
+	if got := first.Get(mdIdempotencyReplayed); len(got) != 0 {
+		t.Fatalf("first call has %s %v", mdIdempotencyReplayed, got)
+	}
+	if got := retry.Get(mdIdempotencyReplayed); len(got) != 1 || got[0] != "true" {
+		t.Fatalf("retry has %s %v, want true", mdIdempotencyReplayed, got)
+	}
+
+	changed := &gopayv1.InitiatePaymentRequest{OrderId: "order-1", CustomerId: "cus-1", AmountCents: 2999, Currency: "EUR"}
+	if _, err := client.InitiatePayment(as(key, "idem-1"), changed); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("key reused for another amount: err = %v, want %s", err, codes.FailedPrecondition)
+	}
+	if _, err := client.InitiatePayment(as(key, ""), initiateRequest); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("no idempotency key: err = %v, want %s", err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,101 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+var errAmountFormat = errors.New("must be a decimal string such as \"10.99\"")
+
+// parseDecimalAmount reads a major-unit decimal string into the currency's
+// minor units, "10.99" USD is 1099 and "1000" JPY is 1000. It works on the
+// digits only, a float would round amounts it cannot represent.
+func parseDecimalAmount(s, currency string) (int64, error) {
+	digits, ok := domain.MinorUnits(currency)
+	if !ok {
+		return 0, fmt.Errorf("needs a valid currency to be read")
+	}
+
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if whole == "" || (hasPoint && frac == "") || !isDigits(whole) || !isDigits(frac) {
+		return 0, errAmountFormat
+	}
+	if len(frac) > digits {
+		if digits == 0 {
+			return 0, fmt.Errorf("must be a whole number for %s", currency)
+		}
+		return 0, fmt.Errorf("must have at most %d decimal places for %s", digits, currency)
+	}
+
+	minor, err := strconv.ParseInt(whole+frac+strings.Repeat("0", digits-len(frac)), 10, 64)
+	switch {
+	case errors.Is(err, strconv.ErrRange):
+		return 0, fmt.Errorf("is too large")
+	case err != nil:
+		return 0, errAmountFormat
+	case minor == 0:
+		return 0, fmt.Errorf("must be positive")
+	}
+	return minor, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestAmountCents picks the amount of an initiation, amount_cents or the
+// decimal amount. Both may be sent only when they agree.
+func requestAmountCents(body initiatePaymentRequest) (int64, *app.FieldError) {
+	if body.Amount == nil {
+		if body.AmountCents == nil {
+			return 0, nil
+		}
+		return *body.AmountCents, nil
+	}
+
+	currency := strings.ToUpper(strings.TrimSpace(body.Currency))
+	cents, err := parseDecimalAmount(*body.Amount, currency)
+	if err != nil {
+		return 0, &app.FieldError{Field: "amount", Message: err.Error()}
+	}
+	if body.AmountCents != nil && *body.AmountCents != cents {
+		return 0, &app.FieldError{
+			Field:   "amount",
+			Message: fmt.Sprintf("is %d in minor units and conflicts with amount_cents %d, send one of them", cents, *body.AmountCents),
+		}
+	}
+	return cents, nil
+}
+
+// withAmountError merges a rejected amount into the rest of the request's
+// validation, it takes the place of the amount_cents error Validate reports
+// for the missing amount
+func withAmountError(amount app.FieldError, err error) *app.ValidationError {
+	var rest *app.ValidationError
+	if !errors.As(err, &rest) {
+		return &app.ValidationError{Fields: []app.FieldError{amount}}
+	}
+
+	merged := &app.ValidationError{}
+	for _, f := range rest.Fields {
+		if f.Field == "amount_cents" {
+			f = amount
+		}
+		merged.Fields = append(merged.Fields, f)
+	}
+	if !slices.Contains(merged.Fields, amount) {
+		merged.Fields = append([]app.FieldError{amount}, merged.Fields...)
+	}
+	return merged
+}
@@ -0,0 +1,156 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+func TestParseDecimalAmount(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     int64
+		// a prefix of the message, empty when the amount is accepted
+		wantErr string
+	}{
+		{"10.99", "USD", 1099, ""},
+		{"10.9", "USD", 1090, ""},
+		{"10", "USD", 1000, ""},
+		{"0.01", "EUR", 1, ""},
+		{"007.50", "EUR", 750, ""},
+		{"1000", "JPY", 1000, ""},
+		{"1.234", "KWD", 1234, ""},
+		{"92233720368547758.07", "USD", 9223372036854775807, ""},
+		{"92233720368547758.08", "USD", 0, "is too large"},
+		{"10.999", "USD", 0, "must have at most 2 decimal places for USD"},
+		{"10.5", "JPY", 0, "must be a whole number for JPY"},
+		{"0", "USD", 0, "must be positive"},
+		{"0.00", "USD", 0, "must be positive"},
+		{"", "USD", 0, errAmountFormat.Error()},
+		{".5", "USD", 0, errAmountFormat.Error()},
+		{"5.", "USD", 0, errAmountFormat.Error()},
+		{"-1.00", "USD", 0, errAmountFormat.Error()},
+		{"+1.00", "USD", 0, errAmountFormat.Error()},
+		{"1,00", "USD", 0, errAmountFormat.Error()},
+		{"1e3", "USD", 0, errAmountFormat.Error()},
+		{" 1.00", "USD", 0, errAmountFormat.Error()},
+		{"1.0.0", "USD", 0, errAmountFormat.Error()},
+		{"10.99", "XXX", 0, "needs a valid currency"},
+		{"10.99", "", 0, "needs a valid currency"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDecimalAmount(tt.amount, tt.currency)
+		switch {
+		case tt.wantErr == "" && (err != nil || got != tt.want):
+			t.Errorf("parseDecimalAmount(%q, %s) = %d, %v, want %d", tt.amount, tt.currency, got, err, tt.want)
+		case tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)):
+			t.Errorf("parseDecimalAmount(%q, %s) err = %v, want %q", tt.amount, tt.currency, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRequestAmountCents(t *testing.T) {
+	ptr := func(v int64) *int64 { return &v }
+	str := func(v string) *string { return &v }
+
+	tests := []struct {
+		name      string
+		body      initiatePaymentRequest
+		want      int64
+		wantField bool
+	}{
+		{name: "neither", body: initiatePaymentRequest{Currency: "USD"}},
+		{name: "cents only", body: initiatePaymentRequest{AmountCents: ptr(1099), Currency: "USD"}, want: 1099},
+		{name: "decimal only", body: initiatePaymentRequest{Amount: str("10.99"), Currency: " usd "}, want: 1099},
+		{name: "both agree", body: initiatePaymentRequest{Amount: str("10.99"), AmountCents: ptr(1099), Currency: "USD"}, want: 1099},
+		{name: "both disagree", body: initiatePaymentRequest{Amount: str("10.99"), AmountCents: ptr(1000), Currency: "USD"}, wantField: true},
+		{name: "bad decimal", body: initiatePaymentRequest{Amount: str("ten"), Currency: "USD"}, wantField: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ferr := requestAmountCents(tt.body)
+			if tt.wantField {
+				if ferr == nil || ferr.Field != "amount" {
+					t.Fatalf("field error = %v, want one on amount", ferr)
+				}
+				return
+			}
+			if ferr != nil || got != tt.want {
+				t.Fatalf("got %d, %v, want %d", got, ferr, tt.want)
+			}
+		})
+	}
+}
+
+// a bad amount is reported with every other rejected field, in one response
+func TestInitiatePaymentReportsAmountWithOtherFields(t *testing.T) {
+	kv := memory.NewKeyValueStore()
+	log := slog.New(slog.DiscardHandler)
+	svc := app.NewPaymentService(memory.NewRepository(), kv, time.Hour, kv, mockprovider.New(time.Second),
+		app.RequestLimits{}, nil, log)
+	h := NewHandler(svc, AdminServices{}, nil, 0, log)
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "bad amount and missing ids",
+			body: `{"amount": "10.999", "currency": "USD"}`,
+			want: []string{"order_id", "customer_id", "amount", "idempotency_key"},
+		},
+		{
+			name: "amount and currency",
+			body: `{"order_id": "o-1", "customer_id": "c-1", "amount": "10.99", "currency": "ZZZ", "idempotency_key": "k-1"}`,
+			want: []string{"amount", "currency"},
+		},
+		{
+			name: "conflicting amounts and metadata",
+			body: `{"order_id": "o-1", "customer_id": "c-1", "amount": "10.99", "amount_cents": 1000, "currency": "USD",
+				"idempotency_key": "k-1", "metadata": {"": "x"}}`,
+			want: []string{"amount", "metadata"},
+		},
+		{
+			name: "amount alone",
+			body: `{"order_id": "o-1", "customer_id": "c-1", "amount": "0", "currency": "EUR", "idempotency_key": "k-1"}`,
+			want: []string{"amount"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/payments", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			h.initiatePayment(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", w.Code, w.Body)
+			}
+			var resp errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range resp.Fields {
+				got = append(got, f.Field)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// headerAPIKey is the alternative to an Authorization bearer key
+const headerAPIKey = "X-API-Key"
+
+// presentedAPIKey reads the key from X-API-Key or an Authorization bearer
+func presentedAPIKey(r *http.Request) string {
+	if key := r.Header.Get(headerAPIKey); key != "" {
+		return key
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+type createAPIKeyRequest struct {
+	MerchantID string   `json:"merchant_id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+}
+
+type apiKeyResponse struct {
+	KeyID      string   `json:"key_id"`
+	MerchantID string   `json:"merchant_id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	// only returned on creation
+	Key       string     `json:"key,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type apiKeyListResponse struct {
+	Keys []apiKeyResponse `json:"keys"`
+}
+
+func (h *Handler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var body createAPIKeyRequest
+	if !h.decodeJSON(w, r, &body) {
+		return
+	}
+
+	key, err := h.admin.APIKeys.Create(r.Context(), app.CreateAPIKeyRequest{
+		MerchantID: body.MerchantID,
+		Name:       body.Name,
+		Scopes:     body.Scopes,
+	})
+	if err != nil {
+		h.mapAPIKeyError(w, r, err)
+		return
+	}
+	h.respond(w, r, http.StatusCreated, toAPIKeyResponse(key))
+}
+
+// listAPIKeys takes an optional ?merchant_id filter
+func (h *Handler) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.admin.APIKeys.List(r.Context(), r.URL.Query().Get("merchant_id"))
+	if err != nil {
+		h.mapAPIKeyError(w, r, err)
+		return
+	}
+
+	resp := apiKeyListResponse{Keys: make([]apiKeyResponse, 0, len(keys))}
+	for _, k := range keys {
+		resp.Keys = append(resp.Keys, toAPIKeyResponse(k))
+	}
+	h.respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.admin.APIKeys.Revoke(r.Context(), chi.URLParam(r, "keyID")); err != nil {
+		h.mapAPIKeyError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) mapAPIKeyError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, domain.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "api key not found or already revoked", "NOT_FOUND")
+		return
+	}
+	h.mapError(w, r, err)
+}
+
+func toAPIKeyResponse(k app.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		KeyID:      k.ID,
+		MerchantID: k.MerchantID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		Key:        k.Key,
+		RevokedAt:  k.RevokedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
@@ -0,0 +1,142 @@
+package httpserver
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// countingRepo counts FindByID loads and holds each one until release closes
+type countingRepo struct {
+	domain.Repository
+	loads   atomic.Int32
+	loading chan struct{}
+	release chan struct{}
+}
+
+func (r *countingRepo) FindByID(ctx context.Context, id domain.PaymentID) (*domain.Payment, error) {
+	if r.loads.Add(1) == 1 {
+		close(r.loading)
+	}
+	<-r.release
+	return r.Repository.FindByID(ctx, id)
+}
+
+func newCoalesceFixture(t *testing.T) (*countingRepo, *app.PaymentService, string) {
+	t.Helper()
+	log := slog.New(slog.DiscardHandler)
+	repo := &countingRepo{Repository: memory.NewRepository(), loading: make(chan struct{}), release: make(chan struct{})}
+	kv := memory.NewKeyValueStore()
+	svc := app.NewPaymentService(repo, kv, time.Hour, kv, mockprovider.New(time.Second),
+		app.RequestLimits{Currencies: []string{"EUR"}, MaxAmountCents: 100000}, nil, log)
+
+	close(repo.release)
+	resp, err := svc.InitiatePayment(context.Background(), app.InitiatePaymentRequest{
+		OrderID: "order-1", CustomerID: "cus-1", AmountCents: 1999, Currency: "EUR", IdempotencyKey: "idem-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.loads.Store(0)
+	repo.loading, repo.release = make(chan struct{}), make(chan struct{})
+	return repo, svc, resp.PaymentID
+}
+
+// burst sends n concurrent requests and lets the first load finish once
+// every request is under way
+func burst(t *testing.T, repo *countingRepo, n int, do func(i int) int) []int {
+	t.Helper()
+	var (
+		wg       sync.WaitGroup
+		started  sync.WaitGroup
+		statuses = make([]int, n)
+	)
+	started.Add(n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			statuses[i] = do(i)
+		}()
+	}
+	started.Wait()
+	<-repo.loading
+	// give the others time to join the load in flight
+	time.Sleep(20 * time.Millisecond)
+	close(repo.release)
+	wg.Wait()
+	return statuses
+}
+
+func TestGetPaymentCoalescesConcurrentLoads(t *testing.T) {
+	const n = 10
+
+	tests := []struct {
+		name string
+		// header sets the request headers of the i-th request
+		header    func(i int, h http.Header)
+		wantLoads int32
+	}{
+		{name: "identical requests share one load", header: func(int, http.Header) {}, wantLoads: 1},
+		{name: "If-None-Match bypasses", header: func(i int, h http.Header) {
+			h.Set("If-None-Match", `"v`+string(rune('a'+i))+`"`)
+		}, wantLoads: n},
+		{name: "X-Min-Version bypasses", header: func(_ int, h http.Header) { h.Set("X-Min-Version", "2") }, wantLoads: n},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, svc, id := newCoalesceFixture(t)
+			api := NewServer(ServerConfig{Metrics: NewMetrics(prometheus.NewRegistry())},
+				NewHandler(svc, AdminServices{}, nil, 0, slog.New(slog.DiscardHandler)), nil, slog.New(slog.DiscardHandler)).inner.Handler
+
+			statuses := burst(t, repo, n, func(i int) int {
+				r := httptest.NewRequest(http.MethodGet, "/v1/payments/"+id, nil)
+				tt.header(i, r.Header)
+				w := httptest.NewRecorder()
+				api.ServeHTTP(w, r)
+				return w.Code
+			})
+
+			for _, status := range statuses {
+				if status != http.StatusOK {
+					t.Fatalf("statuses = %v, want all 200", statuses)
+				}
+			}
+			if got := repo.loads.Load(); got != tt.wantLoads {
+				t.Fatalf("loads = %d, want %d", got, tt.wantLoads)
+			}
+		})
+	}
+}
+
+// callers never share a load across principals
+func TestGetPaymentCoalescesPerPrincipal(t *testing.T) {
+	repo, svc, id := newCoalesceFixture(t)
+	principals := []string{"merchant-a", "merchant-b"}
+
+	burst(t, repo, 6, func(i int) int {
+		ctx := app.WithPrincipal(context.Background(), app.Principal{ID: principals[i%2]})
+		if _, err := svc.GetPayment(ctx, id); err != nil {
+			t.Error(err)
+		}
+		return 0
+	})
+
+	if got := repo.loads.Load(); got != 2 {
+		t.Fatalf("loads = %d, want one per principal", got)
+	}
+}
@@ -0,0 +1,173 @@
+package httpserver
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressionMinBytes is the smallest body gzip is worth its headers for
+const DefaultCompressionMinBytes = 1024
+
+// paths that compress on their own or serve binary data, e.g. profiles
+var uncompressedPaths = []string{"/metrics", "/debug/"}
+
+var gzipWriters = sync.Pool{
+	New: func() any {
+		gz, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return gz
+	},
+}
+
+// compress gzips JSON and text responses of at least minBytes for clients
+// that accept it. Smaller bodies go out as they are, the decision waits
+// until minBytes are written or the handler returns.
+func compress(minBytes int) func(http.Handler) http.Handler {
+	if minBytes <= 0 {
+		minBytes = DefaultCompressionMinBytes
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hasPathPrefix(r.URL.Path, uncompressedPaths) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// caches must not hand a gzipped body to a client that cannot read it
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, minBytes: minBytes}
+			next.ServeHTTP(cw, r)
+			// after a panic the held back bytes are dropped, the recoverer
+			// answers instead
+			cw.close()
+		})
+	}
+}
+
+// acceptsGzip reads Accept-Encoding, a q of 0 refuses the coding
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = v
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// compressWriter holds back the status and the first bytes until it knows
+// whether the body is large and compressible enough
+type compressWriter struct {
+	http.ResponseWriter
+	minBytes int
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	cw.status = code
+	// bodiless responses have nothing to compress
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.passThrough()
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	switch {
+	case cw.gz != nil:
+		return cw.gz.Write(p)
+	case cw.decided:
+		return cw.ResponseWriter.Write(p)
+	case !cw.compressible():
+		cw.passThrough()
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minBytes {
+		if err := cw.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasPrefix(mediaType, "text/")
+}
+
+func (cw *compressWriter) startGzip() error {
+	cw.decided = true
+	h := cw.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	// a strong validator names the identity bytes, not these
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	cw.gz = gzipWriters.Get().(*gzip.Writer)
+	cw.gz.Reset(cw.ResponseWriter)
+	_, err := cw.gz.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// passThrough sends the status and whatever was held back uncompressed
+func (cw *compressWriter) passThrough() {
+	cw.decided = true
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if len(cw.buf) > 0 {
+		_, _ = cw.ResponseWriter.Write(cw.buf)
+		cw.buf = nil
+	}
+}
+
+func (cw *compressWriter) close() {
+	switch {
+	case cw.gz != nil:
+		_ = cw.gz.Close()
+		gzipWriters.Put(cw.gz)
+		cw.gz = nil
+	case !cw.decided:
+		cw.passThrough()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (cw *compressWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
@@ -0,0 +1,87 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const headerTimeoutBody = `{"error":"request headers not received in time","code":"REQUEST_TIMEOUT","status":408}` + "\n"
+
+// headerTimeoutResponse is written by the connection itself, net/http closes
+// a connection whose headers stalled past ReadHeaderTimeout without a reply
+var headerTimeoutResponse = "HTTP/1.1 408 Request Timeout\r\n" +
+	"Content-Type: application/json\r\n" +
+	"Content-Length: " + strconv.Itoa(len(headerTimeoutBody)) + "\r\n" +
+	"Connection: close\r\n\r\n" +
+	headerTimeoutBody
+
+// headerTimeoutListener hands out connections that answer 408 when a
+// request's headers started arriving but did not finish in time
+type headerTimeoutListener struct {
+	net.Listener
+}
+
+func (l headerTimeoutListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &headerTimeoutConn{Conn: c}, nil
+}
+
+type headerTimeoutConn struct {
+	net.Conn
+	// the server is between requests, reads are request headers
+	betweenRequests atomic.Bool
+	// bytes of the next request's headers arrived
+	partial atomic.Bool
+	// the connection speaks HTTP/2, whose frames are not ours to answer
+	h2   atomic.Bool
+	seen atomic.Bool
+}
+
+var h2Preface = []byte("PRI * HTTP/2.0")
+
+func (c *headerTimeoutConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && !c.seen.Swap(true) && bytes.HasPrefix(h2Preface, p[:min(n, len(h2Preface))]) {
+		c.h2.Store(true)
+	}
+	if c.h2.Load() || !c.betweenRequests.Load() {
+		return n, err
+	}
+	if n > 0 {
+		c.partial.Store(true)
+	}
+
+	var ne net.Error
+	if err != nil && c.partial.Load() && errors.As(err, &ne) && ne.Timeout() {
+		c.betweenRequests.Store(false)
+		// the deadline of the previous response may have passed
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_, _ = io.WriteString(c.Conn, headerTimeoutResponse)
+	}
+	return n, err
+}
+
+// trackConnState is the http.Server ConnState hook that tells the
+// connections when they are reading request headers
+func trackConnState(c net.Conn, state http.ConnState) {
+	hc, ok := c.(*headerTimeoutConn)
+	if !ok {
+		return
+	}
+	switch state {
+	case http.StateNew, http.StateIdle:
+		hc.partial.Store(false)
+		hc.betweenRequests.Store(true)
+	default:
+		hc.betweenRequests.Store(false)
+	}
+}
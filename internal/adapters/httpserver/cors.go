@@ -0,0 +1,95 @@
+package httpserver
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig lets browsers call the API from other origins, e.g. the hosted
+// checkout page. CORS is off when AllowedOrigins is empty.
+type CORSConfig struct {
+	// exact origins such as https://checkout.example.com, https://*.example.com
+	// for any subdomain or * for every origin
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// how long browsers may cache a preflight answer
+	MaxAge time.Duration
+}
+
+// response headers the checkout SDK reads
+var corsExposedHeaders = strings.Join([]string{
+	idempotencyKeyHeader,
+	idempotencyReplayedHeader,
+	correlationIDHeader,
+	"X-Degraded",
+	"Retry-After",
+	"ETag",
+}, ", ")
+
+// cors answers preflights itself, without reaching routing, handlers or the
+// request metrics, and marks responses to allowed origins. A disallowed
+// origin gets no CORS headers, the browser then blocks the response.
+func cors(cfg CORSConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(cfg.AllowedOrigins) == 0 {
+			return next
+		}
+		methods := strings.Join(cfg.AllowedMethods, ", ")
+		headers := strings.Join(cfg.AllowedHeaders, ", ")
+		maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			allowed := originAllowed(origin, cfg.AllowedOrigins)
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				if allowed {
+					h.Set("Access-Control-Allow-Origin", origin)
+					h.Set("Access-Control-Allow-Methods", methods)
+					h.Set("Access-Control-Allow-Headers", headers)
+					h.Set("Access-Control-Max-Age", maxAge)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if allowed {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// originAllowed compares scheme and host case-insensitively. A wildcard
+// entry matches subdomains at any depth but not the bare domain.
+func originAllowed(origin string, allowed []string) bool {
+	origin = strings.ToLower(origin)
+	return slices.ContainsFunc(allowed, func(pattern string) bool {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		scheme, host, ok := strings.Cut(pattern, "://*.")
+		if !ok {
+			return false
+		}
+		prefix := scheme + "://"
+		return strings.HasPrefix(origin, prefix) &&
+			strings.HasSuffix(origin, "."+host) &&
+			len(origin) > len(prefix)+len(host)+1
+	})
+}
@@ -0,0 +1,41 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// debugHandler serves net/http/pprof under /debug/pprof/ and runtime stats
+// on /debug/runtime. The named profiles (heap, goroutine, allocs, block,
+// mutex) are served by the pprof index.
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("GET /debug/runtime", runtimeStatsHandler)
+	return mux
+}
+
+type runtimeStats struct {
+	Goroutines int               `json:"goroutines"`
+	CPUs       int               `json:"cpus"`
+	GoVersion  string            `json:"go_version"`
+	MemStats   *runtime.MemStats `json:"mem_stats"`
+}
+
+// runtimeStatsHandler dumps runtime.MemStats, reading them stops the world
+// briefly
+func runtimeStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	_ = writeJSON(w, http.StatusOK, runtimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		CPUs:       runtime.NumCPU(),
+		GoVersion:  runtime.Version(),
+		MemStats:   &mem,
+	})
+}
@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+// DefaultMaxBodyBytes bounds JSON request bodies when the handler is given zero
+const DefaultMaxBodyBytes = 64 << 10
+
+type decodeOptions struct {
+	// an empty body leaves dst as it is
+	optional bool
+	// for payloads whose schema someone else owns, e.g. provider webhooks
+	allowUnknownFields bool
+}
+
+// decodeJSON reads exactly one JSON document of at most the handler's body
+// limit into dst. Unknown fields are rejected. On failure the response is
+// written and false returned.
+func (h *Handler) decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	return h.decodeJSONWith(w, r, dst, decodeOptions{})
+}
+
+func (h *Handler) decodeJSONWith(w http.ResponseWriter, r *http.Request, dst any, opts decodeOptions) bool {
+	if opts.optional && r.ContentLength == 0 {
+		return true
+	}
+	if !isJSONContentType(r.Header.Get("Content-Type")) {
+		writeError(w, r, http.StatusUnsupportedMediaType, "Content-Type must be application/json", "UNSUPPORTED_MEDIA_TYPE")
+		return false
+	}
+
+	limit := h.maxBodyBytes
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	if !opts.allowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+
+	if err := dec.Decode(dst); err != nil {
+		if opts.optional && errors.Is(err, io.EOF) {
+			return true
+		}
+		writeDecodeError(w, r, err)
+		return false
+	}
+
+	var extra json.RawMessage
+	switch err := dec.Decode(&extra); {
+	case errors.Is(err, io.EOF):
+		return true
+	case isBodyTooLarge(err):
+		writeDecodeError(w, r, err)
+	default:
+		writeError(w, r, http.StatusBadRequest, "request body must hold a single JSON document", "INVALID_JSON")
+	}
+	return false
+}
+
+func isJSONContentType(v string) bool {
+	mediaType, _, err := mime.ParseMediaType(v)
+	return err == nil && mediaType == "application/json"
+}
+
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// writeDecodeError names the offending field where encoding/json tells it
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		maxErr  *http.MaxBytesError
+		typeErr *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &maxErr):
+		writeError(w, r, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("request body must be at most %d bytes", maxErr.Limit), "REQUEST_TOO_LARGE")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeValidationError(w, r, &app.ValidationError{Fields: []app.FieldError{{Field: field, Message: "is not a known field"}}})
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		writeValidationError(w, r, &app.ValidationError{Fields: []app.FieldError{{Field: typeErr.Field, Message: "must be " + jsonTypeName(typeErr.Type)}}})
+	default:
+		writeError(w, r, http.StatusBadRequest, "cannot parse request body", "INVALID_JSON")
+	}
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	default:
+		return "a " + t.String()
+	}
+}
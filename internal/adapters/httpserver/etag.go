@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+// paymentETag changes whenever the payment representation does. The version
+// moves with every transition and metadata update, erasure rewrites the
+// customer without one, so the customer ID is hashed in as well.
+func paymentETag(p app.PaymentDetails) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(p.CustomerID))
+	return fmt.Sprintf(`"v%d-%08x"`, p.Version, h.Sum32())
+}
+
+// etagMatches implements the weak comparison If-None-Match calls for
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified answers 304 when the client's copy is current
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// expectedVersion reads the version a write is conditional on from If-Match,
+// which takes the ETag of a GET, or from the expected_version body field. 0
+// leaves the write unconditional.
+func expectedVersion(r *http.Request, bodyVersion int) (int, *app.ValidationError) {
+	invalid := func(field, msg string) (int, *app.ValidationError) {
+		return 0, &app.ValidationError{Fields: []app.FieldError{{Field: field, Message: msg}}}
+	}
+	if bodyVersion < 0 {
+		return invalid("expected_version", "must be positive")
+	}
+
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return bodyVersion, nil
+	}
+	if strings.Contains(ifMatch, ",") {
+		return invalid("If-Match", "must hold a single ETag")
+	}
+	// a gzipped GET weakens the ETag, the version in it is still exact
+	tag := strings.TrimPrefix(ifMatch, "W/")
+	tag, ok := strings.CutPrefix(tag, `"v`)
+	if !ok {
+		return invalid("If-Match", "must be an ETag returned by GET /v1/payments/{id}")
+	}
+	digits, _, _ := strings.Cut(tag, "-")
+	version, err := strconv.Atoi(digits)
+	if err != nil || version < 1 {
+		return invalid("If-Match", "must be an ETag returned by GET /v1/payments/{id}")
+	}
+
+	if bodyVersion != 0 && bodyVersion != version {
+		return invalid("expected_version", "does not match the version in If-Match")
+	}
+	return version, nil
+}
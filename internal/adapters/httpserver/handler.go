@@ -1,90 +1,256 @@
 package httpserver
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/google/uuid"
 
 	"github.com/ademajagon/gopay-service/internal/app"
 	"github.com/ademajagon/gopay-service/internal/domain"
-)
-
-var (
-	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-		Namespace: "gopay_service",
-		Subsystem: "http",
-		Name:      "requests_total",
-		Help:      "Total HTTP requests partitioned by method, path and status code.",
-	}, []string{"method", "path", "status_code"})
-
-	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: "gopay_service",
-		Subsystem: "http",
-		Name:      "request_duration_seconds",
-		Help:      "HTTP request duration in seconds.",
-		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
-	}, []string{"method", "route"})
+	"github.com/ademajagon/gopay-service/internal/logging"
 )
 
 // Request / Response DTOs
 
 type initiatePaymentRequest struct {
-	OrderID        string `json:"order_id"`
-	CustomerID     string `json:"customer_id"`
-	AmountCents    int64  `json:"amount_cents"`
-	Currency       string `json:"currency"`
-	IdempotencyKey string `json:"idempotency_key"`
+	OrderID     string `json:"order_id"`
+	CustomerID  string `json:"customer_id"`
+	AmountCents *int64 `json:"amount_cents"`
+	// decimal major units, e.g. "10.99", instead of amount_cents
+	Amount          *string           `json:"amount,omitempty"`
+	Currency        string            `json:"currency"`
+	IdempotencyKey  string            `json:"idempotency_key"`
+	ClientReference string            `json:"client_reference,omitempty"`
+	CaptureMethod   string            `json:"capture_method,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+}
+
+// updateMetadataRequest is a JSON merge patch of the metadata, a null
+// value removes the key
+type updateMetadataRequest struct {
+	Metadata map[string]*string `json:"metadata"`
+	// ExpectedVersion is the body form of If-Match
+	ExpectedVersion int `json:"expected_version,omitempty"`
 }
 
 type initiatePaymentResponse struct {
+	PaymentID     string `json:"payment_id"`
+	Status        string `json:"status"`
+	CorrelationID string `json:"correlation_id"`
+}
+
+type paymentResponse struct {
+	PaymentID       string            `json:"payment_id"`
+	OrderID         string            `json:"order_id"`
+	CustomerID      string            `json:"customer_id"`
+	AmountCents     int64             `json:"amount_cents"`
+	Currency        string            `json:"currency"`
+	Status          string            `json:"status"`
+	ProviderRef     string            `json:"provider_ref"`
+	FailureReason   string            `json:"failure_reason"`
+	ClientReference string            `json:"client_reference,omitempty"`
+	CorrelationID   string            `json:"correlation_id"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	Version         int               `json:"version"`
+}
+
+type dryRunResponse struct {
+	Valid             bool                   `json:"valid"`
+	NormalizedRequest initiatePaymentRequest `json:"normalized_request"`
+	// CorrelationID is the id a real request would store, omitted when it
+	// would generate one
+	CorrelationID string   `json:"correlation_id,omitempty"`
+	Warnings      []string `json:"warnings"`
+}
+
+type capturePaymentRequest struct {
+	ExpectedVersion int `json:"expected_version"`
+}
+
+type capturePaymentResponse struct {
 	PaymentID string `json:"payment_id"`
 	Status    string `json:"status"`
 }
 
+type cancelPaymentRequest struct {
+	Reason          string `json:"reason"`
+	ExpectedVersion int    `json:"expected_version"`
+}
+
+type cancelPaymentResponse struct {
+	PaymentID string `json:"payment_id"`
+	Status    string `json:"status"`
+}
+
+type refundPaymentRequest struct {
+	// omitted refunds whatever is left of the payment
+	AmountCents int64  `json:"amount_cents"`
+	Reason      string `json:"reason"`
+}
+
+type refundResponse struct {
+	RefundID      string    `json:"refund_id"`
+	PaymentID     string    `json:"payment_id"`
+	AmountCents   int64     `json:"amount_cents"`
+	Currency      string    `json:"currency"`
+	Status        string    `json:"status"`
+	Reason        string    `json:"reason,omitempty"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type refundListResponse struct {
+	Refunds []refundResponse `json:"refunds"`
+}
+
+type timelineEntryResponse struct {
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status"`
+	Reason     string    `json:"reason,omitempty"`
+	Actor      string    `json:"actor"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+type timelineResponse struct {
+	PaymentID string                  `json:"payment_id"`
+	Timeline  []timelineEntryResponse `json:"timeline"`
+}
+
+type paymentListResponse struct {
+	Payments []paymentResponse `json:"payments"`
+}
+
+type paymentPageResponse struct {
+	Payments   []paymentResponse `json:"payments"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	HasMore    bool              `json:"has_more"`
+}
+
+type eraseCustomerResponse struct {
+	Pseudonym      string `json:"pseudonym"`
+	PaymentsErased int    `json:"payments_erased"`
+}
+
+type startBackfillRequest struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type backfillRunResponse struct {
+	RunID         string    `json:"run_id"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	Status        string    `json:"status"`
+	EventsWritten int       `json:"events_written"`
+	LastError     string    `json:"last_error,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type settlementBatchResponse struct {
+	BatchID        string    `json:"batch_id"`
+	Provider       string    `json:"provider"`
+	FileHash       string    `json:"file_hash"`
+	Status         string    `json:"status"`
+	Rows           int       `json:"rows"`
+	Matched        int       `json:"matched"`
+	AmountMismatch int       `json:"amount_mismatch"`
+	UnknownRef     int       `json:"unknown_ref"`
+	LastError      string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
 type errorResponse struct {
 	Error string `json:"error"`
-	Code  int    `json:"code"`
+	// Code is the machine-readable error, e.g. VALIDATION_ERROR
+	Code   string `json:"code"`
+	Status int    `json:"status"`
+	// RequestID is the X-Request-Id clients can quote to support
+	RequestID string `json:"request_id,omitempty"`
+	// Fields lists every rejected field of a VALIDATION_ERROR
+	Fields []fieldError `json:"fields,omitempty"`
+	// CurrentVersion is the stored version a PRECONDITION_FAILED write
+	// expected to find
+	CurrentVersion int `json:"current_version,omitempty"`
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// AdminServices back the /v1/admin routes, a nil service leaves its routes unmounted
+type AdminServices struct {
+	Erasure    *app.ErasureService
+	Backfill   *app.BackfillService
+	Settlement *app.SettlementService
+	// APIKeys also turns on API key auth for the payment routes
+	APIKeys *app.APIKeyService
+	// LogLevel is the level of the service logger, changeable at runtime
+	LogLevel *slog.LevelVar
 }
 
 type Handler struct {
-	svc *app.PaymentService
-	log *slog.Logger
+	svc   *app.PaymentService
+	admin AdminServices
+	// nil leaves /v1/webhook-endpoints unmounted
+	webhooks *app.WebhookService
+	// bounds JSON request bodies, zero uses DefaultMaxBodyBytes
+	maxBodyBytes int64
+	log          *slog.Logger
 }
 
-func NewHandler(svc *app.PaymentService, log *slog.Logger) *Handler {
-	return &Handler{svc: svc, log: log}
+func NewHandler(svc *app.PaymentService, admin AdminServices, webhooks *app.WebhookService, maxBodyBytes int64, log *slog.Logger) *Handler {
+	return &Handler{svc: svc, admin: admin, webhooks: webhooks, maxBodyBytes: maxBodyBytes, log: log}
 }
 
 func (h *Handler) initiatePayment(w http.ResponseWriter, r *http.Request) {
 	var body initiatePaymentRequest
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		writeError(w, http.StatusBadRequest, "cannot parse request body", "INVALID_JSON")
+	if !h.decodeJSON(w, r, &body) {
 		return
 	}
 
 	if headerKey := r.Header.Get("idempotency-key"); headerKey != "" {
 		body.IdempotencyKey = headerKey
 	}
+	amountCents, amountErr := requestAmountCents(body)
 
 	req := app.InitiatePaymentRequest{
-		OrderID:        body.OrderID,
-		CustomerID:     body.CustomerID,
-		AmountCents:    body.AmountCents,
-		Currency:       body.Currency,
-		IdempotencyKey: body.IdempotencyKey,
+		OrderID:         body.OrderID,
+		CustomerID:      body.CustomerID,
+		AmountCents:     amountCents,
+		Currency:        body.Currency,
+		IdempotencyKey:  body.IdempotencyKey,
+		ClientReference: body.ClientReference,
+		CorrelationID:   inboundCorrelationID(r),
+		CaptureMethod:   body.CaptureMethod,
+		Metadata:        body.Metadata,
+	}
+	if amountErr != nil {
+		// reported with every other rejected field, not on its own
+		writeValidationError(w, r, withAmountError(*amountErr, h.svc.ValidateInitiatePayment(req)))
+		return
 	}
 
-	if err := req.Validate(); err != nil {
-		writeError(w, http.StatusBadRequest, err.Error(), "VALIDATION_ERROR")
+	if isDryRun(r) {
+		h.dryRunInitiatePayment(w, r, req)
 		return
 	}
 
@@ -94,22 +260,546 @@ func (h *Handler) initiatePayment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, initiatePaymentResponse{
+	// the key may have come in the body, the middleware only echoes the header
+	w.Header().Set(idempotencyKeyHeader, req.IdempotencyKey)
+	w.Header().Set(correlationIDHeader, result.CorrelationID)
+	status := http.StatusCreated
+	if result.Replayed {
+		w.Header().Set(idempotencyReplayedHeader, "true")
+		status = http.StatusOK
+	}
+	h.respond(w, r, status, initiatePaymentResponse{
+		PaymentID:     result.PaymentID,
+		Status:        result.Status,
+		CorrelationID: result.CorrelationID,
+	})
+}
+
+func (h *Handler) capturePayment(w http.ResponseWriter, r *http.Request) {
+	// the body is optional, it only carries expected_version
+	var body capturePaymentRequest
+	if !h.decodeJSONWith(w, r, &body, decodeOptions{optional: true}) {
+		return
+	}
+	expected, verr := expectedVersion(r, body.ExpectedVersion)
+	if verr != nil {
+		writeValidationError(w, r, verr)
+		return
+	}
+
+	result, err := h.svc.CapturePayment(r.Context(), chi.URLParam(r, "paymentID"), r.Header.Get("Idempotency-Key"), expected)
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+
+	h.respond(w, r, http.StatusOK, capturePaymentResponse{
+		PaymentID: result.PaymentID,
+		Status:    result.Status,
+	})
+}
+
+func (h *Handler) cancelPayment(w http.ResponseWriter, r *http.Request) {
+	// the body is optional, an empty one cancels with the default reason
+	var body cancelPaymentRequest
+	if !h.decodeJSONWith(w, r, &body, decodeOptions{optional: true}) {
+		return
+	}
+
+	expected, verr := expectedVersion(r, body.ExpectedVersion)
+	if verr != nil {
+		writeValidationError(w, r, verr)
+		return
+	}
+
+	result, err := h.svc.CancelPayment(r.Context(), chi.URLParam(r, "paymentID"), body.Reason, expected)
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+
+	h.respond(w, r, http.StatusOK, cancelPaymentResponse{
 		PaymentID: result.PaymentID,
 		Status:    result.Status,
 	})
 }
 
+func (h *Handler) updatePaymentMetadata(w http.ResponseWriter, r *http.Request) {
+	var body updateMetadataRequest
+	if !h.decodeJSON(w, r, &body) {
+		return
+	}
+
+	expected, verr := expectedVersion(r, body.ExpectedVersion)
+	if verr != nil {
+		writeValidationError(w, r, verr)
+		return
+	}
+
+	req := app.UpdateMetadataRequest{
+		PaymentID:       chi.URLParam(r, "paymentID"),
+		Set:             map[string]string{},
+		ExpectedVersion: expected,
+	}
+	for k, v := range body.Metadata {
+		if v == nil {
+			req.Remove = append(req.Remove, k)
+			continue
+		}
+		req.Set[k] = *v
+	}
+
+	result, err := h.svc.UpdatePaymentMetadata(r.Context(), req)
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", paymentETag(result))
+	h.respond(w, r, http.StatusOK, toPaymentResponse(result))
+}
+
+func (h *Handler) refundPayment(w http.ResponseWriter, r *http.Request) {
+	// the body is optional, an empty one refunds the remaining amount
+	var body refundPaymentRequest
+	if !h.decodeJSONWith(w, r, &body, decodeOptions{optional: true}) {
+		return
+	}
+
+	result, err := h.svc.RefundPayment(r.Context(), app.RefundPaymentRequest{
+		PaymentID:      chi.URLParam(r, "paymentID"),
+		IdempotencyKey: r.Header.Get("Idempotency-Key"),
+		AmountCents:    body.AmountCents,
+		Reason:         body.Reason,
+	})
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+
+	h.respond(w, r, http.StatusCreated, toRefundResponse(result))
+}
+
+func (h *Handler) listRefunds(w http.ResponseWriter, r *http.Request) {
+	refunds, err := h.svc.ListRefunds(r.Context(), chi.URLParam(r, "paymentID"))
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+
+	resp := refundListResponse{Refunds: make([]refundResponse, 0, len(refunds))}
+	for _, refund := range refunds {
+		resp.Refunds = append(resp.Refunds, toRefundResponse(refund))
+	}
+	h.respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) paymentTimeline(w http.ResponseWriter, r *http.Request) {
+	paymentID := chi.URLParam(r, "paymentID")
+	entries, err := h.svc.Timeline(r.Context(), paymentID)
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+
+	resp := timelineResponse{PaymentID: paymentID, Timeline: make([]timelineEntryResponse, 0, len(entries))}
+	for _, e := range entries {
+		resp.Timeline = append(resp.Timeline, timelineEntryResponse{
+			FromStatus: e.FromStatus,
+			ToStatus:   e.ToStatus,
+			Reason:     e.Reason,
+			Actor:      e.Actor,
+			OccurredAt: e.OccurredAt,
+		})
+	}
+	h.respond(w, r, http.StatusOK, resp)
+}
+
+func toRefundResponse(d app.RefundDetails) refundResponse {
+	return refundResponse{
+		RefundID:      d.RefundID,
+		PaymentID:     d.PaymentID,
+		AmountCents:   d.AmountCents,
+		Currency:      d.Currency,
+		Status:        d.Status,
+		Reason:        d.Reason,
+		FailureReason: d.FailureReason,
+		CreatedAt:     d.CreatedAt,
+	}
+}
+
+// correlationIDHeader lets a caller thread its own trace id through a payment
+const correlationIDHeader = "X-Correlation-Id"
+
+// inboundCorrelationID adopts the caller's correlation id, a malformed one is
+// dropped rather than failing the payment and a fresh id is generated instead
+func inboundCorrelationID(r *http.Request) string {
+	id := r.Header.Get(correlationIDHeader)
+	if domain.ValidateCorrelationID(id) != nil {
+		return ""
+	}
+	return id
+}
+
+func (h *Handler) findPaymentsByCorrelationID(w http.ResponseWriter, r *http.Request) {
+	result, err := h.svc.FindByCorrelationID(r.Context(), r.URL.Query().Get("correlation_id"))
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+
+	resp := paymentListResponse{Payments: make([]paymentResponse, 0, len(result))}
+	for _, p := range result {
+		resp.Payments = append(resp.Payments, toPaymentResponse(p))
+	}
+	h.respond(w, r, http.StatusOK, resp)
+}
+
+// listPayments keeps ?order_id= as the unpaginated lookup checkout relies on,
+// every other query pages through the filtered list
+func (h *Handler) listPayments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Has("order_id") {
+		h.findPaymentsByOrderID(w, r)
+		return
+	}
+
+	req := app.ListPaymentsRequest{
+		CustomerID:      query.Get("customer_id"),
+		Status:          query.Get("status"),
+		Currency:        query.Get("currency"),
+		ClientReference: query.Get("client_reference"),
+		Cursor:          query.Get("cursor"),
+	}
+
+	for name, dst := range map[string]*time.Time{
+		"created_after":  &req.CreatedAfter,
+		"created_before": &req.CreatedBefore,
+	} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, name+" must be an RFC 3339 timestamp", "VALIDATION_ERROR")
+			return
+		}
+		*dst = t
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			writeError(w, r, http.StatusBadRequest, "limit must be a positive integer", "VALIDATION_ERROR")
+			return
+		}
+		req.Limit = limit
+	}
+
+	result, err := h.svc.ListPayments(r.Context(), req)
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+
+	resp := paymentPageResponse{
+		Payments:   make([]paymentResponse, 0, len(result.Payments)),
+		NextCursor: result.NextCursor,
+		HasMore:    result.NextCursor != "",
+	}
+	for _, p := range result.Payments {
+		resp.Payments = append(resp.Payments, toPaymentResponse(p))
+	}
+	h.respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) findPaymentsByOrderID(w http.ResponseWriter, r *http.Request) {
+	result, err := h.svc.FindByOrderID(r.Context(), r.URL.Query().Get("order_id"))
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+
+	resp := paymentListResponse{Payments: make([]paymentResponse, 0, len(result))}
+	for _, p := range result {
+		resp.Payments = append(resp.Payments, toPaymentResponse(p))
+	}
+	h.respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) getPayment(w http.ResponseWriter, r *http.Request) {
+	get := h.svc.GetPayment
+	// callers asking for freshness never share an in-flight load
+	if r.Header.Get("X-Min-Version") != "" || r.Header.Get("If-None-Match") != "" {
+		get = h.svc.GetPaymentFresh
+	}
+
+	result, err := get(r.Context(), chi.URLParam(r, "paymentID"))
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+	// pollers waiting for a status change mostly get an empty 304
+	if notModified(w, r, paymentETag(result)) {
+		return
+	}
+
+	h.respond(w, r, http.StatusOK, toPaymentResponse(result))
+}
+
+func toPaymentResponse(p app.PaymentDetails) paymentResponse {
+	return paymentResponse{
+		PaymentID:       p.PaymentID,
+		OrderID:         p.OrderID,
+		CustomerID:      p.CustomerID,
+		AmountCents:     p.AmountCents,
+		Currency:        p.Currency,
+		Status:          p.Status,
+		ProviderRef:     p.ProviderRef,
+		FailureReason:   p.FailureReason,
+		ClientReference: p.ClientReference,
+		CorrelationID:   p.CorrelationID,
+		Metadata:        p.Metadata,
+		CreatedAt:       p.CreatedAt,
+		UpdatedAt:       p.UpdatedAt,
+		Version:         p.Version,
+	}
+}
+
+func (h *Handler) dryRunInitiatePayment(w http.ResponseWriter, r *http.Request, req app.InitiatePaymentRequest) {
+	result, err := h.svc.DryRunInitiatePayment(r.Context(), req)
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+
+	h.respond(w, r, http.StatusOK, dryRunResponse{
+		Valid: true,
+		NormalizedRequest: initiatePaymentRequest{
+			OrderID:         result.Normalized.OrderID,
+			CustomerID:      result.Normalized.CustomerID,
+			AmountCents:     &result.Normalized.AmountCents,
+			Currency:        result.Normalized.Currency,
+			IdempotencyKey:  result.Normalized.IdempotencyKey,
+			ClientReference: result.Normalized.ClientReference,
+			CaptureMethod:   result.Normalized.CaptureMethod,
+			Metadata:        result.Normalized.Metadata,
+		},
+		CorrelationID: result.Normalized.CorrelationID,
+		Warnings:      result.Warnings,
+	})
+}
+
+func (h *Handler) eraseCustomer(w http.ResponseWriter, r *http.Request) {
+	result, err := h.admin.Erasure.EraseCustomer(r.Context(), chi.URLParam(r, "customerID"))
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+
+	h.respond(w, r, http.StatusOK, eraseCustomerResponse{
+		Pseudonym:      result.Pseudonym,
+		PaymentsErased: result.PaymentsErased,
+	})
+}
+
+func (h *Handler) startBackfill(w http.ResponseWriter, r *http.Request) {
+	var body startBackfillRequest
+	if !h.decodeJSON(w, r, &body) {
+		return
+	}
+
+	run, err := h.admin.Backfill.Start(r.Context(), body.From, body.To)
+	if err != nil {
+		h.mapBackfillError(w, r, err)
+		return
+	}
+	h.respond(w, r, http.StatusAccepted, toBackfillRunResponse(run))
+}
+
+func (h *Handler) resumeBackfill(w http.ResponseWriter, r *http.Request) {
+	runID, ok := parseRunID(w, r)
+	if !ok {
+		return
+	}
+
+	run, err := h.admin.Backfill.Resume(r.Context(), runID)
+	if err != nil {
+		h.mapBackfillError(w, r, err)
+		return
+	}
+	h.respond(w, r, http.StatusAccepted, toBackfillRunResponse(run))
+}
+
+func (h *Handler) getBackfill(w http.ResponseWriter, r *http.Request) {
+	runID, ok := parseRunID(w, r)
+	if !ok {
+		return
+	}
+
+	run, err := h.admin.Backfill.Get(r.Context(), runID)
+	if err != nil {
+		h.mapBackfillError(w, r, err)
+		return
+	}
+	h.respond(w, r, http.StatusOK, toBackfillRunResponse(run))
+}
+
+func (h *Handler) mapBackfillError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, "backfill run not found", "NOT_FOUND")
+	case errors.Is(err, app.ErrBackfillBusy):
+		w.Header().Set("Retry-After", "60")
+		writeError(w, r, http.StatusServiceUnavailable, err.Error(), "BACKFILL_BUSY")
+	default:
+		h.mapError(w, r, err)
+	}
+}
+
+// largest settlement file accepted in one upload
+const maxSettlementBytes = 512 << 20
+
+func (h *Handler) ingestSettlement(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, maxSettlementBytes)
+
+	batch, err := h.admin.Settlement.Ingest(r.Context(), r.URL.Query().Get("provider"), body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "settlement file too large", "PAYLOAD_TOO_LARGE")
+			return
+		}
+		h.mapError(w, r, err)
+		return
+	}
+	h.respond(w, r, http.StatusOK, toSettlementBatchResponse(batch))
+}
+
+func (h *Handler) getSettlement(w http.ResponseWriter, r *http.Request) {
+	batchID := chi.URLParam(r, "batchID")
+	if _, err := uuid.Parse(batchID); err != nil {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid batch ID: %q", batchID), "VALIDATION_ERROR")
+		return
+	}
+
+	batch, err := h.admin.Settlement.Get(r.Context(), batchID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			writeError(w, r, http.StatusNotFound, "settlement batch not found", "NOT_FOUND")
+			return
+		}
+		h.mapError(w, r, err)
+		return
+	}
+	h.respond(w, r, http.StatusOK, toSettlementBatchResponse(batch))
+}
+
+func toSettlementBatchResponse(b app.SettlementBatch) settlementBatchResponse {
+	return settlementBatchResponse{
+		BatchID:        b.ID,
+		Provider:       b.Provider,
+		FileHash:       b.FileHash,
+		Status:         b.Status,
+		Rows:           b.Rows,
+		Matched:        b.Matched,
+		AmountMismatch: b.AmountMismatch,
+		UnknownRef:     b.UnknownRef,
+		LastError:      b.LastError,
+		CreatedAt:      b.CreatedAt,
+		UpdatedAt:      b.UpdatedAt,
+	}
+}
+
+func parseRunID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	runID := chi.URLParam(r, "runID")
+	if _, err := uuid.Parse(runID); err != nil {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid run ID: %q", runID), "VALIDATION_ERROR")
+		return "", false
+	}
+	return runID, true
+}
+
+func toBackfillRunResponse(run app.BackfillRun) backfillRunResponse {
+	return backfillRunResponse{
+		RunID:         run.ID,
+		From:          run.From,
+		To:            run.To,
+		Status:        run.Status,
+		EventsWritten: run.EventsWritten,
+		LastError:     run.LastError,
+		UpdatedAt:     run.UpdatedAt,
+	}
+}
+
+// isDryRun reports whether the caller asked for validation only,
+// via ?dry_run=true or the X-Dry-Run header
+func isDryRun(r *http.Request) bool {
+	for _, v := range []string{r.URL.Query().Get("dry_run"), r.Header.Get("X-Dry-Run")} {
+		if ok, err := strconv.ParseBool(v); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// statusClientClosedRequest is nginx's status for a request the client
+// abandoned, it keeps those out of the 5xx rates
+const statusClientClosedRequest = 499
+
 // error mapping
 func (h *Handler) mapError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		verr  *app.ValidationError
+		stale *domain.VersionConflictError
+	)
 	switch {
+	case errors.As(err, &verr):
+		writeValidationError(w, r, verr)
+	case errors.Is(err, app.ErrInvalidRequest):
+		writeError(w, r, http.StatusBadRequest, err.Error(), "VALIDATION_ERROR")
+	case errors.Is(err, domain.ErrInvalidCursor):
+		writeError(w, r, http.StatusBadRequest, "cursor is invalid, restart from the first page", "INVALID_CURSOR")
 	case errors.Is(err, domain.ErrNotFound):
-		writeError(w, http.StatusNotFound, "payment not found", "NOT_FOUND")
+		writeError(w, r, http.StatusNotFound, "payment not found", "NOT_FOUND")
+	case errors.As(err, &stale):
+		// no Retry-After, repeating the same write cannot succeed
+		_ = writeJSON(w, http.StatusPreconditionFailed, errorResponse{
+			Error:          fmt.Sprintf("payment is at version %d, not %d, fetch it and decide again", stale.Current, stale.Expected),
+			Code:           "PRECONDITION_FAILED",
+			Status:         http.StatusPreconditionFailed,
+			RequestID:      middleware.GetReqID(r.Context()),
+			CurrentVersion: stale.Current,
+		})
 	case errors.Is(err, domain.ErrVersionConflict):
 		w.Header().Set("Retry-After", "1")
-		writeError(w, http.StatusConflict, "concurrent modification, please retry", "CONFLICT")
+		writeError(w, r, http.StatusConflict, "concurrent modification, please retry", "CONFLICT")
+	case errors.Is(err, app.ErrIdempotencyKeyInFlight):
+		w.Header().Set("Retry-After", "1")
+		writeError(w, r, http.StatusConflict, "a request with this idempotency key is in progress, please retry", "IDEMPOTENCY_KEY_IN_FLIGHT")
+	case errors.Is(err, app.ErrIdempotencyKeyReused):
+		writeError(w, r, http.StatusUnprocessableEntity, "idempotency key was already used with a different request", "IDEMPOTENCY_KEY_REUSED")
+	case errors.Is(err, domain.ErrAlreadyCaptured):
+		writeError(w, r, http.StatusConflict, "payment was already captured with a different idempotency key", "ALREADY_CAPTURED")
+	case errors.Is(err, domain.ErrOverRefund):
+		writeError(w, r, http.StatusUnprocessableEntity, err.Error(), "REFUND_EXCEEDS_PAYMENT")
+	case errors.Is(err, domain.ErrNotRefundable):
+		writeError(w, r, http.StatusUnprocessableEntity, err.Error(), "PAYMENT_NOT_REFUNDABLE")
+	case errors.Is(err, app.ErrProviderDeclined):
+		writeError(w, r, http.StatusUnprocessableEntity, err.Error(), "PROVIDER_DECLINED")
+	case errors.Is(err, app.ErrProviderUnavailable):
+		writeError(w, r, http.StatusBadGateway, "payment provider unavailable, retry with the same Idempotency-Key", "PROVIDER_UNAVAILABLE")
 	case errors.Is(err, domain.ErrInvalidTransition):
-		writeError(w, http.StatusUnprocessableEntity, err.Error(), "INVALID_STATE_TRANSITION")
+		writeError(w, r, http.StatusUnprocessableEntity, err.Error(), "INVALID_STATE_TRANSITION")
+	case errors.Is(err, context.DeadlineExceeded):
+		// usually a statement timeout, idempotency makes the retry safe
+		w.Header().Set("Retry-After", "1")
+		writeError(w, r, http.StatusServiceUnavailable, "the request timed out, retry with the same Idempotency-Key", "TIMEOUT")
+	case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+		// the client went away, nobody reads the response and nothing failed
+		h.log.DebugContext(r.Context(), "client closed the request", "err", err, "path", r.URL.Path, "method", r.Method)
+		writeError(w, r, statusClientClosedRequest, "the request was cancelled", "CLIENT_CLOSED_REQUEST")
 
 	default:
 		h.log.ErrorContext(r.Context(), "unhandled error in HTTP handler",
@@ -117,15 +807,23 @@ func (h *Handler) mapError(w http.ResponseWriter, r *http.Request, err error) {
 			"path", r.URL.Path,
 			"method", r.Method,
 		)
-		writeError(w, http.StatusInternalServerError, "an unexcepted error occurred", "INTERNAL_ERROR")
+		writeError(w, r, http.StatusInternalServerError, "an unexpected error occurred", "INTERNAL_ERROR")
 	}
 }
 
 // Server wraps *http.Server with graceful shutdown
 type Server struct {
-	inner   *http.Server
+	inner *http.Server
+	// metrics is nil when /metrics is served by inner
+	metrics *http.Server
 	log     *slog.Logger
 	timeout time.Duration
+	health  *healthState
+	// ln is the API listener once Listen bound it
+	ln net.Listener
+
+	preStopDelay                time.Duration
+	disableKeepAlivesOnShutdown bool
 }
 
 // ServerConfig groups all HTTP server tuning parameters
@@ -135,56 +833,324 @@ type ServerConfig struct {
 	WriteTimeout    time.Duration
 	IdleTimeout     time.Duration
 	ShutdownTimeout time.Duration
+
+	// AdminToken guards /v1/admin, admin routes are not mounted when empty
+	AdminToken string
+
+	// Signing enables signed-request auth on the API when callers are configured
+	Signing SigningConfig
+
+	// ProviderWebhookSecret verifies /v1/webhooks/provider, the route is not
+	// mounted when empty
+	ProviderWebhookSecret    string
+	ProviderWebhookTolerance time.Duration
+
+	ReadHeaderTimeout time.Duration
+	MaxHeaderBytes    int
+
+	// H2C serves HTTP/2 over cleartext connections
+	H2C                  bool
+	MaxConcurrentStreams int
+
+	DisableKeepAlivesOnShutdown bool
+	// PreStopDelay is how long readiness reports draining before the
+	// listener closes
+	PreStopDelay time.Duration
+
+	// ReadinessTimeout bounds each readiness check, DefaultReadinessTimeout
+	// when zero
+	ReadinessTimeout time.Duration
+	// ReadinessCacheTTL reuses readiness results this long, zero disables
+	ReadinessCacheTTL time.Duration
+
+	// MetricsAddr serves /metrics on a separate listener, on Addr when empty
+	MetricsAddr string
+	// CORS lets browsers call the API from other origins, off by default
+	CORS CORSConfig
+
+	// RequestTimeout bounds handlers on the API routes, zero leaves them to
+	// WriteTimeout. Admin routes run batch work and are not bounded.
+	RequestTimeout time.Duration
+	// PaymentsTimeout replaces RequestTimeout on /v1/payments when set
+	PaymentsTimeout time.Duration
+
+	// Compression gzips JSON and text responses of at least
+	// CompressionMinBytes, DefaultCompressionMinBytes when zero
+	Compression         bool
+	CompressionMinBytes int
+
+	// QuietPaths are path prefixes, e.g. probes and scrapes, whose requests
+	// are logged at debug unless they fail and are left out of the request
+	// metrics
+	QuietPaths []string
+	// DebugEndpoints serves pprof and runtime stats under /debug/ next to
+	// /metrics, the routes 404 when false
+	DebugEndpoints bool
+	// Metrics defaults to DefaultMetrics on the default registry
+	Metrics *Metrics
+
+	// Build is served on /version and exported as build_info
+	Build BuildInfo
+}
+
+// healthState holds the degraded dependencies seen by the last readiness run
+// and whether the server is shutting down
+type healthState struct {
+	mu       sync.RWMutex
+	degraded []string
+
+	draining atomic.Bool
+}
+
+func (h *healthState) set(degraded []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.degraded = degraded
 }
 
-// ReadinessCheck is a function that confirms a dependency is reachable
-type ReadinessCheck func(ctx context.Context) error
+func (h *healthState) get() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.degraded
+}
 
 func NewServer(cfg ServerConfig, h *Handler, checks []ReadinessCheck, log *slog.Logger) *Server {
 	r := chi.NewRouter()
+	health := &healthState{}
+
+	metrics := cfg.Metrics
+	if metrics == nil {
+		metrics = DefaultMetrics
+	}
+
+	var sig *signatureVerifier
+	if len(cfg.Signing.Callers) > 0 {
+		sig = newSignatureVerifier(cfg.Signing, log)
+	}
 
 	r.Use(middleware.RealIP)
 	r.Use(middleware.RequestID)
-	r.Use(middleware.Recoverer)
-	r.Use(requestLogger(log))
-	r.Use(prometheusMiddleware())
+	r.Use(logRequestID)
+	r.Use(recoverer(log, metrics))
+	// before logging and metrics, preflights are browser plumbing
+	r.Use(cors(cfg.CORS))
+	r.Use(requestLogger(log, cfg.QuietPaths))
+	r.Use(prometheusMiddleware(metrics, cfg.QuietPaths))
+	r.Use(degradedHeader(health))
+	r.Use(echoIdempotencyKey)
+	if cfg.Compression {
+		r.Use(compress(cfg.CompressionMinBytes))
+	}
+
+	metrics.buildInfo.WithLabelValues(cfg.Build.Version, cfg.Build.Commit).Set(1)
 
 	// k8s observability
 	r.Get("/healthz/live", livenessHandler())
-	r.Get("/healthz/ready", readinessHandler(checks))
+	r.Get("/version", versionHandler(cfg.Build, time.Now()))
+	r.Get("/healthz/ready", newReadiness(checks, cfg.ReadinessTimeout, cfg.ReadinessCacheTTL, health, metrics).handler)
+
+	var metricsServer *http.Server
+	if cfg.MetricsAddr == "" {
+		r.Handle("/metrics", metrics.Handler())
+		if cfg.DebugEndpoints {
+			r.Mount("/debug", debugHandler())
+		}
+	} else {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		if cfg.DebugEndpoints {
+			mux.Handle("/debug/", debugHandler())
+		}
+		metricsServer = &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		}
+	}
 
-	// routes
+	paymentsTimeout := cfg.PaymentsTimeout
+	if paymentsTimeout <= 0 {
+		paymentsTimeout = cfg.RequestTimeout
+	}
+
+	// routes, timeouts go on groups so they run after chi matched the route
 	r.Route("/v1/payments", func(r chi.Router) {
-		r.Post("/", h.initiatePayment)
-		//r.Get("/{paymentID}")
+		r.Use(authenticate(sig, h.admin.APIKeys, log))
+		r.Group(func(r chi.Router) {
+			r.Use(requestTimeout(paymentsTimeout, metrics))
+			r.With(requireScope(app.ScopePaymentsWrite)).Post("/", h.initiatePayment)
+			r.With(requireScope(app.ScopePaymentsRead)).Get("/", h.listPayments)
+			r.With(requireScope(app.ScopePaymentsRead)).Get("/{paymentID}", h.getPayment)
+			r.With(requireScope(app.ScopePaymentsWrite)).Post("/{paymentID}/capture", h.capturePayment)
+			r.With(requireScope(app.ScopePaymentsWrite)).Post("/{paymentID}/cancel", h.cancelPayment)
+			r.With(requireScope(app.ScopePaymentsWrite)).Patch("/{paymentID}/metadata", h.updatePaymentMetadata)
+			r.With(requireScope(app.ScopeRefundsWrite)).Post("/{paymentID}/refunds", h.refundPayment)
+			r.With(requireScope(app.ScopePaymentsRead)).Get("/{paymentID}/refunds", h.listRefunds)
+			r.With(requireScope(app.ScopePaymentsRead)).Get("/{paymentID}/timeline", h.paymentTimeline)
+		})
 	})
 
+	if h.webhooks != nil {
+		r.Route("/v1/webhook-endpoints", func(r chi.Router) {
+			r.Use(authenticate(sig, h.admin.APIKeys, log))
+			r.Group(func(r chi.Router) {
+				r.Use(requestTimeout(cfg.RequestTimeout, metrics))
+				r.With(requireScope(app.ScopePaymentsWrite)).Post("/", h.createWebhookEndpoint)
+				r.With(requireScope(app.ScopePaymentsRead)).Get("/", h.listWebhookEndpoints)
+				r.With(requireScope(app.ScopePaymentsRead)).Get("/{endpointID}", h.getWebhookEndpoint)
+				r.With(requireScope(app.ScopePaymentsWrite)).Patch("/{endpointID}", h.updateWebhookEndpoint)
+				r.With(requireScope(app.ScopePaymentsWrite)).Delete("/{endpointID}", h.deleteWebhookEndpoint)
+				r.With(requireScope(app.ScopePaymentsRead)).Get("/{endpointID}/deliveries", h.listWebhookDeliveries)
+			})
+		})
+	}
+
+	if cfg.ProviderWebhookSecret != "" {
+		r.With(verifyProviderSignature(cfg.ProviderWebhookSecret, cfg.ProviderWebhookTolerance), requestTimeout(cfg.RequestTimeout, metrics)).
+			Post("/v1/webhooks/provider", h.providerWebhook)
+	}
+
+	if cfg.AdminToken != "" {
+		r.Route("/v1/admin", func(r chi.Router) {
+			r.Use(adminAuth(cfg.AdminToken))
+			r.Get("/payments", h.findPaymentsByCorrelationID)
+			if h.admin.Erasure != nil {
+				r.Post("/customers/{customerID}/erase", h.eraseCustomer)
+			}
+			if h.admin.Backfill != nil {
+				r.Post("/outbox/backfills", h.startBackfill)
+				r.Get("/outbox/backfills/{runID}", h.getBackfill)
+				r.Post("/outbox/backfills/{runID}/resume", h.resumeBackfill)
+			}
+			if h.admin.Settlement != nil {
+				r.Post("/settlements", h.ingestSettlement)
+				r.Get("/settlements/{batchID}", h.getSettlement)
+			}
+			if h.admin.APIKeys != nil {
+				r.Post("/api-keys", h.createAPIKey)
+				r.Get("/api-keys", h.listAPIKeys)
+				r.Delete("/api-keys/{keyID}", h.revokeAPIKey)
+			}
+			if h.admin.LogLevel != nil {
+				r.Get("/log-level", h.getLogLevel)
+				r.Put("/log-level", h.setLogLevel)
+			}
+		})
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(cfg.H2C)
+
 	return &Server{
 		inner: &http.Server{
-			Addr:         cfg.Addr,
-			Handler:      r,
-			ReadTimeout:  cfg.ReadTimeout,
-			WriteTimeout: cfg.WriteTimeout,
-			IdleTimeout:  cfg.IdleTimeout,
+			Addr:              cfg.Addr,
+			Handler:           r,
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+			Protocols:         protocols,
+			HTTP2: &http.HTTP2Config{
+				MaxConcurrentStreams: cfg.MaxConcurrentStreams,
+			},
+			ConnState: trackConnState,
 		},
+		metrics: metricsServer,
 		log:     log,
 		timeout: cfg.ShutdownTimeout,
+		health:  health,
+
+		preStopDelay:                cfg.PreStopDelay,
+		disableKeepAlivesOnShutdown: cfg.DisableKeepAlivesOnShutdown,
+	}
+}
+
+// Handler is the API router, e.g. to serve it from httptest
+func (s *Server) Handler() http.Handler {
+	return s.inner.Handler
+}
+
+// Listen binds the API address, and the metrics address when it is separate,
+// so a taken port fails startup before anything is served. The returned
+// address has the port the system picked for ":0".
+func (s *Server) Listen() (net.Addr, error) {
+	if s.metrics != nil {
+		ln, err := net.Listen("tcp", s.metrics.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("metrics listener: %w", err)
+		}
+		s.log.Info("metrics server listening", "addr", ln.Addr().String())
+		go func() {
+			if err := s.metrics.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.log.Error("metrics server stopped", "err", err)
+			}
+		}()
+	}
+
+	addr := s.inner.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("http server: %w", err)
 	}
+	s.ln = ln
+	s.log.Info("HTTP server listening", "addr", ln.Addr().String())
+	return ln.Addr(), nil
 }
 
+// Start serves the API until Shutdown, binding first unless Listen did
 func (s *Server) Start() error {
-	s.log.Info("HTTP server listening", "addr", s.inner.Addr)
-	if err := s.inner.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	if s.ln == nil {
+		if _, err := s.Listen(); err != nil {
+			return err
+		}
+	}
+	return s.serve(s.ln)
+}
+
+// serve answers the API on ln until Shutdown, stalled request headers get a
+// 408 instead of a silent close
+func (s *Server) serve(ln net.Listener) error {
+	if err := s.inner.Serve(headerTimeoutListener{ln}); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("http server: %w", err)
 	}
 	return nil
 }
 
+// Shutdown fails readiness at once, keeps serving for the pre-stop delay
+// while load balancers notice, then drains in-flight requests
 func (s *Server) Shutdown(ctx context.Context) error {
 	shutCtx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
+
+	s.health.draining.Store(true)
+	if s.preStopDelay > 0 {
+		s.log.Info("HTTP server draining, readiness fails", "pre_stop_delay", s.preStopDelay.String())
+		t := time.NewTimer(s.preStopDelay)
+		select {
+		case <-t.C:
+		case <-shutCtx.Done():
+			t.Stop()
+		}
+	}
+
 	s.log.Info("HTTP server shutting down gracefully")
-	return s.inner.Shutdown(shutCtx)
+	if s.disableKeepAlivesOnShutdown {
+		// idle connections close now instead of waiting for IdleTimeout
+		s.inner.SetKeepAlivesEnabled(false)
+	}
+	err := s.inner.Shutdown(shutCtx)
+
+	// metrics stay scrapeable while the API drains
+	if s.metrics != nil {
+		err = errors.Join(err, s.metrics.Shutdown(shutCtx))
+	}
+	return err
 }
 
 // health probes
@@ -199,83 +1165,209 @@ func livenessHandler() http.HandlerFunc {
 	}
 }
 
-func readinessHandler(checks []ReadinessCheck) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
-		defer cancel()
-
-		for _, check := range checks {
-			if err := check(ctx); err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusServiceUnavailable)
-				body, _ := json.Marshal(map[string]string{
-					"status": "degraded",
-					"error":  err.Error(),
-				})
-				_, _ = w.Write(body)
-				return
-			}
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotency-Replayed"
+)
+
+// echoIdempotencyKey returns the caller's Idempotency-Key on every response,
+// errors included, so clients can match responses to retries
+func echoIdempotencyKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+			w.Header().Set(idempotencyKeyHeader, key)
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(`{"status":"ok"}`))
+// logRequestID makes the request ID part of every log record written with
+// the request's context
+func logRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := logging.WithRequestID(r.Context(), middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// degradedHeader flags responses served while a degraded dependency is down
+// so downstream callers can adapt
+func degradedHeader(health *healthState) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if degraded := health.get(); len(degraded) > 0 {
+				w.Header().Set("X-Degraded", strings.Join(degraded, ","))
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
 }
 
-func requestLogger(log *slog.Logger) func(http.Handler) http.Handler {
+func requestLogger(log *slog.Logger, quietPaths []string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			returned := false
 
 			defer func() {
-				log.InfoContext(r.Context(), "http request",
+				status := responseStatus(ww, returned)
+				// a failing probe stays visible
+				level := slog.LevelInfo
+				if hasPathPrefix(r.URL.Path, quietPaths) && status < http.StatusInternalServerError {
+					level = slog.LevelDebug
+				}
+				log.Log(r.Context(), level, "http request",
 					"method", r.Method,
 					"path", r.URL.Path,
-					"status", ww.Status(),
+					"status", status,
 					"duration", time.Since(start).Milliseconds(),
 					"request_id", middleware.GetReqID(r.Context()),
 					"bytes", ww.BytesWritten())
 			}()
 
 			next.ServeHTTP(ww, r)
+			returned = true
+		})
+	}
+}
+
+func hasPathPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseStatus is the status recorded for a request. A handler that
+// panicked counts as 500 even if it wrote a status before, one that wrote
+// nothing gets net/http's implicit 200.
+func responseStatus(ww middleware.WrapResponseWriter, returned bool) int {
+	switch {
+	case !returned:
+		return http.StatusInternalServerError
+	case ww.Status() == 0:
+		return http.StatusOK
+	}
+	return ww.Status()
+}
+
+// adminAuth requires the static admin bearer token
+func adminAuth(token string) func(http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, want) != 1 {
+				writeError(w, r, http.StatusUnauthorized, "missing or invalid admin token", "UNAUTHORIZED")
+				return
+			}
+			next.ServeHTTP(w, r)
 		})
 	}
 }
 
 // records RED metrics per route
-func prometheusMiddleware() func(http.Handler) http.Handler {
+func prometheusMiddleware(metrics *Metrics, quietPaths []string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hasPathPrefix(r.URL.Path, quietPaths) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			start := time.Now()
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			returned := false
 
 			defer func() {
-				route := chi.RouteContext(r.Context()).RoutePattern()
-				if route == "" {
-					route = "unknown"
-				}
+				route := routePattern(r)
 
-				statusCode := fmt.Sprintf("%d", ww.Status())
-				httpRequestsTotal.WithLabelValues(r.Method, route, statusCode).Inc()
-				httpRequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+				statusCode := strconv.Itoa(responseStatus(ww, returned))
+				metrics.requestsTotal.WithLabelValues(r.Method, route, statusCode).Inc()
+				metrics.requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
 			}()
 
 			next.ServeHTTP(ww, r)
+			returned = true
 		})
 	}
 }
 
-func writeJSON(w http.ResponseWriter, status int, v any) {
+// largest JSON body a handler may produce
+const maxResponseBytes = 8 << 20
+
+// fallback body when a response cannot be encoded
+const internalErrorBody = `{"error":"an unexpected error occurred","code":"INTERNAL_ERROR","status":500}` + "\n"
+
+// routePattern is the matched chi route, "unknown" for unmatched requests
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if route := rctx.RoutePattern(); route != "" {
+			return route
+		}
+	}
+	return "unknown"
+}
+
+// writeJSON encodes v fully before writing anything, so an encoding failure
+// becomes a well-formed 500 instead of a truncated body behind a 2xx status.
+// The returned error is for logging, the response has already been written.
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(v)
+	if err == nil && buf.Len() > maxResponseBytes {
+		err = fmt.Errorf("response of %d bytes exceeds %d byte limit", buf.Len(), maxResponseBytes)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(internalErrorBody))
+		return fmt.Errorf("encode response: %w", err)
+	}
+
 	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(v); err != nil {
-		return
+	_, _ = w.Write(buf.Bytes())
+	return nil
+}
+
+// respond writes v as JSON and logs encoding failures with the route
+func (h *Handler) respond(w http.ResponseWriter, r *http.Request, status int, v any) {
+	if err := writeJSON(w, status, v); err != nil {
+		h.log.ErrorContext(r.Context(), "cannot write JSON response",
+			"err", err,
+			"route", routePattern(r),
+			"method", r.Method,
+			"status", status,
+		)
+	}
+}
+
+func writeValidationError(w http.ResponseWriter, r *http.Request, verr *app.ValidationError) {
+	fields := make([]fieldError, 0, len(verr.Fields))
+	for _, f := range verr.Fields {
+		fields = append(fields, fieldError{Field: f.Field, Message: f.Message})
 	}
+	_ = writeJSON(w, http.StatusBadRequest, errorResponse{
+		Error:     "validation failed",
+		Code:      "VALIDATION_ERROR",
+		Status:    http.StatusBadRequest,
+		RequestID: middleware.GetReqID(r.Context()),
+		Fields:    fields,
+	})
 }
 
-func writeError(w http.ResponseWriter, status int, message, code string) {
-	writeJSON(w, status, errorResponse{Error: message, Code: status})
+func writeError(w http.ResponseWriter, r *http.Request, status int, message, code string) {
+	_ = writeJSON(w, status, errorResponse{
+		Error:     message,
+		Code:      code,
+		Status:    status,
+		RequestID: middleware.GetReqID(r.Context()),
+	})
 }
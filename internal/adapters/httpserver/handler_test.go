@@ -0,0 +1,132 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+func TestMapErrorCancelled(t *testing.T) {
+	tests := []struct {
+		name string
+		// the client hung up before the error came back
+		clientGone bool
+		wantStatus int
+		wantLog    bool
+	}{
+		{name: "client went away", clientGone: true, wantStatus: statusClientClosedRequest},
+		{name: "cancelled under a live request", wantStatus: http.StatusInternalServerError, wantLog: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			h := NewHandler(nil, AdminServices{}, nil, 0, slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.clientGone {
+				cancel()
+			}
+			r := httptest.NewRequest(http.MethodGet, "/v1/payments/p-1", nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+			h.mapError(w, r, fmt.Errorf("find payment: %w", context.Canceled))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if logged := logs.Len() > 0; logged != tt.wantLog {
+				t.Fatalf("logged %q, want a log %v", logs.String(), tt.wantLog)
+			}
+		})
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// the error envelope is part of the API, every code mapError answers with
+// is locked down in testdata/errors
+func TestMapErrorGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		err    error
+		// the client hung up before the error came back
+		clientGone bool
+	}{
+		{golden: "validation_error_fields", err: &app.ValidationError{Fields: []app.FieldError{
+			{Field: "amount_cents", Message: "must be positive"},
+			{Field: "currency", Message: "is not supported"},
+		}}},
+		{golden: "validation_error", err: fmt.Errorf("%w: payment id is malformed", app.ErrInvalidRequest)},
+		{golden: "invalid_cursor", err: domain.ErrInvalidCursor},
+		{golden: "not_found", err: domain.ErrNotFound},
+		{golden: "precondition_failed", err: &domain.VersionConflictError{Expected: 2, Current: 3}},
+		{golden: "conflict", err: domain.ErrVersionConflict},
+		{golden: "idempotency_key_in_flight", err: app.ErrIdempotencyKeyInFlight},
+		{golden: "idempotency_key_reused", err: app.ErrIdempotencyKeyReused},
+		{golden: "already_captured", err: domain.ErrAlreadyCaptured},
+		{golden: "refund_exceeds_payment", err: fmt.Errorf("%w: 20.00 EUR left", domain.ErrOverRefund)},
+		{golden: "payment_not_refundable", err: fmt.Errorf("%w: payment is PENDING", domain.ErrNotRefundable)},
+		{golden: "provider_declined", err: fmt.Errorf("%w: insufficient_funds", app.ErrProviderDeclined)},
+		{golden: "provider_unavailable", err: app.ErrProviderUnavailable},
+		{golden: "invalid_state_transition", err: fmt.Errorf("%w: COMPLETED -> CANCELLED", domain.ErrInvalidTransition)},
+		{golden: "timeout", err: fmt.Errorf("find payment: %w", context.DeadlineExceeded)},
+		{golden: "client_closed_request", err: context.Canceled, clientGone: true},
+		{golden: "internal_error", err: errors.New("connection reset by peer")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			h := NewHandler(nil, AdminServices{}, nil, 0, slog.New(slog.DiscardHandler))
+
+			ctx, cancel := context.WithCancel(context.WithValue(context.Background(), middleware.RequestIDKey, "req-1"))
+			defer cancel()
+			if tt.clientGone {
+				cancel()
+			}
+			r := httptest.NewRequest(http.MethodGet, "/v1/payments/p-1", nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+			h.mapError(w, r, tt.err)
+
+			var body struct {
+				Status int `json:"status"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Status != w.Code {
+				t.Fatalf("status %d, body %q: %v", w.Code, w.Body, err)
+			}
+			// writeJSON ends the body with a newline, Indent keeps it
+			var got bytes.Buffer
+			if err := json.Indent(&got, w.Body.Bytes(), "", "  "); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join("testdata", "errors", tt.golden+".json")
+			if *update {
+				if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v, run the tests with -update to create it", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Fatalf("body:\n%s\nwant %s:\n%s", got.Bytes(), path, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/logging"
+)
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type logLevelResponse struct {
+	Level string `json:"level"`
+}
+
+func (h *Handler) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, r, http.StatusOK, logLevelResponse{Level: strings.ToLower(h.admin.LogLevel.Level().String())})
+}
+
+// setLogLevel changes the level until the next restart, LOG_LEVEL applies
+// again after that
+func (h *Handler) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var body logLevelRequest
+	if !h.decodeJSON(w, r, &body) {
+		return
+	}
+	level, err := logging.ParseLevel(body.Level)
+	if err != nil {
+		writeValidationError(w, r, &app.ValidationError{Fields: []app.FieldError{{Field: "level", Message: "must be debug, info, warn or error"}}})
+		return
+	}
+
+	previous := h.admin.LogLevel.Level()
+	h.admin.LogLevel.Set(level)
+	// warn so the change shows at any level
+	h.log.WarnContext(r.Context(), "log level changed", "from", previous.String(), "to", level.String())
+	h.respond(w, r, http.StatusOK, logLevelResponse{Level: strings.ToLower(level.String())})
+}
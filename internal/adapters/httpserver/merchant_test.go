@@ -0,0 +1,118 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// apiKeyStore keeps API keys in memory
+type apiKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]app.APIKey
+}
+
+func (s *apiKeyStore) CreateAPIKey(_ context.Context, k app.APIKey) (app.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k.ID, k.CreatedAt = domain.NewPaymentID().String(), time.Now()
+	s.keys[k.Prefix] = k
+	return k, nil
+}
+
+func (s *apiKeyStore) FindAPIKeyByPrefix(_ context.Context, prefix string) (app.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[prefix]
+	if !ok {
+		return app.APIKey{}, domain.ErrNotFound
+	}
+	return k, nil
+}
+
+func (s *apiKeyStore) ListAPIKeys(context.Context, string) ([]app.APIKey, error) { return nil, nil }
+
+func (s *apiKeyStore) RevokeAPIKey(context.Context, string) error { return nil }
+
+// merchant B's payments do not exist for merchant A's key, whatever it does
+// with them, and A's idempotency keys never replay B's payments
+func TestAPIKeysSeeOnlyTheirMerchantsPayments(t *testing.T) {
+	log := slog.New(slog.DiscardHandler)
+	kv := memory.NewKeyValueStore()
+	svc := app.NewPaymentService(memory.NewRepository(), kv, time.Hour, kv, mockprovider.New(time.Second),
+		app.RequestLimits{Currencies: []string{"EUR"}, MaxAmountCents: 100000}, nil, log)
+	keys := app.NewAPIKeyService(&apiKeyStore{keys: map[string]app.APIKey{}}, log)
+	api := NewServer(ServerConfig{}, NewHandler(svc, AdminServices{APIKeys: keys}, nil, 0, log), nil, log).inner.Handler
+
+	keyFor := func(merchantID string) string {
+		k, err := keys.Create(context.Background(), app.CreateAPIKeyRequest{MerchantID: merchantID, Scopes: app.APIKeyScopes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k.Key
+	}
+	keyA, keyB := keyFor("merchant-a"), keyFor("merchant-b")
+
+	as := func(key, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-API-Key", key)
+		r.Header.Set("Idempotency-Key", "idem-op")
+		if body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		return w
+	}
+	initiate := func(key string) string {
+		t.Helper()
+		w := as(key, http.MethodPost, "/v1/payments", initiateBody)
+		var body struct {
+			PaymentID string `json:"payment_id"`
+			Replayed  bool   `json:"replayed"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusCreated || body.Replayed {
+			t.Fatalf("initiate: status %d: %s", w.Code, w.Body)
+		}
+		return body.PaymentID
+	}
+
+	paymentB := initiate(keyB)
+	for _, op := range []struct{ method, path, body string }{
+		{http.MethodGet, "/v1/payments/" + paymentB, ""},
+		{http.MethodPost, "/v1/payments/" + paymentB + "/capture", ""},
+		{http.MethodPost, "/v1/payments/" + paymentB + "/cancel", `{"reason":"duplicate"}`},
+		{http.MethodPost, "/v1/payments/" + paymentB + "/refunds", `{"amount_cents":100}`},
+		{http.MethodGet, "/v1/payments/" + paymentB + "/refunds", ""},
+		{http.MethodGet, "/v1/payments/" + paymentB + "/timeline", ""},
+	} {
+		if w := as(keyA, op.method, op.path, op.body); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s with merchant A's key: status %d, want 404: %s", op.method, op.path, w.Code, w.Body)
+		}
+	}
+
+	w := as(keyA, http.MethodGet, "/v1/payments", "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), paymentB) {
+		t.Fatalf("merchant A's listing: status %d: %s", w.Code, w.Body)
+	}
+
+	// the same idempotency key is a new payment for another merchant
+	if paymentA := initiate(keyA); paymentA == paymentB {
+		t.Fatalf("merchant A's request replayed merchant B's payment %s", paymentB)
+	}
+
+	if w := as(keyB, http.MethodGet, "/v1/payments/"+paymentB, ""); w.Code != http.StatusOK {
+		t.Fatalf("merchant B reading its payment: status %d: %s", w.Code, w.Body)
+	}
+}
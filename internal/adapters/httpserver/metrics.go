@@ -0,0 +1,109 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the HTTP collectors so servers can share or isolate a registry
+type Metrics struct {
+	requestsTotal      *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	degradedDependency *prometheus.GaugeVec
+	buildInfo          *prometheus.GaugeVec
+	panicsTotal        *prometheus.CounterVec
+	timeoutsTotal      *prometheus.CounterVec
+
+	gatherer prometheus.Gatherer
+}
+
+// DefaultMetrics is registered on the default registry and used when a
+// ServerConfig leaves Metrics nil
+var DefaultMetrics = NewMetrics(prometheus.DefaultRegisterer)
+
+// NewMetrics registers the HTTP collectors on reg. Collectors already on reg
+// are reused, so building several servers against one registry does not panic.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		requestsTotal: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gopay_service",
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Total HTTP requests partitioned by method, path and status code.",
+		}, []string{"method", "path", "status_code"})),
+
+		requestDuration: register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "gopay_service",
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request duration in seconds.",
+			Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"method", "route"})),
+
+		degradedDependency: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "gopay_service",
+			Name:      "degraded",
+			Help:      "1 while a non-critical dependency is failing its readiness check.",
+		}, []string{"dependency"})),
+
+		panicsTotal: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gopay_service",
+			Subsystem: "http",
+			Name:      "panics_total",
+			Help:      "Handler panics recovered, partitioned by route.",
+		}, []string{"route"})),
+
+		timeoutsTotal: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gopay_service",
+			Subsystem: "http",
+			Name:      "timeouts_total",
+			Help:      "Requests answered with 503 because the handler exceeded its route timeout, partitioned by route.",
+		}, []string{"route"})),
+
+		buildInfo: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "gopay_service",
+			Name:      "build_info",
+			Help:      "Always 1, labelled with the version and commit of the running build.",
+		}, []string{"version", "commit"})),
+	}
+
+	// go_goroutines only exists on registries with the Go collector
+	register(reg, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "gopay_service",
+		Name:      "goroutines",
+		Help:      "Number of goroutines that currently exist.",
+	}, func() float64 { return float64(runtime.NumGoroutine()) }))
+
+	if g, ok := reg.(prometheus.Gatherer); ok && reg != prometheus.DefaultRegisterer {
+		m.gatherer = g
+	}
+	return m
+}
+
+// Handler serves the registry the metrics were registered on, a registerer
+// that cannot gather falls back to the default registry
+func (m *Metrics) Handler() http.Handler {
+	if m.gatherer == nil {
+		return promhttp.Handler()
+	}
+	return promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{})
+}
+
+// register returns the collector already registered under the same
+// descriptor instead of panicking on duplicate registration
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
@@ -0,0 +1,214 @@
+package httpserver
+
+import (
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+// newTestServer serves the API on the memory adapters without auth, the
+// metrics go to cfg.Metrics
+func newTestServer(t *testing.T, cfg ServerConfig) *Server {
+	t.Helper()
+	log := slog.New(slog.DiscardHandler)
+	kv := memory.NewKeyValueStore()
+	svc := app.NewPaymentService(memory.NewRepository(), kv, time.Hour, kv, mockprovider.New(time.Second),
+		app.RequestLimits{Currencies: []string{"EUR", "USD"}, MaxAmountCents: 100000}, nil, log)
+	return NewServer(cfg, NewHandler(svc, AdminServices{}, nil, 0, log), nil, log)
+}
+
+func serve(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+const initiateBody = `{"order_id":"order-1","customer_id":"cus-1","amount_cents":1999,"currency":"EUR","idempotency_key":"idem-1"}`
+
+func TestMetricsScrape(t *testing.T) {
+	s := newTestServer(t, ServerConfig{Metrics: NewMetrics(prometheus.NewRegistry()), QuietPaths: []string{"/healthz/", "/metrics"}})
+	api := s.inner.Handler
+
+	if w := serve(t, api, http.MethodPost, "/v1/payments", initiateBody); w.Code != http.StatusCreated {
+		t.Fatalf("initiate: status %d: %s", w.Code, w.Body)
+	}
+
+	w := serve(t, api, http.MethodGet, "/metrics", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("scrape: status %d", w.Code)
+	}
+	for _, want := range []string{
+		`gopay_service_http_requests_total{method="POST",path="/v1/payments",status_code="201"} 1`,
+		`gopay_service_http_request_duration_seconds_count{method="POST",route="/v1/payments"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("scrape is missing %s", want)
+		}
+	}
+	// the scrape itself is a quiet path
+	if strings.Contains(w.Body.String(), `path="/metrics"`) {
+		t.Errorf("scrapes are counted as requests")
+	}
+}
+
+// with METRICS_ADDR /metrics leaves the API listener, the probes stay on it
+func TestMetricsOnSeparateListener(t *testing.T) {
+	s := newTestServer(t, ServerConfig{MetricsAddr: "127.0.0.1:0", Metrics: NewMetrics(prometheus.NewRegistry())})
+	if s.metrics == nil {
+		t.Fatal("no metrics listener")
+	}
+	api, scrape := s.inner.Handler, s.metrics.Handler
+
+	if w := serve(t, api, http.MethodGet, "/metrics", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("API /metrics: status %d, want 404", w.Code)
+	}
+	for _, path := range []string{"/healthz/live", "/healthz/ready"} {
+		if w := serve(t, api, http.MethodGet, path, ""); w.Code != http.StatusOK {
+			t.Fatalf("API %s: status %d, want 200", path, w.Code)
+		}
+		if w := serve(t, scrape, http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+			t.Fatalf("metrics listener %s: status %d, want 404", path, w.Code)
+		}
+	}
+
+	if w := serve(t, api, http.MethodPost, "/v1/payments", initiateBody); w.Code != http.StatusCreated {
+		t.Fatalf("initiate: status %d: %s", w.Code, w.Body)
+	}
+	w := serve(t, scrape, http.MethodGet, "/metrics", "")
+	body, _ := io.ReadAll(w.Body)
+	if w.Code != http.StatusOK || !strings.Contains(string(body), `gopay_service_http_requests_total{method="POST",path="/v1/payments",status_code="201"} 1`) {
+		t.Fatalf("scrape: status %d, body:\n%s", w.Code, body)
+	}
+}
+
+// counterValue gathers the counter name with exactly the given labels, zero
+// when it was never incremented
+func counterValue(t *testing.T, g prometheus.Gatherer, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			got := map[string]string{}
+			for _, l := range m.GetLabel() {
+				got[l.GetName()] = l.GetValue()
+			}
+			if maps.Equal(got, labels) {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// counted is the number of payments initiated through the API
+func counted(t *testing.T, g prometheus.Gatherer) float64 {
+	t.Helper()
+	return counterValue(t, g, "gopay_service_http_requests_total",
+		map[string]string{"method": "POST", "path": "/v1/payments", "status_code": "201"})
+}
+
+func TestMetricsRegistries(t *testing.T) {
+	t.Run("two servers on one registry", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		public := newTestServer(t, ServerConfig{Metrics: NewMetrics(reg)})
+		admin := newTestServer(t, ServerConfig{Metrics: NewMetrics(reg)})
+
+		for _, s := range []*Server{public, admin} {
+			if w := serve(t, s.inner.Handler, http.MethodPost, "/v1/payments", initiateBody); w.Code != http.StatusCreated {
+				t.Fatalf("initiate: status %d: %s", w.Code, w.Body)
+			}
+		}
+		if got := counted(t, reg); got != 2 {
+			t.Fatalf("counted %v requests, want both servers on the shared collector", got)
+		}
+	})
+
+	t.Run("separate registries", func(t *testing.T) {
+		first, second := prometheus.NewRegistry(), prometheus.NewRegistry()
+		s := newTestServer(t, ServerConfig{Metrics: NewMetrics(first)})
+		newTestServer(t, ServerConfig{Metrics: NewMetrics(second)})
+
+		if w := serve(t, s.inner.Handler, http.MethodPost, "/v1/payments", initiateBody); w.Code != http.StatusCreated {
+			t.Fatalf("initiate: status %d: %s", w.Code, w.Body)
+		}
+		if got := counted(t, first); got != 1 {
+			t.Fatalf("first registry counted %v, want 1", got)
+		}
+		if got := counted(t, second); got != 0 {
+			t.Fatalf("second registry counted %v, want 0", got)
+		}
+	})
+
+	// the default registry is shared by the whole test binary
+	t.Run("default registry", func(t *testing.T) {
+		if NewMetrics(prometheus.DefaultRegisterer).requestsTotal != DefaultMetrics.requestsTotal {
+			t.Fatal("registering again on the default registry did not reuse its collectors")
+		}
+	})
+}
+
+// dashboards and alerts query these names and labels
+func TestMetricsNamesAndLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(reg)
+	m.requestsTotal.WithLabelValues("GET", "/v1/payments", "200").Inc()
+	m.requestDuration.WithLabelValues("GET", "/v1/payments").Observe(0.01)
+	m.degradedDependency.WithLabelValues("redis").Set(0)
+	m.panicsTotal.WithLabelValues("/v1/payments").Inc()
+	m.timeoutsTotal.WithLabelValues("/v1/payments").Inc()
+	m.buildInfo.WithLabelValues("v1", "abc").Set(1)
+
+	want := map[string][]string{
+		"gopay_service_http_requests_total":           {"method", "path", "status_code"},
+		"gopay_service_http_request_duration_seconds": {"method", "route"},
+		"gopay_service_degraded":                      {"dependency"},
+		"gopay_service_http_panics_total":             {"route"},
+		"gopay_service_http_timeouts_total":           {"route"},
+		"gopay_service_build_info":                    {"commit", "version"},
+		"gopay_service_goroutines":                    nil,
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]string{}
+	for _, f := range families {
+		var labels []string
+		for _, l := range f.GetMetric()[0].GetLabel() {
+			labels = append(labels, l.GetName())
+		}
+		got[f.GetName()] = labels
+	}
+	if len(got) != len(want) {
+		t.Errorf("gathered %d families, want %d: %v", len(got), len(want), got)
+	}
+	for name, labels := range want {
+		if !slices.Equal(got[name], labels) {
+			t.Errorf("%s labels = %v, want %v", name, got[name], labels)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultReadinessTimeout bounds each readiness check when none is configured
+const DefaultReadinessTimeout = time.Second
+
+// Severity decides what a failing readiness check does to the pod
+type Severity int
+
+const (
+	// SeverityCritical failures take the pod out of rotation with a 503
+	SeverityCritical Severity = iota
+	// SeverityDegraded failures keep serving but are reported and flagged
+	SeverityDegraded
+)
+
+// ReadinessCheck confirms a dependency is reachable
+type ReadinessCheck struct {
+	Name     string
+	Severity Severity
+	Check    func(ctx context.Context) error
+}
+
+// check results as reported per dependency
+const (
+	checkOK      = "ok"
+	checkTimeout = "timeout"
+	checkFailed  = "failed"
+)
+
+// readinessReport is the body of /healthz/ready
+type readinessReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+	// failure messages of the checks that did not pass
+	Errors map[string]string `json:"errors,omitempty"`
+	// soft checks that fail without taking the pod out of rotation
+	Degraded []string `json:"degraded,omitempty"`
+
+	critical bool
+}
+
+// readiness runs every check side by side, each within its own timeout, and
+// reuses the outcome for cacheTTL so frequent probes do not reach the
+// databases on every request
+type readiness struct {
+	checks   []ReadinessCheck
+	timeout  time.Duration
+	cacheTTL time.Duration
+	health   *healthState
+	metrics  *Metrics
+
+	// held while checks run so concurrent probes share one run
+	mu      sync.Mutex
+	last    readinessReport
+	checked time.Time
+}
+
+func newReadiness(checks []ReadinessCheck, timeout, cacheTTL time.Duration, health *healthState, metrics *Metrics) *readiness {
+	if timeout <= 0 {
+		timeout = DefaultReadinessTimeout
+	}
+	return &readiness{checks: checks, timeout: timeout, cacheTTL: cacheTTL, health: health, metrics: metrics}
+}
+
+func (rd *readiness) handler(w http.ResponseWriter, r *http.Request) {
+	if rd.health.draining.Load() {
+		_ = writeJSON(w, http.StatusServiceUnavailable, readinessReport{Status: "draining"})
+		return
+	}
+
+	report := rd.report(context.WithoutCancel(r.Context()))
+	status := http.StatusOK
+	if report.critical {
+		status = http.StatusServiceUnavailable
+	}
+	_ = writeJSON(w, status, report)
+}
+
+func (rd *readiness) report(ctx context.Context) readinessReport {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if !rd.checked.IsZero() && time.Since(rd.checked) < rd.cacheTTL {
+		return rd.last
+	}
+
+	errs := make([]error, len(rd.checks))
+	var wg sync.WaitGroup
+	for i, check := range rd.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, rd.timeout)
+			defer cancel()
+			errs[i] = check.Check(checkCtx)
+		}()
+	}
+	wg.Wait()
+
+	report := readinessReport{Status: "ok", Checks: make(map[string]string, len(rd.checks))}
+	for i, check := range rd.checks {
+		err := errs[i]
+		switch {
+		case err == nil:
+			report.Checks[check.Name] = checkOK
+		case errors.Is(err, context.DeadlineExceeded):
+			report.Checks[check.Name] = checkTimeout
+		default:
+			report.Checks[check.Name] = checkFailed
+		}
+		if err != nil {
+			if report.Errors == nil {
+				report.Errors = map[string]string{}
+			}
+			report.Errors[check.Name] = err.Error()
+		}
+
+		if check.Severity == SeverityDegraded {
+			if err != nil {
+				report.Degraded = append(report.Degraded, check.Name)
+				rd.metrics.degradedDependency.WithLabelValues(check.Name).Set(1)
+			} else {
+				rd.metrics.degradedDependency.WithLabelValues(check.Name).Set(0)
+			}
+			continue
+		}
+		if err != nil {
+			report.critical = true
+			report.Status = "degraded"
+		}
+	}
+	// a failed critical check says nothing new about the soft ones, keep the
+	// X-Degraded header as it was
+	if !report.critical {
+		rd.health.set(report.Degraded)
+	}
+
+	rd.last, rd.checked = report, time.Now()
+	return report
+}
@@ -0,0 +1,152 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+func gaugeValue(t *testing.T, g prometheus.Gatherer, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			got := map[string]string{}
+			for _, l := range m.GetLabel() {
+				got[l.GetName()] = l.GetValue()
+			}
+			if maps.Equal(got, labels) {
+				return m.GetGauge().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// dependency outcomes of the matrix below
+const (
+	up      = ""
+	down    = "down"
+	stalled = "stalled"
+)
+
+func dependencyCheck(name string, severity Severity, outcome string) ReadinessCheck {
+	return ReadinessCheck{Name: name, Severity: severity, Check: func(ctx context.Context) error {
+		switch outcome {
+		case down:
+			return errors.New(name + " unreachable")
+		case stalled:
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}}
+}
+
+// postgres takes the pod out of rotation, redis and the outbox backlog only
+// degrade it, the severities main wires by default
+func TestReadinessFailureMatrix(t *testing.T) {
+	tests := []struct {
+		name                    string
+		postgres, redis, outbox string
+
+		status   int
+		body     string
+		checks   map[string]string
+		degraded []string
+	}{
+		{name: "all up", postgres: up, redis: up, outbox: up, status: http.StatusOK, body: "ok",
+			checks: map[string]string{"postgres": checkOK, "redis": checkOK, "outbox": checkOK}},
+		{name: "postgres down", postgres: down, status: http.StatusServiceUnavailable, body: "degraded",
+			checks: map[string]string{"postgres": checkFailed, "redis": checkOK, "outbox": checkOK}},
+		{name: "postgres stalled", postgres: stalled, status: http.StatusServiceUnavailable, body: "degraded",
+			checks: map[string]string{"postgres": checkTimeout, "redis": checkOK, "outbox": checkOK}},
+		{name: "redis down", redis: down, status: http.StatusOK, body: "ok",
+			checks:   map[string]string{"postgres": checkOK, "redis": checkFailed, "outbox": checkOK},
+			degraded: []string{"redis"}},
+		{name: "outbox backlog", outbox: down, status: http.StatusOK, body: "ok",
+			checks:   map[string]string{"postgres": checkOK, "redis": checkOK, "outbox": checkFailed},
+			degraded: []string{"outbox"}},
+		{name: "redis stalled and outbox backlog", redis: stalled, outbox: down, status: http.StatusOK, body: "ok",
+			checks:   map[string]string{"postgres": checkOK, "redis": checkTimeout, "outbox": checkFailed},
+			degraded: []string{"redis", "outbox"}},
+		{name: "everything down", postgres: down, redis: down, outbox: down, status: http.StatusServiceUnavailable, body: "degraded",
+			checks:   map[string]string{"postgres": checkFailed, "redis": checkFailed, "outbox": checkFailed},
+			degraded: []string{"redis", "outbox"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			log := slog.New(slog.DiscardHandler)
+			kv := memory.NewKeyValueStore()
+			svc := app.NewPaymentService(memory.NewRepository(), kv, time.Hour, kv, mockprovider.New(time.Second),
+				app.RequestLimits{}, nil, log)
+			s := NewServer(ServerConfig{Metrics: NewMetrics(reg), ReadinessTimeout: 20 * time.Millisecond},
+				NewHandler(svc, AdminServices{}, nil, 0, log), []ReadinessCheck{
+					dependencyCheck("postgres", SeverityCritical, tt.postgres),
+					dependencyCheck("redis", SeverityDegraded, tt.redis),
+					dependencyCheck("outbox", SeverityDegraded, tt.outbox),
+				}, log)
+			api := s.inner.Handler
+
+			w := serve(t, api, http.MethodGet, "/healthz/ready", "")
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			var report readinessReport
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.body || !maps.Equal(report.Checks, tt.checks) {
+				t.Fatalf("report %+v, want status %s and checks %v", report, tt.body, tt.checks)
+			}
+			if !slices.Equal(report.Degraded, tt.degraded) {
+				t.Fatalf("degraded %v, want %v", report.Degraded, tt.degraded)
+			}
+			for name, result := range tt.checks {
+				if _, reported := report.Errors[name]; reported != (result != checkOK) {
+					t.Errorf("%s is %s, error reported: %v", name, result, reported)
+				}
+			}
+
+			for _, name := range []string{"redis", "outbox"} {
+				want := 0.0
+				if slices.Contains(tt.degraded, name) {
+					want = 1
+				}
+				if got := gaugeValue(t, reg, "gopay_service_degraded", map[string]string{"dependency": name}); got != want {
+					t.Errorf("degraded gauge of %s = %v, want %v", name, got, want)
+				}
+			}
+
+			// a critical failure leaves the header as the last healthy run set it
+			wantHeader := ""
+			if tt.status == http.StatusOK {
+				wantHeader = strings.Join(tt.degraded, ",")
+			}
+			if got := serve(t, api, http.MethodGet, "/healthz/live", "").Header().Get("X-Degraded"); got != wantHeader {
+				t.Fatalf("X-Degraded = %q, want %q", got, wantHeader)
+			}
+		})
+	}
+}
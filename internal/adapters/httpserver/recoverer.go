@@ -0,0 +1,53 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// recoverer turns a handler panic into the JSON 500 of every other error,
+// logs the stack and counts it. http.ErrAbortHandler is re-panicked so
+// net/http aborts the response quietly, as the handler asked.
+func recoverer(log *slog.Logger, metrics *Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(rec)
+				}
+
+				// a panic passed on by requestTimeout brings its own stack
+				stack := debug.Stack()
+				if hp, ok := rec.(handlerPanic); ok {
+					rec, stack = hp.value, hp.stack
+				}
+
+				route := routePattern(r)
+				metrics.panicsTotal.WithLabelValues(route).Inc()
+				log.ErrorContext(r.Context(), "handler panicked",
+					"panic", fmt.Sprint(rec),
+					"method", r.Method,
+					"route", route,
+					"stack", string(stack))
+
+				// a response already under way cannot become a 500 anymore
+				if ww.Status() == 0 {
+					writeError(ww, r, http.StatusInternalServerError, "an unexpected error occurred", "INTERNAL_ERROR")
+				}
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func panicking(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("after") {
+	case "write":
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("partial"))
+	case "abort":
+		panic(http.ErrAbortHandler)
+	}
+	panic("deliberate")
+}
+
+func TestRecoverer(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		route string
+		// wantStatus zero means the panic reaches net/http
+		wantStatus int
+		wantJSON   bool
+	}{
+		{name: "panic", path: "/boom/1", route: "/boom/{id}", wantStatus: http.StatusInternalServerError, wantJSON: true},
+		{name: "panic behind a route timeout", path: "/slow/1", route: "/slow/{id}", wantStatus: http.StatusInternalServerError, wantJSON: true},
+		{name: "panic after the response started", path: "/boom/1?after=write", route: "/boom/{id}", wantStatus: http.StatusAccepted},
+		{name: "abort", path: "/boom/1?after=abort", route: "/boom/{id}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			metrics := NewMetrics(reg)
+			var logs bytes.Buffer
+			log := slog.New(slog.NewJSONHandler(&logs, nil))
+
+			r := chi.NewRouter()
+			r.Use(middleware.RequestID)
+			r.Use(recoverer(log, metrics))
+			r.Get("/boom/{id}", panicking)
+			r.With(requestTimeout(time.Second, metrics)).Get("/slow/{id}", panicking)
+
+			w := httptest.NewRecorder()
+			var repanicked any
+			func() {
+				defer func() { repanicked = recover() }()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			}()
+
+			panics := counterValue(t, reg, "gopay_service_http_panics_total", map[string]string{"route": tt.route})
+			if tt.wantStatus == 0 {
+				if repanicked != http.ErrAbortHandler {
+					t.Fatalf("recovered %v, want http.ErrAbortHandler passed on", repanicked)
+				}
+				if panics != 0 || logs.Len() != 0 {
+					t.Fatalf("abort counted %v times and logged %q, want it left alone", panics, logs.String())
+				}
+				return
+			}
+
+			if repanicked != nil {
+				t.Fatalf("panic escaped: %v", repanicked)
+			}
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if panics != 1 {
+				t.Fatalf("panics_total = %v, want 1", panics)
+			}
+
+			var entry struct {
+				Msg   string `json:"msg"`
+				Panic string `json:"panic"`
+				Route string `json:"route"`
+				Stack string `json:"stack"`
+			}
+			if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+				t.Fatalf("log %q: %v", logs.String(), err)
+			}
+			// the stack is the one the panic happened on, not the recoverer's
+			if entry.Msg != "handler panicked" || entry.Panic != "deliberate" || entry.Route != tt.route || !strings.Contains(entry.Stack, "httpserver.panicking") {
+				t.Fatalf("logged %+v", entry)
+			}
+
+			if !tt.wantJSON {
+				return
+			}
+			var body errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q: %v", w.Body, err)
+			}
+			if body.Code != "INTERNAL_ERROR" || body.Status != http.StatusInternalServerError || body.RequestID == "" {
+				t.Fatalf("body = %+v, want INTERNAL_ERROR with the request id", body)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("Content-Type = %q", ct)
+			}
+		})
+	}
+}
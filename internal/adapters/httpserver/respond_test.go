@@ -0,0 +1,82 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// a value encoding/json rejects never goes out behind a 2xx status with a
+// truncated body, the client gets a JSON 500 and the log names the route
+func TestRespondWithAnUnencodableValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		// empty when the value encodes
+		wantErr string
+	}{
+		{name: "encodable", value: map[string]any{"payment_id": "pay-1", "amount": 1.5}},
+		{name: "channel", value: map[string]any{"payment_id": "pay-1", "events": make(chan int)}, wantErr: "unsupported type"},
+		{name: "function", value: struct{ Next func() }{Next: func() {}}, wantErr: "unsupported type"},
+		{name: "NaN", value: map[string]float64{"rate": math.NaN()}, wantErr: "unsupported value"},
+		{name: "over the size limit", value: strings.Repeat("x", maxResponseBytes), wantErr: "exceeds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			h := &Handler{log: slog.New(slog.NewJSONHandler(&logs, nil))}
+			r := chi.NewRouter()
+			r.Get("/v1/things/{thingID}", func(w http.ResponseWriter, r *http.Request) {
+				h.respond(w, r, http.StatusCreated, tt.value)
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/things/42", nil))
+
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("Content-Type = %q", ct)
+			}
+			if tt.wantErr == "" {
+				if w.Code != http.StatusCreated || logs.Len() != 0 {
+					t.Fatalf("status %d, logged %q, want 201 and nothing logged", w.Code, logs.String())
+				}
+				return
+			}
+
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want 500", w.Code)
+			}
+			var body errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q is not JSON: %v", w.Body, err)
+			}
+			if body.Code != "INTERNAL_ERROR" || body.Status != http.StatusInternalServerError {
+				t.Fatalf("body = %+v, want INTERNAL_ERROR", body)
+			}
+
+			var entry struct {
+				Level  string `json:"level"`
+				Msg    string `json:"msg"`
+				Err    string `json:"err"`
+				Route  string `json:"route"`
+				Method string `json:"method"`
+				Status int    `json:"status"`
+			}
+			if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+				t.Fatalf("log %q: %v", logs.String(), err)
+			}
+			if entry.Level != "ERROR" || entry.Msg != "cannot write JSON response" || entry.Route != "/v1/things/{thingID}" ||
+				entry.Method != http.MethodGet || entry.Status != http.StatusCreated || !strings.Contains(entry.Err, tt.wantErr) {
+				t.Fatalf("logged %+v, want the route, the intended status and %q", entry, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// listen serves s on an httptest listener the way Start does
+func listen(t *testing.T, s *Server) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config = s.inner
+	srv.Listener = headerTimeoutListener{srv.Listener}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func dial(t *testing.T, srv *httptest.Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+const liveRequest = "GET /healthz/live HTTP/1.1\r\nHost: gopay\r\n\r\n"
+
+// keepAlive sends a complete request on conn and reads its response, the
+// connection stays open
+func keepAlive(t *testing.T, conn net.Conn, br *bufio.Reader) {
+	t.Helper()
+	if _, err := io.WriteString(conn, liveRequest); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Close {
+		t.Fatalf("status %d, close %v, want a kept-alive 200", resp.StatusCode, resp.Close)
+	}
+}
+
+func TestStalledHeadersGetRequestTimeout(t *testing.T) {
+	const readHeaderTimeout = 50 * time.Millisecond
+
+	tests := []struct {
+		name string
+		// before is sent first, its response is read before the stall
+		before bool
+		stall  string
+		want   string
+	}{
+		{name: "first request", stall: "GET /healthz/live HTTP/1.1\r\nHost: gopay\r\n", want: "HTTP/1.1 408 Request Timeout"},
+		{name: "after a kept-alive request", before: true, stall: "GET /healthz/live HTTP/1.1\r\n", want: "HTTP/1.1 408 Request Timeout"},
+		// nothing of a request arrived, an idle close is not a timeout
+		{name: "idle connection", before: true, stall: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, ServerConfig{
+				ReadHeaderTimeout: readHeaderTimeout,
+				// passes before the stall, the 408 must still go out
+				WriteTimeout: 10 * time.Millisecond,
+				IdleTimeout:  readHeaderTimeout,
+				Metrics:      NewMetrics(prometheus.NewRegistry()),
+			})
+			conn := dial(t, listen(t, s))
+			br := bufio.NewReader(conn)
+			if tt.before {
+				keepAlive(t, conn, br)
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			started := time.Now()
+			if _, err := io.WriteString(conn, tt.stall); err != nil {
+				t.Fatal(err)
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			got, err := io.ReadAll(br)
+			if err != nil {
+				t.Fatalf("read: %v, want the server to close the connection", err)
+			}
+			if elapsed := time.Since(started); elapsed < readHeaderTimeout/2 {
+				t.Fatalf("closed after %s, before the timeout", elapsed)
+			}
+
+			if tt.want == "" {
+				if len(got) != 0 {
+					t.Fatalf("got %q, want a close without a reply", got)
+				}
+				return
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(string(got))), nil)
+			if err != nil {
+				t.Fatalf("response %q: %v", got, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if !strings.HasPrefix(string(got), tt.want) || !resp.Close || !strings.Contains(string(body), `"code":"REQUEST_TIMEOUT"`) {
+				t.Fatalf("got %q, want %s with a JSON error and Connection: close", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShutdownClosesIdleKeepAlives(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		name := "keep-alives left on"
+		if disable {
+			name = "keep-alives disabled on shutdown"
+		}
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t, ServerConfig{
+				// idle connections would otherwise stay open this long
+				IdleTimeout:                 time.Minute,
+				ShutdownTimeout:             5 * time.Second,
+				DisableKeepAlivesOnShutdown: disable,
+				Metrics:                     NewMetrics(prometheus.NewRegistry()),
+			})
+			srv := listen(t, s)
+
+			conns := make([]net.Conn, 3)
+			readers := make([]*bufio.Reader, len(conns))
+			for i := range conns {
+				conns[i] = dial(t, srv)
+				readers[i] = bufio.NewReader(conns[i])
+				keepAlive(t, conns[i], readers[i])
+			}
+
+			started := time.Now()
+			if err := s.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(started); elapsed > time.Second {
+				t.Fatalf("shutdown took %s with only idle connections", elapsed)
+			}
+
+			for i, conn := range conns {
+				_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+				if _, err := readers[i].ReadByte(); !errors.Is(err, io.EOF) {
+					t.Fatalf("connection %d: read err = %v, want closed by the server", i, err)
+				}
+			}
+		})
+	}
+}
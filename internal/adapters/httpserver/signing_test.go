@@ -0,0 +1,158 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+var signingNow = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newTestVerifier(nonces NonceStore) *signatureVerifier {
+	v := newSignatureVerifier(SigningConfig{
+		Callers: map[string]string{"billing": testSecret},
+		Scopes:  []string{"payments:read"},
+		Window:  5 * time.Minute,
+		Nonces:  nonces,
+	}, slog.New(slog.DiscardHandler))
+	v.now = func() time.Time { return signingNow }
+	return v
+}
+
+// signedRequest signs signedURI and sends the request to sentURI
+func signedRequest(method, signedURI, sentURI, body string, ts time.Time) *http.Request {
+	r := httptest.NewRequest(method, sentURI, strings.NewReader(body))
+	r.Header.Set(headerCallerID, "billing")
+	r.Header.Set(headerSignatureTimestamp, strconv.FormatInt(ts.Unix(), 10))
+	r.Header.Set(headerSignature, SignRequest(testSecret, method, signedURI, []byte(body), ts))
+	return r
+}
+
+func TestSignatureVerify(t *testing.T) {
+	body := `{"order_id":"o-1"}`
+
+	tests := []struct {
+		name       string
+		req        *http.Request
+		wantReason string
+	}{
+		{name: "signed", req: signedRequest(http.MethodPost, "/v1/payments", "/v1/payments", body, signingNow)},
+		{name: "signed with a query", req: signedRequest(http.MethodPost, "/v1/payments?dry_run=true", "/v1/payments?dry_run=true", body, signingNow)},
+		{name: "query added", req: signedRequest(http.MethodPost, "/v1/payments", "/v1/payments?dry_run=true", body, signingNow), wantReason: "invalid signature"},
+		{name: "query dropped", req: signedRequest(http.MethodPost, "/v1/payments?dry_run=true", "/v1/payments", body, signingNow), wantReason: "invalid signature"},
+		{name: "query changed", req: signedRequest(http.MethodGet, "/v1/payments?limit=10", "/v1/payments?limit=500", "", signingNow), wantReason: "invalid signature"},
+		{name: "other path", req: signedRequest(http.MethodPost, "/v1/payments", "/v1/payments/p-1/cancel", body, signingNow), wantReason: "invalid signature"},
+		{name: "body changed", req: func() *http.Request {
+			r := signedRequest(http.MethodPost, "/v1/payments", "/v1/payments", body, signingNow)
+			r.Body = http.NoBody
+			return r
+		}(), wantReason: "invalid signature"},
+		{name: "unknown caller", req: func() *http.Request {
+			r := signedRequest(http.MethodPost, "/v1/payments", "/v1/payments", body, signingNow)
+			r.Header.Set(headerCallerID, "someone")
+			return r
+		}(), wantReason: "unknown caller"},
+		{name: "timestamp not a number", req: func() *http.Request {
+			r := signedRequest(http.MethodPost, "/v1/payments", "/v1/payments", body, signingNow)
+			r.Header.Set(headerSignatureTimestamp, "yesterday")
+			return r
+		}(), wantReason: "invalid signature timestamp"},
+		{name: "timestamp re-signed by another", req: func() *http.Request {
+			r := signedRequest(http.MethodPost, "/v1/payments", "/v1/payments", body, signingNow)
+			r.Header.Set(headerSignatureTimestamp, strconv.FormatInt(signingNow.Add(time.Second).Unix(), 10))
+			return r
+		}(), wantReason: "invalid signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, reason := newTestVerifier(memory.NewKeyValueStore()).verify(tt.req)
+			if reason != tt.wantReason {
+				t.Fatalf("reason = %q, want %q", reason, tt.wantReason)
+			}
+			if tt.wantReason == "" && (principal.ID != "billing" || principal.AuthMethod != "signature") {
+				t.Fatalf("principal = %+v", principal)
+			}
+		})
+	}
+}
+
+func TestSignatureClockSkew(t *testing.T) {
+	window := 5 * time.Minute
+
+	for _, tt := range []struct {
+		skew time.Duration
+		ok   bool
+	}{
+		{0, true},
+		{window, true},
+		{-window, true},
+		{window + time.Second, false},
+		{-window - time.Second, false},
+		{time.Hour, false},
+		{-time.Hour, false},
+	} {
+		// the caller's clock is skew ahead of ours
+		r := signedRequest(http.MethodGet, "/v1/payments", "/v1/payments", "", signingNow.Add(tt.skew))
+		_, reason := newTestVerifier(memory.NewKeyValueStore()).verify(r)
+		if ok := reason == ""; ok != tt.ok {
+			t.Errorf("skew %s: reason %q, want accepted %v", tt.skew, reason, tt.ok)
+		}
+		if !tt.ok && reason != "signature timestamp outside the accepted window" {
+			t.Errorf("skew %s: reason %q", tt.skew, reason)
+		}
+	}
+}
+
+func TestSignatureReplay(t *testing.T) {
+	now := signingNow
+	v := newTestVerifier(memory.NewKeyValueStore())
+	v.now = func() time.Time { return now }
+	send := func(uri string) string {
+		_, reason := v.verify(signedRequest(http.MethodPost, uri, uri, `{}`, now))
+		return reason
+	}
+
+	if reason := send("/v1/payments"); reason != "" {
+		t.Fatalf("first request rejected: %s", reason)
+	}
+	if reason := send("/v1/payments"); reason != "replayed request" {
+		t.Fatalf("replay: reason %q, want replayed request", reason)
+	}
+	// another query is another request, not a replay
+	if reason := send("/v1/payments?dry_run=true"); reason != "" {
+		t.Fatalf("request with a query rejected: %s", reason)
+	}
+
+	// a later request of the same content carries another timestamp
+	now = now.Add(time.Second)
+	if reason := send("/v1/payments"); reason != "" {
+		t.Fatalf("request a second later rejected: %s", reason)
+	}
+}
+
+type failingNonces struct{}
+
+func (failingNonces) Remember(context.Context, string, time.Duration) (bool, error) {
+	return false, errors.New("redis down")
+}
+
+// without the nonce store the timestamp window is the only replay protection
+func TestSignatureNonceStoreDown(t *testing.T) {
+	v := newTestVerifier(failingNonces{})
+	for range 2 {
+		if _, reason := v.verify(signedRequest(http.MethodPost, "/v1/payments", "/v1/payments", `{}`, signingNow)); reason != "" {
+			t.Fatalf("reason = %q, want the request accepted", reason)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// requestTimeout cancels the request context after d and answers 503 TIMEOUT
+// if the handler has not returned by then. The handler writes to a buffer
+// that is copied out when it returns in time, so a late write cannot mix
+// with the timeout response. Use it per route, e.g. through Group or With,
+// so the handler goroutine does not race chi's routing. Zero d disables it.
+func requestTimeout(d time.Duration, metrics *Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := &timeoutWriter{header: make(http.Header)}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						if p != http.ErrAbortHandler {
+							p = handlerPanic{value: p, stack: debug.Stack()}
+						}
+						panicked <- p
+						return
+					}
+					close(done)
+				}()
+				next.ServeHTTP(tw, r)
+			}()
+
+			select {
+			case p := <-panicked:
+				// the recoverer up the chain answers it
+				panic(p)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.flushTo(w)
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+				if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					// the client went away, nobody reads a response
+					return
+				}
+				metrics.timeoutsTotal.WithLabelValues(routePattern(r)).Inc()
+				w.Header().Set("Retry-After", "1")
+				writeError(w, r, http.StatusServiceUnavailable, "the request timed out, retry with the same Idempotency-Key", "TIMEOUT")
+			}
+		})
+	}
+}
+
+// handlerPanic carries a panic out of the handler goroutine with the stack
+// it happened on
+type handlerPanic struct {
+	value any
+	stack []byte
+}
+
+// timeoutWriter buffers a response until the handler returns, writes after
+// the timeout fail with http.ErrHandlerTimeout
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// flushTo copies the buffered response to w, callers hold mu
+func (tw *timeoutWriter) flushTo(w http.ResponseWriter) {
+	dst := w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	w.WriteHeader(tw.code)
+	_, _ = w.Write(tw.buf.Bytes())
+}
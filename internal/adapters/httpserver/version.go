@@ -0,0 +1,37 @@
+package httpserver
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// BuildInfo identifies the running build, main fills it from its ldflags
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildTime string
+}
+
+type versionResponse struct {
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	BuildTime     string `json:"build_time"`
+	GoVersion     string `json:"go_version"`
+	StartedAt     string `json:"started_at"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+}
+
+// versionHandler reports the build and how long the server has been up
+func versionHandler(build BuildInfo, started time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_ = writeJSON(w, http.StatusOK, versionResponse{
+			Version:       build.Version,
+			Commit:        build.Commit,
+			BuildTime:     build.BuildTime,
+			GoVersion:     runtime.Version(),
+			StartedAt:     started.UTC().Format(time.RFC3339),
+			UptimeSeconds: int64(time.Since(started).Seconds()),
+		})
+	}
+}
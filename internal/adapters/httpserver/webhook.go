@@ -0,0 +1,153 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+// headerProviderSignature carries t=<unix>,v1=<hex hmac>, the scheme Stripe
+// uses. Several v1 entries are allowed while a secret is rotated.
+const headerProviderSignature = "Stripe-Signature"
+
+// SignProviderWebhook computes a v1 value: hex HMAC-SHA256 over the unix
+// timestamp and the raw body joined by a dot
+func SignProviderWebhook(secret string, body []byte, ts time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts.Unix(), 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyProviderSignature rejects webhooks not signed with secret or signed
+// more than tolerance ago, which bounds how long a captured request can be
+// replayed
+func verifyProviderSignature(secret string, tolerance time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ts, signatures := parseProviderSignature(r.Header.Get(headerProviderSignature))
+			if ts.IsZero() || len(signatures) == 0 {
+				writeError(w, r, http.StatusBadRequest, "missing or malformed signature header", "INVALID_SIGNATURE")
+				return
+			}
+			if age := time.Since(ts); age > tolerance || age < -tolerance {
+				writeError(w, r, http.StatusBadRequest, "signature timestamp outside the accepted window", "INVALID_SIGNATURE")
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodyBytes+1))
+			if err != nil || len(body) > maxSignedBodyBytes {
+				writeError(w, r, http.StatusBadRequest, "cannot read signed body", "INVALID_SIGNATURE")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			want := []byte(SignProviderWebhook(secret, body, ts))
+			for _, sig := range signatures {
+				if hmac.Equal(want, []byte(sig)) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			writeError(w, r, http.StatusBadRequest, "invalid signature", "INVALID_SIGNATURE")
+		})
+	}
+}
+
+func parseProviderSignature(header string) (time.Time, []string) {
+	var (
+		ts         time.Time
+		signatures []string
+	)
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+				ts = time.Unix(unix, 0)
+			}
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	return ts, signatures
+}
+
+// providerWebhookEvent is the subset of a Stripe event the service reads
+type providerWebhookEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID string `json:"id"`
+			// the metadata of the authorization, payment_id is our id
+			Metadata         map[string]string `json:"metadata"`
+			LastPaymentError *struct {
+				Code        string `json:"code"`
+				DeclineCode string `json:"decline_code"`
+			} `json:"last_payment_error"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// toProviderEvent returns false for event types the service does not act on
+func (e providerWebhookEvent) toProviderEvent() (app.ProviderEvent, bool) {
+	evt := app.ProviderEvent{ID: e.ID, PaymentID: e.Data.Object.Metadata["payment_id"], ProviderRef: e.Data.Object.ID}
+	switch e.Type {
+	case "payment_intent.succeeded":
+		evt.Type = app.ProviderPaymentSucceeded
+	case "payment_intent.payment_failed":
+		evt.Type = app.ProviderPaymentFailed
+		evt.FailureReason = "payment_failed"
+		if pe := e.Data.Object.LastPaymentError; pe != nil && pe.DeclineCode != "" {
+			evt.FailureReason = pe.DeclineCode
+		} else if pe != nil && pe.Code != "" {
+			evt.FailureReason = pe.Code
+		}
+	case "payment_intent.canceled":
+		evt.Type = app.ProviderPaymentFailed
+		evt.FailureReason = "canceled_by_provider"
+	default:
+		return app.ProviderEvent{}, false
+	}
+	return evt, true
+}
+
+// providerWebhook acknowledges everything it will never be able to apply,
+// unknown references included, so the provider stops redelivering them
+func (h *Handler) providerWebhook(w http.ResponseWriter, r *http.Request) {
+	var body providerWebhookEvent
+	// the provider owns the schema and may add fields at any time
+	if !h.decodeJSONWith(w, r, &body, decodeOptions{allowUnknownFields: true}) {
+		return
+	}
+
+	evt, ok := body.toProviderEvent()
+	if !ok || evt.ProviderRef == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	err := h.svc.ApplyProviderEvent(r.Context(), evt)
+	if errors.Is(err, app.ErrUnknownProviderRef) {
+		h.log.WarnContext(r.Context(), "provider webhook for unknown payment",
+			"event_id", evt.ID,
+			"provider_ref", evt.ProviderRef)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		h.mapError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,169 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+type webhookEndpointRequest struct {
+	URL        *string  `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Enabled    *bool    `json:"enabled"`
+}
+
+type webhookEndpointResponse struct {
+	EndpointID string   `json:"endpoint_id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Enabled    bool     `json:"enabled"`
+	// only returned on creation
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type webhookEndpointListResponse struct {
+	Endpoints []webhookEndpointResponse `json:"endpoints"`
+}
+
+type webhookDeliveryResponse struct {
+	DeliveryID     string     `json:"delivery_id"`
+	EventID        string     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type webhookDeliveryListResponse struct {
+	Deliveries []webhookDeliveryResponse `json:"deliveries"`
+}
+
+func (h *Handler) createWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	var body webhookEndpointRequest
+	if !h.decodeJSON(w, r, &body) {
+		return
+	}
+	if body.URL == nil {
+		writeError(w, r, http.StatusBadRequest, "url is required", "VALIDATION_ERROR")
+		return
+	}
+
+	endpoint, err := h.webhooks.Create(r.Context(), app.CreateWebhookEndpointRequest{
+		URL:        *body.URL,
+		EventTypes: body.EventTypes,
+	})
+	if err != nil {
+		h.mapWebhookError(w, r, err)
+		return
+	}
+	h.respond(w, r, http.StatusCreated, toWebhookEndpointResponse(endpoint))
+}
+
+func (h *Handler) listWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := h.webhooks.List(r.Context())
+	if err != nil {
+		h.mapWebhookError(w, r, err)
+		return
+	}
+
+	resp := webhookEndpointListResponse{Endpoints: make([]webhookEndpointResponse, 0, len(endpoints))}
+	for _, e := range endpoints {
+		resp.Endpoints = append(resp.Endpoints, toWebhookEndpointResponse(e))
+	}
+	h.respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) getWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	endpoint, err := h.webhooks.Get(r.Context(), chi.URLParam(r, "endpointID"))
+	if err != nil {
+		h.mapWebhookError(w, r, err)
+		return
+	}
+	h.respond(w, r, http.StatusOK, toWebhookEndpointResponse(endpoint))
+}
+
+func (h *Handler) updateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	var body webhookEndpointRequest
+	if !h.decodeJSON(w, r, &body) {
+		return
+	}
+
+	endpoint, err := h.webhooks.Update(r.Context(), chi.URLParam(r, "endpointID"), app.UpdateWebhookEndpointRequest{
+		URL:        body.URL,
+		EventTypes: body.EventTypes,
+		Enabled:    body.Enabled,
+	})
+	if err != nil {
+		h.mapWebhookError(w, r, err)
+		return
+	}
+	h.respond(w, r, http.StatusOK, toWebhookEndpointResponse(endpoint))
+}
+
+func (h *Handler) deleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	if err := h.webhooks.Delete(r.Context(), chi.URLParam(r, "endpointID")); err != nil {
+		h.mapWebhookError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listWebhookDeliveries with ?status=FAILED lists what was never delivered
+func (h *Handler) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := h.webhooks.Deliveries(r.Context(), chi.URLParam(r, "endpointID"), r.URL.Query().Get("status"))
+	if err != nil {
+		h.mapWebhookError(w, r, err)
+		return
+	}
+
+	resp := webhookDeliveryListResponse{Deliveries: make([]webhookDeliveryResponse, 0, len(deliveries))}
+	for _, d := range deliveries {
+		item := webhookDeliveryResponse{
+			DeliveryID:     d.ID,
+			EventID:        d.EventID,
+			EventType:      d.EventType,
+			Status:         d.Status,
+			Attempts:       d.Attempts,
+			LastStatusCode: d.LastStatusCode,
+			LastError:      d.LastError,
+			DeliveredAt:    d.DeliveredAt,
+			CreatedAt:      d.CreatedAt,
+		}
+		if d.Status == app.DeliveryPending {
+			item.NextAttemptAt = &d.NextAttemptAt
+		}
+		resp.Deliveries = append(resp.Deliveries, item)
+	}
+	h.respond(w, r, http.StatusOK, resp)
+}
+
+func (h *Handler) mapWebhookError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, domain.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "webhook endpoint not found", "NOT_FOUND")
+		return
+	}
+	h.mapError(w, r, err)
+}
+
+func toWebhookEndpointResponse(e app.WebhookEndpoint) webhookEndpointResponse {
+	return webhookEndpointResponse{
+		EndpointID: e.ID,
+		URL:        e.URL,
+		EventTypes: e.EventTypes,
+		Enabled:    e.Enabled,
+		Secret:     e.Secret,
+		CreatedAt:  e.CreatedAt,
+		UpdatedAt:  e.UpdatedAt,
+	}
+}
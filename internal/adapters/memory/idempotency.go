@@ -0,0 +1,103 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type entry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// KeyValueStore stands in for redis, it serves as idempotency cache and nonce store
+type KeyValueStore struct {
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+func NewKeyValueStore() *KeyValueStore {
+	return &KeyValueStore{entries: make(map[string]entry)}
+}
+
+func (s *KeyValueStore) Get(_ context.Context, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.live(key)
+	return e.value, ok, nil
+}
+
+// Set keeps the first writer like SET NX
+func (s *KeyValueStore) Set(_ context.Context, key string, result string, ttl time.Duration) error {
+	s.setNX(key, result, ttl)
+	return nil
+}
+
+func (s *KeyValueStore) Delete(_ context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range keys {
+		delete(s.entries, k)
+	}
+	return nil
+}
+
+func (s *KeyValueStore) Reserve(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.setNX("reservation:"+key, "1", ttl), nil
+}
+
+func (s *KeyValueStore) Release(ctx context.Context, key string) error {
+	return s.Delete(ctx, "reservation:"+key)
+}
+
+// AcquireLock and ReleaseLock stand in for the redis payment lock
+func (s *KeyValueStore) AcquireLock(_ context.Context, paymentID string, ttl time.Duration) (string, bool, error) {
+	token := uuid.NewString()
+	if !s.setNX("lock:"+paymentID, token, ttl) {
+		return "", false, nil
+	}
+	return token, true, nil
+}
+
+func (s *KeyValueStore) ReleaseLock(_ context.Context, paymentID, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.live("lock:" + paymentID); ok && e.value == token {
+		delete(s.entries, "lock:"+paymentID)
+	}
+	return nil
+}
+
+func (s *KeyValueStore) Remember(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	return s.setNX("nonce:"+nonce, "1", ttl), nil
+}
+
+func (s *KeyValueStore) setNX(key, value string, ttl time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.live(key); ok {
+		return false
+	}
+	s.entries[key] = entry{value: value, expiresAt: time.Now().Add(ttl)}
+	return true
+}
+
+// live returns the entry if present and unexpired, callers hold mu
+func (s *KeyValueStore) live(key string) (entry, bool) {
+	e, ok := s.entries[key]
+	if !ok {
+		return entry{}, false
+	}
+	if time.Now().After(e.expiresAt) {
+		delete(s.entries, key)
+		return entry{}, false
+	}
+	return e, true
+}
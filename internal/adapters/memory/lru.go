@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// IdempotencyStore is the shared store LRUIdempotencyStore sits in front of
+type IdempotencyStore interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key string, result string, ttl time.Duration) error
+	Reserve(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, key string) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+type lruEntry struct {
+	key string
+	entry
+}
+
+// LRUIdempotencyStore keeps the responses this instance saw in a bounded
+// in-process cache, so replays are still answered without the database
+// while the shared store is down. Cached responses never change once
+// written, so serving them locally is safe with several instances.
+// Reservations always go to the shared store, the unique index on the
+// payments table stays the source of truth.
+type LRUIdempotencyStore struct {
+	shared  IdempotencyStore
+	size    int
+	maxTTL  time.Duration
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewLRUIdempotencyStore holds at most size responses for at most maxTTL each
+func NewLRUIdempotencyStore(shared IdempotencyStore, size int, maxTTL time.Duration) *LRUIdempotencyStore {
+	return &LRUIdempotencyStore{
+		shared:  shared,
+		size:    size,
+		maxTTL:  maxTTL,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+func (s *LRUIdempotencyStore) Get(ctx context.Context, key string) (string, bool, error) {
+	if value, ok := s.local(key); ok {
+		return value, true, nil
+	}
+
+	value, ok, err := s.shared.Get(ctx, key)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	s.remember(key, value, s.maxTTL)
+	return value, true, nil
+}
+
+// Set remembers the response locally even when the shared store fails
+func (s *LRUIdempotencyStore) Set(ctx context.Context, key string, result string, ttl time.Duration) error {
+	s.remember(key, result, ttl)
+	return s.shared.Set(ctx, key, result, ttl)
+}
+
+func (s *LRUIdempotencyStore) Reserve(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.shared.Reserve(ctx, key, ttl)
+}
+
+func (s *LRUIdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.shared.Release(ctx, key)
+}
+
+// Delete forgets the keys locally first, a failed shared delete is retried
+// by the caller
+func (s *LRUIdempotencyStore) Delete(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	for _, k := range keys {
+		if el, ok := s.entries[k]; ok {
+			s.order.Remove(el)
+			delete(s.entries, k)
+		}
+	}
+	s.mu.Unlock()
+
+	return s.shared.Delete(ctx, keys...)
+}
+
+func (s *LRUIdempotencyStore) local(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return "", false
+	}
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expiresAt) {
+		s.order.Remove(el)
+		delete(s.entries, key)
+		return "", false
+	}
+	s.order.MoveToFront(el)
+	return e.value, true
+}
+
+// remember keeps the first value like the shared store's SET NX
+func (s *LRUIdempotencyStore) remember(key, value string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt := time.Now().Add(min(ttl, s.maxTTL))
+	if el, ok := s.entries[key]; ok {
+		e := el.Value.(*lruEntry)
+		if time.Now().Before(e.expiresAt) {
+			return
+		}
+		e.entry = entry{value: value, expiresAt: expiresAt}
+		s.order.MoveToFront(el)
+		return
+	}
+
+	s.entries[key] = s.order.PushFront(&lruEntry{key: key, entry: entry{value: value, expiresAt: expiresAt}})
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*lruEntry).key)
+	}
+}
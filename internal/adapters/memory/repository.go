@@ -0,0 +1,296 @@
+// Package memory holds in-process adapters for local mode, nothing survives a restart.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// Repository mirrors the postgres repository semantics on a map
+type Repository struct {
+	mu       sync.RWMutex
+	payments map[string]*domain.Payment
+	// scoped idempotency key -> payment id, like the unique index in postgres
+	byKey map[string]string
+	// payment id -> refunds, oldest first
+	refunds map[string][]*domain.Refund
+	// payment id -> status changes, oldest first
+	history map[string][]domain.StatusChange
+}
+
+func NewRepository() *Repository {
+	return &Repository{
+		payments: make(map[string]*domain.Payment),
+		byKey:    make(map[string]string),
+		refunds:  make(map[string][]*domain.Refund),
+		history:  make(map[string][]domain.StatusChange),
+	}
+}
+
+func (r *Repository) Save(ctx context.Context, p *domain.Payment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := p.ID().String()
+	stored, ok := r.payments[id]
+
+	// same version rules as the postgres insert and update
+	switch {
+	case p.Version() < 1:
+		return fmt.Errorf("%w: payment %s has version %d", domain.ErrVersionInvariant, id, p.Version())
+	case p.Version() == 1:
+		if ok {
+			return domain.ErrVersionConflict
+		}
+		if existing, taken := r.byKey[scopedKey(p)]; taken {
+			return &domain.DuplicateIdempotencyKeyError{Existing: clone(r.payments[existing])}
+		}
+	case !ok:
+		return fmt.Errorf("%w: payment %s has version %d but no stored row",
+			domain.ErrVersionInvariant, id, p.Version())
+	case stored.Version() >= p.Version():
+		return domain.ErrVersionConflict
+	case stored.Version() != p.Version()-1:
+		return fmt.Errorf("%w: payment %s has version %d, stored version is %d",
+			domain.ErrVersionInvariant, id, p.Version(), stored.Version())
+	}
+
+	// events are drained like the transactional outbox write, only the
+	// status history is kept
+	changes := domain.StatusChanges(p.PopEvents(), domain.ActorFromContext(ctx))
+	r.history[id] = append(r.history[id], changes...)
+
+	r.payments[id] = clone(p)
+	r.byKey[scopedKey(p)] = id
+	return nil
+}
+
+func scopedKey(p *domain.Payment) string {
+	return domain.ScopedIdempotencyKey(p.MerchantID(), p.IdempotencyKey())
+}
+
+// visible reports whether p may be read under ctx, see domain.WithMerchant
+func visible(ctx context.Context, p *domain.Payment) bool {
+	merchantID, scoped := domain.MerchantFromContext(ctx)
+	return !scoped || p.MerchantID() == merchantID
+}
+
+func (r *Repository) FindByIdempotencyKey(_ context.Context, merchantID, key string) (*domain.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.byKey[domain.ScopedIdempotencyKey(merchantID, key)]
+	if !ok {
+		return nil, nil
+	}
+	return clone(r.payments[id]), nil
+}
+
+func (r *Repository) FindByID(ctx context.Context, id domain.PaymentID) (*domain.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.payments[id.String()]
+	if !ok || !visible(ctx, p) {
+		return nil, domain.ErrNotFound
+	}
+	return clone(p), nil
+}
+
+func (r *Repository) FindByCorrelationID(ctx context.Context, correlationID string) ([]*domain.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found []*domain.Payment
+	for _, p := range r.payments {
+		if p.CorrelationID() == correlationID && visible(ctx, p) {
+			found = append(found, clone(p))
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].CreatedAt().After(found[j].CreatedAt()) })
+	return found, nil
+}
+
+func (r *Repository) FindByOrderID(ctx context.Context, orderID string) ([]*domain.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found []*domain.Payment
+	for _, p := range r.payments {
+		if p.OrderID() == orderID && visible(ctx, p) {
+			found = append(found, clone(p))
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].CreatedAt().After(found[j].CreatedAt()) })
+	return found, nil
+}
+
+func (r *Repository) FindExpiredPending(_ context.Context, olderThan time.Time, limit int) ([]*domain.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found []*domain.Payment
+	for _, p := range r.payments {
+		if p.Status() == domain.StatusPending && p.CreatedAt().Before(olderThan) {
+			found = append(found, clone(p))
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].CreatedAt().Before(found[j].CreatedAt()) })
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+func (r *Repository) FindByProviderRef(_ context.Context, ref string) (*domain.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.payments {
+		if ref != "" && p.ProviderRef() == ref {
+			return clone(p), nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (r *Repository) List(ctx context.Context, f domain.ListFilter) ([]*domain.Payment, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found []*domain.Payment
+	for _, p := range r.payments {
+		switch {
+		case !visible(ctx, p),
+			f.CustomerID != "" && p.CustomerID() != f.CustomerID,
+			f.Status != "" && p.Status() != f.Status,
+			f.ClientReference != "" && p.ClientReference() != f.ClientReference,
+			f.Currency != "" && p.Amount().Currency() != f.Currency,
+			!f.CreatedAfter.IsZero() && p.CreatedAt().Before(f.CreatedAfter),
+			!f.CreatedBefore.IsZero() && !p.CreatedAt().Before(f.CreatedBefore),
+			!f.After.Precedes(p):
+			continue
+		}
+		found = append(found, p)
+	}
+
+	// same order as the postgres keyset, (created_at, id) descending
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].CreatedAt().Equal(found[j].CreatedAt()) {
+			return found[i].CreatedAt().After(found[j].CreatedAt())
+		}
+		return found[i].ID().String() > found[j].ID().String()
+	})
+
+	next := ""
+	if len(found) > f.Limit {
+		found = found[:f.Limit]
+		next = domain.NewCursor(found[f.Limit-1]).Encode()
+	}
+
+	page := make([]*domain.Payment, 0, len(found))
+	for _, p := range found {
+		page = append(page, clone(p))
+	}
+	return page, next, nil
+}
+
+func (r *Repository) SaveRefund(_ context.Context, refund *domain.Refund) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	paymentID := refund.PaymentID().String()
+	p, ok := r.payments[paymentID]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	for _, existing := range r.refunds[paymentID] {
+		if existing.IdempotencyKey() == refund.IdempotencyKey() {
+			return domain.ErrVersionConflict
+		}
+	}
+	refunded, err := domain.RefundedAmount(p.Amount().Currency(), r.refunds[paymentID])
+	if err != nil {
+		return err
+	}
+	if err := domain.CheckRefundable(p.Amount(), refunded, refund.Amount()); err != nil {
+		return err
+	}
+
+	refund.PopEvents()
+	r.refunds[paymentID] = append(r.refunds[paymentID], cloneRefund(refund))
+	return nil
+}
+
+func (r *Repository) UpdateRefund(_ context.Context, refund *domain.Refund) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := r.refunds[refund.PaymentID().String()]
+	for i, existing := range stored {
+		if existing.ID() != refund.ID() {
+			continue
+		}
+		if existing.Status() != domain.RefundStatusPending {
+			return domain.ErrVersionConflict
+		}
+		refund.PopEvents()
+		stored[i] = cloneRefund(refund)
+		return nil
+	}
+	return domain.ErrNotFound
+}
+
+func (r *Repository) FindRefundByIdempotencyKey(_ context.Context, paymentID domain.PaymentID, key string) (*domain.Refund, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, refund := range r.refunds[paymentID.String()] {
+		if refund.IdempotencyKey() == key {
+			return cloneRefund(refund), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *Repository) ListRefunds(_ context.Context, paymentID domain.PaymentID) ([]*domain.Refund, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored := r.refunds[paymentID.String()]
+	refunds := make([]*domain.Refund, 0, len(stored))
+	for _, refund := range stored {
+		refunds = append(refunds, cloneRefund(refund))
+	}
+	return refunds, nil
+}
+
+// clone keeps callers from mutating stored aggregates
+func clone(p *domain.Payment) *domain.Payment {
+	return domain.Reconstitute(
+		p.ID(), p.MerchantID(), p.OrderID(), p.CustomerID(), p.Amount(),
+		p.Status(),
+		p.ProviderRef(), p.FailureReason(), p.IdempotencyKey(), p.ClientReference(), p.CorrelationID(), p.CaptureKey(),
+		p.RequestHash(), p.Metadata(), p.CreatedAt(), p.UpdatedAt(), p.Version(),
+	)
+}
+
+func cloneRefund(r *domain.Refund) *domain.Refund {
+	return domain.ReconstituteRefund(
+		r.ID(), r.PaymentID(), r.Amount(), r.Status(),
+		r.Reason(), r.IdempotencyKey(), r.ProviderRef(), r.FailureReason(), r.CreatedAt(),
+	)
+}
+
+func (r *Repository) StatusHistory(_ context.Context, id domain.PaymentID) ([]domain.StatusChange, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.history[id.String()]), nil
+}
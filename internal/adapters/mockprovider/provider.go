@@ -0,0 +1,69 @@
+// Package mockprovider is a deterministic stand-in for a payment gateway, it
+// lets failure paths be exercised end to end without a real one.
+package mockprovider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+// magic amounts in minor units, any currency
+const (
+	// DeclineAmount is declined by every call
+	DeclineAmount int64 = 666
+	// TimeoutAmount never gets an answer, the call fails once the timeout passes
+	TimeoutAmount int64 = 999
+)
+
+// Provider approves everything except the magic amounts. References are
+// derived from the request, so retries return the same one.
+type Provider struct {
+	timeout time.Duration
+}
+
+func New(timeout time.Duration) *Provider {
+	return &Provider{timeout: timeout}
+}
+
+func (p *Provider) Authorize(ctx context.Context, req app.ProviderRequest) (app.ProviderResult, error) {
+	if result, done, err := p.magic(ctx, req.AmountCents); done {
+		return result, err
+	}
+	return app.ProviderResult{ProviderRef: "mock_" + req.PaymentID}, nil
+}
+
+func (p *Provider) Capture(ctx context.Context, req app.ProviderCaptureRequest) (app.ProviderResult, error) {
+	if result, done, err := p.magic(ctx, req.AmountCents); done {
+		return result, err
+	}
+	return app.ProviderResult{ProviderRef: req.ProviderRef}, nil
+}
+
+func (p *Provider) Refund(ctx context.Context, req app.ProviderRefundRequest) (app.ProviderResult, error) {
+	if result, done, err := p.magic(ctx, req.AmountCents); done {
+		return result, err
+	}
+	return app.ProviderResult{ProviderRef: "mock_re_" + req.RefundID}, nil
+}
+
+// magic answers for the magic amounts, done is false for every other amount
+func (p *Provider) magic(ctx context.Context, amountCents int64) (app.ProviderResult, bool, error) {
+	switch amountCents {
+	case DeclineAmount:
+		return app.ProviderResult{Declined: true, DeclineReason: "card_declined"}, true, nil
+	case TimeoutAmount:
+		timer := time.NewTimer(p.timeout)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return app.ProviderResult{}, true, ctx.Err()
+		case <-timer.C:
+			return app.ProviderResult{}, true, fmt.Errorf("mock provider: no answer after %s", p.timeout)
+		}
+	default:
+		return app.ProviderResult{}, false, nil
+	}
+}
@@ -0,0 +1,67 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// keyPartitioner hashes the record key with murmur2 the way the Java client
+// does, messages sharing a key land on one partition and keep their order
+var keyPartitioner = kgo.StickyKeyPartitioner(nil)
+
+// KafkaPublisher produces to the brokers directly. The producer is
+// idempotent, so a retried batch is neither duplicated nor reordered.
+type KafkaPublisher struct {
+	client *kgo.Client
+}
+
+// NewKafkaPublisher takes the seed brokers, timeout bounds the delivery of
+// every record including the client's own retries
+func NewKafkaPublisher(brokers []string, clientID string, timeout time.Duration) (*KafkaPublisher, error) {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers...),
+		kgo.ClientID(clientID),
+		kgo.RecordPartitioner(keyPartitioner),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.RecordDeliveryTimeout(timeout),
+		kgo.ProduceRequestTimeout(timeout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create kafka client: %w", err)
+	}
+	return &KafkaPublisher{client: client}, nil
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, topic, key string, payload []byte) error {
+	record := &kgo.Record{Topic: topic, Key: []byte(key), Value: payload}
+	if err := p.client.ProduceSync(ctx, record).FirstErr(); err != nil {
+		return produceError(err)
+	}
+	return nil
+}
+
+// produceError marks what the broker refused because of the record itself,
+// everything else, timeouts and leadership changes included, is retried
+func produceError(err error) error {
+	switch {
+	case errors.Is(err, kerr.MessageTooLarge),
+		errors.Is(err, kerr.RecordListTooLarge),
+		errors.Is(err, kerr.InvalidRecord),
+		errors.Is(err, kerr.CorruptMessage),
+		errors.Is(err, kerr.InvalidTimestamp):
+		return fmt.Errorf("%w: kafka: %w", ErrRejected, err)
+	default:
+		return fmt.Errorf("kafka produce: %w", err)
+	}
+}
+
+// Close releases the broker connections, Publish waits for every record so
+// none is left buffered
+func (p *KafkaPublisher) Close() {
+	p.client.Close()
+}
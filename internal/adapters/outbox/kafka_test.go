@@ -0,0 +1,75 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestProduceError(t *testing.T) {
+	tests := []struct {
+		err      error
+		rejected bool
+	}{
+		{err: kerr.MessageTooLarge, rejected: true},
+		{err: kerr.RecordListTooLarge, rejected: true},
+		{err: kerr.InvalidRecord, rejected: true},
+		{err: kerr.CorruptMessage, rejected: true},
+		{err: fmt.Errorf("produce: %w", kerr.InvalidTimestamp), rejected: true},
+		{err: kerr.NotLeaderForPartition},
+		{err: kerr.RequestTimedOut},
+		{err: kgo.ErrRecordTimeout},
+		{err: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		got := produceError(tt.err)
+		if errors.Is(got, ErrRejected) != tt.rejected || !errors.Is(got, tt.err) {
+			t.Errorf("produceError(%v) = %v, rejected want %v", tt.err, got, tt.rejected)
+		}
+	}
+}
+
+// every event of a payment carries its ID as key, so they all go to one
+// partition whichever producer instance sends them
+func TestKeyPartitionerIsStablePerKey(t *testing.T) {
+	const partitions = 12
+	partition := func(key string) int {
+		return keyPartitioner.ForTopic("gopay.payment-events").Partition(&kgo.Record{Key: []byte(key)}, partitions)
+	}
+
+	seen := map[int]bool{}
+	for i := range 64 {
+		key := fmt.Sprintf("pay-%d", i)
+		p := partition(key)
+		for range 3 {
+			if again := partition(key); again != p {
+				t.Fatalf("key %s went to partitions %d and %d", key, p, again)
+			}
+		}
+		seen[p] = true
+	}
+	if len(seen) < 2 {
+		t.Fatalf("64 keys all went to partitions %v", seen)
+	}
+}
+
+// a broker that cannot be reached is worth retrying, the event is not dropped
+func TestKafkaPublishToUnreachableBrokerIsRetriable(t *testing.T) {
+	p, err := NewKafkaPublisher([]string{"127.0.0.1:1"}, "gopay-test", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = p.Publish(ctx, "gopay.payment-events", "pay-1", []byte(`{}`))
+	if err == nil || errors.Is(err, ErrRejected) {
+		t.Fatalf("Publish = %v, want a retriable error", err)
+	}
+}
@@ -0,0 +1,50 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATSPublisher publishes to a JetStream stream, the topic is the subject.
+// A stream keeps per-subject order, so the key travels only as a header.
+type NATSPublisher struct {
+	conn *nats.Conn
+	js   jetstream.JetStream
+}
+
+// NewNATSPublisher needs a stream bound to the subjects it will publish on
+func NewNATSPublisher(url, clientName string) (*NATSPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name(clientName), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("connect to nats: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("create jetstream context: %w", err)
+	}
+	return &NATSPublisher{conn: conn, js: js}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, topic, key string, payload []byte) error {
+	msg := nats.NewMsg(topic)
+	msg.Header.Set("Gopay-Key", key)
+	msg.Data = payload
+
+	if _, err := p.js.PublishMsg(ctx, msg); err != nil {
+		if errors.Is(err, nats.ErrMaxPayload) {
+			return fmt.Errorf("%w: nats: %w", ErrRejected, err)
+		}
+		return fmt.Errorf("nats publish: %w", err)
+	}
+	return nil
+}
+
+// Close flushes pending messages before closing the connection
+func (p *NATSPublisher) Close() {
+	_ = p.conn.Drain()
+}
@@ -0,0 +1,52 @@
+// Package outboxtest provides an in-memory outbox.Publisher for tests.
+package outboxtest
+
+import (
+	"context"
+	"sync"
+)
+
+// Message is one call to Publish
+type Message struct {
+	Topic   string
+	Key     string
+	Payload []byte
+}
+
+// Publisher records every successful publish. Fail, when set, decides per
+// message whether the publish fails, wrap outbox.ErrRejected to simulate a
+// poison message and return anything else to simulate an outage.
+type Publisher struct {
+	Fail func(Message) error
+
+	mu        sync.Mutex
+	published []Message
+}
+
+func (p *Publisher) Publish(_ context.Context, topic, key string, payload []byte) error {
+	msg := Message{Topic: topic, Key: key, Payload: append([]byte(nil), payload...)}
+	if p.Fail != nil {
+		if err := p.Fail(msg); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published = append(p.published, msg)
+	return nil
+}
+
+// Published returns the messages published so far, in order
+func (p *Publisher) Published() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Message(nil), p.published...)
+}
+
+// Reset forgets every published message
+func (p *Publisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published = nil
+}
@@ -0,0 +1,17 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrRejected marks a publish the broker refused because of the message
+// itself, retrying it unchanged will not help. Any other error is treated as
+// the broker being unavailable.
+var ErrRejected = errors.New("message rejected by broker")
+
+// Publisher delivers one message. Messages with the same key must reach
+// consumers in the order they were published.
+type Publisher interface {
+	Publish(ctx context.Context, topic, key string, payload []byte) error
+}
@@ -0,0 +1,13 @@
+package outbox
+
+import "github.com/ademajagon/gopay-service/internal/adapters/postgres"
+
+// Queries is every statement of the relay, see postgres.Queries
+func Queries() []postgres.NamedQuery {
+	id := "00000000-0000-4000-8000-000000000001"
+	return []postgres.NamedQuery{
+		{Name: "outbox.claim", SQL: claimQuery, Args: []any{100}},
+		{Name: "outbox.mark_published", SQL: markPublishedQuery, Args: []any{[]string{id}}},
+		{Name: "outbox.record_rejection", SQL: recordRejectionQuery, Args: []any{id, "rejected", 10}},
+	}
+}
@@ -0,0 +1,16 @@
+package outbox_test
+
+import (
+	"testing"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/outbox"
+	"github.com/ademajagon/gopay-service/internal/adapters/postgres/pgtest"
+)
+
+func TestQueriesAreRegistered(t *testing.T) {
+	pgtest.Unregistered(t, ".", outbox.Queries())
+}
+
+func TestQueriesMatchMigrations(t *testing.T) {
+	pgtest.Explain(t, pgtest.NewSchema(t), outbox.Queries())
+}
@@ -0,0 +1,375 @@
+// Package outbox publishes the rows the repositories write to outbox_events.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+var (
+	outboxPublishedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gopay_service",
+		Subsystem: "outbox",
+		Name:      "published_total",
+		Help:      "Outbox events published and marked as such.",
+	})
+
+	outboxPublishFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gopay_service",
+		Subsystem: "outbox",
+		Name:      "publish_failures_total",
+		Help:      "Failed publishes, partitioned by whether the broker was unavailable or rejected the event.",
+	}, []string{"reason"})
+
+	outboxParkedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gopay_service",
+		Subsystem: "outbox",
+		Name:      "parked_total",
+		Help:      "Outbox events parked after exhausting their publish attempts.",
+	})
+
+	outboxPublishDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gopay_service",
+		Subsystem: "outbox",
+		Name:      "publish_duration_seconds",
+		Help:      "Time the broker took to acknowledge or fail one event.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	})
+
+	outboxEventLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gopay_service",
+		Subsystem: "outbox",
+		Name:      "event_latency_seconds",
+		Help:      "Time from writing an outbox event to the broker acknowledging it.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	})
+
+	outboxListenerConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gopay_service",
+		Subsystem: "outbox",
+		Name:      "listener_connected",
+		Help:      "1 while the relay listens for new event notifications, 0 while it only polls.",
+	})
+)
+
+// batchTimeout bounds one claim-publish-mark cycle, also once shutdown started
+const batchTimeout = 30 * time.Second
+
+type RelayConfig struct {
+	Topic        string
+	BatchSize    int
+	PollInterval time.Duration
+	// retries after a failed batch back off from PollInterval up to this
+	MaxBackoff time.Duration
+	// rejected events are parked after this many attempts
+	MaxAttempts int
+	// notifications on this channel start a batch right away, empty polls only
+	NotifyChannel string
+}
+
+// Relay moves outbox_events to a Publisher. Rows are claimed with FOR UPDATE
+// SKIP LOCKED, so replicas share the table, and marked published in the same
+// transaction once the broker acknowledged them. A crash between the two
+// republishes the batch, consumers must tolerate duplicates. Replicas claim
+// whole aggregates, the events of one are published in order by one replica.
+type Relay struct {
+	pool      *pgxpool.Pool
+	publisher Publisher
+	cfg       RelayConfig
+	log       *slog.Logger
+}
+
+func NewRelay(pool *pgxpool.Pool, publisher Publisher, cfg RelayConfig, log *slog.Logger) *Relay {
+	return &Relay{pool: pool, publisher: publisher, cfg: cfg, log: log}
+}
+
+// Run blocks until ctx is cancelled, a batch in flight at that point is
+// still published and committed before Run returns
+func (r *Relay) Run(ctx context.Context) {
+	wake := make(chan struct{}, 1)
+	if r.cfg.NotifyChannel != "" {
+		go r.listen(ctx, wake)
+	}
+
+	backoff := r.cfg.PollInterval
+	backingOff := false
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		// a failing broker is not retried faster because events keep coming
+		var woken <-chan struct{}
+		if !backingOff {
+			woken = wake
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-woken:
+		}
+
+		claimed, err := r.relayBatch(ctx)
+		backingOff = err != nil
+		switch {
+		case err != nil:
+			r.log.WarnContext(ctx, "outbox relay batch failed, backing off", "err", err, "backoff", backoff)
+			timer.Reset(backoff)
+			backoff = min(backoff*2, r.cfg.MaxBackoff)
+			continue
+		case claimed == r.cfg.BatchSize:
+			// a full batch means more rows are probably waiting
+			timer.Reset(0)
+		default:
+			timer.Reset(r.cfg.PollInterval)
+		}
+		backoff = r.cfg.PollInterval
+	}
+}
+
+// listen wakes the poll loop on every notification over a dedicated
+// connection, reconnecting with backoff. Notifications only shorten the
+// wait, the poll still finds every event without them.
+func (r *Relay) listen(ctx context.Context, wake chan<- struct{}) {
+	backoff := r.cfg.PollInterval
+	for {
+		err := r.listenOnce(ctx, wake, func() { backoff = r.cfg.PollInterval })
+		outboxListenerConnected.Set(0)
+		if ctx.Err() != nil {
+			return
+		}
+		r.log.WarnContext(ctx, "outbox listener disconnected, polling until it reconnects",
+			"err", err,
+			"backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, r.cfg.MaxBackoff)
+	}
+}
+
+// listenOnce returns when the connection fails, connected is called once
+// LISTEN succeeded
+func (r *Relay) listenOnce(ctx context.Context, wake chan<- struct{}, connected func()) error {
+	conn, err := pgx.ConnectConfig(ctx, r.pool.Config().ConnConfig.Copy())
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer func() { _ = conn.Close(context.WithoutCancel(ctx)) }()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{r.cfg.NotifyChannel}.Sanitize()); err != nil {
+		return fmt.Errorf("listen on %s: %w", r.cfg.NotifyChannel, err)
+	}
+	outboxListenerConnected.Set(1)
+	connected()
+	// events written while disconnected are only found by the next poll
+	notify(wake)
+
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return fmt.Errorf("wait for notification: %w", err)
+		}
+		notify(wake)
+	}
+}
+
+// notify never blocks, a pending wake-up already covers this event
+func notify(wake chan<- struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+type event struct {
+	ID          string
+	AggregateID string
+	EventType   string
+	Payload     json.RawMessage
+	CreatedAt   time.Time
+}
+
+// message returns the envelope the row holds, rows written before events
+// were stored in envelopes get one built from their columns
+func (e event) message() ([]byte, error) {
+	var env domain.Envelope
+	if err := json.Unmarshal(e.Payload, &env); err == nil && env.EventID == e.ID {
+		return e.Payload, nil
+	}
+	return json.Marshal(domain.Envelope{
+		EventID:       e.ID,
+		EventType:     e.EventType,
+		SchemaVersion: domain.EventSchemaVersion,
+		OccurredAt:    e.CreatedAt.UTC(),
+		AggregateID:   e.AggregateID,
+		Payload:       e.Payload,
+	})
+}
+
+const markPublishedQuery = `UPDATE outbox_events SET published_at = NOW() WHERE id = ANY($1)`
+
+// relayBatch publishes the claimed rows in order. A rejected row holds back
+// the rest of its aggregate for this batch but not other aggregates, an
+// unavailable broker ends the batch. Whatever was published is marked.
+func (r *Relay) relayBatch(ctx context.Context) (int, error) {
+	// shutdown must not abandon a batch the broker may already have
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), batchTimeout)
+	defer cancel()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	events, err := claim(ctx, tx, r.cfg.BatchSize)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	var (
+		published  []string
+		held       = map[string]bool{}
+		publishErr error
+	)
+	for _, evt := range events {
+		if held[evt.AggregateID] {
+			continue
+		}
+
+		payload, err := evt.message()
+		if err == nil {
+			start := time.Now()
+			err = r.publisher.Publish(ctx, r.cfg.Topic, evt.AggregateID, payload)
+			outboxPublishDuration.Observe(time.Since(start).Seconds())
+		}
+		if err == nil {
+			outboxEventLatency.Observe(time.Since(evt.CreatedAt).Seconds())
+			published = append(published, evt.ID)
+			continue
+		}
+
+		if !errors.Is(err, ErrRejected) {
+			outboxPublishFailuresTotal.WithLabelValues("unavailable").Inc()
+			publishErr = fmt.Errorf("publish event %s: %w", evt.ID, err)
+			break
+		}
+
+		outboxPublishFailuresTotal.WithLabelValues("rejected").Inc()
+		held[evt.AggregateID] = true
+		if err := r.recordRejection(ctx, tx, evt, err); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(published) > 0 {
+		if _, err := tx.Exec(ctx, markPublishedQuery, published); err != nil {
+			return 0, fmt.Errorf("mark events published: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	outboxPublishedTotal.Add(float64(len(published)))
+	if len(published) > 0 {
+		r.log.DebugContext(ctx, "outbox batch published", "events", len(published))
+	}
+	return len(events), publishErr
+}
+
+const recordRejectionQuery = `
+	UPDATE outbox_events SET
+		attempts   = attempts + 1,
+		last_error = $2,
+		parked_at  = CASE WHEN attempts + 1 >= $3 THEN NOW() END
+	WHERE id = $1
+	RETURNING parked_at IS NOT NULL
+`
+
+// recordRejection counts the attempt and parks the row once it used them all
+func (r *Relay) recordRejection(ctx context.Context, tx pgx.Tx, evt event, cause error) error {
+	var parked bool
+	if err := tx.QueryRow(ctx, recordRejectionQuery, evt.ID, cause.Error(), r.cfg.MaxAttempts).Scan(&parked); err != nil {
+		return fmt.Errorf("record rejected event %s: %w", evt.ID, err)
+	}
+
+	if parked {
+		outboxParkedTotal.Inc()
+		r.log.ErrorContext(ctx, "outbox event parked after repeated rejections",
+			"event_id", evt.ID,
+			"aggregate_id", evt.AggregateID,
+			"event_type", evt.EventType,
+			"err", cause,
+		)
+	} else {
+		r.log.WarnContext(ctx, "outbox event rejected", "event_id", evt.ID, "err", cause)
+	}
+	return nil
+}
+
+// claimQuery locks the oldest pending event of each aggregate it takes and
+// then every pending event after it. An aggregate whose oldest event another
+// replica holds is skipped as a whole, its later events are not heads. The
+// second lock waits instead of skipping, a skipped row would leave a gap.
+const claimQuery = `
+	WITH heads AS (
+		SELECT o.aggregate_id
+		FROM outbox_events o
+		WHERE o.published_at IS NULL
+		  AND o.parked_at IS NULL
+		  AND NOT EXISTS (
+			SELECT 1 FROM outbox_events older
+			WHERE older.aggregate_id = o.aggregate_id
+			  AND older.published_at IS NULL
+			  AND older.parked_at IS NULL
+			  AND older.seq < o.seq
+		  )
+		ORDER BY o.seq
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	SELECT e.id, e.aggregate_id, e.event_type, e.payload, e.created_at
+	FROM outbox_events e
+	JOIN heads USING (aggregate_id)
+	WHERE e.published_at IS NULL
+	  AND e.parked_at IS NULL
+	ORDER BY e.seq
+	LIMIT $1
+	FOR UPDATE OF e
+`
+
+func claim(ctx context.Context, tx pgx.Tx, limit int) ([]event, error) {
+	rows, err := tx.Query(ctx, claimQuery, limit)
+	if err != nil {
+		return nil, fmt.Errorf("claim outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var claimed []event
+	for rows.Next() {
+		var evt event
+		if err := rows.Scan(&evt.ID, &evt.AggregateID, &evt.EventType, &evt.Payload, &evt.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan outbox event: %w", err)
+		}
+		claimed = append(claimed, evt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate outbox events: %w", err)
+	}
+	return claimed, nil
+}
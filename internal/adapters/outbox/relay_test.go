@@ -0,0 +1,101 @@
+package outbox
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/postgres/pgtest"
+)
+
+// claimAs claims in a transaction of its own that stays open, like a replica
+// in the middle of a batch, and returns the claimed event types
+func claimAs(t *testing.T, pool *pgxpool.Pool, limit int) (pgx.Tx, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tx.Rollback(ctx) })
+
+	events, err := claim(ctx, tx, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, evt := range events {
+		types = append(types, evt.EventType)
+	}
+	return tx, types
+}
+
+// two replicas never hold events of one aggregate at the same time, so
+// neither can publish a later event before an earlier one
+func TestClaimKeepsAnAggregateOnOneReplica(t *testing.T) {
+	pool := pgtest.NewSchema(t)
+	ctx := context.Background()
+
+	_, err := pool.Exec(ctx, `
+		INSERT INTO outbox_events (aggregate_id, event_type, payload) VALUES
+			('pay-a', 'a1', '{}'), ('pay-b', 'b1', '{}'), ('pay-a', 'a2', '{}'), ('pay-a', 'a3', '{}')
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, got := claimAs(t, pool, 1)
+	if !slices.Equal(got, []string{"a1"}) {
+		t.Fatalf("first replica claimed %v, want [a1]", got)
+	}
+
+	// a2 and a3 wait behind a1, which the first replica holds
+	_, got = claimAs(t, pool, 10)
+	if !slices.Equal(got, []string{"b1"}) {
+		t.Fatalf("second replica claimed %v, want [b1]", got)
+	}
+
+	if _, err := first.Exec(ctx, markPublishedQuery, []string{mustEventID(t, pool, "a1")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rest of the aggregate goes to one replica, in order
+	_, got = claimAs(t, pool, 10)
+	if !slices.Equal(got, []string{"a2", "a3"}) {
+		t.Fatalf("third replica claimed %v, want [a2 a3]", got)
+	}
+}
+
+// a parked event no longer holds back the events after it
+func TestClaimSkipsParkedEvents(t *testing.T) {
+	pool := pgtest.NewSchema(t)
+	ctx := context.Background()
+
+	_, err := pool.Exec(ctx, `
+		INSERT INTO outbox_events (aggregate_id, event_type, payload, parked_at) VALUES
+			('pay-a', 'a1', '{}', NOW()), ('pay-a', 'a2', '{}', NULL)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, got := claimAs(t, pool, 10); !slices.Equal(got, []string{"a2"}) {
+		t.Fatalf("claimed %v, want [a2]", got)
+	}
+}
+
+func mustEventID(t *testing.T, pool *pgxpool.Pool, eventType string) string {
+	t.Helper()
+	var id string
+	if err := pool.QueryRow(context.Background(), `SELECT id::text FROM outbox_events WHERE event_type = $1`, eventType).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
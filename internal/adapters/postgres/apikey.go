@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+const apiKeyColumns = `id, merchant_id, name, prefix, key_hash, scopes, revoked_at, created_at`
+
+const createAPIKeyQuery = `
+	INSERT INTO api_keys (merchant_id, name, prefix, key_hash, scopes)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING ` + apiKeyColumns
+
+func (r *Repository) CreateAPIKey(ctx context.Context, k app.APIKey) (app.APIKey, error) {
+	ctx, cancel := r.withWriteTimeout(ctx)
+	defer cancel()
+
+	return scanAPIKey(r.pool.QueryRow(ctx, createAPIKeyQuery, k.MerchantID, k.Name, k.Prefix, k.Hash, k.Scopes))
+}
+
+const findAPIKeyByPrefixQuery = `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE prefix = $1`
+
+func (r *Repository) FindAPIKeyByPrefix(ctx context.Context, prefix string) (app.APIKey, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	k, err := scanAPIKey(r.pool.QueryRow(ctx, findAPIKeyByPrefixQuery, prefix))
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		r.failover.Observe(err)
+	}
+	return k, err
+}
+
+const listAPIKeysQuery = `
+	SELECT ` + apiKeyColumns + `
+	FROM api_keys
+	WHERE $1 = '' OR merchant_id = $1
+	ORDER BY created_at
+`
+
+func (r *Repository) ListAPIKeys(ctx context.Context, merchantID string) ([]app.APIKey, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, listAPIKeysQuery, merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []app.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate api keys: %w", err)
+	}
+	return keys, nil
+}
+
+const revokeAPIKeyQuery = `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+
+func (r *Repository) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, cancel := r.withWriteTimeout(ctx)
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, revokeAPIKeyQuery, id)
+	if err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func scanAPIKey(row pgx.Row) (app.APIKey, error) {
+	var k app.APIKey
+	err := row.Scan(&k.ID, &k.MerchantID, &k.Name, &k.Prefix, &k.Hash, &k.Scopes, &k.RevokedAt, &k.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return app.APIKey{}, domain.ErrNotFound
+		}
+		return app.APIKey{}, fmt.Errorf("scan api key: %w", err)
+	}
+	return k, nil
+}
@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+const backfillRunColumns = `
+	id, range_from, range_to, cursor_created_at, cursor_id,
+	events_written, status, last_error, updated_at
+`
+
+const createBackfillRunQuery = `
+	INSERT INTO outbox_backfill_runs (range_from, range_to)
+	VALUES ($1, $2)
+	RETURNING ` + backfillRunColumns
+
+func (r *Repository) CreateBackfillRun(ctx context.Context, from, to time.Time) (app.BackfillRun, error) {
+	return scanBackfillRun(r.pool.QueryRow(ctx, createBackfillRunQuery, from, to))
+}
+
+const getBackfillRunQuery = `SELECT ` + backfillRunColumns + ` FROM outbox_backfill_runs WHERE id = $1`
+
+func (r *Repository) GetBackfillRun(ctx context.Context, id string) (app.BackfillRun, error) {
+	return scanBackfillRun(r.pool.QueryRow(ctx, getBackfillRunQuery, id))
+}
+
+// nextBackfillBatchQuery starts at the beginning of the range for a zero cursor
+const nextBackfillBatchQuery = `
+	SELECT ` + paymentColumns + `
+	FROM payments
+	WHERE created_at >= $1 AND created_at < $2
+	  AND ($3::timestamptz IS NULL OR (created_at, id) > ($3, $4::uuid))
+	ORDER BY created_at, id
+	LIMIT $5
+`
+
+func (r *Repository) NextBackfillBatch(ctx context.Context, run app.BackfillRun, limit int) ([]*domain.Payment, error) {
+	var cursorAt *time.Time
+	var cursorID *string
+	if run.CursorID != "" {
+		cursorAt, cursorID = &run.CursorCreatedAt, &run.CursorID
+	}
+
+	return r.queryPayments(ctx, r.pool, nextBackfillBatchQuery, run.From, run.To, cursorAt, cursorID, limit)
+}
+
+const insertBackfilledEventQuery = `
+	INSERT INTO outbox_events (id, aggregate_id, event_type, payload, created_at)
+	VALUES ($1, $2, $3, $4, NOW())
+	ON CONFLICT (id) DO NOTHING
+`
+
+const advanceBackfillCheckpointQuery = `
+	UPDATE outbox_backfill_runs
+	SET cursor_created_at = $2,
+	    cursor_id         = $3,
+	    events_written    = events_written + $4,
+	    updated_at        = NOW()
+	WHERE id = $1
+`
+
+func (r *Repository) CommitBackfillBatch(ctx context.Context, runID string, records []app.OutboxRecord, last *domain.Payment) error {
+	return r.withTx(ctx, "commit_backfill_batch", func(ctx context.Context, tx pgx.Tx) error {
+		written := 0
+		for _, rec := range records {
+			tag, err := tx.Exec(ctx, insertBackfilledEventQuery, rec.ID, rec.AggregateID, rec.EventType, rec.Payload)
+			if err != nil {
+				return fmt.Errorf("insert outbox event %s: %w", rec.EventType, err)
+			}
+			written += int(tag.RowsAffected())
+		}
+
+		if _, err := tx.Exec(ctx, advanceBackfillCheckpointQuery, runID, last.CreatedAt(), last.ID().String(), written); err != nil {
+			return fmt.Errorf("advance backfill checkpoint: %w", err)
+		}
+		return nil
+	})
+}
+
+const setBackfillStatusQuery = `
+	UPDATE outbox_backfill_runs
+	SET status = $2, last_error = $3, updated_at = NOW()
+	WHERE id = $1
+`
+
+func (r *Repository) SetBackfillStatus(ctx context.Context, runID, status, lastError string) error {
+	if _, err := r.pool.Exec(ctx, setBackfillStatusQuery, runID, status, lastError); err != nil {
+		return fmt.Errorf("set backfill status: %w", err)
+	}
+	return nil
+}
+
+func scanBackfillRun(row pgx.Row) (app.BackfillRun, error) {
+	var (
+		run      app.BackfillRun
+		cursorAt *time.Time
+		cursorID *string
+	)
+
+	err := row.Scan(
+		&run.ID, &run.From, &run.To, &cursorAt, &cursorID,
+		&run.EventsWritten, &run.Status, &run.LastError, &run.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return app.BackfillRun{}, domain.ErrNotFound
+		}
+		return app.BackfillRun{}, fmt.Errorf("scan backfill run: %w", err)
+	}
+
+	if cursorAt != nil && cursorID != nil {
+		run.CursorCreatedAt, run.CursorID = *cursorAt, *cursorID
+	}
+	return run, nil
+}
@@ -0,0 +1,105 @@
+package postgres_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/postgres"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// queryCounter counts the statements a pool sends and the connections it dials
+type queryCounter struct {
+	queries atomic.Int32
+	dials   atomic.Int32
+}
+
+func (c *queryCounter) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	c.queries.Add(1)
+	return ctx
+}
+
+func (c *queryCounter) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+// countingPool points at a port nothing listens on, a statement that got as
+// far as the network would fail with a dial error rather than the context's
+func countingPool(t *testing.T, c *queryCounter) *pgxpool.Pool {
+	t.Helper()
+	cfg, err := pgxpool.ParseConfig("postgres://gopay@127.0.0.1:1/gopay?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.Tracer = c
+	dial := cfg.ConnConfig.DialFunc
+	cfg.ConnConfig.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c.dials.Add(1)
+		return dial(ctx, network, addr)
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// a request whose client already hung up never takes a connection, neither
+// from the primary nor from the replica
+func TestCancelledContextNeverQueries(t *testing.T) {
+	var primary, replica queryCounter
+	repo := postgres.NewRepository(countingPool(t, &primary), countingPool(t, &replica), nil, postgres.QueryTimeouts{})
+
+	reads := map[string]func(context.Context) error{
+		"FindByID": func(ctx context.Context) error {
+			_, err := repo.FindByID(ctx, domain.NewPaymentID())
+			return err
+		},
+		"FindByIdempotencyKey": func(ctx context.Context) error {
+			_, err := repo.FindByIdempotencyKey(ctx, "", "idem-1")
+			return err
+		},
+		"FindByIdempotencyKey consistent": func(ctx context.Context) error {
+			_, err := repo.FindByIdempotencyKey(domain.WithConsistentRead(ctx), "", "idem-1")
+			return err
+		},
+		"FindByOrderID": func(ctx context.Context) error {
+			_, err := repo.FindByOrderID(ctx, "order-1")
+			return err
+		},
+		"List": func(ctx context.Context) error {
+			_, _, err := repo.List(ctx, domain.ListFilter{Limit: 10})
+			return err
+		},
+		"StatusHistory": func(ctx context.Context) error {
+			_, err := repo.StatusHistory(ctx, domain.NewPaymentID())
+			return err
+		},
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for name, read := range reads {
+		if err := read(cancelled); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: err = %v, want context.Canceled", name, err)
+		}
+	}
+	for name, c := range map[string]*queryCounter{"primary": &primary, "replica": &replica} {
+		if q, d := c.queries.Load(), c.dials.Load(); q != 0 || d != 0 {
+			t.Fatalf("%s: %d queries and %d dials for cancelled requests, want none", name, q, d)
+		}
+	}
+
+	// the same read with a live context does reach for the replica
+	if err := reads["FindByID"](context.Background()); err == nil || errors.Is(err, context.Canceled) {
+		t.Fatalf("live read err = %v, want a connection error", err)
+	}
+	if replica.dials.Load() == 0 {
+		t.Fatal("live read never dialled, the counters prove nothing")
+	}
+}
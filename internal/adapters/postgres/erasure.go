@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+const pseudonymizeBatchQuery = `
+	WITH batch AS (
+		SELECT id FROM payments
+		WHERE customer_id = $1
+		ORDER BY created_at
+		LIMIT $3
+		FOR UPDATE
+	)
+	UPDATE payments p
+	SET customer_id = $2, metadata = p.metadata - $4::text[]
+	FROM batch
+	WHERE p.id = batch.id
+	RETURNING p.id, p.merchant_id, p.idempotency_key
+`
+
+// scrubOutboxMetadataQuery removes PII keys from the metadata of the stored
+// events of a batch, enveloped and older bare payloads alike
+const scrubOutboxMetadataQuery = `
+	UPDATE outbox_events o
+	SET payload = jsonb_set(o.payload, s.path, (o.payload #> s.path) - $2::text[])
+	FROM (
+		SELECT id, CASE WHEN payload ? 'schema_version'
+		                THEN '{payload,Metadata}'::text[] ELSE '{Metadata}'::text[] END AS path
+		FROM outbox_events
+		WHERE aggregate_id = ANY ($1::text[])
+	) s
+	WHERE o.id = s.id AND jsonb_typeof(o.payload #> s.path) = 'object'
+`
+
+// scrubDeliveryMetadataQuery does the same for webhook deliveries, which
+// hold the event without its envelope
+const scrubDeliveryMetadataQuery = `
+	UPDATE webhook_deliveries
+	SET payload = jsonb_set(payload, '{Metadata}', (payload -> 'Metadata') - $2::text[])
+	WHERE event_id IN (SELECT id FROM outbox_events WHERE aggregate_id = ANY ($1::text[]))
+	  AND jsonb_typeof(payload -> 'Metadata') = 'object'
+`
+
+const insertErasureLogQuery = `
+	INSERT INTO erasure_log (pseudonym, payments_erased, erased_at)
+	VALUES ($1, $2, $3)
+`
+
+// EraseCustomer replaces customerID with pseudonym on every payment in batches of
+// batchSize and removes piiKeys from their metadata, in the payments and in the
+// outbox events and webhook deliveries written for them. Events the relay has
+// already published are out of reach. It then records the erasure and its
+// outbox event in a final transaction and returns the merchant-scoped
+// idempotency keys of the touched payments so caches can be purged.
+func (r *Repository) EraseCustomer(ctx context.Context, customerID, pseudonym string, piiKeys []string, batchSize int) ([]string, error) {
+	if piiKeys == nil {
+		piiKeys = []string{}
+	}
+
+	var keys []string
+	for {
+		var ids, batch []string
+		err := r.withTx(ctx, "erase_customer_batch", func(ctx context.Context, tx pgx.Tx) error {
+			rows, err := tx.Query(ctx, pseudonymizeBatchQuery, customerID, pseudonym, batchSize, piiKeys)
+			if err != nil {
+				return fmt.Errorf("pseudonymize payments: %w", err)
+			}
+			ids, batch = nil, nil
+			var id, merchantID, key string
+			_, err = pgx.ForEachRow(rows, []any{&id, &merchantID, &key}, func() error {
+				ids = append(ids, id)
+				batch = append(batch, domain.ScopedIdempotencyKey(merchantID, key))
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("pseudonymize payments: %w", err)
+			}
+
+			if len(piiKeys) == 0 || len(ids) == 0 {
+				return nil
+			}
+			if _, err := tx.Exec(ctx, scrubOutboxMetadataQuery, ids, piiKeys); err != nil {
+				return fmt.Errorf("scrub outbox events: %w", err)
+			}
+			if _, err := tx.Exec(ctx, scrubDeliveryMetadataQuery, ids, piiKeys); err != nil {
+				return fmt.Errorf("scrub webhook deliveries: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, batch...)
+		if len(batch) < batchSize {
+			break
+		}
+	}
+
+	evt := domain.CustomerErased{
+		Pseudonym:      pseudonym,
+		PaymentsErased: len(keys),
+		OccurredAt:     time.Now().UTC(),
+	}
+
+	err := r.withTx(ctx, "record_erasure", func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, insertErasureLogQuery, pseudonym, len(keys), evt.OccurredAt); err != nil {
+			return fmt.Errorf("insert erasure log: %w", err)
+		}
+		return insertOutboxEvent(ctx, tx, pseudonym, evt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,274 @@
+package postgres_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/adapters/postgres"
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/domain/domaintest"
+)
+
+// erasure removes the PII keys from the payments and from every stored copy
+// of their metadata, the other keys stay
+func TestEraseCustomerScrubsStoredEvents(t *testing.T) {
+	repo, pool := newRepository(t)
+	ctx := context.Background()
+
+	metadata := domain.Metadata{"email": "ada@example.com", "cart_id": "cart-1"}
+	var payments []*domain.Payment
+	for _, key := range []string{"idem-1", "idem-2", "idem-3"} {
+		p := domaintest.NewPaymentBuilder().
+			WithID(domain.NewPaymentID()).
+			WithCustomerID("cus-ada").
+			WithIdempotencyKey(key).
+			WithMetadata(metadata).
+			BuildNew()
+		if err := repo.Save(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+		payments = append(payments, p)
+	}
+
+	// a delivery as the dispatcher fans it out, the event without its envelope
+	_, err := pool.Exec(ctx, `
+		WITH endpoint AS (
+			INSERT INTO webhook_endpoints (url, secret, event_types)
+			VALUES ('https://example.com/hook', 'secret', '{payment.initiated}')
+			RETURNING id
+		)
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, payload)
+		SELECT endpoint.id, o.id, o.event_type, o.payload -> 'payload'
+		FROM endpoint, outbox_events o
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a batch smaller than the payments walks every batch
+	keys, err := repo.EraseCustomer(ctx, "cus-ada", "erased_ada", []string{"email"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(payments) {
+		t.Fatalf("erased %d payments, want %d", len(keys), len(payments))
+	}
+
+	want := domain.Metadata{"cart_id": "cart-1"}
+	for _, p := range payments {
+		stored, err := repo.FindByID(ctx, p.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.CustomerID() != "erased_ada" || !maps.Equal(stored.Metadata(), want) {
+			t.Fatalf("payment kept customer %s, metadata %v", stored.CustomerID(), stored.Metadata())
+		}
+	}
+
+	for _, q := range []string{
+		`SELECT payload -> 'payload' -> 'Metadata' FROM outbox_events WHERE event_type = 'payment.initiated'`,
+		`SELECT payload -> 'Metadata' FROM webhook_deliveries`,
+	} {
+		rows, err := pool.Query(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for rows.Next() {
+			var raw []byte
+			if err := rows.Scan(&raw); err != nil {
+				t.Fatal(err)
+			}
+			var got domain.Metadata
+			if err := json.Unmarshal(raw, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, want) {
+				t.Errorf("%s: metadata = %v, want %v", q, got, want)
+			}
+			n++
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if n != len(payments) {
+			t.Errorf("%s: %d rows, want %d", q, n, len(payments))
+		}
+	}
+}
+
+// archived builds a payment of the customer that finished a year ago
+func archived(t *testing.T, repo *postgres.Repository, key string, finish func(*domain.Payment) error) *domain.Payment {
+	t.Helper()
+	ctx := context.Background()
+	p := domaintest.NewPaymentBuilder().
+		WithCustomerID("cus-ada").
+		WithIdempotencyKey(key).
+		WithMetadata(domain.Metadata{"email": "ada@example.com"}).
+		WithClock(time.Now().AddDate(-1, 0, 0)).
+		BuildNew()
+	if err := repo.Save(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := finish(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// erasure reaches idempotency replays and finished payments, and leaves an
+// audit trail that only knows the pseudonym
+func TestEraseCustomerCoversReplaysAuditAndArchive(t *testing.T) {
+	repo, pool := newRepository(t)
+	ctx := context.Background()
+	log := slog.New(slog.DiscardHandler)
+
+	kv := memory.NewKeyValueStore()
+	svc := app.NewPaymentService(repo, kv, time.Hour, kv, mockprovider.New(time.Millisecond),
+		app.RequestLimits{Currencies: []string{"EUR"}, MaxAmountCents: 100000}, nil, log)
+	req := app.InitiatePaymentRequest{
+		OrderID: "order-live", CustomerID: "cus-ada", AmountCents: 1999, Currency: "EUR",
+		IdempotencyKey: "idem-live", Metadata: map[string]string{"email": "ada@example.com"},
+	}
+	live, err := svc.InitiatePayment(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	completed := archived(t, repo, "idem-completed", func(p *domain.Payment) error {
+		if err := p.MarkProcessing("prov_old"); err != nil {
+			return err
+		}
+		return p.Complete()
+	})
+	expired := archived(t, repo, "idem-expired", (*domain.Payment).Expire)
+
+	other := domaintest.NewPaymentBuilder().WithID(domain.NewPaymentID()).WithCustomerID("cus-bob").WithIdempotencyKey("idem-bob").BuildNew()
+	if err := repo.Save(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	history := map[domain.PaymentID]int{}
+	for _, p := range []*domain.Payment{completed, expired} {
+		changes, err := repo.StatusHistory(ctx, p.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		history[p.ID()] = len(changes)
+	}
+
+	erasure := app.NewErasureService(repo, kv, "erasure-key", []string{"email"}, 2, log)
+	result, err := erasure.EraseCustomer(ctx, "cus-ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.PaymentsErased != 3 {
+		t.Fatalf("erased %d payments, want 3", result.PaymentsErased)
+	}
+
+	t.Run("idempotency replays", func(t *testing.T) {
+		// the cached response was purged, the replay reads the erased row
+		replay, err := svc.InitiatePayment(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !replay.Replayed || replay.Source != app.SourceDB || replay.PaymentID != live.PaymentID {
+			t.Fatalf("replay = %+v, want %s replayed from the database", replay, live.PaymentID)
+		}
+		for _, key := range []string{"idem-live", "idem-completed", "idem-expired"} {
+			p, err := repo.FindByIdempotencyKey(ctx, "", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.CustomerID() != result.Pseudonym || p.Metadata()["email"] != "" {
+				t.Fatalf("%s: customer %s, metadata %v, want the pseudonym without the email", key, p.CustomerID(), p.Metadata())
+			}
+		}
+	})
+
+	t.Run("archived payments", func(t *testing.T) {
+		for _, p := range []*domain.Payment{completed, expired} {
+			stored, err := repo.FindByID(ctx, p.ID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.CustomerID() != result.Pseudonym || stored.Status() != p.Status() || stored.Amount() != p.Amount() {
+				t.Fatalf("payment %s is %s for %s of %v, want %s for the pseudonym of %v",
+					p.ID(), stored.Status(), stored.CustomerID(), stored.Amount(), p.Status(), p.Amount())
+			}
+			changes, err := repo.StatusHistory(ctx, p.ID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != history[p.ID()] {
+				t.Fatalf("payment %s has %d status changes, want the %d it had", p.ID(), len(changes), history[p.ID()])
+			}
+		}
+
+		byOriginal, _, err := repo.List(ctx, domain.ListFilter{CustomerID: "cus-ada", Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		byPseudonym, _, err := repo.List(ctx, domain.ListFilter{CustomerID: result.Pseudonym, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(byOriginal) != 0 || len(byPseudonym) != 3 {
+			t.Fatalf("listed %d by the customer id and %d by the pseudonym, want 0 and 3", len(byOriginal), len(byPseudonym))
+		}
+
+		untouched, err := repo.FindByID(ctx, other.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if untouched.CustomerID() != "cus-bob" {
+			t.Fatalf("another customer's payment became %s", untouched.CustomerID())
+		}
+	})
+
+	t.Run("audit log", func(t *testing.T) {
+		rows, err := pool.Query(ctx, `SELECT pseudonym, payments_erased FROM erasure_log`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var logged []string
+		for rows.Next() {
+			var pseudonym string
+			var n int
+			if err := rows.Scan(&pseudonym, &n); err != nil {
+				t.Fatal(err)
+			}
+			if pseudonym != result.Pseudonym || n != 3 {
+				t.Errorf("erasure_log row (%s, %d), want (%s, 3)", pseudonym, n, result.Pseudonym)
+			}
+			logged = append(logged, pseudonym)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(logged) != 1 {
+			t.Fatalf("%d erasure_log rows, want 1", len(logged))
+		}
+
+		var aggregateID, payload string
+		err = pool.QueryRow(ctx,
+			`SELECT aggregate_id, payload::text FROM outbox_events WHERE event_type = 'customer.erased'`).Scan(&aggregateID, &payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if aggregateID != result.Pseudonym || strings.Contains(payload, "cus-ada") || !strings.Contains(payload, result.Pseudonym) {
+			t.Fatalf("customer.erased for %s with %s, want the pseudonym only", aggregateID, payload)
+		}
+	})
+}
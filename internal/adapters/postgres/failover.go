@@ -0,0 +1,139 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	dbConnectionResetsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gopay_service",
+		Subsystem: "db",
+		Name:      "connection_resets_total",
+		Help:      "Queries that failed because the server connection was reset or shut down.",
+	})
+
+	dbPoolResetsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gopay_service",
+		Subsystem: "db",
+		Name:      "pool_resets_total",
+		Help:      "Times the pool was reset after a burst of connection resets, e.g. a failover.",
+	})
+)
+
+// isConnectionReset reports whether err means the server side of the
+// connection is gone, as seen when the primary fails over
+func isConnectionReset(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03", // cannot_connect_now
+			"08000", // connection_exception
+			"08003", // connection_does_not_exist
+			"08006", // connection_failure
+			"25006": // read_only_sql_transaction, we reached a demoted primary
+			return true
+		}
+		return false
+	}
+
+	var netErr *net.OpError
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.As(err, &netErr)
+}
+
+// FailoverConfig controls when a burst of resets is treated as a failover
+type FailoverConfig struct {
+	// resets within Window that trigger a pool reset
+	Threshold int
+	Window    time.Duration
+	// how long readiness reports not ready after a reset
+	Cooldown time.Duration
+}
+
+// FailoverDetector resets the pool after a burst of connection resets and
+// keeps readiness down while connections are re-established, pool.Ping alone
+// would pass on a fresh connection while idle ones are still dead
+type FailoverDetector struct {
+	pool *pgxpool.Pool
+	cfg  FailoverConfig
+	log  *slog.Logger
+	now  func() time.Time
+
+	mu        sync.Mutex
+	resets    []time.Time
+	coolUntil time.Time
+}
+
+func NewFailoverDetector(pool *pgxpool.Pool, cfg FailoverConfig, log *slog.Logger) *FailoverDetector {
+	return &FailoverDetector{pool: pool, cfg: cfg, log: log, now: time.Now}
+}
+
+// Observe inspects an error returned by a query
+func (d *FailoverDetector) Observe(err error) {
+	if d == nil || !isConnectionReset(err) {
+		return
+	}
+	dbConnectionResetsTotal.Inc()
+
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// keep only resets inside the window
+	cutoff := now.Add(-d.cfg.Window)
+	kept := d.resets[:0]
+	for _, t := range d.resets {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	d.resets = append(kept, now)
+
+	if len(d.resets) < d.cfg.Threshold || now.Before(d.coolUntil) {
+		return
+	}
+
+	d.resets = d.resets[:0]
+	d.coolUntil = now.Add(d.cfg.Cooldown)
+	dbPoolResetsTotal.Inc()
+	d.log.Warn("postgres connection reset burst, resetting pool",
+		"threshold", d.cfg.Threshold,
+		"window", d.cfg.Window.String(),
+		"cooldown", d.cfg.Cooldown.String(),
+		"err", err)
+
+	// drops idle connections, in-use ones are discarded when released
+	d.pool.Reset()
+}
+
+// Ready fails during the cool-down after a pool reset
+func (d *FailoverDetector) Ready(_ context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if until := d.coolUntil; d.now().Before(until) {
+		return fmt.Errorf("recovering from failover until %s", until.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// failingOverPrimary is a fake database that answers like a primary going
+// through a failover: while it is failing over, queries see the connection
+// shut down under them or reach the demoted node, which is read-only
+type failingOverPrimary struct {
+	failingOver bool
+	queries     int
+}
+
+func (p *failingOverPrimary) query() error {
+	p.queries++
+	if !p.failingOver {
+		return nil
+	}
+	switch p.queries % 3 {
+	case 0:
+		return &pgconn.PgError{Code: "57P01", Message: "terminating connection due to administrator command"}
+	case 1:
+		return fmt.Errorf("save payment: %w", &pgconn.PgError{Code: "25006", Message: "cannot execute UPDATE in a read-only transaction"})
+	default:
+		return io.ErrUnexpectedEOF
+	}
+}
+
+func poolResets(t *testing.T) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == "gopay_service_db_pool_resets_total" {
+			return f.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestFailoverDetectorDegradesAndRecovers(t *testing.T) {
+	// never connects, Reset only drops what it holds
+	pool, err := pgxpool.New(context.Background(), "postgres://gopay@127.0.0.1:1/gopay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	now := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+	d := NewFailoverDetector(pool, FailoverConfig{Threshold: 3, Window: 10 * time.Second, Cooldown: 15 * time.Second},
+		slog.New(slog.DiscardHandler))
+	d.now = func() time.Time { return now }
+	ready := func() error { return d.Ready(context.Background()) }
+
+	db := &failingOverPrimary{}
+	resets := poolResets(t)
+
+	// healthy queries and errors that are not resets leave readiness alone
+	d.Observe(db.query())
+	d.Observe(&pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"})
+	d.Observe(context.DeadlineExceeded)
+	if err := ready(); err != nil {
+		t.Fatalf("ready = %v before any reset", err)
+	}
+
+	db.failingOver = true
+	// resets further apart than the window are not a failover
+	for range 4 {
+		d.Observe(db.query())
+		now = now.Add(6 * time.Second)
+	}
+	if err := ready(); err != nil || poolResets(t) != resets {
+		t.Fatalf("ready = %v after scattered resets, pool reset %v times", err, poolResets(t)-resets)
+	}
+
+	// a burst inside the window degrades readiness and resets the pool once
+	for range 3 {
+		d.Observe(db.query())
+		now = now.Add(time.Second)
+	}
+	if err := ready(); err == nil {
+		t.Fatal("ready after a burst of resets, want recovering from failover")
+	}
+	if got := poolResets(t) - resets; got != 1 {
+		t.Fatalf("pool reset %v times, want once", got)
+	}
+
+	// the node keeps refusing writes during the cool-down, no second reset
+	for range 5 {
+		d.Observe(db.query())
+	}
+	if got := poolResets(t) - resets; got != 1 {
+		t.Fatalf("pool reset %v times during the cool-down, want once", got)
+	}
+	now = now.Add(10 * time.Second)
+	if err := ready(); err == nil {
+		t.Fatal("ready before the cool-down ended")
+	}
+
+	// the new primary answers, readiness recovers once the cool-down passed
+	db.failingOver = false
+	d.Observe(db.query())
+	now = now.Add(5 * time.Second)
+	if err := ready(); err != nil {
+		t.Fatalf("ready = %v after the cool-down", err)
+	}
+
+	// a later failover is detected again
+	db.failingOver = true
+	for range 3 {
+		d.Observe(db.query())
+	}
+	if err := ready(); err == nil || poolResets(t)-resets != 2 {
+		t.Fatalf("ready = %v, pool reset %v times, want a second failover detected", err, poolResets(t)-resets)
+	}
+}
+
+func TestIsConnectionReset(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil},
+		{err: &pgconn.PgError{Code: "57P01"}, want: true},
+		{err: &pgconn.PgError{Code: "25006"}, want: true},
+		{err: fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: "08006"}), want: true},
+		{err: io.EOF, want: true},
+		{err: &pgconn.PgError{Code: "23505"}},
+		{err: &pgconn.PgError{Code: "40001"}},
+		{err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		if got := isConnectionReset(tt.err); got != tt.want {
+			t.Errorf("isConnectionReset(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
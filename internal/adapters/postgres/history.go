@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+const insertStatusHistoryQuery = `
+	INSERT INTO payment_status_history (payment_id, from_status, to_status, reason, actor, occurred_at)
+	VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6)
+`
+
+// writeStatusHistory runs in the transaction of the payment upsert, so the
+// history never disagrees with the stored status
+func writeStatusHistory(ctx context.Context, tx pgx.Tx, changes []domain.StatusChange) error {
+	for _, c := range changes {
+		if _, err := tx.Exec(ctx, insertStatusHistoryQuery, c.PaymentID, string(c.From), string(c.To), c.Reason, c.Actor, c.OccurredAt); err != nil {
+			return fmt.Errorf("insert status history %s -> %s: %w", c.From, c.To, err)
+		}
+	}
+	return nil
+}
+
+const statusHistoryQuery = `
+	SELECT payment_id, COALESCE(from_status, ''), to_status, reason, actor, occurred_at
+	FROM payment_status_history
+	WHERE payment_id = $1
+	ORDER BY occurred_at, id
+`
+
+func (r *Repository) StatusHistory(ctx context.Context, id domain.PaymentID) ([]domain.StatusChange, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, statusHistoryQuery, id.String())
+	if err != nil {
+		r.failover.Observe(err)
+		return nil, fmt.Errorf("query status history: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []domain.StatusChange
+	for rows.Next() {
+		var (
+			c        domain.StatusChange
+			from, to string
+		)
+		if err := rows.Scan(&c.PaymentID, &from, &to, &c.Reason, &c.Actor, &c.OccurredAt); err != nil {
+			return nil, fmt.Errorf("scan status history: %w", err)
+		}
+		c.From, c.To = domain.PaymentStatus(from), domain.PaymentStatus(to)
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		r.failover.Observe(err)
+		return nil, fmt.Errorf("iterate status history: %w", err)
+	}
+	return changes, nil
+}
@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	outboxBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gopay_service",
+		Subsystem: "outbox",
+		Name:      "backlog",
+		Help:      "Unpublished, unparked outbox events at the last check.",
+	})
+
+	outboxOldestUnpublishedAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gopay_service",
+		Subsystem: "outbox",
+		Name:      "oldest_unpublished_age_seconds",
+		Help:      "Age of the oldest unpublished, unparked outbox event at the last check, 0 when none.",
+	})
+)
+
+// OutboxMonitorConfig controls how often the backlog is measured and when a
+// lagging relay is reported
+type OutboxMonitorConfig struct {
+	Interval time.Duration
+	// Ready fails once the oldest unpublished event is older, zero disables
+	LagThreshold time.Duration
+}
+
+// OutboxMonitor measures the outbox backlog whether or not this instance
+// runs the relay, so a stuck or missing relay shows up in metrics
+type OutboxMonitor struct {
+	pool *pgxpool.Pool
+	cfg  OutboxMonitorConfig
+	log  *slog.Logger
+
+	mu     sync.Mutex
+	oldest time.Time
+}
+
+func NewOutboxMonitor(pool *pgxpool.Pool, cfg OutboxMonitorConfig, log *slog.Logger) *OutboxMonitor {
+	return &OutboxMonitor{pool: pool, cfg: cfg, log: log}
+}
+
+// Run blocks until ctx is cancelled
+func (m *OutboxMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	m.check(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check(ctx)
+		}
+	}
+}
+
+const outboxBacklogQuery = `
+	SELECT COUNT(*), MIN(created_at)
+	FROM outbox_events
+	WHERE published_at IS NULL AND parked_at IS NULL
+`
+
+// check is best effort, a failed query leaves the last values
+func (m *OutboxMonitor) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Interval)
+	defer cancel()
+
+	var (
+		n      int64
+		oldest *time.Time
+	)
+	err := m.pool.QueryRow(ctx, outboxBacklogQuery).Scan(&n, &oldest)
+	if err != nil {
+		m.log.DebugContext(ctx, "cannot measure outbox backlog", "err", err)
+		return
+	}
+
+	outboxBacklog.Set(float64(n))
+	age := 0.0
+	if oldest != nil {
+		age = time.Since(*oldest).Seconds()
+	}
+	outboxOldestUnpublishedAge.Set(age)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.oldest = time.Time{}
+	if oldest != nil {
+		m.oldest = *oldest
+	}
+}
+
+// Ready fails while the oldest unpublished event seen by the last check is
+// older than the lag threshold
+func (m *OutboxMonitor) Ready(_ context.Context) error {
+	if m.cfg.LagThreshold <= 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.oldest.IsZero() {
+		return nil
+	}
+	if age := time.Since(m.oldest); age > m.cfg.LagThreshold {
+		return fmt.Errorf("oldest unpublished outbox event is %s old, threshold %s", age.Round(time.Second), m.cfg.LagThreshold)
+	}
+	return nil
+}
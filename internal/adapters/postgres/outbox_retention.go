@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	outboxPurgedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gopay_service",
+		Subsystem: "outbox",
+		Name:      "purged_total",
+		Help:      "Published outbox events deleted by the retention job.",
+	})
+
+	outboxPurgeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gopay_service",
+		Subsystem: "outbox",
+		Name:      "purge_duration_seconds",
+		Help:      "Duration of one retention run over all its batches.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+	})
+)
+
+// OutboxRetentionConfig bounds how long published events are kept and how
+// hard the purge leans on the table
+type OutboxRetentionConfig struct {
+	// published events older than this are deleted
+	Retention time.Duration
+	Interval  time.Duration
+	// rows deleted per statement, keeps every lock short
+	BatchSize int
+	// sleep between batches so the purge yields to payment traffic
+	Pause time.Duration
+}
+
+// OutboxRetention deletes published outbox events past the retention
+// period. Replicas run it side by side, SKIP LOCKED hands each batch to one
+// of them. Unpublished and parked events are never touched.
+type OutboxRetention struct {
+	pool *pgxpool.Pool
+	cfg  OutboxRetentionConfig
+	log  *slog.Logger
+}
+
+func NewOutboxRetention(pool *pgxpool.Pool, cfg OutboxRetentionConfig, log *slog.Logger) *OutboxRetention {
+	return &OutboxRetention{pool: pool, cfg: cfg, log: log}
+}
+
+// Run blocks until ctx is cancelled
+func (r *OutboxRetention) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := r.Purge(ctx); err != nil {
+			r.log.WarnContext(ctx, "outbox retention run failed", "err", err, "purged", n)
+		} else if n > 0 {
+			r.log.InfoContext(ctx, "outbox retention run completed", "purged", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+const purgeOutboxQuery = `
+	DELETE FROM outbox_events
+	WHERE id IN (
+		SELECT id
+		FROM outbox_events
+		WHERE published_at < $1
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)
+`
+
+// Purge deletes batches until none is left past the retention period and
+// returns how many rows went
+func (r *OutboxRetention) Purge(ctx context.Context) (int64, error) {
+	start := time.Now()
+	defer func() { outboxPurgeDuration.Observe(time.Since(start).Seconds()) }()
+
+	// one cutoff per run, rows published meanwhile wait for the next
+	cutoff := start.Add(-r.cfg.Retention)
+	var total int64
+	for {
+		tag, err := r.pool.Exec(ctx, purgeOutboxQuery, cutoff, r.cfg.BatchSize)
+		if err != nil {
+			return total, fmt.Errorf("delete published outbox events: %w", err)
+		}
+		n := tag.RowsAffected()
+		total += n
+		outboxPurgedTotal.Add(float64(n))
+		if n < int64(r.cfg.BatchSize) {
+			return total, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(r.cfg.Pause):
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Package pgtest checks the registered queries of the adapters against the
+// migrations. Tests that need a database run against the URL in
+// GOPAY_TEST_DATABASE_DSN and are skipped without it.
+package pgtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/postgres"
+	"github.com/ademajagon/gopay-service/migrations"
+)
+
+// DSNEnv names the variable holding a postgres:// URL of a database the
+// tests may create schemas in
+const DSNEnv = "GOPAY_TEST_DATABASE_DSN"
+
+// NewSchema creates an empty schema, applies every migration to it and
+// returns a pool whose search_path is that schema. The schema is dropped
+// when the test ends.
+func NewSchema(t testing.TB) *pgxpool.Pool {
+	t.Helper()
+
+	dsn := os.Getenv(DSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", DSNEnv)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	admin, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect to %s: %v", DSNEnv, err)
+	}
+	defer func() { _ = admin.Close(context.Background()) }()
+
+	suffix := make([]byte, 6)
+	_, _ = rand.Read(suffix)
+	schema := "gopay_test_" + hex.EncodeToString(suffix)
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		conn, err := pgx.Connect(context.Background(), dsn)
+		if err != nil {
+			t.Logf("drop schema %s: %v", schema, err)
+			return
+		}
+		defer func() { _ = conn.Close(context.Background()) }()
+		if _, err := conn.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Logf("drop schema %s: %v", schema, err)
+		}
+	})
+
+	scoped, err := url.Parse(dsn)
+	if err != nil || (scoped.Scheme != "postgres" && scoped.Scheme != "postgresql") {
+		t.Fatalf("%s must be a postgres:// URL", DSNEnv)
+	}
+	query := scoped.Query()
+	query.Set("search_path", schema)
+	scoped.RawQuery = query.Encode()
+
+	if err := Migrate(scoped.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := pgxpool.New(ctx, scoped.String())
+	if err != nil {
+		t.Fatalf("open pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// Migrate applies every embedded migration, the way the server does at start
+func Migrate(dsn string) error {
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return fmt.Errorf("read migrations: %w", err)
+	}
+	m, err := migrate.NewWithSourceInstance("iofs", src, dsn)
+	if err != nil {
+		return fmt.Errorf("init migrate: %w", err)
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("apply migrations: %w", err)
+	}
+	return nil
+}
+
+// Explain prepares every query, checks it takes as many parameters as it
+// has sample arguments and explains it with them. Nothing is executed, so
+// an empty schema is enough.
+func Explain(t *testing.T, pool *pgxpool.Pool, queries []postgres.NamedQuery) {
+	t.Helper()
+
+	for _, q := range queries {
+		t.Run(q.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			tx, err := pool.Begin(ctx)
+			if err != nil {
+				t.Fatalf("begin: %v", err)
+			}
+			defer func() { _ = tx.Rollback(ctx) }()
+
+			sd, err := tx.Prepare(ctx, q.Name, q.SQL)
+			if err != nil {
+				t.Fatalf("prepare: %v", err)
+			}
+			if len(sd.ParamOIDs) != len(q.Args) {
+				t.Fatalf("statement takes %d parameters, %d sample arguments registered", len(sd.ParamOIDs), len(q.Args))
+			}
+			if _, err := tx.Exec(ctx, "EXPLAIN "+q.SQL, q.Args...); err != nil {
+				t.Fatalf("explain: %v", err)
+			}
+		})
+	}
+}
+
+// sqlVerbs start the string constants Unregistered treats as statements, a
+// verb alone is not one
+var sqlVerbs = []string{"SELECT", "INSERT", "UPDATE", "DELETE", "WITH"}
+
+// Unregistered fails t for every statement in the non-test Go files of dir
+// that is not in queries. A statement is a string constant expression
+// starting with an upper case SQL verb, constants defined in dir are
+// resolved. A statement that only starts a registered query passes, that is
+// the fixed part of a query built at run time.
+func Unregistered(t *testing.T, dir string, queries []postgres.NamedQuery) {
+	t.Helper()
+
+	names := map[string]bool{}
+	for _, q := range queries {
+		if q.Name == "" || names[q.Name] {
+			t.Errorf("query name %q is empty or registered twice", q.Name)
+		}
+		names[q.Name] = true
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	var parsed []*ast.File
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, f)
+	}
+
+	consts := packageConsts(parsed)
+	registered := func(stmt string) bool {
+		return slices.ContainsFunc(queries, func(q postgres.NamedQuery) bool {
+			return strings.HasPrefix(q.SQL, stmt)
+		})
+	}
+
+	for _, f := range parsed {
+		ast.Inspect(f, func(n ast.Node) bool {
+			expr, ok := n.(ast.Expr)
+			if !ok {
+				return true
+			}
+			s, ok := stringConst(expr, consts, 0)
+			if !ok {
+				return true
+			}
+			if isStatement(s) && !registered(s) {
+				t.Errorf("%s: statement is not registered in Queries: %.60q", fset.Position(expr.Pos()), strings.TrimSpace(s))
+			}
+			return false
+		})
+	}
+}
+
+// packageConsts maps the package level constants to their expressions
+func packageConsts(files []*ast.File) map[string]ast.Expr {
+	consts := map[string]ast.Expr{}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for i, name := range vs.Names {
+					if i < len(vs.Values) {
+						consts[name.Name] = vs.Values[i]
+					}
+				}
+			}
+		}
+	}
+	return consts
+}
+
+// stringConst evaluates literals, their concatenation and the constants
+// they name, depth stops a constant defined through itself
+func stringConst(expr ast.Expr, consts map[string]ast.Expr, depth int) (string, bool) {
+	if depth > 16 {
+		return "", false
+	}
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		s, err := strconv.Unquote(e.Value)
+		return s, err == nil
+	case *ast.ParenExpr:
+		return stringConst(e.X, consts, depth+1)
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return "", false
+		}
+		x, ok := stringConst(e.X, consts, depth+1)
+		if !ok {
+			return "", false
+		}
+		y, ok := stringConst(e.Y, consts, depth+1)
+		return x + y, ok
+	case *ast.Ident:
+		if v, ok := consts[e.Name]; ok {
+			return stringConst(v, consts, depth+1)
+		}
+	}
+	return "", false
+}
+
+func isStatement(s string) bool {
+	fields := strings.Fields(s)
+	return len(fields) > 1 && slices.Contains(sqlVerbs, fields[0])
+}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// NamedQuery is a statement an adapter sends, with arguments of the Go
+// types its call site binds. pgtest prepares and explains every one of them
+// against the migrated schema, so a migration that breaks a query fails CI.
+type NamedQuery struct {
+	Name string
+	SQL  string
+	Args []any
+}
+
+// sample arguments, the values only have to bind, no row has to match
+var (
+	sampleID   = "00000000-0000-4000-8000-000000000001"
+	sampleTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sampleJSON = []byte(`{"order":"o-1"}`)
+)
+
+// Queries is every statement of this package. A statement sent without
+// being registered here fails pgtest.Unregistered.
+func Queries() []NamedQuery {
+	// every filter and the cursor, the no-filter page is the other extreme
+	listAll, listAllArgs := listQuery("merchant-1", domain.ListFilter{
+		CustomerID:      "cus-1",
+		Status:          domain.StatusCompleted,
+		Currency:        "EUR",
+		ClientReference: "ref-1",
+		CreatedAfter:    sampleTime,
+		CreatedBefore:   sampleTime,
+		After:           domain.Cursor{CreatedAt: sampleTime, ID: sampleID},
+		Limit:           20,
+	})
+	listFirst, listFirstArgs := listQuery(nil, domain.ListFilter{Limit: 20})
+
+	return []NamedQuery{
+		{"payments.insert", insertPaymentQuery, []any{
+			sampleID, "order-1", "cus-1", int64(1000), "EUR", "PENDING", "", "",
+			"idem-1", "ref-1", sampleID, "hash", sampleJSON, sampleTime, sampleTime, "merchant-1",
+		}},
+		{"payments.update", updatePaymentQuery, []any{sampleID, "COMPLETED", "pi_1", "", "cap-1", sampleJSON, sampleTime, 2}},
+		{"payments.stored_version", storedVersionQuery, []any{sampleID}},
+		{"payments.find_by_idempotency_key", findByIdempotencyKeyQuery, []any{"merchant-1", "idem-1"}},
+		{"payments.find_by_id", findByIDQuery, []any{sampleID, "merchant-1"}},
+		{"payments.find_by_id_unscoped", findByIDQuery, []any{sampleID, nil}},
+		{"payments.find_by_correlation_id", findByCorrelationIDQuery, []any{sampleID, "merchant-1"}},
+		{"payments.find_by_order_id", findByOrderIDQuery, []any{"order-1", nil}},
+		{"payments.find_expired_pending", findExpiredPendingQuery, []any{sampleTime, 100}},
+		{"payments.find_by_provider_ref", findByProviderRefQuery, []any{"pi_1"}},
+		{"payments.find_by_provider_refs", findByProviderRefsQuery, []any{[]string{"pi_1", "pi_2"}}},
+		{"payments.list", listFirst, listFirstArgs},
+		{"payments.list_filtered", listAll, listAllArgs},
+		{"payments.lock_amount", lockPaymentAmountQuery, []any{sampleID}},
+		{"payments.pseudonymize_batch", pseudonymizeBatchQuery, []any{"cus-1", "erased-1", 500, []string{"email"}}},
+
+		{"outbox.insert_event", insertOutboxEventQuery, []any{sampleID, sampleID, "payment.initiated", sampleJSON, OutboxNotifyChannel}},
+		{"outbox.insert_backfilled_event", insertBackfilledEventQuery, []any{sampleID, sampleID, "payment.initiated", sampleJSON}},
+		{"outbox.backlog", outboxBacklogQuery, nil},
+		{"outbox.purge", purgeOutboxQuery, []any{sampleTime, 1000}},
+
+		{"status_history.insert", insertStatusHistoryQuery, []any{sampleID, "PENDING", "COMPLETED", "", "system", sampleTime}},
+		{"status_history.list", statusHistoryQuery, []any{sampleID}},
+
+		{"backfill_runs.create", createBackfillRunQuery, []any{sampleTime, sampleTime}},
+		{"backfill_runs.get", getBackfillRunQuery, []any{sampleID}},
+		{"backfill_runs.next_batch", nextBackfillBatchQuery, []any{sampleTime, sampleTime, &sampleTime, &sampleID, 500}},
+		{"backfill_runs.advance_checkpoint", advanceBackfillCheckpointQuery, []any{sampleID, sampleTime, sampleID, 10}},
+		{"backfill_runs.set_status", setBackfillStatusQuery, []any{sampleID, "FAILED", "boom"}},
+
+		{"erasure_log.insert", insertErasureLogQuery, []any{"erased-1", 3, sampleTime}},
+		{"outbox.scrub_metadata", scrubOutboxMetadataQuery, []any{[]string{sampleID}, []string{"email"}}},
+		{"webhook_deliveries.scrub_metadata", scrubDeliveryMetadataQuery, []any{[]string{sampleID}, []string{"email"}}},
+
+		{"refunds.sum_refunded", refundedAmountQuery, []any{sampleID}},
+		{"refunds.insert", insertRefundQuery, []any{sampleID, sampleID, int64(500), "EUR", "PENDING", "", "idem-1", sampleTime}},
+		{"refunds.update", updateRefundQuery, []any{sampleID, "SUCCEEDED", "re_1", ""}},
+		{"refunds.find_by_idempotency_key", findRefundByIdempotencyKeyQuery, []any{sampleID, "idem-1"}},
+		{"refunds.list", listRefundsQuery, []any{sampleID}},
+
+		{"settlement_batches.create", createSettlementBatchQuery, []any{"stripe", "hash"}},
+		{"settlement_batches.find", findSettlementBatchQuery, []any{"stripe", "hash"}},
+		{"settlement_batches.get", getSettlementBatchQuery, []any{sampleID}},
+		{"settlement_batches.complete", completeSettlementBatchQuery, []any{sampleID}},
+		{"settlement_batches.fail", failSettlementBatchQuery, []any{sampleID, "boom"}},
+		{"settlement_items.insert", insertSettlementItemQuery, []any{sampleID, 1, "pi_1", int64(1000), "EUR", &sampleID, "MATCHED"}},
+
+		{"api_keys.create", createAPIKeyQuery, []any{"merchant-1", "ci", "gpk_abcd", "hash", []string{"payments:read"}}},
+		{"api_keys.find_by_prefix", findAPIKeyByPrefixQuery, []any{"gpk_abcd"}},
+		{"api_keys.list", listAPIKeysQuery, []any{"merchant-1"}},
+		{"api_keys.revoke", revokeAPIKeyQuery, []any{sampleID}},
+
+		{"webhook_endpoints.create", createWebhookEndpointQuery, []any{"https://example.com/hook", "secret", []string{"payment.completed"}, true}},
+		{"webhook_endpoints.get", getWebhookEndpointQuery, []any{sampleID}},
+		{"webhook_endpoints.list", listWebhookEndpointsQuery, nil},
+		{"webhook_endpoints.update", updateWebhookEndpointQuery, []any{sampleID, "https://example.com/hook", []string{"payment.completed"}, true}},
+		{"webhook_endpoints.delete", deleteWebhookEndpointQuery, []any{sampleID}},
+		{"webhook_deliveries.list", listWebhookDeliveriesQuery, []any{sampleID, "PENDING", 50}},
+
+		{"pg_stat_activity.idle_in_transaction", idleInTransactionQuery, []any{"gopay-service", 30.0}},
+	}
+}
@@ -0,0 +1,16 @@
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/postgres"
+	"github.com/ademajagon/gopay-service/internal/adapters/postgres/pgtest"
+)
+
+func TestQueriesAreRegistered(t *testing.T) {
+	pgtest.Unregistered(t, ".", postgres.Queries())
+}
+
+func TestQueriesMatchMigrations(t *testing.T) {
+	pgtest.Explain(t, pgtest.NewSchema(t), postgres.Queries())
+}
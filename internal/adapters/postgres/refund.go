@@ -0,0 +1,188 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+const refundColumns = `
+	id, payment_id, amount_cents, currency, status, reason, idempotency_key,
+	provider_ref, failure_reason, created_at
+`
+
+const lockPaymentAmountQuery = `SELECT amount_cents FROM payments WHERE id = $1 FOR UPDATE`
+
+const refundedAmountQuery = `
+	SELECT COALESCE(SUM(amount_cents), 0)
+	FROM refunds
+	WHERE payment_id = $1 AND status <> 'FAILED'
+`
+
+const insertRefundQuery = `
+	INSERT INTO refunds (
+		id, payment_id, amount_cents, currency, status, reason,
+		idempotency_key, created_at, updated_at
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+	ON CONFLICT (payment_id, idempotency_key) DO NOTHING
+`
+
+// SaveRefund locks the payment row so concurrent refunds of one payment
+// are checked against each other's amounts
+func (r *Repository) SaveRefund(ctx context.Context, refund *domain.Refund) error {
+	return r.withTx(ctx, "save_refund", func(ctx context.Context, tx pgx.Tx) error {
+		var amountCents int64
+		err := tx.QueryRow(ctx, lockPaymentAmountQuery, refund.PaymentID().String()).Scan(&amountCents)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("lock payment: %w", err)
+		}
+
+		var refunded int64
+		err = tx.QueryRow(ctx, refundedAmountQuery, refund.PaymentID().String()).Scan(&refunded)
+		if err != nil {
+			return fmt.Errorf("sum refunds: %w", err)
+		}
+		if remaining := amountCents - refunded; refund.Amount().Amount() > remaining {
+			return fmt.Errorf("%w: %d requested, %d remaining", domain.ErrOverRefund, refund.Amount().Amount(), remaining)
+		}
+
+		tag, err := tx.Exec(ctx, insertRefundQuery,
+			refund.ID(),
+			refund.PaymentID().String(),
+			refund.Amount().Amount(),
+			refund.Amount().Currency(),
+			string(refund.Status()),
+			refund.Reason(),
+			refund.IdempotencyKey(),
+			refund.CreatedAt(),
+		)
+		if err != nil {
+			return fmt.Errorf("insert refund: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return domain.ErrVersionConflict
+		}
+
+		return writeOutboxEvents(ctx, tx, refund.ID(), refund.PopEvents())
+	})
+}
+
+const updateRefundQuery = `
+	UPDATE refunds SET
+		status         = $2,
+		provider_ref   = $3,
+		failure_reason = $4
+	WHERE id = $1
+	  AND status = 'PENDING'
+`
+
+func (r *Repository) UpdateRefund(ctx context.Context, refund *domain.Refund) error {
+	return r.withTx(ctx, "update_refund", func(ctx context.Context, tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, updateRefundQuery,
+			refund.ID(),
+			string(refund.Status()),
+			refund.ProviderRef(),
+			refund.FailureReason(),
+		)
+		if err != nil {
+			return fmt.Errorf("update refund: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return domain.ErrVersionConflict
+		}
+
+		return writeOutboxEvents(ctx, tx, refund.ID(), refund.PopEvents())
+	})
+}
+
+const findRefundByIdempotencyKeyQuery = `SELECT ` + refundColumns + ` FROM refunds WHERE payment_id = $1 AND idempotency_key = $2`
+
+func (r *Repository) FindRefundByIdempotencyKey(ctx context.Context, paymentID domain.PaymentID, key string) (*domain.Refund, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	refund, err := scanRefund(r.pool.QueryRow(ctx, findRefundByIdempotencyKeyQuery, paymentID.String(), key))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, nil
+		}
+		r.failover.Observe(err)
+		return nil, err
+	}
+	return refund, nil
+}
+
+const listRefundsQuery = `SELECT ` + refundColumns + ` FROM refunds WHERE payment_id = $1 ORDER BY created_at`
+
+func (r *Repository) ListRefunds(ctx context.Context, paymentID domain.PaymentID) ([]*domain.Refund, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, listRefundsQuery, paymentID.String())
+	if err != nil {
+		r.failover.Observe(err)
+		return nil, fmt.Errorf("query refunds: %w", err)
+	}
+	defer rows.Close()
+
+	var refunds []*domain.Refund
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+	if err := rows.Err(); err != nil {
+		r.failover.Observe(err)
+		return nil, fmt.Errorf("iterate refunds: %w", err)
+	}
+	return refunds, nil
+}
+
+func scanRefund(row pgx.Row) (*domain.Refund, error) {
+	var (
+		id             string
+		rawPaymentID   string
+		amountCents    int64
+		currency       string
+		status         string
+		reason         string
+		idempotencyKey string
+		providerRef    string
+		failureReason  string
+		createdAt      time.Time
+	)
+
+	err := row.Scan(&id, &rawPaymentID, &amountCents, &currency, &status, &reason, &idempotencyKey,
+		&providerRef, &failureReason, &createdAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("scan refund row: %w", err)
+	}
+
+	paymentID, err := domain.ParsePaymentID(rawPaymentID)
+	if err != nil {
+		return nil, fmt.Errorf("parse stored payment ID %w", err)
+	}
+	amount, err := domain.NewMoney(amountCents, currency)
+	if err != nil {
+		return nil, fmt.Errorf("parse stored money %w", err)
+	}
+
+	return domain.ReconstituteRefund(
+		id, paymentID, amount,
+		domain.RefundStatus(status),
+		reason, idempotencyKey, providerRef, failureReason, createdAt,
+	), nil
+}
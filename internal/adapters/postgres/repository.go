@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/ademajagon/gopay-service/internal/domain"
@@ -15,50 +18,118 @@ import (
 
 type Repository struct {
 	pool *pgxpool.Pool
+	// replica for lookups and listings that tolerate lag, nil reads from pool
+	read     *pgxpool.Pool
+	txs      *txRegistry
+	failover *FailoverDetector
+	timeouts QueryTimeouts
 }
 
-func NewRepository(pool *pgxpool.Pool) *Repository {
-	return &Repository{pool: pool}
+// QueryTimeouts bound single statements and transactions on top of the
+// caller's deadline, zero leaves only the caller's deadline
+type QueryTimeouts struct {
+	Read  time.Duration
+	Write time.Duration
 }
 
-func (r *Repository) Save(p *domain.Payment) error {
-	ctx := context.Background()
+// NewRepository accepts a nil read pool and a nil failover detector
+func NewRepository(pool, read *pgxpool.Pool, failover *FailoverDetector, timeouts QueryTimeouts) *Repository {
+	return &Repository{pool: pool, read: read, txs: newTxRegistry(), failover: failover, timeouts: timeouts}
+}
+
+// reader picks the replica unless ctx asks to see every committed write
+func (r *Repository) reader(ctx context.Context) *pgxpool.Pool {
+	if r.read == nil || domain.IsConsistentRead(ctx) {
+		return r.pool
+	}
+	return r.read
+}
+
+// observe feeds failover detection, which watches the primary only
+func (r *Repository) observe(db *pgxpool.Pool, err error) {
+	if db == r.pool {
+		r.failover.Observe(err)
+	}
+}
+
+// withReadTimeout bounds a read-only statement
+func (r *Repository) withReadTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, r.timeouts.Read)
+}
+
+// withWriteTimeout bounds a statement or transaction that writes
+func (r *Repository) withWriteTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, r.timeouts.Write)
+}
+
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
 
-	return r.withTx(ctx, func(tx pgx.Tx) error {
+// idempotencyKeyIndex is the unique index a concurrent duplicate insert
+// violates, keys are unique per merchant
+const idempotencyKeyIndex = "idx_payments_merchant_idempotency_key"
+
+func (r *Repository) Save(ctx context.Context, p *domain.Payment) error {
+	err := r.withTx(ctx, "save_payment", func(ctx context.Context, tx pgx.Tx) error {
 		if err := r.upsertPayment(ctx, tx, p); err != nil {
 			return err
 		}
 
-		if err := r.writeOutboxEvents(ctx, tx, p); err != nil {
+		events := p.PopEvents()
+		if err := writeStatusHistory(ctx, tx, domain.StatusChanges(events, domain.ActorFromContext(ctx))); err != nil {
+			return err
+		}
+		if err := writeOutboxEvents(ctx, tx, p.ID().String(), events); err != nil {
 			return err
 		}
 		return nil
 	})
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" /* unique_violation */ && pgErr.ConstraintName == idempotencyKeyIndex {
+		// the failed transaction is gone, the winner is read outside it
+		existing, findErr := r.FindByIdempotencyKey(domain.WithConsistentRead(ctx), p.MerchantID(), p.IdempotencyKey())
+		if findErr != nil || existing == nil {
+			return fmt.Errorf("%w: reading the existing payment failed: %w", domain.ErrDuplicateIdempotencyKey, errors.Join(err, findErr))
+		}
+		return &domain.DuplicateIdempotencyKeyError{Existing: existing}
+	}
+	return err
 }
 
+// upsertPayment inserts version 1 and otherwise updates from exactly the
+// previous version, anything else is a bug in the caller
 func (r *Repository) upsertPayment(ctx context.Context, tx pgx.Tx, p *domain.Payment) error {
-	const q = `
-		INSERT INTO payments (
-			id, order_id, customer_id,
-			amount_cents, currency,
-			status, provider_ref, failure_reason,
-			idempotency_key,
-			created_at, updated_at,
-			version
-		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
-		)
-		ON CONFLICT (id) DO UPDATE SET
-			status         = EXCLUDED.status,
-			provider_ref   = EXCLUDED.provider_ref,
-			failure_reason = EXCLUDED.failure_reason,
-			updated_at     = EXCLUDED.updated_at,
-			version        = EXCLUDED.version
-		WHERE
-			payments.version = EXCLUDED.version - 1
-	`
-
-	tag, err := tx.Exec(ctx, q,
+	switch {
+	case p.Version() < 1:
+		return fmt.Errorf("%w: payment %s has version %d", domain.ErrVersionInvariant, p.ID(), p.Version())
+	case p.Version() == 1:
+		return r.insertPayment(ctx, tx, p)
+	default:
+		return r.updatePayment(ctx, tx, p)
+	}
+}
+
+const insertPaymentQuery = `
+	INSERT INTO payments (
+		id, order_id, customer_id,
+		amount_cents, currency,
+		status, provider_ref, failure_reason,
+		idempotency_key, client_reference, correlation_id,
+		request_hash, metadata, created_at, updated_at,
+		merchant_id, version
+	) VALUES (
+		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, 1
+	)
+	ON CONFLICT (id) DO NOTHING
+`
+
+func (r *Repository) insertPayment(ctx context.Context, tx pgx.Tx, p *domain.Payment) error {
+	tag, err := tx.Exec(ctx, insertPaymentQuery,
 		p.ID().String(),
 		p.OrderID(),
 		p.CustomerID(),
@@ -68,99 +139,358 @@ func (r *Repository) upsertPayment(ctx context.Context, tx pgx.Tx, p *domain.Pay
 		p.ProviderRef(),
 		p.FailureReason(),
 		p.IdempotencyKey(),
+		p.ClientReference(),
+		p.CorrelationID(),
+		p.RequestHash(),
+		metadataJSON(p.Metadata()),
 		p.CreatedAt(),
 		p.UpdatedAt(),
-		p.Version(),
+		p.MerchantID(),
 	)
-
 	if err != nil {
-		return fmt.Errorf("upsert payment: %w", err)
+		return fmt.Errorf("insert payment: %w", err)
 	}
 
+	// the id is already taken, someone else created it first
 	if tag.RowsAffected() == 0 {
 		return domain.ErrVersionConflict
 	}
-
 	return nil
 }
 
-func (r *Repository) writeOutboxEvents(ctx context.Context, tx pgx.Tx, p *domain.Payment) error {
-	events := p.PopEvents()
-	if len(events) == 0 {
+const updatePaymentQuery = `
+	UPDATE payments SET
+		status         = $2,
+		provider_ref   = $3,
+		failure_reason = $4,
+		capture_key    = $5,
+		metadata       = $6,
+		updated_at     = $7,
+		version        = $8
+	WHERE id = $1
+	  AND version = $8 - 1
+`
+
+func (r *Repository) updatePayment(ctx context.Context, tx pgx.Tx, p *domain.Payment) error {
+	tag, err := tx.Exec(ctx, updatePaymentQuery,
+		p.ID().String(),
+		string(p.Status()),
+		p.ProviderRef(),
+		p.FailureReason(),
+		p.CaptureKey(),
+		metadataJSON(p.Metadata()),
+		p.UpdatedAt(),
+		p.Version(),
+	)
+	if err != nil {
+		return fmt.Errorf("update payment: %w", err)
+	}
+	if tag.RowsAffected() == 1 {
 		return nil
 	}
 
-	const q = `
-		INSERT INTO outbox_events (aggregate_id, event_type, payload, created_at)
-		VALUES ($1, $2, $3, NOW())
-	`
+	return r.diagnoseVersion(ctx, tx, p)
+}
+
+const storedVersionQuery = `SELECT version FROM payments WHERE id = $1`
+
+// diagnoseVersion tells a lost race apart from an aggregate whose version
+// could never have been loaded from the stored row
+func (r *Repository) diagnoseVersion(ctx context.Context, tx pgx.Tx, p *domain.Payment) error {
+	var stored int
+	err := tx.QueryRow(ctx, storedVersionQuery, p.ID().String()).Scan(&stored)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return fmt.Errorf("%w: payment %s has version %d but no stored row",
+			domain.ErrVersionInvariant, p.ID(), p.Version())
+	case err != nil:
+		return fmt.Errorf("read stored version: %w", err)
+	case stored >= p.Version():
+		return domain.ErrVersionConflict
+	default:
+		return fmt.Errorf("%w: payment %s has version %d, stored version is %d",
+			domain.ErrVersionInvariant, p.ID(), p.Version(), stored)
+	}
+}
+
+// OutboxNotifyChannel is notified with the aggregate id of every outbox
+// event on commit, relays listen on it to skip the wait for their next poll
+const OutboxNotifyChannel = "outbox_new_event"
+
+const insertOutboxEventQuery = `
+	WITH inserted AS (
+		INSERT INTO outbox_events (id, aggregate_id, event_type, payload, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+	)
+	SELECT pg_notify($5, $2)
+`
+
+// writeOutboxEvents stores each event in its envelope, the row id is the
+// event id the relay publishes. All inserts go in one round-trip, they share
+// created_at and their seq keeps the order of events.
+func writeOutboxEvents(ctx context.Context, tx pgx.Tx, aggregateID string, events []domain.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
 
+	batch := &pgx.Batch{}
+	types := make([]string, 0, len(events))
 	for _, evt := range events {
-		payload, err := json.Marshal(evt)
+		env, err := domain.NewEnvelope(aggregateID, evt)
 		if err != nil {
-			return fmt.Errorf("marshal event %s: %w", domain.EventType(evt), err)
+			return err
 		}
-		if _, err := tx.Exec(ctx, q, p.ID().String(), domain.EventType(evt), payload); err != nil {
-			return fmt.Errorf("insert outbox event %s: %w", domain.EventType(evt), err)
+		payload, err := json.Marshal(env)
+		if err != nil {
+			return fmt.Errorf("marshal envelope %s: %w", env.EventType, err)
 		}
+		batch.Queue(insertOutboxEventQuery, env.EventID, aggregateID, env.EventType, payload, OutboxNotifyChannel)
+		types = append(types, env.EventType)
 	}
-	return nil
-}
 
-func (r *Repository) Write(ctx context.Context, aggregateID, eventType string, payload []byte) error {
-	const q = `
-		INSERT INTO outbox_events (aggregate_id, event_type, payload, created_at)
-		VALUES ($1, $2, $3, NOW())
-	`
-
-	if _, err := r.pool.Exec(ctx, q, aggregateID, eventType, payload); err != nil {
-		return fmt.Errorf("outbox write: %w", err)
+	// the results must be closed before tx runs anything else, a failed
+	// statement aborts tx and the caller rolls it back
+	results := tx.SendBatch(ctx, batch)
+	for i, eventType := range types {
+		if _, err := results.Exec(); err != nil {
+			_ = results.Close()
+			return fmt.Errorf("insert outbox event %s (%d of %d): %w", eventType, i+1, len(types), err)
+		}
+	}
+	if err := results.Close(); err != nil {
+		return fmt.Errorf("insert outbox events: %w", err)
 	}
 	return nil
 }
 
-func (r *Repository) FindByIdempotencyKey(key string) (*domain.Payment, error) {
-	ctx := context.Background()
+// insertOutboxEvent stores a single event, see writeOutboxEvents
+func insertOutboxEvent(ctx context.Context, tx pgx.Tx, aggregateID string, evt domain.Event) error {
+	return writeOutboxEvents(ctx, tx, aggregateID, []domain.Event{evt})
+}
 
-	const q = `
-		SELECT id, order_id, customer_id, amount_cents, currency,
-		       status, provider_ref, failure_reason,
-		       idempotency_key, created_at, updated_at, version
-		FROM payments
-		WHERE idempotency_key = $1
-	`
+const findByIdempotencyKeyQuery = `SELECT ` + paymentColumns + ` FROM payments WHERE merchant_id = $1 AND idempotency_key = $2`
 
-	row := r.pool.QueryRow(ctx, q, key)
-	p, err := scanPayment(row)
+func (r *Repository) FindByIdempotencyKey(ctx context.Context, merchantID, key string) (*domain.Payment, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	db := r.reader(ctx)
+	p, err := scanPayment(db.QueryRow(ctx, findByIdempotencyKeyQuery, merchantID, key))
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			return nil, nil
 		}
+		r.observe(db, err)
 		return nil, err
 	}
 	return p, nil
 }
 
+// merchantScope binds the merchant the reads of ctx are confined to, NULL
+// when they see every payment. Statements compare it with
+// ($n::text IS NULL OR merchant_id = $n).
+func merchantScope(ctx context.Context) any {
+	if merchantID, ok := domain.MerchantFromContext(ctx); ok {
+		return merchantID
+	}
+	return nil
+}
+
+const findByIDQuery = `SELECT ` + paymentColumns + ` FROM payments WHERE id = $1 AND ($2::text IS NULL OR merchant_id = $2)`
+
+func (r *Repository) FindByID(ctx context.Context, id domain.PaymentID) (*domain.Payment, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	db := r.reader(ctx)
+	p, err := scanPayment(db.QueryRow(ctx, findByIDQuery, id.String(), merchantScope(ctx)))
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		r.observe(db, err)
+	}
+	return p, err
+}
+
+const findByCorrelationIDQuery = `
+	SELECT ` + paymentColumns + `
+	FROM payments
+	WHERE correlation_id = $1 AND ($2::text IS NULL OR merchant_id = $2)
+	ORDER BY created_at DESC
+`
+
+func (r *Repository) FindByCorrelationID(ctx context.Context, correlationID string) ([]*domain.Payment, error) {
+	return r.queryPayments(ctx, r.pool, findByCorrelationIDQuery, correlationID, merchantScope(ctx))
+}
+
+const findByOrderIDQuery = `
+	SELECT ` + paymentColumns + `
+	FROM payments
+	WHERE order_id = $1 AND ($2::text IS NULL OR merchant_id = $2)
+	ORDER BY created_at DESC
+`
+
+func (r *Repository) FindByOrderID(ctx context.Context, orderID string) ([]*domain.Payment, error) {
+	return r.queryPayments(ctx, r.pool, findByOrderIDQuery, orderID, merchantScope(ctx))
+}
+
+// findExpiredPendingQuery is served by idx_payments_active_status
+const findExpiredPendingQuery = `
+	SELECT ` + paymentColumns + `
+	FROM payments
+	WHERE status = 'PENDING' AND created_at < $1
+	ORDER BY created_at
+	LIMIT $2
+`
+
+func (r *Repository) FindExpiredPending(ctx context.Context, olderThan time.Time, limit int) ([]*domain.Payment, error) {
+	return r.queryPayments(ctx, r.pool, findExpiredPendingQuery, olderThan, limit)
+}
+
+const findByProviderRefQuery = `SELECT ` + paymentColumns + ` FROM payments WHERE provider_ref = $1 AND provider_ref <> ''`
+
+func (r *Repository) FindByProviderRef(ctx context.Context, ref string) (*domain.Payment, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	p, err := scanPayment(r.pool.QueryRow(ctx, findByProviderRefQuery, ref))
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		r.failover.Observe(err)
+	}
+	return p, err
+}
+
+// List pages on (created_at, id) so deep pages cost the same as the first
+func (r *Repository) List(ctx context.Context, f domain.ListFilter) ([]*domain.Payment, string, error) {
+	q, args := listQuery(merchantScope(ctx), f)
+	payments, err := r.queryPayments(ctx, r.reader(ctx), q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(payments) <= f.Limit {
+		return payments, "", nil
+	}
+	payments = payments[:f.Limit]
+	return payments, domain.NewCursor(payments[f.Limit-1]).Encode(), nil
+}
+
+// listQuery builds the statement for the filters f sets within merchant, a
+// merchantScope. It asks for one row more than the limit to tell whether
+// another page exists.
+func listQuery(merchant any, f domain.ListFilter) (string, []any) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if merchant != nil {
+		where = append(where, "merchant_id = "+arg(merchant))
+	}
+	if f.CustomerID != "" {
+		where = append(where, "customer_id = "+arg(f.CustomerID))
+	}
+	if f.Status != "" {
+		where = append(where, "status = "+arg(string(f.Status)))
+	}
+	if f.Currency != "" {
+		where = append(where, "currency = "+arg(f.Currency))
+	}
+	if f.ClientReference != "" {
+		// repeats the predicate of the partial idx_payments_client_reference
+		where = append(where, "client_reference = "+arg(f.ClientReference)+" AND client_reference <> ''")
+	}
+	if !f.CreatedAfter.IsZero() {
+		where = append(where, "created_at >= "+arg(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		where = append(where, "created_at < "+arg(f.CreatedBefore))
+	}
+	if !f.After.IsZero() {
+		where = append(where, fmt.Sprintf("(created_at, id) < (%s, %s)", arg(f.After.CreatedAt), arg(f.After.ID)))
+	}
+
+	q := `SELECT ` + paymentColumns + ` FROM payments`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	q += ` ORDER BY created_at DESC, id DESC LIMIT ` + arg(f.Limit+1)
+	return q, args
+}
+
+// queryPayments runs a multi-row payment query on db
+func (r *Repository) queryPayments(ctx context.Context, db *pgxpool.Pool, q string, args ...any) ([]*domain.Payment, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.Query(ctx, q, args...)
+	if err != nil {
+		r.observe(db, err)
+		return nil, fmt.Errorf("query payments: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []*domain.Payment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	if err := rows.Err(); err != nil {
+		r.failover.Observe(err)
+		return nil, fmt.Errorf("iterate payments: %w", err)
+	}
+	return payments, nil
+}
+
+// metadataJSON encodes metadata for the jsonb column, which is never null
+func metadataJSON(m domain.Metadata) []byte {
+	if m == nil {
+		m = domain.Metadata{}
+	}
+	// a map of strings always encodes
+	b, _ := json.Marshal(m)
+	return b
+}
+
+// paymentColumns is the column list scanPayment expects, in order
+const paymentColumns = `
+	id, merchant_id, order_id, customer_id, amount_cents, currency,
+	status, provider_ref, failure_reason,
+	idempotency_key, client_reference, correlation_id, capture_key,
+	request_hash, metadata, created_at, updated_at, version
+`
+
 func scanPayment(row pgx.Row) (*domain.Payment, error) {
 	var (
-		rawID          string
-		orderID        string
-		customerID     string
-		amountCents    int64
-		currency       string
-		status         string
-		providerRef    string
-		failureReason  string
-		idempotencyKey string
-		createdAt      time.Time
-		updatedAt      time.Time
-		version        int
+		rawID           string
+		merchantID      string
+		orderID         string
+		customerID      string
+		amountCents     int64
+		currency        string
+		status          string
+		providerRef     string
+		failureReason   string
+		idempotencyKey  string
+		clientReference string
+		correlationID   string
+		captureKey      string
+		requestHash     string
+		metadata        domain.Metadata
+		createdAt       time.Time
+		updatedAt       time.Time
+		version         int
 	)
 
 	err := row.Scan(
-		&rawID, &orderID, &customerID, &amountCents, &currency,
+		&rawID, &merchantID, &orderID, &customerID, &amountCents, &currency,
 		&status, &providerRef, &failureReason,
-		&idempotencyKey, &createdAt, &updatedAt, &version,
+		&idempotencyKey, &clientReference, &correlationID, &captureKey,
+		&requestHash, &metadata, &createdAt, &updatedAt, &version,
 	)
 
 	if err != nil {
@@ -180,14 +510,23 @@ func scanPayment(row pgx.Row) (*domain.Payment, error) {
 	}
 
 	return domain.Reconstitute(
-		id, orderID, customerID, amount,
+		id, merchantID, orderID, customerID, amount,
 		domain.PaymentStatus(status),
-		providerRef, failureReason, idempotencyKey,
-		createdAt, updatedAt, version,
+		providerRef, failureReason, idempotencyKey, clientReference, correlationID, captureKey,
+		requestHash, metadata, createdAt, updatedAt, version,
 	), nil
 }
 
-func (r *Repository) withTx(ctx context.Context, fn func(pgx.Tx) error) error {
+// withTx runs fn inside a transaction registered with the watchdog under op,
+// fn must use the ctx it is given so the watchdog can cancel it
+func (r *Repository) withTx(ctx context.Context, op string, fn func(context.Context, pgx.Tx) error) (err error) {
+	ctx, cancel := r.withWriteTimeout(ctx)
+	defer cancel()
+
+	id := r.txs.track(op, cancel)
+	defer r.txs.untrack(id)
+	defer func() { r.failover.Observe(err) }()
+
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("begin transaction: %w", err)
@@ -200,7 +539,7 @@ func (r *Repository) withTx(ctx context.Context, fn func(pgx.Tx) error) error {
 		}
 	}()
 
-	if err := fn(tx); err != nil {
+	if err := fn(ctx, tx); err != nil {
 		_ = tx.Rollback(ctx)
 		return err
 	}
@@ -213,11 +552,15 @@ func (r *Repository) withTx(ctx context.Context, fn func(pgx.Tx) error) error {
 
 type PoolConfig struct {
 	DSN               string
+	ApplicationName   string
 	MaxConns          int32
 	MinConns          int32
 	MaxConnLifetime   time.Duration
 	MaxConnIdleTime   time.Duration
 	HealthCheckPeriod time.Duration
+	// statements running longer are logged to Logger, zero disables
+	SlowQueryThreshold time.Duration
+	Logger             *slog.Logger
 }
 
 func NewPool(ctx context.Context, cfg PoolConfig) (*pgxpool.Pool, error) {
@@ -232,6 +575,15 @@ func NewPool(ctx context.Context, cfg PoolConfig) (*pgxpool.Pool, error) {
 	poolCfg.MaxConnIdleTime = cfg.MaxConnIdleTime
 	poolCfg.HealthCheckPeriod = cfg.HealthCheckPeriod
 
+	// tag sessions so pg_stat_activity can be filtered to this service
+	if _, ok := poolCfg.ConnConfig.RuntimeParams["application_name"]; !ok && cfg.ApplicationName != "" {
+		poolCfg.ConnConfig.RuntimeParams["application_name"] = cfg.ApplicationName
+	}
+
+	if cfg.SlowQueryThreshold > 0 && cfg.Logger != nil {
+		poolCfg.ConnConfig.Tracer = &slowQueryTracer{threshold: cfg.SlowQueryThreshold, log: cfg.Logger}
+	}
+
 	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		return nil, fmt.Errorf("create connection pool: %w", err)
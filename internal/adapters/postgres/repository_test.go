@@ -0,0 +1,417 @@
+package postgres_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/postgres"
+	"github.com/ademajagon/gopay-service/internal/adapters/postgres/pgtest"
+	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/domain/domaintest"
+)
+
+func newRepository(t *testing.T) (*postgres.Repository, *pgxpool.Pool) {
+	t.Helper()
+	pool := pgtest.NewSchema(t)
+	return postgres.NewRepository(pool, nil, nil, postgres.QueryTimeouts{}), pool
+}
+
+type outboxRow struct {
+	eventType string
+	// xmin of the row, the id of the transaction that wrote it
+	xid string
+}
+
+func outboxRows(t *testing.T, pool *pgxpool.Pool, aggregateID string) []outboxRow {
+	t.Helper()
+	rows, err := pool.Query(context.Background(),
+		`SELECT event_type, xmin::text FROM outbox_events WHERE aggregate_id = $1 ORDER BY seq`, aggregateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (outboxRow, error) {
+		var r outboxRow
+		return r, row.Scan(&r.eventType, &r.xid)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func paymentXID(t *testing.T, pool *pgxpool.Pool, id domain.PaymentID) string {
+	t.Helper()
+	var xid string
+	if err := pool.QueryRow(context.Background(), `SELECT xmin::text FROM payments WHERE id = $1`, id.String()).Scan(&xid); err != nil {
+		t.Fatal(err)
+	}
+	return xid
+}
+
+// Save is the only writer of payment events, each lands once and in the
+// transaction that wrote the payment row
+func TestSaveWritesEveryEventOnceWithThePayment(t *testing.T) {
+	repo, pool := newRepository(t)
+	ctx := context.Background()
+
+	p := domaintest.NewPaymentBuilder().BuildNew()
+	if err := repo.Save(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	inserted := paymentXID(t, pool, p.ID())
+
+	if err := p.MarkProcessing("prov_123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Complete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	updated := paymentXID(t, pool, p.ID())
+
+	// saving again without changes writes nothing
+	if len(p.PopEvents()) != 0 {
+		t.Fatal("Save left events on the aggregate")
+	}
+
+	// in the order they happened, although one transaction shares created_at
+	got := outboxRows(t, pool, p.ID().String())
+	want := []outboxRow{
+		{"payment.initiated", inserted},
+		{"payment.processing", updated},
+		{"payment.completed", updated},
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("outbox rows = %v, want %v", got, want)
+	}
+}
+
+// a failing outbox insert takes the payment row down with it
+func TestSaveRollsBackThePaymentWhenTheOutboxFails(t *testing.T) {
+	repo, pool := newRepository(t)
+	ctx := context.Background()
+
+	_, err := pool.Exec(ctx, `
+		CREATE FUNCTION reject_outbox() RETURNS trigger AS $$
+		BEGIN RAISE EXCEPTION 'outbox unavailable'; END
+		$$ LANGUAGE plpgsql;
+		CREATE TRIGGER reject_outbox BEFORE INSERT ON outbox_events
+		FOR EACH ROW EXECUTE FUNCTION reject_outbox();
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := domaintest.NewPaymentBuilder().BuildNew()
+	if err := repo.Save(ctx, p); err == nil {
+		t.Fatal("Save succeeded although the outbox insert failed")
+	}
+	if _, err := repo.FindByID(ctx, p.ID()); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("FindByID err = %v, want the payment rolled back", err)
+	}
+}
+
+// a batch failing on a later event leaves none of its events and keeps the
+// payment at the version before
+func TestSaveRollsBackEveryEventWhenOneFails(t *testing.T) {
+	repo, pool := newRepository(t)
+	ctx := context.Background()
+
+	p := domaintest.NewPaymentBuilder().BuildNew()
+	if err := repo.Save(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := pool.Exec(ctx, `
+		CREATE FUNCTION reject_completed() RETURNS trigger AS $$
+		BEGIN RAISE EXCEPTION 'outbox unavailable'; END
+		$$ LANGUAGE plpgsql;
+		CREATE TRIGGER reject_completed BEFORE INSERT ON outbox_events
+		FOR EACH ROW WHEN (NEW.event_type = 'payment.completed') EXECUTE FUNCTION reject_completed();
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.MarkProcessing("prov_123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Complete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(ctx, p); err == nil {
+		t.Fatal("Save succeeded although an outbox insert failed")
+	}
+
+	stored, err := repo.FindByID(ctx, p.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version() != 1 || stored.Status() != domain.StatusPending {
+		t.Fatalf("payment at version %d, %s, want the insert kept as is", stored.Version(), stored.Status())
+	}
+	if got := outboxRows(t, pool, p.ID().String()); len(got) != 1 || got[0].eventType != "payment.initiated" {
+		t.Fatalf("outbox rows = %v, want only the insert's", got)
+	}
+}
+
+func TestSaveVersionInvariant(t *testing.T) {
+	tests := []struct {
+		name string
+		// stored is saved first unless its version is 0
+		stored  int
+		save    int
+		wantErr error
+	}{
+		{name: "insert at version 1", save: 1},
+		{name: "update by one", stored: 1, save: 2},
+		{name: "insert at version 0", save: 0, wantErr: domain.ErrVersionInvariant},
+		{name: "insert at version 7", save: 7, wantErr: domain.ErrVersionInvariant},
+		{name: "double increment", stored: 1, save: 3, wantErr: domain.ErrVersionInvariant},
+		{name: "stale update", stored: 2, save: 2, wantErr: domain.ErrVersionConflict},
+		{name: "update behind the stored row", stored: 3, save: 2, wantErr: domain.ErrVersionConflict},
+		{name: "insert over an existing id", stored: 1, save: 1, wantErr: domain.ErrVersionConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, _ := newRepository(t)
+			ctx := context.Background()
+
+			if tt.stored > 0 {
+				// stored rows are walked up one version at a time, as Save allows
+				for v := 1; v <= tt.stored; v++ {
+					if err := repo.Save(ctx, domaintest.NewPaymentBuilder().WithVersion(v).Build()); err != nil {
+						t.Fatalf("storing version %d: %v", v, err)
+					}
+				}
+			}
+
+			// another idempotency key, so an insert over the id is not a replay
+			p := domaintest.NewPaymentBuilder().WithIdempotencyKey("idem-2").WithVersion(tt.save).Build()
+			err := repo.Save(ctx, p)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("err = %v", err)
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			case tt.wantErr == domain.ErrVersionConflict && errors.Is(err, domain.ErrVersionInvariant):
+				t.Fatalf("err = %v, a lost race is not an invariant violation", err)
+			}
+		})
+	}
+}
+
+// two connections inserting one idempotency key at once: the index makes the
+// second wait for the first to commit, then fail, and Save hands the loser
+// the winner's payment
+func TestConcurrentInsertOfOneIdempotencyKey(t *testing.T) {
+	repo, pool := newRepository(t)
+	ctx := context.Background()
+
+	// both inserts queue up behind the lock and start together once it goes
+	holder, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = holder.Rollback(ctx) }()
+	if _, err := holder.Exec(ctx, `LOCK TABLE payments IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		t.Fatal(err)
+	}
+
+	payments := [2]*domain.Payment{
+		domaintest.NewPaymentBuilder().BuildNew(),
+		domaintest.NewPaymentBuilder().BuildNew(),
+	}
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i, p := range payments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = repo.Save(ctx, p)
+		}()
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var waiting int
+		err := pool.QueryRow(ctx, `
+			SELECT count(*) FROM pg_stat_activity
+			WHERE datname = current_database() AND wait_event_type = 'Lock' AND query LIKE '%INSERT INTO payments%'`).Scan(&waiting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if waiting == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d inserts waiting on the lock, want 2", waiting)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := holder.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	winner, loser := 0, 1
+	if errs[0] != nil {
+		winner, loser = 1, 0
+	}
+	if errs[winner] != nil {
+		t.Fatalf("both inserts failed: %v, %v", errs[0], errs[1])
+	}
+	var dup *domain.DuplicateIdempotencyKeyError
+	if !errors.As(errs[loser], &dup) || !errors.Is(errs[loser], domain.ErrDuplicateIdempotencyKey) {
+		t.Fatalf("loser err = %v, want a DuplicateIdempotencyKeyError", errs[loser])
+	}
+	if dup.Existing.ID() != payments[winner].ID() {
+		t.Fatalf("loser was handed payment %s, want the winner's %s", dup.Existing.ID(), payments[winner].ID())
+	}
+
+	var rows int
+	if err := pool.QueryRow(ctx, `SELECT count(*) FROM payments WHERE idempotency_key = $1`, "idem-1").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Fatalf("%d payments with the key, want 1", rows)
+	}
+	if got := outboxRows(t, pool, payments[loser].ID().String()); len(got) != 0 {
+		t.Fatalf("the loser left outbox rows %v", got)
+	}
+}
+
+// BenchmarkSaveEvents measures an update carrying several events, which go
+// to the outbox in one batch
+func BenchmarkSaveEvents(b *testing.B) {
+	pool := pgtest.NewSchema(b)
+	repo := postgres.NewRepository(pool, nil, nil, postgres.QueryTimeouts{})
+	ctx := context.Background()
+
+	for b.Loop() {
+		p := domaintest.NewPaymentBuilder().WithID(domain.NewPaymentID()).WithIdempotencyKey(domain.NewPaymentID().String()).BuildNew()
+		if err := repo.Save(ctx, p); err != nil {
+			b.Fatal(err)
+		}
+		if err := p.MarkProcessing("prov_123"); err != nil {
+			b.Fatal(err)
+		}
+		if err := p.Complete(); err != nil {
+			b.Fatal(err)
+		}
+		if err := repo.Save(ctx, p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// client_reference survives a save and a read back, and filters the listing
+// through the partial index
+func TestClientReferenceRoundTripAndFilter(t *testing.T) {
+	repo, _ := newRepository(t)
+	ctx := context.Background()
+
+	want := map[string][]domain.PaymentID{}
+	for i, ref := range []string{"inv-1", "inv-2", "inv-1", ""} {
+		p := domaintest.NewPaymentBuilder().
+			WithID(domain.NewPaymentID()).
+			WithIdempotencyKey("idem-" + string(rune('a'+i))).
+			WithClientReference(ref).
+			BuildNew()
+		if err := repo.Save(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+		want[ref] = append(want[ref], p.ID())
+
+		stored, err := repo.FindByID(ctx, p.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.ClientReference() != ref || stored.RequestHash() != p.RequestHash() {
+			t.Fatalf("read back reference %q and hash %s, want %q and %s", stored.ClientReference(), stored.RequestHash(), ref, p.RequestHash())
+		}
+	}
+
+	for _, ref := range []string{"inv-1", "inv-2", "inv-3"} {
+		listed, _, err := repo.List(ctx, domain.ListFilter{ClientReference: ref, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []domain.PaymentID
+		for _, p := range listed {
+			got = append(got, p.ID())
+		}
+		if len(got) != len(want[ref]) {
+			t.Fatalf("%s: listed %v, want %v", ref, got, want[ref])
+		}
+		for _, id := range want[ref] {
+			if !slices.Contains(got, id) {
+				t.Fatalf("%s: listed %v, want %v", ref, got, want[ref])
+			}
+		}
+	}
+}
+
+// idempotency keys are unique per merchant, and reads under a merchant's
+// scope never see another merchant's payments
+func TestMerchantScoping(t *testing.T) {
+	repo, _ := newRepository(t)
+	ctx := context.Background()
+
+	a := domaintest.NewPaymentBuilder().WithMerchantID("merchant-a").BuildNew()
+	b := domaintest.NewPaymentBuilder().WithMerchantID("merchant-b").BuildNew()
+	for _, p := range []*domain.Payment{a, b} {
+		if err := repo.Save(ctx, p); err != nil {
+			t.Fatalf("save %s: %v", p.MerchantID(), err)
+		}
+	}
+	var dup *domain.DuplicateIdempotencyKeyError
+	again := domaintest.NewPaymentBuilder().WithMerchantID("merchant-a").BuildNew()
+	if err := repo.Save(ctx, again); !errors.As(err, &dup) || dup.Existing.ID() != a.ID() {
+		t.Fatalf("second payment of merchant-a with the key: err = %v, want a duplicate of %s", err, a.ID())
+	}
+
+	if p, err := repo.FindByIdempotencyKey(ctx, "merchant-b", "idem-1"); err != nil || p == nil || p.ID() != b.ID() {
+		t.Fatalf("merchant-b's key found %v, %v, want %s", p, err, b.ID())
+	}
+	if p, err := repo.FindByIdempotencyKey(ctx, "", "idem-1"); err != nil || p != nil {
+		t.Fatalf("a platform caller's key found %v, %v, want nothing", p, err)
+	}
+
+	scoped := domain.WithMerchant(ctx, "merchant-a")
+	if _, err := repo.FindByID(scoped, b.ID()); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("merchant-a reading merchant-b's payment: err = %v, want ErrNotFound", err)
+	}
+	if p, err := repo.FindByID(scoped, a.ID()); err != nil || p.MerchantID() != "merchant-a" {
+		t.Fatalf("merchant-a reading its payment: %v, %v", p, err)
+	}
+	for name, find := range map[string]func(context.Context) ([]*domain.Payment, error){
+		"List": func(ctx context.Context) ([]*domain.Payment, error) {
+			page, _, err := repo.List(ctx, domain.ListFilter{Limit: 10})
+			return page, err
+		},
+		"FindByOrderID": func(ctx context.Context) ([]*domain.Payment, error) {
+			return repo.FindByOrderID(ctx, "order-1")
+		},
+		"FindByCorrelationID": func(ctx context.Context) ([]*domain.Payment, error) {
+			return repo.FindByCorrelationID(ctx, "corr-1")
+		},
+	} {
+		if got, err := find(scoped); err != nil || len(got) != 1 || got[0].ID() != a.ID() {
+			t.Errorf("%s for merchant-a = %v, %v, want only %s", name, got, err, a.ID())
+		}
+		if got, err := find(ctx); err != nil || len(got) != 2 {
+			t.Errorf("%s unscoped = %v, %v, want both payments", name, got, err)
+		}
+	}
+}
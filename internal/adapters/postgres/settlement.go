@@ -0,0 +1,166 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+const settlementBatchColumns = `
+	id, provider, file_hash, status, rows, matched, amount_mismatch,
+	unknown_ref, last_error, created_at, updated_at
+`
+
+const createSettlementBatchQuery = `
+	INSERT INTO settlement_batches (provider, file_hash)
+	VALUES ($1, $2)
+	ON CONFLICT (provider, file_hash) DO NOTHING
+	RETURNING ` + settlementBatchColumns
+
+const findSettlementBatchQuery = `SELECT ` + settlementBatchColumns + ` FROM settlement_batches WHERE provider = $1 AND file_hash = $2`
+
+func (r *Repository) CreateSettlementBatch(ctx context.Context, provider, fileHash string) (app.SettlementBatch, bool, error) {
+	batch, err := scanSettlementBatch(r.pool.QueryRow(ctx, createSettlementBatchQuery, provider, fileHash))
+	if err == nil {
+		return batch, true, nil
+	}
+	if !errors.Is(err, domain.ErrNotFound) {
+		return app.SettlementBatch{}, false, err
+	}
+
+	batch, err = scanSettlementBatch(r.pool.QueryRow(ctx, findSettlementBatchQuery, provider, fileHash))
+	return batch, false, err
+}
+
+const getSettlementBatchQuery = `SELECT ` + settlementBatchColumns + ` FROM settlement_batches WHERE id = $1`
+
+func (r *Repository) GetSettlementBatch(ctx context.Context, id string) (app.SettlementBatch, error) {
+	return scanSettlementBatch(r.pool.QueryRow(ctx, getSettlementBatchQuery, id))
+}
+
+const findByProviderRefsQuery = `SELECT ` + paymentColumns + ` FROM payments WHERE provider_ref = ANY($1)`
+
+func (r *Repository) FindByProviderRefs(ctx context.Context, refs []string) (map[string]*domain.Payment, error) {
+	payments, err := r.queryPayments(ctx, r.pool, findByProviderRefsQuery, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	byRef := make(map[string]*domain.Payment, len(payments))
+	for _, p := range payments {
+		byRef[p.ProviderRef()] = p
+	}
+	return byRef, nil
+}
+
+const insertSettlementItemQuery = `
+	INSERT INTO settlement_items (
+		batch_id, line, provider_ref, amount_cents, currency, payment_id, status
+	) VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (batch_id, line) DO NOTHING
+`
+
+func (r *Repository) InsertSettlementItems(ctx context.Context, batchID string, items []app.SettlementItem) error {
+	batch := &pgx.Batch{}
+	for _, item := range items {
+		var paymentID *string
+		if item.PaymentID != "" {
+			paymentID = &item.PaymentID
+		}
+		batch.Queue(insertSettlementItemQuery, batchID, item.Line, item.ProviderRef, item.AmountCents, item.Currency, paymentID, item.Status)
+	}
+
+	if err := r.pool.SendBatch(ctx, batch).Close(); err != nil {
+		r.failover.Observe(err)
+		return fmt.Errorf("insert settlement items: %w", err)
+	}
+	return nil
+}
+
+// completeSettlementBatchQuery only matches the transition into COMPLETED,
+// a concurrent upload of the same file finds the batch already completed
+const completeSettlementBatchQuery = `
+	WITH totals AS (
+		SELECT count(*)                                          AS rows,
+		       count(*) FILTER (WHERE status = 'MATCHED')         AS matched,
+		       count(*) FILTER (WHERE status = 'AMOUNT_MISMATCH') AS amount_mismatch,
+		       count(*) FILTER (WHERE status = 'UNKNOWN_REF')     AS unknown_ref
+		FROM settlement_items
+		WHERE batch_id = $1
+	)
+	UPDATE settlement_batches b
+	SET status          = 'COMPLETED',
+	    rows            = totals.rows,
+	    matched         = totals.matched,
+	    amount_mismatch = totals.amount_mismatch,
+	    unknown_ref     = totals.unknown_ref,
+	    last_error      = '',
+	    updated_at      = NOW()
+	FROM totals
+	WHERE b.id = $1 AND b.status <> 'COMPLETED'
+	RETURNING b.id, b.provider, b.file_hash, b.status, b.rows, b.matched, b.amount_mismatch,
+	          b.unknown_ref, b.last_error, b.created_at, b.updated_at
+`
+
+func (r *Repository) CompleteSettlementBatch(ctx context.Context, batchID string) (app.SettlementBatch, error) {
+	var batch app.SettlementBatch
+	err := r.withTx(ctx, "complete_settlement_batch", func(ctx context.Context, tx pgx.Tx) error {
+		// only the transition into COMPLETED emits the event
+		var err error
+		batch, err = scanSettlementBatch(tx.QueryRow(ctx, completeSettlementBatchQuery, batchID))
+		if errors.Is(err, domain.ErrNotFound) {
+			batch, err = scanSettlementBatch(tx.QueryRow(ctx, getSettlementBatchQuery, batchID))
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		evt := domain.SettlementIngested{
+			BatchID:        batch.ID,
+			Provider:       batch.Provider,
+			FileHash:       batch.FileHash,
+			Rows:           batch.Rows,
+			Matched:        batch.Matched,
+			AmountMismatch: batch.AmountMismatch,
+			UnknownRef:     batch.UnknownRef,
+			OccurredAt:     time.Now().UTC(),
+		}
+		return insertOutboxEvent(ctx, tx, batch.ID, evt)
+	})
+	return batch, err
+}
+
+const failSettlementBatchQuery = `
+	UPDATE settlement_batches
+	SET status = 'FAILED', last_error = $2, updated_at = NOW()
+	WHERE id = $1 AND status <> 'COMPLETED'
+`
+
+func (r *Repository) FailSettlementBatch(ctx context.Context, batchID, lastError string) error {
+	if _, err := r.pool.Exec(ctx, failSettlementBatchQuery, batchID, lastError); err != nil {
+		return fmt.Errorf("fail settlement batch: %w", err)
+	}
+	return nil
+}
+
+func scanSettlementBatch(row pgx.Row) (app.SettlementBatch, error) {
+	var b app.SettlementBatch
+	err := row.Scan(
+		&b.ID, &b.Provider, &b.FileHash, &b.Status, &b.Rows, &b.Matched, &b.AmountMismatch,
+		&b.UnknownRef, &b.LastError, &b.CreatedAt, &b.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return app.SettlementBatch{}, domain.ErrNotFound
+		}
+		return app.SettlementBatch{}, fmt.Errorf("scan settlement batch: %w", err)
+	}
+	return b, nil
+}
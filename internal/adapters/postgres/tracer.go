@@ -0,0 +1,130 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var slowQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gopay_service",
+	Subsystem: "postgres",
+	Name:      "slow_queries_total",
+	Help:      "Statements that ran longer than the slow query threshold.",
+}, []string{"verb", "table"})
+
+// slowQueryTracer logs statements slower than threshold. Only the verb and
+// table are logged, never the SQL arguments, which hold customer data.
+type slowQueryTracer struct {
+	threshold time.Duration
+	log       *slog.Logger
+}
+
+type traceStartKey struct{}
+
+type traceStart struct {
+	at  time.Time
+	sql string
+}
+
+func (t *slowQueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, traceStartKey{}, traceStart{at: time.Now(), sql: data.SQL})
+}
+
+func (t *slowQueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(traceStartKey{}).(traceStart)
+	if !ok {
+		return
+	}
+	t.observe(ctx, start, data.CommandTag.RowsAffected(), 1, data.Err)
+}
+
+// a batch is timed as a whole, it is one round-trip
+func (t *slowQueryTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	var sql string
+	if data.Batch != nil && data.Batch.Len() > 0 {
+		sql = data.Batch.QueuedQueries[0].SQL
+	}
+	return context.WithValue(ctx, traceStartKey{}, &batchTrace{traceStart: traceStart{at: time.Now(), sql: sql}})
+}
+
+type batchTrace struct {
+	traceStart
+	statements int
+	rows       int64
+}
+
+func (t *slowQueryTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	if b, ok := ctx.Value(traceStartKey{}).(*batchTrace); ok {
+		b.statements++
+		b.rows += data.CommandTag.RowsAffected()
+	}
+}
+
+func (t *slowQueryTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	if b, ok := ctx.Value(traceStartKey{}).(*batchTrace); ok {
+		t.observe(ctx, b.traceStart, b.rows, b.statements, data.Err)
+	}
+}
+
+func (t *slowQueryTracer) observe(ctx context.Context, start traceStart, rows int64, statements int, err error) {
+	elapsed := time.Since(start.at)
+	if elapsed < t.threshold {
+		return
+	}
+
+	verb, table := describeStatement(start.sql)
+	slowQueries.WithLabelValues(verb, table).Inc()
+
+	attrs := []any{
+		"verb", verb,
+		"table", table,
+		"duration", elapsed.String(),
+		"rows_affected", rows,
+	}
+	if statements > 1 {
+		attrs = append(attrs, "statements", statements)
+	}
+	if err != nil {
+		attrs = append(attrs, "err", err)
+	}
+	t.log.WarnContext(ctx, "slow postgres query", attrs...)
+}
+
+// describeStatement names the statement's first data-modifying or select
+// verb and the table it reads or writes, a CTE is looked through
+func describeStatement(sql string) (verb, table string) {
+	verb, table = "OTHER", "unknown"
+
+	fields := strings.Fields(sql)
+	for i, f := range fields {
+		word := strings.ToUpper(strings.TrimLeft(f, "("))
+		if verb == "OTHER" {
+			switch word {
+			case "SELECT", "INSERT", "UPDATE", "DELETE":
+				verb = word
+				if word == "UPDATE" && i+1 < len(fields) {
+					return verb, tableName(fields[i+1])
+				}
+			}
+			continue
+		}
+		if (word == "FROM" || word == "INTO") && i+1 < len(fields) {
+			return verb, tableName(fields[i+1])
+		}
+	}
+	return verb, table
+}
+
+func tableName(token string) string {
+	name := strings.ToLower(strings.Trim(token, `"(),;`))
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
@@ -0,0 +1,236 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	dbLongRunningTransactions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gopay_service",
+		Subsystem: "db",
+		Name:      "long_running_transactions",
+		Help:      "Transactions open longer than the watchdog warn threshold at the last check.",
+	})
+
+	dbTransactionsCancelledTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gopay_service",
+		Subsystem: "db",
+		Name:      "transactions_cancelled_total",
+		Help:      "Transactions whose context was cancelled by the watchdog after the hard limit.",
+	})
+
+	dbIdleInTransactionSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gopay_service",
+		Subsystem: "db",
+		Name:      "idle_in_transaction_sessions",
+		Help:      "Sessions of this application idle in transaction beyond the threshold, per pg_stat_activity.",
+	})
+)
+
+// activeTx is a transaction currently open through withTx
+type activeTx struct {
+	op      string
+	started time.Time
+	callers []uintptr
+	cancel  context.CancelFunc
+
+	warned    bool
+	cancelled bool
+}
+
+// formatStack formats the call stack captured when a transaction began
+func formatStack(callers []uintptr) string {
+	var sb strings.Builder
+	frames := runtime.CallersFrames(callers)
+	for {
+		f, more := frames.Next()
+		fmt.Fprintf(&sb, "%s\n\t%s:%d\n", f.Function, f.File, f.Line)
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
+
+// txRegistry tracks open transactions so the watchdog can find leaked ones
+type txRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	active map[uint64]*activeTx
+}
+
+func newTxRegistry() *txRegistry {
+	return &txRegistry{active: make(map[uint64]*activeTx)}
+}
+
+func (r *txRegistry) track(op string, cancel context.CancelFunc) uint64 {
+	pcs := make([]uintptr, 32)
+	// skip runtime.Callers, track and withTx
+	n := runtime.Callers(3, pcs)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	r.active[r.nextID] = &activeTx{
+		op:      op,
+		started: time.Now(),
+		callers: pcs[:n],
+		cancel:  cancel,
+	}
+	return r.nextID
+}
+
+func (r *txRegistry) untrack(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.active, id)
+}
+
+// WatchdogConfig controls how the transaction watchdog reports and intervenes
+type WatchdogConfig struct {
+	Interval time.Duration
+	// open transactions older than this are logged with their starting stack
+	WarnAfter time.Duration
+	// open transactions older than this get their context cancelled, zero disables
+	CancelAfter time.Duration
+	// server-side sessions idle in transaction longer than this are counted,
+	// zero disables
+	IdleInTxAfter time.Duration
+}
+
+// Watchdog periodically inspects in-process transactions and pg_stat_activity
+// to surface transactions that were never committed or rolled back
+type Watchdog struct {
+	pool    *pgxpool.Pool
+	txs     *txRegistry
+	cfg     WatchdogConfig
+	appName string
+	log     *slog.Logger
+}
+
+func NewWatchdog(pool *pgxpool.Pool, repo *Repository, cfg WatchdogConfig, log *slog.Logger) *Watchdog {
+	return &Watchdog{
+		pool:    pool,
+		txs:     repo.txs,
+		cfg:     cfg,
+		appName: pool.Config().ConnConfig.RuntimeParams["application_name"],
+		log:     log,
+	}
+}
+
+// Run blocks until ctx is cancelled
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.checkTransactions(ctx)
+			if w.cfg.IdleInTxAfter > 0 {
+				w.checkIdleSessions(ctx)
+			}
+		}
+	}
+}
+
+// longTx is what the watchdog found about one transaction under the lock
+type longTx struct {
+	id      uint64
+	op      string
+	started time.Time
+	age     time.Duration
+	// the stack is logged on the first check that finds the transaction
+	stack []uintptr
+	// set when this check is the one cancelling the transaction
+	cancel context.CancelFunc
+}
+
+func (w *Watchdog) checkTransactions(ctx context.Context) {
+	now := time.Now()
+	var long []longTx
+
+	// snapshot under the lock, withTx registers and unregisters through it
+	w.txs.mu.Lock()
+	for id, tx := range w.txs.active {
+		age := now.Sub(tx.started)
+		if age < w.cfg.WarnAfter {
+			continue
+		}
+		found := longTx{id: id, op: tx.op, started: tx.started, age: age}
+		if !tx.warned {
+			tx.warned = true
+			found.stack = tx.callers
+		}
+		if w.cfg.CancelAfter > 0 && age >= w.cfg.CancelAfter && !tx.cancelled {
+			tx.cancelled = true
+			found.cancel = tx.cancel
+		}
+		long = append(long, found)
+	}
+	w.txs.mu.Unlock()
+
+	dbLongRunningTransactions.Set(float64(len(long)))
+
+	for _, tx := range long {
+		if tx.stack != nil {
+			w.log.WarnContext(ctx, "long running transaction",
+				"tx_id", tx.id,
+				"operation", tx.op,
+				"age", tx.age.String(),
+				"started_at", tx.started,
+				"stack", formatStack(tx.stack),
+			)
+		}
+
+		if tx.cancel != nil {
+			tx.cancel()
+			dbTransactionsCancelledTotal.Inc()
+			w.log.ErrorContext(ctx, "transaction exceeded hard limit, context cancelled",
+				"tx_id", tx.id,
+				"operation", tx.op,
+				"age", tx.age.String(),
+			)
+		}
+	}
+}
+
+const idleInTransactionQuery = `
+	SELECT count(*)
+	FROM pg_stat_activity
+	WHERE application_name = $1
+	  AND state IN ('idle in transaction', 'idle in transaction (aborted)')
+	  AND state_change < NOW() - make_interval(secs => $2)
+`
+
+func (w *Watchdog) checkIdleSessions(ctx context.Context) {
+	checkCtx, cancel := context.WithTimeout(ctx, w.cfg.Interval)
+	defer cancel()
+
+	var n int64
+	if err := w.pool.QueryRow(checkCtx, idleInTransactionQuery, w.appName, w.cfg.IdleInTxAfter.Seconds()).Scan(&n); err != nil {
+		w.log.WarnContext(ctx, "query idle in transaction sessions", "err", err)
+		return
+	}
+
+	dbIdleInTransactionSessions.Set(float64(n))
+	if n > 0 {
+		w.log.WarnContext(ctx, "sessions idle in transaction",
+			"count", n,
+			"application_name", w.appName,
+			"threshold", w.cfg.IdleInTxAfter.String(),
+		)
+	}
+}
@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordHandler keeps the records logged through it
+type recordHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r.Clone())
+	return nil
+}
+
+// logged returns the attributes of every record with the message
+func (h *recordHandler) logged(msg string) []map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var found []map[string]string
+	for _, r := range h.records {
+		if r.Message != msg {
+			continue
+		}
+		attrs := map[string]string{}
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value.String()
+			return true
+		})
+		found = append(found, attrs)
+	}
+	return found
+}
+
+// stuckTx runs a transaction whose fn blocks until release closes or the
+// watchdog cancels its context
+func stuckTx(reg *txRegistry, release <-chan struct{}) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- holdTx(reg, release) }()
+	return done
+}
+
+// holdTx registers the transaction the way withTx does
+func holdTx(reg *txRegistry, release <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	id := reg.track("stuck_op", cancel)
+	defer reg.untrack(id)
+
+	select {
+	case <-release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runWatchdog(t *testing.T, reg *txRegistry, cfg WatchdogConfig) *recordHandler {
+	t.Helper()
+	logs := &recordHandler{}
+	w := &Watchdog{txs: reg, cfg: cfg, log: slog.New(logs)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		w.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return logs
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatchdogReportsAStuckTransactionOnce(t *testing.T) {
+	reg := newTxRegistry()
+	logs := runWatchdog(t, reg, WatchdogConfig{Interval: 5 * time.Millisecond, WarnAfter: 20 * time.Millisecond})
+
+	release := make(chan struct{})
+	done := stuckTx(reg, release)
+
+	waitFor(t, "the stuck transaction to be reported", func() bool { return len(logs.logged("long running transaction")) > 0 })
+	// it stays stuck for several more checks
+	time.Sleep(50 * time.Millisecond)
+
+	reports := logs.logged("long running transaction")
+	if len(reports) != 1 {
+		t.Fatalf("reported %d times, want once", len(reports))
+	}
+	if reports[0]["operation"] != "stuck_op" || !strings.Contains(reports[0]["stack"], "stuckTx") {
+		t.Fatalf("report = %v, want stuck_op with the stack that began it", reports[0])
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("fn ended with %v, want it left running without a hard limit", err)
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if len(reg.active) != 0 {
+		t.Fatalf("%d transactions still registered", len(reg.active))
+	}
+}
+
+func TestWatchdogCancelsAfterTheHardLimit(t *testing.T) {
+	reg := newTxRegistry()
+	logs := runWatchdog(t, reg, WatchdogConfig{
+		Interval:    5 * time.Millisecond,
+		WarnAfter:   10 * time.Millisecond,
+		CancelAfter: 30 * time.Millisecond,
+	})
+
+	release := make(chan struct{})
+	defer close(release)
+	done := stuckTx(reg, release)
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("fn ended with %v, want its context cancelled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the watchdog never cancelled the transaction")
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if n := len(logs.logged("transaction exceeded hard limit, context cancelled")); n != 1 {
+		t.Fatalf("logged the cancellation %d times, want once", n)
+	}
+}
@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+const webhookEndpointColumns = `id, url, secret, event_types, enabled, created_at, updated_at`
+
+const webhookDeliveryColumns = `
+	id, endpoint_id, event_id, event_type, status, attempts, last_status_code,
+	last_error, next_attempt_at, delivered_at, created_at
+`
+
+const createWebhookEndpointQuery = `
+	INSERT INTO webhook_endpoints (url, secret, event_types, enabled)
+	VALUES ($1, $2, $3, $4)
+	RETURNING ` + webhookEndpointColumns
+
+func (r *Repository) CreateWebhookEndpoint(ctx context.Context, e app.WebhookEndpoint) (app.WebhookEndpoint, error) {
+	ctx, cancel := r.withWriteTimeout(ctx)
+	defer cancel()
+
+	return scanWebhookEndpoint(r.pool.QueryRow(ctx, createWebhookEndpointQuery, e.URL, e.Secret, e.EventTypes, e.Enabled))
+}
+
+const getWebhookEndpointQuery = `SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints WHERE id = $1`
+
+func (r *Repository) GetWebhookEndpoint(ctx context.Context, id string) (app.WebhookEndpoint, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	return scanWebhookEndpoint(r.pool.QueryRow(ctx, getWebhookEndpointQuery, id))
+}
+
+const listWebhookEndpointsQuery = `SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints ORDER BY created_at`
+
+func (r *Repository) ListWebhookEndpoints(ctx context.Context) ([]app.WebhookEndpoint, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, listWebhookEndpointsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("query webhook endpoints: %w", err)
+	}
+	defer rows.Close()
+
+	var endpoints []app.WebhookEndpoint
+	for rows.Next() {
+		e, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate webhook endpoints: %w", err)
+	}
+	return endpoints, nil
+}
+
+const updateWebhookEndpointQuery = `
+	UPDATE webhook_endpoints
+	SET url = $2, event_types = $3, enabled = $4, updated_at = NOW()
+	WHERE id = $1
+	RETURNING ` + webhookEndpointColumns
+
+func (r *Repository) UpdateWebhookEndpoint(ctx context.Context, e app.WebhookEndpoint) (app.WebhookEndpoint, error) {
+	ctx, cancel := r.withWriteTimeout(ctx)
+	defer cancel()
+
+	return scanWebhookEndpoint(r.pool.QueryRow(ctx, updateWebhookEndpointQuery, e.ID, e.URL, e.EventTypes, e.Enabled))
+}
+
+const deleteWebhookEndpointQuery = `DELETE FROM webhook_endpoints WHERE id = $1`
+
+func (r *Repository) DeleteWebhookEndpoint(ctx context.Context, id string) error {
+	ctx, cancel := r.withWriteTimeout(ctx)
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx, deleteWebhookEndpointQuery, id)
+	if err != nil {
+		return fmt.Errorf("delete webhook endpoint: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+const listWebhookDeliveriesQuery = `
+	SELECT ` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE endpoint_id = $1
+	  AND ($2 = '' OR status = $2)
+	ORDER BY created_at DESC
+	LIMIT $3
+`
+
+func (r *Repository) ListWebhookDeliveries(ctx context.Context, endpointID, status string, limit int) ([]app.WebhookDelivery, error) {
+	ctx, cancel := r.withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, listWebhookDeliveriesQuery, endpointID, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []app.WebhookDelivery
+	for rows.Next() {
+		var d app.WebhookDelivery
+		err := rows.Scan(
+			&d.ID, &d.EndpointID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.LastStatusCode,
+			&d.LastError, &d.NextAttemptAt, &d.DeliveredAt, &d.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func scanWebhookEndpoint(row pgx.Row) (app.WebhookEndpoint, error) {
+	var e app.WebhookEndpoint
+	err := row.Scan(&e.ID, &e.URL, &e.Secret, &e.EventTypes, &e.Enabled, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return app.WebhookEndpoint{}, domain.ErrNotFound
+		}
+		return app.WebhookEndpoint{}, fmt.Errorf("scan webhook endpoint: %w", err)
+	}
+	return e, nil
+}
@@ -0,0 +1,125 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+var breakerStateGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "gopay_service",
+	Subsystem: "redis",
+	Name:      "breaker_state",
+	Help:      "Redis circuit breaker state: 0 closed, 1 half-open, 2 open.",
+})
+
+// ErrBreakerOpen is a call skipped because redis failed repeatedly
+var ErrBreakerOpen = errors.New("redis circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// BreakerConfig controls when the breaker stops calling redis
+type BreakerConfig struct {
+	// consecutive failures that open the breaker, zero disables it
+	Threshold int
+	// how long calls are skipped before a single probe is let through
+	Cooldown time.Duration
+}
+
+// Breaker fails calls fast while redis is down, so an outage does not cost
+// every request the full read timeout. After the cool-down one probe call
+// decides whether the breaker closes again.
+type Breaker struct {
+	cfg BreakerConfig
+	log *slog.Logger
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func NewBreaker(cfg BreakerConfig, log *slog.Logger) *Breaker {
+	breakerStateGauge.Set(float64(breakerClosed))
+	return &Breaker{cfg: cfg, log: log, now: time.Now}
+}
+
+// do runs call unless the breaker is open, a nil breaker always runs it
+func (b *Breaker) do(ctx context.Context, call func() error) error {
+	if b == nil || b.cfg.Threshold <= 0 {
+		return call()
+	}
+	if !b.allow() {
+		return ErrBreakerOpen
+	}
+	err := call()
+	b.record(ctx, err)
+	return err
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cfg.Cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		return true
+	case breakerHalfOpen:
+		// a probe is already in flight
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *Breaker) record(ctx context.Context, err error) {
+	// a miss is an answer, a caller giving up says nothing about redis
+	failed := err != nil && !errors.Is(err, redis.Nil) && !errors.Is(err, context.Canceled)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.log.InfoContext(ctx, "redis circuit breaker closed")
+			b.setState(breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.cfg.Threshold {
+		if b.state != breakerOpen {
+			b.log.WarnContext(ctx, "redis circuit breaker opened",
+				"failures", b.failures,
+				"cooldown", b.cfg.Cooldown.String(),
+				"err", err)
+		}
+		b.openedAt = b.now()
+		b.setState(breakerOpen)
+	}
+}
+
+// setState keeps the gauge in step, callers hold mu
+func (b *Breaker) setState(s breakerState) {
+	b.state = s
+	breakerStateGauge.Set(float64(s))
+}
@@ -0,0 +1,125 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Deployment modes NewClient understands
+const (
+	ModeSingle   = "single"
+	ModeSentinel = "sentinel"
+	ModeCluster  = "cluster"
+)
+
+type Config struct {
+	// ModeSingle when empty
+	Mode string
+	// the server for single mode, sentinels or cluster seed nodes otherwise
+	Addrs []string
+	// the sentinel-monitored master, sentinel mode only
+	MasterName string
+	Password   string
+	// Redis logical database number, always 0 in cluster mode
+	DB int
+
+	TLS          bool
+	TLSCAFile    string
+	TLSInsecure  bool
+	PoolSize     int
+	MinIdleConns int
+	MaxRetries   int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// NewClient builds the client for cfg.Mode, callers only see the
+// UniversalClient so switching modes is a configuration change
+func NewClient(cfg Config) (redis.UniversalClient, error) {
+	var tlsConfig *tls.Config
+	if cfg.TLS {
+		var err error
+		if tlsConfig, err = newTLSConfig(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.Mode {
+	case ModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    cfg.MasterName,
+			SentinelAddrs: cfg.Addrs,
+			Password:      cfg.Password,
+			DB:            cfg.DB,
+			TLSConfig:     tlsConfig,
+			PoolSize:      cfg.PoolSize,
+			MinIdleConns:  cfg.MinIdleConns,
+			MaxRetries:    cfg.MaxRetries,
+			DialTimeout:   cfg.DialTimeout,
+			ReadTimeout:   cfg.ReadTimeout,
+			WriteTimeout:  cfg.WriteTimeout,
+		}), nil
+	case ModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        cfg.Addrs,
+			Password:     cfg.Password,
+			TLSConfig:    tlsConfig,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			MaxRetries:   cfg.MaxRetries,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		}), nil
+	case ModeSingle, "":
+		if len(cfg.Addrs) != 1 {
+			return nil, fmt.Errorf("single mode needs exactly one redis address, got %d", len(cfg.Addrs))
+		}
+		return redis.NewClient(&redis.Options{
+			Addr:         cfg.Addrs[0],
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			TLSConfig:    tlsConfig,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			MaxRetries:   cfg.MaxRetries,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", cfg.Mode)
+	}
+}
+
+// newTLSConfig trusts the system roots plus the CA file when one is given
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// dev only, config rejects it in production
+		InsecureSkipVerify: cfg.TLSInsecure,
+	}
+	if cfg.TLSCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(cfg.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read redis CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("redis CA file %s holds no PEM certificates", cfg.TLSCAFile)
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
+}
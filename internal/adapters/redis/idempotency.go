@@ -13,13 +13,16 @@ import (
 type IdempotencyStore struct {
 	client    redis.UniversalClient
 	namespace string
-	log       *slog.Logger
+	// nil calls redis unconditionally
+	breaker *Breaker
+	log     *slog.Logger
 }
 
-func NewIdempotencyStore(client redis.UniversalClient, namespace string, log *slog.Logger) *IdempotencyStore {
+func NewIdempotencyStore(client redis.UniversalClient, namespace string, breaker *Breaker, log *slog.Logger) *IdempotencyStore {
 	return &IdempotencyStore{
 		client:    client,
 		namespace: namespace,
+		breaker:   breaker,
 		log:       log,
 	}
 }
@@ -29,7 +32,11 @@ func (s *IdempotencyStore) key(k string) string {
 }
 
 func (s *IdempotencyStore) Get(ctx context.Context, key string) (string, bool, error) {
-	val, err := s.client.Get(ctx, s.key(key)).Result()
+	var val string
+	err := s.breaker.do(ctx, func() (err error) {
+		val, err = s.client.Get(ctx, s.key(key)).Result()
+		return err
+	})
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return "", false, nil
@@ -40,7 +47,11 @@ func (s *IdempotencyStore) Get(ctx context.Context, key string) (string, bool, e
 }
 
 func (s *IdempotencyStore) Set(ctx context.Context, key string, result string, ttl time.Duration) error {
-	ok, err := s.client.SetNX(ctx, s.key(key), result, ttl).Result()
+	var ok bool
+	err := s.breaker.do(ctx, func() (err error) {
+		ok, err = s.client.SetNX(ctx, s.key(key), result, ttl).Result()
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("redis SETNX idempotency key: %w", err)
 	}
@@ -50,25 +61,43 @@ func (s *IdempotencyStore) Set(ctx context.Context, key string, result string, t
 	return nil
 }
 
-type Config struct {
-	Addr     string
-	Password string
-	// Redis logical database number
-	DB int
+func (s *IdempotencyStore) reservationKey(k string) string {
+	return fmt.Sprintf("%s:idempotency-reservation:%s", s.namespace, k)
+}
+
+func (s *IdempotencyStore) Reserve(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	var ok bool
+	err := s.breaker.do(ctx, func() (err error) {
+		ok, err = s.client.SetNX(ctx, s.reservationKey(key), "1", ttl).Result()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("redis SETNX idempotency reservation: %w", err)
+	}
+	return ok, nil
 }
 
-func NewClient(cfg Config) redis.UniversalClient {
-	return redis.NewClient(&redis.Options{
-		Addr:         cfg.Addr,
-		Password:     cfg.Password,
-		DB:           cfg.DB,
-		PoolSize:     20,
-		MinIdleConns: 5,
-		MaxRetries:   3,
-		DialTimeout:  2 * time.Second,
-		ReadTimeout:  500 * time.Millisecond,
-		WriteTimeout: 500 * time.Millisecond,
+func (s *IdempotencyStore) Release(ctx context.Context, key string) error {
+	err := s.breaker.do(ctx, func() error {
+		return s.client.Del(ctx, s.reservationKey(key)).Err()
 	})
+	if err != nil {
+		return fmt.Errorf("redis DEL idempotency reservation: %w", err)
+	}
+	return nil
+}
+
+// Delete removes cached responses, keys are deleted one by one in a pipeline
+// so they never have to share a cluster slot
+func (s *IdempotencyStore) Delete(ctx context.Context, keys ...string) error {
+	pipe := s.client.Pipeline()
+	for _, k := range keys {
+		pipe.Del(ctx, s.key(k))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("redis DEL idempotency keys: %w", err)
+	}
+	return nil
 }
 
 func Ping(ctx context.Context, client redis.UniversalClient) error {
@@ -0,0 +1,69 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// releaseScript deletes the lock only while it still holds the caller's
+// token, so a lock that expired and was taken by another is left alone
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Locker hands out per-payment locks that expire on their own, so a
+// crashed holder blocks others for at most the ttl
+type Locker struct {
+	client    redis.UniversalClient
+	namespace string
+	// nil calls redis unconditionally
+	breaker *Breaker
+}
+
+func NewLocker(client redis.UniversalClient, namespace string, breaker *Breaker) *Locker {
+	return &Locker{client: client, namespace: namespace, breaker: breaker}
+}
+
+func (l *Locker) key(paymentID string) string {
+	return fmt.Sprintf("%s:payment-lock:%s", l.namespace, paymentID)
+}
+
+func (l *Locker) AcquireLock(ctx context.Context, paymentID string, ttl time.Duration) (string, bool, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", false, fmt.Errorf("generate lock token: %w", err)
+	}
+	token := hex.EncodeToString(raw)
+
+	var ok bool
+	err := l.breaker.do(ctx, func() (err error) {
+		ok, err = l.client.SetNX(ctx, l.key(paymentID), token, ttl).Result()
+		return err
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("redis SETNX payment lock: %w", err)
+	}
+	if !ok {
+		return "", false, nil
+	}
+	return token, true, nil
+}
+
+func (l *Locker) ReleaseLock(ctx context.Context, paymentID, token string) error {
+	err := l.breaker.do(ctx, func() error {
+		return releaseScript.Run(ctx, l.client, []string{l.key(paymentID)}, token).Err()
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("redis release payment lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NonceStore records request signatures for the replay window
+type NonceStore struct {
+	client    redis.UniversalClient
+	namespace string
+}
+
+func NewNonceStore(client redis.UniversalClient, namespace string) *NonceStore {
+	return &NonceStore{client: client, namespace: namespace}
+}
+
+func (s *NonceStore) Remember(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	ok, err := s.client.SetNX(ctx, fmt.Sprintf("%s:nonce:%s", s.namespace, nonce), 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis SETNX nonce: %w", err)
+	}
+	return ok, nil
+}
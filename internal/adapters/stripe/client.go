@@ -0,0 +1,180 @@
+// Package stripe implements the payment provider port on the Stripe API.
+package stripe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the live API, stripe-mock listens on http://localhost:12111
+const DefaultBaseURL = "https://api.stripe.com"
+
+type Config struct {
+	BaseURL string
+	APIKey  string
+	// bounds one HTTP attempt, retries get their own
+	Timeout time.Duration
+	// retries after the first attempt for errors Stripe marks retryable
+	MaxRetries int
+}
+
+// Client speaks the form-encoded Stripe REST API
+type Client struct {
+	base       string
+	apiKey     string
+	http       *http.Client
+	maxRetries int
+}
+
+func NewClient(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(cfg.BaseURL, "/"))
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid stripe base url %q", cfg.BaseURL)
+	}
+	return &Client{
+		base:       base.String(),
+		apiKey:     cfg.APIKey,
+		http:       &http.Client{Timeout: cfg.Timeout},
+		maxRetries: cfg.MaxRetries,
+	}, nil
+}
+
+// APIError is an error object returned by Stripe
+type APIError struct {
+	Status      int    `json:"-"`
+	Type        string `json:"type"`
+	Code        string `json:"code"`
+	DeclineCode string `json:"decline_code"`
+	Message     string `json:"message"`
+	// set from the Stripe-Should-Retry header when present
+	shouldRetry *bool
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("stripe %d %s: %s", e.Status, e.Type, e.Message)
+}
+
+// Retryable reports whether the same request may succeed if sent again.
+// Stripe's own header wins, otherwise rate limits, conflicts and server
+// errors are retried.
+func (e *APIError) Retryable() bool {
+	if e.shouldRetry != nil {
+		return *e.shouldRetry
+	}
+	return e.Status == http.StatusTooManyRequests ||
+		e.Status == http.StatusConflict ||
+		e.Status >= http.StatusInternalServerError
+}
+
+// Terminal errors are a definite no, the request was not carried out
+func (e *APIError) Terminal() bool {
+	switch e.Type {
+	case "card_error", "invalid_request_error", "idempotency_error":
+		return !e.Retryable()
+	default:
+		return false
+	}
+}
+
+// Reason is the failure reason recorded on the payment, the most specific
+// code Stripe gave
+func (e *APIError) Reason() string {
+	switch {
+	case e.DeclineCode != "":
+		return e.DeclineCode
+	case e.Code != "":
+		return e.Code
+	case e.Status == http.StatusTooManyRequests:
+		return "rate_limited"
+	default:
+		return e.Type
+	}
+}
+
+// post sends a form to path and decodes the response into out. The
+// idempotency key makes retries safe, Stripe replays the first answer.
+func (c *Client) post(ctx context.Context, path, idempotencyKey string, form url.Values, out any) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = c.do(ctx, path, idempotencyKey, form, out)
+		if err == nil || attempt >= c.maxRetries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		timer := time.NewTimer(backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, path, idempotencyKey string, form url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("build stripe request: %w", err)
+	}
+	req.SetBasicAuth(c.apiKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("stripe %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("read stripe response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var envelope struct {
+			Error *APIError `json:"error"`
+		}
+		if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error == nil {
+			envelope.Error = &APIError{Type: "api_error", Message: strings.TrimSpace(string(body))}
+		}
+		apiErr := envelope.Error
+		apiErr.Status = resp.StatusCode
+		switch resp.Header.Get("Stripe-Should-Retry") {
+		case "true":
+			apiErr.shouldRetry = ptr(true)
+		case "false":
+			apiErr.shouldRetry = ptr(false)
+		}
+		return apiErr
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decode stripe response: %w", err)
+	}
+	return nil
+}
+
+// retryable treats transport errors as retryable, the idempotency key makes
+// resending safe even when the first request did arrive
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	return true
+}
+
+// backoff doubles from 500ms and caps at 5s
+func backoff(attempt int) time.Duration {
+	return min(500*time.Millisecond<<attempt, 5*time.Second)
+}
+
+func ptr[T any](v T) *T { return &v }
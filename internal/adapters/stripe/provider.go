@@ -0,0 +1,123 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+// Provider maps the payment provider port onto PaymentIntents and Refunds.
+// Idempotency keys are our own ID plus the action, so a retried call of ours
+// is replayed by Stripe instead of charging again.
+type Provider struct {
+	client *Client
+}
+
+func NewProvider(client *Client) *Provider {
+	return &Provider{client: client}
+}
+
+type paymentIntent struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// set when the last confirmation attempt failed
+	LastPaymentError *APIError `json:"last_payment_error"`
+}
+
+type refund struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	FailureReason string `json:"failure_reason"`
+}
+
+// Authorize creates the PaymentIntent, the customer confirms it with the
+// client secret and the outcome arrives by webhook
+func (p *Provider) Authorize(ctx context.Context, req app.ProviderRequest) (app.ProviderResult, error) {
+	form := url.Values{
+		"amount":                {strconv.FormatInt(req.AmountCents, 10)},
+		"currency":              {strings.ToLower(req.Currency)},
+		"capture_method":        {captureMethod(req.Capture)},
+		"metadata[payment_id]":  {req.PaymentID},
+		"metadata[customer_id]": {req.CustomerID},
+	}
+	if req.ClientReference != "" {
+		form.Set("description", req.ClientReference)
+	}
+
+	var intent paymentIntent
+	err := p.client.post(ctx, "/v1/payment_intents", idempotencyKey(req.IdempotencyKey, "authorize"), form, &intent)
+	if err != nil {
+		return result(err)
+	}
+	if intent.Status == "canceled" || intent.LastPaymentError != nil {
+		return declined(intent.LastPaymentError), nil
+	}
+	return app.ProviderResult{ProviderRef: intent.ID}, nil
+}
+
+func (p *Provider) Capture(ctx context.Context, req app.ProviderCaptureRequest) (app.ProviderResult, error) {
+	form := url.Values{
+		"amount_to_capture": {strconv.FormatInt(req.AmountCents, 10)},
+	}
+
+	var intent paymentIntent
+	path := "/v1/payment_intents/" + url.PathEscape(req.ProviderRef) + "/capture"
+	if err := p.client.post(ctx, path, idempotencyKey(req.IdempotencyKey, "capture"), form, &intent); err != nil {
+		return result(err)
+	}
+	return app.ProviderResult{ProviderRef: intent.ID}, nil
+}
+
+func (p *Provider) Refund(ctx context.Context, req app.ProviderRefundRequest) (app.ProviderResult, error) {
+	form := url.Values{
+		"payment_intent":      {req.ProviderRef},
+		"amount":              {strconv.FormatInt(req.AmountCents, 10)},
+		"metadata[refund_id]": {req.RefundID},
+	}
+
+	var r refund
+	if err := p.client.post(ctx, "/v1/refunds", idempotencyKey(req.IdempotencyKey, "refund"), form, &r); err != nil {
+		return result(err)
+	}
+	if r.Status == "failed" || r.Status == "canceled" {
+		reason := r.FailureReason
+		if reason == "" {
+			reason = "refund_" + r.Status
+		}
+		return app.ProviderResult{ProviderRef: r.ID, Declined: true, DeclineReason: reason}, nil
+	}
+	return app.ProviderResult{ProviderRef: r.ID}, nil
+}
+
+// result turns a terminal Stripe error into a decline, anything else leaves
+// the outcome unknown and is returned as is
+func result(err error) (app.ProviderResult, error) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Terminal() {
+		return declined(apiErr), nil
+	}
+	return app.ProviderResult{}, err
+}
+
+func declined(apiErr *APIError) app.ProviderResult {
+	reason := "declined"
+	if apiErr != nil {
+		reason = apiErr.Reason()
+	}
+	return app.ProviderResult{Declined: true, DeclineReason: reason}
+}
+
+func captureMethod(capture bool) string {
+	if capture {
+		return "automatic"
+	}
+	return "manual"
+}
+
+func idempotencyKey(key, action string) string {
+	return "gopay-" + key + "-" + action
+}
@@ -0,0 +1,307 @@
+// Package webhook delivers outbox events to the merchant endpoints
+// subscribed to them.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var deliveriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gopay_service",
+	Subsystem: "webhook",
+	Name:      "deliveries_total",
+	Help:      "Merchant webhook delivery attempts, partitioned by outcome.",
+}, []string{"outcome"})
+
+// HeaderSignature carries t=<unix>,v1=<hex HMAC-SHA256 of "<unix>.<body>">
+const HeaderSignature = "X-Gopay-Signature"
+
+type Config struct {
+	BatchSize    int
+	PollInterval time.Duration
+	// bounds one POST to a merchant
+	Timeout time.Duration
+	// a delivery is failed for good after this many attempts
+	MaxAttempts int
+	// retries back off exponentially from MinBackoff up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Dispatcher fans outbox events out into one delivery per subscribed
+// endpoint, then sends due deliveries. Deliveries are leased rather than
+// locked, so no transaction stays open while a merchant answers.
+type Dispatcher struct {
+	pool   *pgxpool.Pool
+	client *http.Client
+	cfg    Config
+	log    *slog.Logger
+}
+
+func NewDispatcher(pool *pgxpool.Pool, cfg Config, log *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		pool: pool,
+		// merchants must answer directly, a redirect is a failed attempt
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cfg: cfg,
+		log: log,
+	}
+}
+
+// Run blocks until ctx is cancelled, a delivery in flight is finished
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.fanOut(ctx); err != nil {
+			d.log.WarnContext(ctx, "webhook fan-out failed", "err", err)
+		}
+		if err := d.deliverDue(ctx); err != nil {
+			d.log.WarnContext(ctx, "webhook delivery batch failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+const fanOutQuery = `
+	WITH claimed AS (
+		SELECT id, event_type, payload, created_at
+		FROM outbox_events
+		WHERE webhooks_fanned_out_at IS NULL
+		  AND event_type IN ('payment.completed', 'payment.failed')
+		ORDER BY seq
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	), fanned AS (
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, payload, created_at)
+		-- merchants get the event without the outbox envelope
+		SELECT e.id, c.id, c.event_type,
+		       CASE WHEN c.payload ? 'schema_version' THEN c.payload -> 'payload' ELSE c.payload END,
+		       c.created_at
+		FROM claimed c
+		JOIN webhook_endpoints e ON e.enabled AND c.event_type = ANY (e.event_types)
+		ON CONFLICT (endpoint_id, event_id) DO NOTHING
+	)
+	UPDATE outbox_events o
+	SET webhooks_fanned_out_at = NOW()
+	FROM claimed c
+	WHERE o.id = c.id
+`
+
+// fanOut turns new outbox events into deliveries in one statement, so an
+// event is either fanned out to every subscribed endpoint or to none
+func (d *Dispatcher) fanOut(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), d.cfg.PollInterval+10*time.Second)
+	defer cancel()
+
+	if _, err := d.pool.Exec(ctx, fanOutQuery, d.cfg.BatchSize); err != nil {
+		return fmt.Errorf("fan out outbox events: %w", err)
+	}
+	return nil
+}
+
+type delivery struct {
+	ID        string
+	EventID   string
+	EventType string
+	Payload   json.RawMessage
+	Attempts  int
+	CreatedAt time.Time
+	URL       string
+	Secret    string
+}
+
+const leaseDeliveriesQuery = `
+	WITH due AS (
+		SELECT id
+		FROM webhook_deliveries
+		WHERE status = 'PENDING' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE webhook_deliveries w
+	SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+	FROM due, webhook_endpoints e
+	WHERE w.id = due.id AND e.id = w.endpoint_id
+	RETURNING w.id, w.event_id, w.event_type, w.payload, w.attempts, w.created_at, e.url, e.secret
+`
+
+// deliverDue leases due deliveries past the HTTP timeout, a dispatcher that
+// dies mid-batch leaves them to be picked up again after the lease
+func (d *Dispatcher) deliverDue(ctx context.Context) error {
+	rows, err := d.pool.Query(ctx, leaseDeliveriesQuery, d.cfg.BatchSize, (2 * d.cfg.Timeout).Seconds())
+	if err != nil {
+		return fmt.Errorf("lease webhook deliveries: %w", err)
+	}
+	var due []delivery
+	for rows.Next() {
+		var dl delivery
+		if err := rows.Scan(&dl.ID, &dl.EventID, &dl.EventType, &dl.Payload, &dl.Attempts, &dl.CreatedAt, &dl.URL, &dl.Secret); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan webhook delivery: %w", err)
+		}
+		due = append(due, dl)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate webhook deliveries: %w", err)
+	}
+
+	for _, dl := range due {
+		// on shutdown the rest of the batch is sent again once its lease expires
+		if ctx.Err() != nil {
+			break
+		}
+		// a started delivery is finished and recorded, the client timeout bounds it
+		ctx := context.WithoutCancel(ctx)
+		status, sendErr := d.send(ctx, dl)
+		if err := d.record(ctx, dl, status, sendErr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// body is what merchants receive, id stays the same across retries
+type body struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func (d *Dispatcher) send(ctx context.Context, dl delivery) (int, error) {
+	raw, err := json.Marshal(body{ID: dl.EventID, Type: dl.EventType, CreatedAt: dl.CreatedAt, Data: dl.Payload})
+	if err != nil {
+		return 0, fmt.Errorf("marshal webhook body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.URL, bytes.NewReader(raw))
+	if err != nil {
+		return 0, fmt.Errorf("build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gopay-webhooks/1")
+	req.Header.Set("X-Gopay-Event-Id", dl.EventID)
+	req.Header.Set(HeaderSignature, Sign(dl.Secret, raw, time.Now()))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+const (
+	markDeliveredQuery = `
+		UPDATE webhook_deliveries
+		SET status = 'DELIVERED', attempts = $2, last_status_code = $3, last_error = '',
+		    delivered_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`
+	markFailedQuery = `
+		UPDATE webhook_deliveries
+		SET status = 'FAILED', attempts = $2, last_status_code = $3, last_error = $4, updated_at = NOW()
+		WHERE id = $1
+	`
+	scheduleRetryQuery = `
+		UPDATE webhook_deliveries
+		SET attempts = $2, last_status_code = $3, last_error = $4,
+		    next_attempt_at = NOW() + $5 * INTERVAL '1 second', updated_at = NOW()
+		WHERE id = $1
+	`
+)
+
+func (d *Dispatcher) record(ctx context.Context, dl delivery, statusCode int, sendErr error) error {
+	attempts := dl.Attempts + 1
+
+	var (
+		q    string
+		args []any
+	)
+	switch {
+	case sendErr == nil:
+		deliveriesTotal.WithLabelValues("delivered").Inc()
+		q = markDeliveredQuery
+		args = []any{dl.ID, attempts, statusCode}
+	case attempts >= d.cfg.MaxAttempts:
+		deliveriesTotal.WithLabelValues("failed").Inc()
+		d.log.WarnContext(ctx, "webhook delivery failed for good",
+			"delivery_id", dl.ID,
+			"event_id", dl.EventID,
+			"attempts", attempts,
+			"err", sendErr)
+		q = markFailedQuery
+		args = []any{dl.ID, attempts, statusCode, truncate(sendErr.Error())}
+	default:
+		deliveriesTotal.WithLabelValues("retry").Inc()
+		q = scheduleRetryQuery
+		args = []any{dl.ID, attempts, statusCode, truncate(sendErr.Error()), d.backoff(attempts).Seconds()}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := d.pool.Exec(ctx, q, args...); err != nil {
+		return fmt.Errorf("record webhook delivery %s: %w", dl.ID, err)
+	}
+	return nil
+}
+
+// backoff doubles from MinBackoff for every attempt already made
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.cfg.MinBackoff
+	for i := 1; i < attempts && wait < d.cfg.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, d.cfg.MaxBackoff)
+}
+
+// Sign computes the X-Gopay-Signature value merchants verify
+func Sign(secret string, body []byte, ts time.Time) string {
+	unix := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(body)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func truncate(s string) string {
+	const maxErrorLen = 500
+	if len(s) > maxErrorLen {
+		return s[:maxErrorLen]
+	}
+	return s
+}
@@ -0,0 +1,15 @@
+package webhook
+
+import "github.com/ademajagon/gopay-service/internal/adapters/postgres"
+
+// Queries is every statement of the dispatcher, see postgres.Queries
+func Queries() []postgres.NamedQuery {
+	id := "00000000-0000-4000-8000-000000000001"
+	return []postgres.NamedQuery{
+		{Name: "webhook_deliveries.fan_out", SQL: fanOutQuery, Args: []any{100}},
+		{Name: "webhook_deliveries.lease", SQL: leaseDeliveriesQuery, Args: []any{100, 20.0}},
+		{Name: "webhook_deliveries.mark_delivered", SQL: markDeliveredQuery, Args: []any{id, 1, 200}},
+		{Name: "webhook_deliveries.mark_failed", SQL: markFailedQuery, Args: []any{id, 8, 500, "endpoint answered 500"}},
+		{Name: "webhook_deliveries.schedule_retry", SQL: scheduleRetryQuery, Args: []any{id, 2, 503, "endpoint answered 503", 60.0}},
+	}
+}
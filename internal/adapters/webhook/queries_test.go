@@ -0,0 +1,16 @@
+package webhook_test
+
+import (
+	"testing"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/postgres/pgtest"
+	"github.com/ademajagon/gopay-service/internal/adapters/webhook"
+)
+
+func TestQueriesAreRegistered(t *testing.T) {
+	pgtest.Unregistered(t, ".", webhook.Queries())
+}
+
+func TestQueriesMatchMigrations(t *testing.T) {
+	pgtest.Explain(t, pgtest.NewSchema(t), webhook.Queries())
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// ErrInvalidAPIKey is a key that is malformed, unknown or revoked
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKeyScopes are the scopes a key can be granted
+var APIKeyScopes = []string{ScopePaymentsRead, ScopePaymentsWrite, ScopeRefundsWrite}
+
+// apiKeyPrefix starts every key so leaked keys are easy to scan for
+const apiKeyPrefix = "gpk_"
+
+// APIKey authenticates one merchant integration. Only the hash of the key is
+// stored, Prefix finds the row without it.
+type APIKey struct {
+	ID         string
+	MerchantID string
+	Name       string
+	Prefix     string
+	Hash       string
+	Scopes     []string
+	// Key is the whole key, only returned when the key is created
+	Key       string
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, k APIKey) (APIKey, error)
+	// FindAPIKeyByPrefix returns domain.ErrNotFound for unknown prefixes
+	FindAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error)
+	// ListAPIKeys lists every key when merchantID is empty
+	ListAPIKeys(ctx context.Context, merchantID string) ([]APIKey, error)
+	// RevokeAPIKey returns domain.ErrNotFound for unknown or already revoked keys
+	RevokeAPIKey(ctx context.Context, id string) error
+}
+
+// APIKeyService issues API keys and resolves them to principals
+type APIKeyService struct {
+	store APIKeyStore
+	log   *slog.Logger
+}
+
+func NewAPIKeyService(store APIKeyStore, log *slog.Logger) *APIKeyService {
+	return &APIKeyService{store: store, log: log}
+}
+
+type CreateAPIKeyRequest struct {
+	MerchantID string
+	Name       string
+	Scopes     []string
+}
+
+// Create issues a key, the returned APIKey.Key cannot be retrieved later
+func (s *APIKeyService) Create(ctx context.Context, req CreateAPIKeyRequest) (APIKey, error) {
+	switch {
+	case req.MerchantID == "":
+		return APIKey{}, fmt.Errorf("%w: merchant_id is required", ErrInvalidRequest)
+	case len(req.MerchantID) > 255 || len(req.Name) > 255:
+		return APIKey{}, fmt.Errorf("%w: merchant_id and name must be at most 255 characters", ErrInvalidRequest)
+	case len(req.Scopes) == 0:
+		return APIKey{}, fmt.Errorf("%w: at least one scope is required", ErrInvalidRequest)
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(APIKeyScopes, scope) {
+			return APIKey{}, fmt.Errorf("%w: unknown scope %q, expected one of %v", ErrInvalidRequest, scope, APIKeyScopes)
+		}
+	}
+	scopes := slices.Clone(req.Scopes)
+	slices.Sort(scopes)
+
+	// gpk_<12 hex lookup prefix>_<64 hex secret>
+	raw := make([]byte, 6+32)
+	if _, err := rand.Read(raw); err != nil {
+		return APIKey{}, fmt.Errorf("generate api key: %w", err)
+	}
+	prefix := apiKeyPrefix + hex.EncodeToString(raw[:6])
+	key := prefix + "_" + hex.EncodeToString(raw[6:])
+
+	created, err := s.store.CreateAPIKey(ctx, APIKey{
+		MerchantID: req.MerchantID,
+		Name:       req.Name,
+		Prefix:     prefix,
+		Hash:       hashAPIKey(key),
+		Scopes:     slices.Compact(scopes),
+	})
+	if err != nil {
+		return APIKey{}, fmt.Errorf("create api key: %w", err)
+	}
+	created.Key = key
+
+	s.log.InfoContext(ctx, "api key created",
+		"key_id", created.ID,
+		"merchant_id", created.MerchantID,
+		"prefix", created.Prefix,
+		"scopes", created.Scopes)
+	return created, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context, merchantID string) ([]APIKey, error) {
+	return s.store.ListAPIKeys(ctx, merchantID)
+}
+
+// Revoke takes effect on the next request made with the key
+func (s *APIKeyService) Revoke(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return fmt.Errorf("%w: invalid api key ID %q", ErrInvalidRequest, id)
+	}
+	if err := s.store.RevokeAPIKey(ctx, id); err != nil {
+		return err
+	}
+	s.log.InfoContext(ctx, "api key revoked", "key_id", id)
+	return nil
+}
+
+// Authenticate resolves a presented key. Malformed, unknown and revoked
+// keys are all ErrInvalidAPIKey so callers cannot tell them apart.
+func (s *APIKeyService) Authenticate(ctx context.Context, key string) (Principal, error) {
+	prefix, _, ok := strings.Cut(key[min(len(apiKeyPrefix), len(key)):], "_")
+	if !strings.HasPrefix(key, apiKeyPrefix) || !ok {
+		return Principal{}, ErrInvalidAPIKey
+	}
+
+	stored, err := s.store.FindAPIKeyByPrefix(ctx, apiKeyPrefix+prefix)
+	if errors.Is(err, domain.ErrNotFound) {
+		return Principal{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return Principal{}, fmt.Errorf("find api key: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(stored.Hash)) != 1 || stored.RevokedAt != nil {
+		return Principal{}, ErrInvalidAPIKey
+	}
+
+	return Principal{
+		ID:         stored.ID,
+		Scopes:     stored.Scopes,
+		MerchantID: stored.MerchantID,
+		AuthMethod: "api_key",
+	}, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
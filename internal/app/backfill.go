@@ -0,0 +1,272 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// ErrBackfillBusy is returned when too many backfill runs are already queued
+var ErrBackfillBusy = errors.New("backfill queue is full")
+
+const (
+	BackfillQueued    = "QUEUED"
+	BackfillRunning   = "RUNNING"
+	BackfillCompleted = "COMPLETED"
+	BackfillFailed    = "FAILED"
+)
+
+// backfillNamespace seeds deterministic event ids so re-runs never duplicate
+var backfillNamespace = uuid.MustParse("6f1c1f0e-3c1e-4b7a-9a53-2d0a2f6f9b41")
+
+// BackfillRun is the persisted, resumable state of one backfill
+type BackfillRun struct {
+	ID   string
+	From time.Time
+	To   time.Time
+	// CursorCreatedAt and CursorID point at the last payment already written
+	CursorCreatedAt time.Time
+	CursorID        string
+	EventsWritten   int
+	Status          string
+	LastError       string
+	UpdatedAt       time.Time
+}
+
+// OutboxRecord is an outbox row with a caller-chosen id
+type OutboxRecord struct {
+	ID          string
+	AggregateID string
+	EventType   string
+	Payload     []byte
+}
+
+type BackfillStore interface {
+	CreateBackfillRun(ctx context.Context, from, to time.Time) (BackfillRun, error)
+	// GetBackfillRun returns domain.ErrNotFound for unknown ids
+	GetBackfillRun(ctx context.Context, id string) (BackfillRun, error)
+	// NextBackfillBatch returns payments in (created_at, id) order after the run cursor
+	NextBackfillBatch(ctx context.Context, run BackfillRun, limit int) ([]*domain.Payment, error)
+	// CommitBackfillBatch inserts records (skipping existing ids) and advances the
+	// cursor in one transaction
+	CommitBackfillBatch(ctx context.Context, runID string, records []OutboxRecord, last *domain.Payment) error
+	SetBackfillStatus(ctx context.Context, runID, status, lastError string) error
+}
+
+// backfilledOrigin marks synthesized events so consumers can tell them apart
+const backfilledOrigin = "backfill"
+
+type backfilledInitiated struct {
+	domain.PaymentInitiated
+	Origin string `json:"origin"`
+}
+
+type backfilledCompleted struct {
+	domain.PaymentCompleted
+	Origin string `json:"origin"`
+}
+
+// BackfillService synthesizes outbox events for payments that predate the outbox
+type BackfillService struct {
+	store     BackfillStore
+	batchSize int
+	// events per second written to the outbox
+	rate  int
+	queue chan string
+	log   *slog.Logger
+
+	// the throttle's clock, replaced in tests
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+}
+
+func NewBackfillService(store BackfillStore, batchSize, rate int, log *slog.Logger) *BackfillService {
+	return &BackfillService{
+		store:     store,
+		batchSize: batchSize,
+		rate:      rate,
+		queue:     make(chan string, 8),
+		log:       log,
+		now:       time.Now,
+		after:     time.After,
+	}
+}
+
+// Start records a new run for payments created in [from, to) and queues it
+func (s *BackfillService) Start(ctx context.Context, from, to time.Time) (BackfillRun, error) {
+	if !from.Before(to) {
+		return BackfillRun{}, fmt.Errorf("%w: from must be before to", ErrInvalidRequest)
+	}
+
+	run, err := s.store.CreateBackfillRun(ctx, from, to)
+	if err != nil {
+		return BackfillRun{}, fmt.Errorf("create backfill run: %w", err)
+	}
+	return run, s.enqueue(run.ID)
+}
+
+// Resume re-queues an interrupted run from its checkpoint
+func (s *BackfillService) Resume(ctx context.Context, id string) (BackfillRun, error) {
+	run, err := s.store.GetBackfillRun(ctx, id)
+	if err != nil {
+		return BackfillRun{}, err
+	}
+	if run.Status == BackfillCompleted {
+		return run, nil
+	}
+	return run, s.enqueue(run.ID)
+}
+
+func (s *BackfillService) Get(ctx context.Context, id string) (BackfillRun, error) {
+	return s.store.GetBackfillRun(ctx, id)
+}
+
+func (s *BackfillService) enqueue(id string) error {
+	select {
+	case s.queue <- id:
+		return nil
+	default:
+		return ErrBackfillBusy
+	}
+}
+
+// Run processes queued backfills one at a time until ctx is cancelled,
+// an interrupted run keeps its checkpoint and can be resumed
+func (s *BackfillService) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-s.queue:
+			if err := s.process(ctx, id); err != nil && ctx.Err() == nil {
+				s.log.ErrorContext(ctx, "outbox backfill failed", "run_id", id, "err", err)
+				if err := s.store.SetBackfillStatus(ctx, id, BackfillFailed, err.Error()); err != nil {
+					s.log.ErrorContext(ctx, "record backfill failure", "run_id", id, "err", err)
+				}
+			}
+		}
+	}
+}
+
+func (s *BackfillService) process(ctx context.Context, id string) error {
+	run, err := s.store.GetBackfillRun(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.store.SetBackfillStatus(ctx, id, BackfillRunning, ""); err != nil {
+		return err
+	}
+	s.log.InfoContext(ctx, "outbox backfill started", "run_id", id, "from", run.From, "to", run.To)
+
+	for {
+		started := s.now()
+
+		payments, err := s.store.NextBackfillBatch(ctx, run, s.batchSize)
+		if err != nil {
+			return fmt.Errorf("load backfill batch: %w", err)
+		}
+		if len(payments) == 0 {
+			break
+		}
+
+		records := make([]OutboxRecord, 0, len(payments))
+		for _, p := range payments {
+			recs, err := synthesize(p)
+			if err != nil {
+				return err
+			}
+			records = append(records, recs...)
+		}
+
+		last := payments[len(payments)-1]
+		if err := s.store.CommitBackfillBatch(ctx, id, records, last); err != nil {
+			return fmt.Errorf("commit backfill batch: %w", err)
+		}
+		run.CursorCreatedAt = last.CreatedAt()
+		run.CursorID = last.ID().String()
+
+		// throttle so the relay is not flooded
+		budget := time.Duration(len(records)) * time.Second / time.Duration(s.rate)
+		if wait := budget - s.now().Sub(started); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.after(wait):
+			}
+		}
+	}
+
+	s.log.InfoContext(ctx, "outbox backfill completed", "run_id", id)
+	return s.store.SetBackfillStatus(ctx, id, BackfillCompleted, "")
+}
+
+// synthesize rebuilds the events a payment would have written: initiated for
+// every payment and completed for a completed one, in that order
+func synthesize(p *domain.Payment) ([]OutboxRecord, error) {
+	initiated := domain.PaymentInitiated{
+		PaymentID:       p.ID().String(),
+		OrderID:         p.OrderID(),
+		ClientReference: p.ClientReference(),
+		CorrelationID:   p.CorrelationID(),
+		Amount:          p.Amount().Amount(),
+		Currency:        p.Amount().Currency(),
+		Metadata:        p.Metadata(),
+		OccurredAt:      p.CreatedAt(),
+	}
+	rec, err := backfillRecord(p, initiated, backfilledInitiated{PaymentInitiated: initiated, Origin: backfilledOrigin})
+	if err != nil {
+		return nil, err
+	}
+	records := []OutboxRecord{rec}
+
+	if p.Status() == domain.StatusCompleted {
+		// the last update of a completed payment is its completion
+		completed := domain.PaymentCompleted{
+			PaymentID:       p.ID().String(),
+			CorrelationID:   p.CorrelationID(),
+			ClientReference: p.ClientReference(),
+			ProviderRef:     p.ProviderRef(),
+			Amount:          p.Amount().Amount(),
+			Currency:        p.Amount().Currency(),
+			OccurredAt:      p.UpdatedAt(),
+		}
+		rec, err := backfillRecord(p, completed, backfilledCompleted{PaymentCompleted: completed, Origin: backfilledOrigin})
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// backfillRecord wraps body, evt marked as backfilled, in an envelope whose
+// id only depends on the payment and the event type
+func backfillRecord(p *domain.Payment, evt domain.Event, body any) (OutboxRecord, error) {
+	env, err := domain.NewEnvelope(p.ID().String(), evt)
+	if err != nil {
+		return OutboxRecord{}, err
+	}
+	// a rerun writes the same ids, the insert skips what the last run wrote
+	env.EventID = uuid.NewSHA1(backfillNamespace, []byte(p.ID().String()+":"+env.EventType)).String()
+	if env.Payload, err = json.Marshal(body); err != nil {
+		return OutboxRecord{}, fmt.Errorf("marshal event %s: %w", env.EventType, err)
+	}
+
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return OutboxRecord{}, fmt.Errorf("marshal envelope %s: %w", env.EventType, err)
+	}
+	return OutboxRecord{
+		ID:          env.EventID,
+		AggregateID: env.AggregateID,
+		EventType:   env.EventType,
+		Payload:     payload,
+	}, nil
+}
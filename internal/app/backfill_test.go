@@ -0,0 +1,214 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/domain/domaintest"
+)
+
+func TestSynthesizeIsDeterministic(t *testing.T) {
+	tests := []struct {
+		name    string
+		payment func() *domain.Payment
+		want    []string
+	}{
+		{name: "pending", payment: domaintest.Pending, want: []string{"payment.initiated"}},
+		{name: "processing", payment: domaintest.Processing, want: []string{"payment.initiated"}},
+		{name: "failed", payment: domaintest.Failed, want: []string{"payment.initiated"}},
+		{name: "completed", payment: domaintest.Completed, want: []string{"payment.initiated", "payment.completed"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := synthesize(tt.payment())
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := synthesize(tt.payment())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var types []string
+			for i, rec := range first {
+				types = append(types, rec.EventType)
+				if rec.ID != again[i].ID || string(rec.Payload) != string(again[i].Payload) {
+					t.Fatalf("%s differs between runs:\n%s\n%s", rec.EventType, rec.Payload, again[i].Payload)
+				}
+				if i > 0 && rec.ID == first[i-1].ID {
+					t.Fatalf("%s reuses the id of %s", rec.EventType, first[i-1].EventType)
+				}
+
+				var env domain.Envelope
+				if err := json.Unmarshal(rec.Payload, &env); err != nil {
+					t.Fatal(err)
+				}
+				var body struct{ Origin string }
+				if err := json.Unmarshal(env.Payload, &body); err != nil {
+					t.Fatal(err)
+				}
+				if env.EventID != rec.ID || env.AggregateID != domaintest.FixedID.String() || body.Origin != backfilledOrigin {
+					t.Fatalf("envelope %+v, origin %q", env, body.Origin)
+				}
+			}
+			if !slices.Equal(types, tt.want) {
+				t.Fatalf("events = %v, want %v", types, tt.want)
+			}
+		})
+	}
+}
+
+func TestSynthesizeCompletedCarriesTheCompletion(t *testing.T) {
+	p := domaintest.Completed()
+	records, err := synthesize(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var env domain.Envelope
+	if err := json.Unmarshal(records[1].Payload, &env); err != nil {
+		t.Fatal(err)
+	}
+	var got domain.PaymentCompleted
+	if err := json.Unmarshal(env.Payload, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ProviderRef != p.ProviderRef() || got.Amount != p.Amount().Amount() || !got.OccurredAt.Equal(p.UpdatedAt()) {
+		t.Fatalf("completed = %+v, want the payment's provider ref, amount and last update", got)
+	}
+	if !env.OccurredAt.Equal(p.UpdatedAt()) {
+		t.Fatalf("envelope occurred at %s, want %s", env.OccurredAt, p.UpdatedAt())
+	}
+}
+
+// fakeClock only moves when the backfill waits or the store takes time
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// memoryBackfillStore serves payments in batches and keeps what was written
+type memoryBackfillStore struct {
+	BackfillStore
+	payments []*domain.Payment
+	clock    *fakeClock
+	// how long each commit takes on the fake clock
+	commitTook time.Duration
+	written    []OutboxRecord
+	status     string
+}
+
+func (s *memoryBackfillStore) GetBackfillRun(context.Context, string) (BackfillRun, error) {
+	return BackfillRun{ID: "run-1"}, nil
+}
+
+func (s *memoryBackfillStore) NextBackfillBatch(_ context.Context, run BackfillRun, limit int) ([]*domain.Payment, error) {
+	start := 0
+	if run.CursorID != "" {
+		start = slices.IndexFunc(s.payments, func(p *domain.Payment) bool { return p.ID().String() == run.CursorID }) + 1
+	}
+	return s.payments[start:min(start+limit, len(s.payments))], nil
+}
+
+func (s *memoryBackfillStore) CommitBackfillBatch(_ context.Context, _ string, records []OutboxRecord, _ *domain.Payment) error {
+	s.clock.now = s.clock.now.Add(s.commitTook)
+	s.written = append(s.written, records...)
+	return nil
+}
+
+func (s *memoryBackfillStore) SetBackfillStatus(_ context.Context, _, status, _ string) error {
+	s.status = status
+	return nil
+}
+
+func TestBackfillThrottle(t *testing.T) {
+	payment := func(build func() *domain.Payment) *domain.Payment {
+		p := build()
+		return domaintest.NewPaymentBuilder().
+			WithID(domain.NewPaymentID()).
+			WithStatus(p.Status()).
+			WithProviderRef(p.ProviderRef()).
+			WithVersion(p.Version()).
+			Build()
+	}
+
+	tests := []struct {
+		name       string
+		payments   []*domain.Payment
+		commitTook time.Duration
+		want       []time.Duration
+	}{
+		{
+			// 10 events a second is 100ms an event, a completed payment writes two
+			name:     "waits out the budget of each batch",
+			payments: []*domain.Payment{payment(domaintest.Pending), payment(domaintest.Completed), payment(domaintest.Pending), payment(domaintest.Pending), payment(domaintest.Failed)},
+			want:     []time.Duration{300 * time.Millisecond, 200 * time.Millisecond, 100 * time.Millisecond},
+		},
+		{
+			name:       "time spent writing counts",
+			payments:   []*domain.Payment{payment(domaintest.Pending), payment(domaintest.Pending), payment(domaintest.Completed)},
+			commitTook: 150 * time.Millisecond,
+			want:       []time.Duration{50 * time.Millisecond, 50 * time.Millisecond},
+		},
+		{
+			name:       "a slow store is not slowed further",
+			payments:   []*domain.Payment{payment(domaintest.Pending), payment(domaintest.Pending), payment(domaintest.Pending)},
+			commitTook: time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: domaintest.FixedTime}
+			store := &memoryBackfillStore{payments: tt.payments, clock: clock, commitTook: tt.commitTook}
+			s := NewBackfillService(store, 2, 10, slog.New(slog.DiscardHandler))
+			s.now = func() time.Time { return clock.now }
+			s.after = clock.after
+
+			if err := s.process(context.Background(), "run-1"); err != nil {
+				t.Fatal(err)
+			}
+			if store.status != BackfillCompleted {
+				t.Fatalf("status = %s, want %s", store.status, BackfillCompleted)
+			}
+			if !slices.Equal(clock.waits, tt.want) {
+				t.Fatalf("waits = %v, want %v", clock.waits, tt.want)
+			}
+		})
+	}
+}
+
+// a cancelled run stops in the middle of a wait and keeps its checkpoint
+func TestBackfillThrottleStopsOnCancel(t *testing.T) {
+	clock := &fakeClock{now: domaintest.FixedTime}
+	store := &memoryBackfillStore{payments: []*domain.Payment{domaintest.Pending()}, clock: clock}
+	s := NewBackfillService(store, 2, 1, slog.New(slog.DiscardHandler))
+	s.now = func() time.Time { return clock.now }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.after = func(time.Duration) <-chan time.Time {
+		cancel()
+		return nil
+	}
+
+	if err := s.process(ctx, "run-1"); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if len(store.written) != 1 || store.status != BackfillRunning {
+		t.Fatalf("wrote %d records, status %s, want the batch kept and the run still running", len(store.written), store.status)
+	}
+}
@@ -0,0 +1,193 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// captureProvider answers captures with the queued errors and results first,
+// then like the mock gateway, and counts the calls
+type captureProvider struct {
+	*mockprovider.Provider
+
+	mu       sync.Mutex
+	answers  []error
+	declined bool
+	captures int
+}
+
+func (p *captureProvider) Capture(ctx context.Context, req app.ProviderCaptureRequest) (app.ProviderResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.captures++
+	if len(p.answers) > 0 {
+		err := p.answers[0]
+		p.answers = p.answers[1:]
+		return app.ProviderResult{}, err
+	}
+	if p.declined {
+		return app.ProviderResult{Declined: true, DeclineReason: "authorization_expired"}, nil
+	}
+	return p.Provider.Capture(ctx, req)
+}
+
+// beforeCaptureSave runs hook right before the first save of a capture
+type beforeCaptureSave struct {
+	domain.Repository
+	once sync.Once
+	hook func(ctx context.Context, p *domain.Payment) error
+}
+
+func (r *beforeCaptureSave) Save(ctx context.Context, p *domain.Payment) error {
+	if p.Status() == domain.StatusProcessing && p.CaptureKey() != "" {
+		var err error
+		r.once.Do(func() { err = r.hook(ctx, p) })
+		if err != nil {
+			return err
+		}
+	}
+	return r.Repository.Save(ctx, p)
+}
+
+func authorizedPayment(t *testing.T, repo domain.Repository) string {
+	t.Helper()
+	req := validRequest()
+	req.CaptureMethod = "manual"
+	resp, err := newGatewayService(repo, mockprovider.New(time.Millisecond)).InitiatePayment(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != string(domain.StatusAuthorized) {
+		t.Fatalf("status = %s, want AUTHORIZED", resp.Status)
+	}
+	return resp.PaymentID
+}
+
+func paymentStatus(t *testing.T, repo domain.Repository, paymentID string) domain.PaymentStatus {
+	t.Helper()
+	id, _ := domain.ParsePaymentID(paymentID)
+	p, err := repo.FindByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Status()
+}
+
+// a capture the gateway did not answer stays saved, the retry asks again
+func TestCaptureRetriedAfterGatewayError(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepository()
+	id := authorizedPayment(t, repo)
+
+	provider := &captureProvider{Provider: mockprovider.New(time.Millisecond), answers: []error{errors.New("timeout")}}
+	svc := newGatewayService(repo, provider)
+
+	if _, err := svc.CapturePayment(ctx, id, "cap-1", 0); !errors.Is(err, app.ErrProviderUnavailable) {
+		t.Fatalf("err = %v, want ErrProviderUnavailable", err)
+	}
+	if got := paymentStatus(t, repo, id); got != domain.StatusProcessing {
+		t.Fatalf("status = %s, want the capture kept", got)
+	}
+
+	if _, err := svc.CapturePayment(ctx, id, "cap-2", 0); !errors.Is(err, domain.ErrAlreadyCaptured) {
+		t.Fatalf("another key: err = %v, want ErrAlreadyCaptured", err)
+	}
+
+	resp, err := svc.CapturePayment(ctx, id, "cap-1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != string(domain.StatusProcessing) || provider.captures != 2 {
+		t.Fatalf("status %s after %d gateway captures, want PROCESSING after 2", resp.Status, provider.captures)
+	}
+}
+
+// nothing moves at the gateway before the capture is saved
+func TestCaptureSavedBeforeTheGateway(t *testing.T) {
+	tests := []struct {
+		name    string
+		hook    func(repo domain.Repository) func(context.Context, *domain.Payment) error
+		wantErr error
+		want    domain.PaymentStatus
+	}{
+		{
+			name: "save fails",
+			hook: func(domain.Repository) func(context.Context, *domain.Payment) error {
+				return func(context.Context, *domain.Payment) error { return errors.New("connection reset") }
+			},
+			want: domain.StatusAuthorized,
+		},
+		{
+			name: "a cancel wins the race",
+			hook: func(repo domain.Repository) func(context.Context, *domain.Payment) error {
+				return func(ctx context.Context, p *domain.Payment) error {
+					current, err := repo.FindByID(ctx, p.ID())
+					if err != nil {
+						return err
+					}
+					if err := current.Cancel("cancelled_by_merchant"); err != nil {
+						return err
+					}
+					return repo.Save(ctx, current)
+				}
+			},
+			wantErr: domain.ErrVersionConflict,
+			want:    domain.StatusCancelled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := memory.NewRepository()
+			id := authorizedPayment(t, repo)
+
+			provider := &captureProvider{Provider: mockprovider.New(time.Millisecond)}
+			svc := newGatewayService(&beforeCaptureSave{Repository: repo, hook: tt.hook(repo)}, provider)
+
+			_, err := svc.CapturePayment(context.Background(), id, "cap-1", 0)
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if provider.captures != 0 {
+				t.Fatalf("gateway captured %d times, want none", provider.captures)
+			}
+			if got := paymentStatus(t, repo, id); got != tt.want {
+				t.Fatalf("status = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaptureDeclinedFailsThePayment(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepository()
+	id := authorizedPayment(t, repo)
+
+	provider := &captureProvider{Provider: mockprovider.New(time.Millisecond), declined: true}
+	svc := newGatewayService(repo, provider)
+
+	if _, err := svc.CapturePayment(ctx, id, "cap-1", 0); !errors.Is(err, app.ErrProviderDeclined) {
+		t.Fatalf("err = %v, want ErrProviderDeclined", err)
+	}
+	if got := paymentStatus(t, repo, id); got != domain.StatusFailed {
+		t.Fatalf("status = %s, want FAILED", got)
+	}
+
+	// the replay reports the failure without asking the gateway again
+	resp, err := svc.CapturePayment(ctx, id, "cap-1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != string(domain.StatusFailed) || provider.captures != 1 {
+		t.Fatalf("status %s after %d gateway captures, want FAILED after 1", resp.Status, provider.captures)
+	}
+}
@@ -0,0 +1,170 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+func TestClientReferenceValidation(t *testing.T) {
+	tests := []struct {
+		ref   string
+		valid bool
+	}{
+		{ref: "", valid: true},
+		{ref: "inv-2024_001", valid: true},
+		{ref: strings.Repeat("r", 64), valid: true},
+		{ref: strings.Repeat("r", 65)},
+		{ref: "inv 1"},
+		{ref: "inv/1"},
+		{ref: "rechnung-ü"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			svc := newService(t)
+			req := validRequest()
+			req.ClientReference = tt.ref
+
+			_, initErr := svc.InitiatePayment(context.Background(), req)
+			_, listErr := svc.ListPayments(context.Background(), app.ListPaymentsRequest{ClientReference: tt.ref})
+			for name, err := range map[string]error{"initiate": initErr, "list": listErr} {
+				if tt.valid && err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+				if !tt.valid && !errors.Is(err, app.ErrInvalidRequest) {
+					t.Errorf("%s: err = %v, want ErrInvalidRequest", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestListPaymentsByClientReference(t *testing.T) {
+	svc := newService(t)
+	ctx := context.Background()
+
+	refs := []string{"inv-1", "inv-2", "inv-1", ""}
+	byRef := map[string][]string{}
+	for i, ref := range refs {
+		req := validRequest()
+		req.IdempotencyKey = "idem-" + string(rune('a'+i))
+		req.ClientReference = ref
+		resp, err := svc.InitiatePayment(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byRef[ref] = append(byRef[ref], resp.PaymentID)
+	}
+
+	for _, ref := range []string{"inv-1", "inv-2", "inv-3"} {
+		page, err := svc.ListPayments(ctx, app.ListPaymentsRequest{ClientReference: ref})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Payments) != len(byRef[ref]) {
+			t.Fatalf("%s: listed %d payments, want %d", ref, len(page.Payments), len(byRef[ref]))
+		}
+		for _, p := range page.Payments {
+			if p.ClientReference != ref {
+				t.Fatalf("%s: listed payment %s of %q", ref, p.PaymentID, p.ClientReference)
+			}
+		}
+	}
+
+	// no filter lists the payments without a reference too
+	page, err := svc.ListPayments(ctx, app.ListPaymentsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Payments) != len(refs) {
+		t.Fatalf("listed %d payments unfiltered, want %d", len(page.Payments), len(refs))
+	}
+}
+
+// a retry that only changes client_reference is a reused key unless the
+// reference is configured non-semantic, then it replays the stored payment
+func TestClientReferenceFingerprint(t *testing.T) {
+	tests := []struct {
+		name        string
+		nonSemantic bool
+		// the cache lost the response, the replay comes from the database
+		evicted bool
+		retry   func(*app.InitiatePaymentRequest)
+		replays bool
+	}{
+		{name: "strict", retry: func(r *app.InitiatePaymentRequest) { r.ClientReference = "inv-2" }},
+		{name: "strict from the database", evicted: true, retry: func(r *app.InitiatePaymentRequest) { r.ClientReference = "inv-2" }},
+		{name: "non-semantic", nonSemantic: true, retry: func(r *app.InitiatePaymentRequest) { r.ClientReference = "inv-2" }, replays: true},
+		{name: "non-semantic from the database", nonSemantic: true, evicted: true, retry: func(r *app.InitiatePaymentRequest) { r.ClientReference = "" }, replays: true},
+		{name: "non-semantic manual capture", nonSemantic: true, retry: func(r *app.InitiatePaymentRequest) {
+			r.ClientReference = "inv-2"
+			r.CaptureMethod = "manual"
+		}},
+		{name: "non-semantic other amount", nonSemantic: true, retry: func(r *app.InitiatePaymentRequest) {
+			r.ClientReference = "inv-2"
+			r.AmountCents++
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := testLimits
+			limits.ClientReferenceNonSemantic = tt.nonSemantic
+			repo := memory.NewRepository()
+			newSvc := func() *app.PaymentService {
+				kv := memory.NewKeyValueStore()
+				return app.NewPaymentService(repo, kv, time.Hour, kv, mockprovider.New(time.Second),
+					limits, nil, slog.New(slog.DiscardHandler))
+			}
+			svc := newSvc()
+			ctx := context.Background()
+
+			req := validRequest()
+			req.ClientReference = "inv-1"
+			first, err := svc.InitiatePayment(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.evicted {
+				svc = newSvc()
+			}
+			tt.retry(&req)
+			replay, err := svc.InitiatePayment(ctx, req)
+			if !tt.replays {
+				if !errors.Is(err, app.ErrIdempotencyKeyReused) {
+					t.Fatalf("err = %v, want ErrIdempotencyKeyReused", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !replay.Replayed || replay.PaymentID != first.PaymentID {
+				t.Fatalf("retry = %+v, want a replay of %s", replay, first.PaymentID)
+			}
+
+			// the stored payment keeps the reference it was created with
+			details, err := svc.GetPayment(ctx, first.PaymentID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if details.ClientReference != "inv-1" {
+				t.Fatalf("client reference = %q, want inv-1", details.ClientReference)
+			}
+			// and a second retry now replays from the cache the first one filled
+			again, err := svc.InitiatePayment(ctx, req)
+			if err != nil || again.Source != app.SourceCache {
+				t.Fatalf("second retry = %+v, %v, want a cache replay", again, err)
+			}
+		})
+	}
+}
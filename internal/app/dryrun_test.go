@@ -0,0 +1,200 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+var testLimits = app.RequestLimits{Currencies: []string{"EUR", "USD", "JPY"}, MaxAmountCents: 100000}
+
+func newService(t *testing.T) *app.PaymentService {
+	t.Helper()
+	kv := memory.NewKeyValueStore()
+	return app.NewPaymentService(memory.NewRepository(), kv, time.Hour, kv, mockprovider.New(time.Second),
+		testLimits, nil, slog.New(slog.DiscardHandler))
+}
+
+func validRequest() app.InitiatePaymentRequest {
+	return app.InitiatePaymentRequest{
+		OrderID:        "order-1",
+		CustomerID:     "cus-1",
+		AmountCents:    1999,
+		Currency:       "EUR",
+		IdempotencyKey: "idem-1",
+	}
+}
+
+// rejection is what a caller can tell apart, the rejected fields of a
+// validation error or the message of any other error
+func rejection(err error) string {
+	var verr *app.ValidationError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &verr):
+		fields := make([]string, 0, len(verr.Fields))
+		for _, f := range verr.Fields {
+			fields = append(fields, f.Field)
+		}
+		return "fields " + strings.Join(fields, ",")
+	default:
+		return err.Error()
+	}
+}
+
+// a payload dry-run rejects is rejected for real and the other way round,
+// both go through the same construction path
+func TestDryRunRejectsWhatInitiateRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*app.InitiatePaymentRequest)
+		valid  bool
+	}{
+		{name: "valid", mutate: func(*app.InitiatePaymentRequest) {}, valid: true},
+		{name: "untrimmed and lower case", mutate: func(r *app.InitiatePaymentRequest) {
+			r.OrderID, r.Currency = "  order-1 ", "eur"
+		}, valid: true},
+		{name: "manual capture", mutate: func(r *app.InitiatePaymentRequest) { r.CaptureMethod = "manual" }, valid: true},
+		{name: "at the amount limit", mutate: func(r *app.InitiatePaymentRequest) { r.AmountCents = 100000 }, valid: true},
+		{name: "with metadata", mutate: func(r *app.InitiatePaymentRequest) { r.Metadata = map[string]string{"cart_id": "c-1"} }, valid: true},
+		{name: "inbound correlation id", mutate: func(r *app.InitiatePaymentRequest) { r.CorrelationID = "corr-abc" }, valid: true},
+		{name: "no order", mutate: func(r *app.InitiatePaymentRequest) { r.OrderID = " " }},
+		{name: "no customer", mutate: func(r *app.InitiatePaymentRequest) { r.CustomerID = "" }},
+		{name: "zero amount", mutate: func(r *app.InitiatePaymentRequest) { r.AmountCents = 0 }},
+		{name: "negative amount", mutate: func(r *app.InitiatePaymentRequest) { r.AmountCents = -1 }},
+		{name: "over the amount limit", mutate: func(r *app.InitiatePaymentRequest) { r.AmountCents = 100001 }},
+		{name: "unknown currency", mutate: func(r *app.InitiatePaymentRequest) { r.Currency = "ZZZ" }},
+		{name: "currency not accepted", mutate: func(r *app.InitiatePaymentRequest) { r.Currency = "GBP" }},
+		{name: "no idempotency key", mutate: func(r *app.InitiatePaymentRequest) { r.IdempotencyKey = "" }},
+		{name: "long idempotency key", mutate: func(r *app.InitiatePaymentRequest) { r.IdempotencyKey = strings.Repeat("k", 256) }},
+		{name: "unknown capture method", mutate: func(r *app.InitiatePaymentRequest) { r.CaptureMethod = "later" }},
+		{name: "reserved metadata key", mutate: func(r *app.InitiatePaymentRequest) { r.Metadata = map[string]string{"customer_id": "x"} }},
+		{name: "bad client reference", mutate: func(r *app.InitiatePaymentRequest) { r.ClientReference = strings.Repeat("r", 1000) }},
+		{name: "bad correlation id", mutate: func(r *app.InitiatePaymentRequest) { r.CorrelationID = "not allowed!" }},
+		{name: "everything wrong", mutate: func(r *app.InitiatePaymentRequest) {
+			*r = app.InitiatePaymentRequest{AmountCents: -5, Currency: "eu", CaptureMethod: "x"}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := validRequest()
+			tt.mutate(&req)
+
+			_, dryErr := newService(t).DryRunInitiatePayment(context.Background(), req)
+			_, realErr := newService(t).InitiatePayment(context.Background(), req)
+
+			if (realErr == nil) != tt.valid {
+				t.Fatalf("InitiatePayment err = %v, want valid %v", realErr, tt.valid)
+			}
+			if got, want := rejection(dryErr), rejection(realErr); got != want {
+				t.Fatalf("dry run rejects with %q, a real request with %q", got, want)
+			}
+			if realErr != nil && !errors.Is(dryErr, app.ErrInvalidRequest) {
+				t.Fatalf("dry run err = %v, want ErrInvalidRequest", dryErr)
+			}
+		})
+	}
+}
+
+func TestDryRunWarnsAboutDuplicateOrders(t *testing.T) {
+	ctx := context.Background()
+	svc := newService(t)
+
+	first, err := svc.InitiatePayment(ctx, validRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := validRequest()
+	req.IdempotencyKey = "idem-2"
+	dry, err := svc.DryRunInitiatePayment(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(dry.Warnings, func(w string) bool { return strings.Contains(w, first.PaymentID) }) {
+		t.Fatalf("warnings = %v, want one naming payment %s", dry.Warnings, first.PaymentID)
+	}
+
+	// a retry of the same request is a replay, not a second attempt
+	dry, err = svc.DryRunInitiatePayment(ctx, validRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dry.Warnings) != 1 || !strings.Contains(dry.Warnings[0], "replay") {
+		t.Fatalf("warnings = %v, want only the replay", dry.Warnings)
+	}
+
+	// another order has no attempts
+	req.OrderID = "order-2"
+	dry, err = svc.DryRunInitiatePayment(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dry.Warnings) != 0 {
+		t.Fatalf("warnings = %v, want none", dry.Warnings)
+	}
+}
+
+// the dry run reports the correlation id a real request would store
+func TestDryRunCorrelationID(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		inbound string
+		// stored under the same idempotency key first, with this correlation id
+		stored string
+		want   string
+	}{
+		{name: "generated by a real request", want: ""},
+		{name: "inbound", inbound: "corr-in", want: "corr-in"},
+		{name: "replay keeps the stored one", stored: "corr-first", want: "corr-first"},
+		{name: "replay ignores the inbound one", inbound: "corr-in", stored: "corr-first", want: "corr-first"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newService(t)
+			if tt.stored != "" {
+				req := validRequest()
+				req.CorrelationID = tt.stored
+				if _, err := svc.InitiatePayment(ctx, req); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := validRequest()
+			req.CorrelationID = tt.inbound
+			for range 2 {
+				dry, err := svc.DryRunInitiatePayment(ctx, req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if dry.Normalized.CorrelationID != tt.want {
+					t.Fatalf("correlation id = %q, want %q", dry.Normalized.CorrelationID, tt.want)
+				}
+			}
+
+			if tt.stored == "" {
+				return
+			}
+			resp, err := svc.InitiatePayment(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.CorrelationID != tt.want {
+				t.Fatalf("real request stored %q, dry run said %q", resp.CorrelationID, tt.want)
+			}
+		})
+	}
+}
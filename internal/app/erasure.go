@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// CustomerEraser pseudonymizes a customer across all stored payments
+type CustomerEraser interface {
+	// EraseCustomer also removes piiKeys from the metadata of the payments
+	// and of their stored events. It returns the idempotency keys of the
+	// payments it rewrote, scoped by merchant as the cache holds them.
+	EraseCustomer(ctx context.Context, customerID, pseudonym string, piiKeys []string, batchSize int) ([]string, error)
+}
+
+// CacheEvicter removes cached idempotency responses
+type CacheEvicter interface {
+	Delete(ctx context.Context, keys ...string) error
+}
+
+type EraseCustomerResponse struct {
+	Pseudonym      string
+	PaymentsErased int
+}
+
+const pseudonymPrefix = "erased_"
+
+// ErasureService handles right-to-erasure requests, financial records are kept
+// but the customer identifier is replaced with a deterministic pseudonym and
+// the metadata keys configured as PII are removed
+type ErasureService struct {
+	eraser    CustomerEraser
+	cache     CacheEvicter
+	key       []byte
+	piiKeys   []string
+	batchSize int
+	log       *slog.Logger
+}
+
+func NewErasureService(eraser CustomerEraser, cache CacheEvicter, key string, piiKeys []string, batchSize int, log *slog.Logger) *ErasureService {
+	return &ErasureService{
+		eraser:    eraser,
+		cache:     cache,
+		key:       []byte(key),
+		piiKeys:   piiKeys,
+		batchSize: batchSize,
+		log:       log,
+	}
+}
+
+// Pseudonym is stable for a given key so repeated erasures and aggregates line up
+func (s *ErasureService) Pseudonym(customerID string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(customerID))
+	return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func (s *ErasureService) EraseCustomer(ctx context.Context, customerID string) (EraseCustomerResponse, error) {
+	if strings.TrimSpace(customerID) == "" {
+		return EraseCustomerResponse{}, fmt.Errorf("%w: customer_id is required", ErrInvalidRequest)
+	}
+
+	if strings.HasPrefix(customerID, pseudonymPrefix) {
+		return EraseCustomerResponse{}, fmt.Errorf("%w: customer is already erased", ErrInvalidRequest)
+	}
+	pseudonym := s.Pseudonym(customerID)
+
+	keys, err := s.eraser.EraseCustomer(ctx, customerID, pseudonym, s.piiKeys, s.batchSize)
+	if err != nil {
+		return EraseCustomerResponse{}, fmt.Errorf("erase customer: %w", err)
+	}
+
+	// the database is already pseudonymized, a failed purge only leaves
+	// entries that expire with the idempotency TTL
+	if len(keys) > 0 {
+		if err := s.cache.Delete(ctx, keys...); err != nil {
+			s.log.WarnContext(ctx, "purge idempotency cache after erasure", "err", err, "pseudonym", pseudonym)
+		}
+	}
+
+	s.log.InfoContext(ctx, "customer erased",
+		"pseudonym", pseudonym,
+		"payments_erased", len(keys),
+	)
+
+	return EraseCustomerResponse{Pseudonym: pseudonym, PaymentsErased: len(keys)}, nil
+}
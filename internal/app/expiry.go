@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/logging"
+)
+
+// ExpiryConfig controls when pending payments expire and how often the
+// sweeper looks for them
+type ExpiryConfig struct {
+	// pending payments older than this expire
+	After     time.Duration
+	Interval  time.Duration
+	BatchSize int
+}
+
+// ExpirySweeper fails pending payments nobody moved on within the window.
+// Replicas run it side by side: each expiry is a version-checked save, so a
+// payment another replica expired, or that transitioned while the batch was
+// in flight, is skipped. A payment whose authorization answer was lost is
+// pending as well and may have been charged, a success the gateway reports
+// after the expiry is refunded by ApplyProviderEvent.
+type ExpirySweeper struct {
+	repo    domain.Repository
+	cfg     ExpiryConfig
+	metrics *Metrics
+	log     *slog.Logger
+}
+
+// NewExpirySweeper uses DefaultMetrics when metrics is nil
+func NewExpirySweeper(repo domain.Repository, cfg ExpiryConfig, metrics *Metrics, log *slog.Logger) *ExpirySweeper {
+	if metrics == nil {
+		metrics = DefaultMetrics
+	}
+	return &ExpirySweeper{repo: repo, cfg: cfg, metrics: metrics, log: log}
+}
+
+// Run blocks until ctx is cancelled
+func (s *ExpirySweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.Sweep(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.log.WarnContext(ctx, "payment expiry sweep failed", "err", err, "expired", n)
+		} else if n > 0 {
+			s.log.InfoContext(ctx, "expired stale pending payments", "expired", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep expires pending payments older than the window in batches and
+// returns how many it expired
+func (s *ExpirySweeper) Sweep(ctx context.Context) (int, error) {
+	ctx = domain.WithActor(ctx, domain.SystemActor)
+	cutoff := time.Now().Add(-s.cfg.After)
+
+	total := 0
+	for {
+		batch, err := s.repo.FindExpiredPending(ctx, cutoff, s.cfg.BatchSize)
+		if err != nil {
+			return total, err
+		}
+
+		expired := 0
+		for _, p := range batch {
+			ok, err := s.expire(ctx, p)
+			if err != nil {
+				return total, err
+			}
+			if ok {
+				expired++
+			}
+		}
+		total += expired
+
+		// a batch lost entirely to other replicas would be fetched again
+		if len(batch) < s.cfg.BatchSize || expired == 0 {
+			return total, nil
+		}
+	}
+}
+
+// expire reports false for a payment that changed since it was loaded
+func (s *ExpirySweeper) expire(ctx context.Context, p *domain.Payment) (bool, error) {
+	ctx = logging.WithPaymentID(ctx, p.ID().String())
+	from := p.Status()
+	if err := p.Expire(); err != nil {
+		// not pending anymore, nothing to expire
+		return false, nil
+	}
+
+	err := s.repo.Save(ctx, p)
+	switch {
+	case errors.Is(err, domain.ErrVersionConflict):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	s.metrics.transitioned(from, p.Status())
+	s.log.InfoContext(ctx, "payment expired",
+		"payment_id", p.ID().String(),
+		"correlation_id", p.CorrelationID(),
+		"created_at", p.CreatedAt())
+	return true, nil
+}
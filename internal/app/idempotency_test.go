@@ -0,0 +1,156 @@
+package app_test
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// reservingStore closes secondTry once a second request tried to reserve a
+// key, by then the first one holds it
+type reservingStore struct {
+	*memory.KeyValueStore
+	tries     atomic.Int32
+	secondTry chan struct{}
+}
+
+func (s *reservingStore) Reserve(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	acquired, err := s.KeyValueStore.Reserve(ctx, key, ttl)
+	if s.tries.Add(1) == 2 {
+		close(s.secondTry)
+	}
+	return acquired, err
+}
+
+// heldProvider authorizes only once proceed is closed
+type heldProvider struct {
+	app.PaymentProvider
+	proceed <-chan struct{}
+}
+
+func (p heldProvider) Authorize(ctx context.Context, req app.ProviderRequest) (app.ProviderResult, error) {
+	select {
+	case <-p.proceed:
+	case <-time.After(5 * time.Second):
+	}
+	return p.PaymentProvider.Authorize(ctx, req)
+}
+
+// lookupBarrier lets the idempotency key lookups through only once both
+// requests made one, so both find nothing and both insert
+type lookupBarrier struct {
+	domain.Repository
+	arrived sync.WaitGroup
+}
+
+func (r *lookupBarrier) FindByIdempotencyKey(ctx context.Context, merchantID, key string) (*domain.Payment, error) {
+	r.arrived.Done()
+	r.arrived.Wait()
+	return r.Repository.FindByIdempotencyKey(ctx, merchantID, key)
+}
+
+// two requests carrying one key at the same moment create one payment, the
+// loser answers with the winner's
+func TestConcurrentInitiationsCreateOnePayment(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  func(*memory.Repository) *app.PaymentService
+	}{
+		{
+			// the second request finds the key reserved and waits for the
+			// first one's cached response
+			name: "reservation",
+			svc: func(repo *memory.Repository) *app.PaymentService {
+				kv := &reservingStore{KeyValueStore: memory.NewKeyValueStore(), secondTry: make(chan struct{})}
+				provider := heldProvider{PaymentProvider: mockprovider.New(time.Second), proceed: kv.secondTry}
+				return app.NewPaymentService(repo, kv, time.Hour, kv, provider, testLimits, nil, slog.New(slog.DiscardHandler))
+			},
+		},
+		{
+			// without redis both get past the lookup, the unique key makes
+			// one insert fail
+			name: "unique key",
+			svc: func(repo *memory.Repository) *app.PaymentService {
+				barrier := &lookupBarrier{Repository: repo}
+				barrier.arrived.Add(2)
+				return app.NewPaymentService(barrier, app.NoIdempotencyCache{}, time.Hour, memory.NewKeyValueStore(),
+					mockprovider.New(time.Second), testLimits, nil, slog.New(slog.DiscardHandler))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := memory.NewRepository()
+			svc := tt.svc(repo)
+
+			var (
+				wg        sync.WaitGroup
+				responses [2]app.InitiatePaymentResponse
+				errs      [2]error
+			)
+			for i := range 2 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					responses[i], errs[i] = svc.InitiatePayment(context.Background(), validRequest())
+				}()
+			}
+			wg.Wait()
+
+			for i, err := range errs {
+				if err != nil {
+					t.Fatalf("request %d: %v", i, err)
+				}
+			}
+			first, second := responses[0], responses[1]
+			if first.PaymentID != second.PaymentID || first.Replayed == second.Replayed {
+				t.Fatalf("responses %+v and %+v, want one payment created and replayed once", first, second)
+			}
+
+			stored, _, err := repo.List(context.Background(), domain.ListFilter{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored) != 1 {
+				t.Fatalf("%d payments stored, want 1", len(stored))
+			}
+		})
+	}
+}
+
+// an entry that does not decode is evicted, otherwise it would shadow the
+// response of the request that replaced it until it expired
+func TestCorruptCacheEntryIsEvicted(t *testing.T) {
+	kv := memory.NewKeyValueStore()
+	svc := app.NewPaymentService(memory.NewRepository(), kv, time.Hour, kv, mockprovider.New(time.Second),
+		testLimits, nil, slog.New(slog.DiscardHandler))
+	ctx := context.Background()
+
+	if err := kv.Set(ctx, domain.ScopedIdempotencyKey("", "idem-1"), "{not json", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	first, err := svc.InitiatePayment(ctx, validRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Replayed {
+		t.Fatalf("first = %+v, a corrupt entry is no replay", first)
+	}
+
+	replay, err := svc.InitiatePayment(ctx, validRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.PaymentID != first.PaymentID || replay.Source != app.SourceCache {
+		t.Fatalf("replay = %+v, want payment %s from the cache", replay, first.PaymentID)
+	}
+}
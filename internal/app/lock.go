@@ -0,0 +1,67 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// PaymentLocker serializes work on one payment across instances. A lock is
+// only an optimization, the version check on save stays the guarantee.
+type PaymentLocker interface {
+	// AcquireLock returns ok false while another holds the lock. The ttl
+	// frees locks of processes that died holding them.
+	AcquireLock(ctx context.Context, paymentID string, ttl time.Duration) (token string, ok bool, err error)
+	// ReleaseLock frees the lock if it still holds token
+	ReleaseLock(ctx context.Context, paymentID, token string) error
+}
+
+const (
+	// paymentLockTTL outlives a load-mutate-save cycle including a slow gateway
+	paymentLockTTL = 30 * time.Second
+	// how long a method waits for the holder before going ahead without
+	paymentLockWait = time.Second
+	paymentLockPoll = 25 * time.Millisecond
+)
+
+// lockPayment takes the payment's lock for an update-style method and
+// returns its release. A lock that stays held or cannot be taken is
+// skipped, the method then runs on the optimistic version check alone.
+func (s *PaymentService) lockPayment(ctx context.Context, id domain.PaymentID) (unlock func()) {
+	if s.locker == nil {
+		return func() {}
+	}
+
+	deadline := time.Now().Add(paymentLockWait)
+	for {
+		token, ok, err := s.locker.AcquireLock(ctx, id.String(), paymentLockTTL)
+		if err != nil {
+			s.log.WarnContext(ctx, "payment lock unavailable, continuing without",
+				"err", err,
+				"payment_id", id.String())
+			return func() {}
+		}
+		if ok {
+			return func() {
+				if err := s.locker.ReleaseLock(context.WithoutCancel(ctx), id.String(), token); err != nil {
+					s.log.WarnContext(ctx, "cannot release payment lock, it expires on its own",
+						"err", err,
+						"payment_id", id.String())
+				}
+			}
+		}
+
+		if time.Now().After(deadline) {
+			s.log.InfoContext(ctx, "payment lock still held, continuing without",
+				"payment_id", id.String())
+			return func() {}
+		}
+		select {
+		case <-ctx.Done():
+			// the caller's own load fails on the cancelled context
+			return func() {}
+		case <-time.After(paymentLockPoll):
+		}
+	}
+}
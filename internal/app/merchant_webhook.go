@@ -0,0 +1,238 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookEventTypes are the outbox events merchants can subscribe to, the
+// fan-out index in migration 000016 lists the same types
+var WebhookEventTypes = []string{"payment.completed", "payment.failed"}
+
+const (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryFailed    = "FAILED"
+)
+
+// WebhookEndpoint is a merchant URL notified of payment status changes
+type WebhookEndpoint struct {
+	ID  string
+	URL string
+	// Secret signs every delivery, it is only returned when the endpoint is created
+	Secret     string
+	EventTypes []string
+	Enabled    bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// WebhookDelivery is one event sent, or being sent, to one endpoint
+type WebhookDelivery struct {
+	ID             string
+	EndpointID     string
+	EventID        string
+	EventType      string
+	Status         string
+	Attempts       int
+	LastStatusCode int
+	LastError      string
+	NextAttemptAt  time.Time
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+}
+
+type WebhookStore interface {
+	CreateWebhookEndpoint(ctx context.Context, e WebhookEndpoint) (WebhookEndpoint, error)
+	// GetWebhookEndpoint returns domain.ErrNotFound for unknown ids
+	GetWebhookEndpoint(ctx context.Context, id string) (WebhookEndpoint, error)
+	ListWebhookEndpoints(ctx context.Context) ([]WebhookEndpoint, error)
+	UpdateWebhookEndpoint(ctx context.Context, e WebhookEndpoint) (WebhookEndpoint, error)
+	// DeleteWebhookEndpoint drops the endpoint with its deliveries
+	DeleteWebhookEndpoint(ctx context.Context, id string) error
+	// ListWebhookDeliveries returns the newest deliveries first, status is optional
+	ListWebhookDeliveries(ctx context.Context, endpointID, status string, limit int) ([]WebhookDelivery, error)
+}
+
+// WebhookService manages merchant webhook endpoints, deliveries are made by
+// the webhook dispatcher from the outbox
+type WebhookService struct {
+	store WebhookStore
+	// accept plain http URLs, for local receivers only
+	allowHTTP bool
+	log       *slog.Logger
+}
+
+func NewWebhookService(store WebhookStore, allowHTTP bool, log *slog.Logger) *WebhookService {
+	return &WebhookService{store: store, allowHTTP: allowHTTP, log: log}
+}
+
+type CreateWebhookEndpointRequest struct {
+	URL string
+	// every subscribable type when empty
+	EventTypes []string
+}
+
+// UpdateWebhookEndpointRequest leaves nil fields unchanged
+type UpdateWebhookEndpointRequest struct {
+	URL        *string
+	EventTypes []string
+	Enabled    *bool
+}
+
+func (s *WebhookService) Create(ctx context.Context, req CreateWebhookEndpointRequest) (WebhookEndpoint, error) {
+	if err := s.validateURL(req.URL); err != nil {
+		return WebhookEndpoint{}, err
+	}
+	types, err := webhookEventTypes(req.EventTypes)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return WebhookEndpoint{}, fmt.Errorf("generate webhook secret: %w", err)
+	}
+
+	endpoint, err := s.store.CreateWebhookEndpoint(ctx, WebhookEndpoint{
+		URL:        req.URL,
+		Secret:     "whsec_" + hex.EncodeToString(secret),
+		EventTypes: types,
+		Enabled:    true,
+	})
+	if err != nil {
+		return WebhookEndpoint{}, fmt.Errorf("create webhook endpoint: %w", err)
+	}
+
+	s.log.InfoContext(ctx, "webhook endpoint created", "endpoint_id", endpoint.ID, "event_types", endpoint.EventTypes)
+	return endpoint, nil
+}
+
+func (s *WebhookService) Get(ctx context.Context, id string) (WebhookEndpoint, error) {
+	if err := validateEndpointID(id); err != nil {
+		return WebhookEndpoint{}, err
+	}
+	endpoint, err := s.store.GetWebhookEndpoint(ctx, id)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	endpoint.Secret = ""
+	return endpoint, nil
+}
+
+func (s *WebhookService) List(ctx context.Context) ([]WebhookEndpoint, error) {
+	endpoints, err := s.store.ListWebhookEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range endpoints {
+		endpoints[i].Secret = ""
+	}
+	return endpoints, nil
+}
+
+func (s *WebhookService) Update(ctx context.Context, id string, req UpdateWebhookEndpointRequest) (WebhookEndpoint, error) {
+	if err := validateEndpointID(id); err != nil {
+		return WebhookEndpoint{}, err
+	}
+	endpoint, err := s.store.GetWebhookEndpoint(ctx, id)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	if req.URL != nil {
+		if err := s.validateURL(*req.URL); err != nil {
+			return WebhookEndpoint{}, err
+		}
+		endpoint.URL = *req.URL
+	}
+	if req.EventTypes != nil {
+		if endpoint.EventTypes, err = webhookEventTypes(req.EventTypes); err != nil {
+			return WebhookEndpoint{}, err
+		}
+	}
+	if req.Enabled != nil {
+		endpoint.Enabled = *req.Enabled
+	}
+
+	endpoint, err = s.store.UpdateWebhookEndpoint(ctx, endpoint)
+	if err != nil {
+		return WebhookEndpoint{}, fmt.Errorf("update webhook endpoint: %w", err)
+	}
+	endpoint.Secret = ""
+	return endpoint, nil
+}
+
+func (s *WebhookService) Delete(ctx context.Context, id string) error {
+	if err := validateEndpointID(id); err != nil {
+		return err
+	}
+	if err := s.store.DeleteWebhookEndpoint(ctx, id); err != nil {
+		return err
+	}
+	s.log.InfoContext(ctx, "webhook endpoint deleted", "endpoint_id", id)
+	return nil
+}
+
+// maxDeliveriesPage bounds one delivery listing
+const maxDeliveriesPage = 100
+
+// Deliveries lists an endpoint's deliveries, status FAILED returns those
+// that exhausted their attempts
+func (s *WebhookService) Deliveries(ctx context.Context, endpointID, status string) ([]WebhookDelivery, error) {
+	if err := validateEndpointID(endpointID); err != nil {
+		return nil, err
+	}
+	switch status {
+	case "", DeliveryPending, DeliveryDelivered, DeliveryFailed:
+	default:
+		return nil, fmt.Errorf("%w: status must be one of %s, %s or %s", ErrInvalidRequest, DeliveryPending, DeliveryDelivered, DeliveryFailed)
+	}
+	if _, err := s.store.GetWebhookEndpoint(ctx, endpointID); err != nil {
+		return nil, err
+	}
+	return s.store.ListWebhookDeliveries(ctx, endpointID, status, maxDeliveriesPage)
+}
+
+func (s *WebhookService) validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute URL", ErrInvalidRequest)
+	}
+	if u.Scheme != "https" && (u.Scheme != "http" || !s.allowHTTP) {
+		return fmt.Errorf("%w: url must use https", ErrInvalidRequest)
+	}
+	if len(raw) > 2048 {
+		return fmt.Errorf("%w: url must be at most 2048 characters", ErrInvalidRequest)
+	}
+	return nil
+}
+
+func webhookEventTypes(types []string) ([]string, error) {
+	if len(types) == 0 {
+		return slices.Clone(WebhookEventTypes), nil
+	}
+	for _, t := range types {
+		if !slices.Contains(WebhookEventTypes, t) {
+			return nil, fmt.Errorf("%w: unknown event type %q, expected one of %v", ErrInvalidRequest, t, WebhookEventTypes)
+		}
+	}
+	types = slices.Clone(types)
+	slices.Sort(types)
+	return slices.Compact(types), nil
+}
+
+func validateEndpointID(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return fmt.Errorf("%w: invalid webhook endpoint ID %q", ErrInvalidRequest, id)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/logging"
+)
+
+// UpdateMetadataRequest merges Set into a payment's metadata after deleting
+// the keys in Remove
+type UpdateMetadataRequest struct {
+	PaymentID string
+	Set       map[string]string
+	Remove    []string
+	// ExpectedVersion makes the update conditional on the version the caller
+	// read, 0 applies it to whatever version is stored
+	ExpectedVersion int
+}
+
+// UpdatePaymentMetadata applies req under the payment lock and the version
+// check, a concurrent update is retried against the reloaded payment
+func (s *PaymentService) UpdatePaymentMetadata(ctx context.Context, req UpdateMetadataRequest) (PaymentDetails, error) {
+	id, err := domain.ParsePaymentID(req.PaymentID)
+	if err != nil {
+		return PaymentDetails{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	ctx = logging.WithPaymentID(ctx, id.String())
+	if len(req.Set) == 0 && len(req.Remove) == 0 {
+		return PaymentDetails{}, fmt.Errorf("%w: metadata must set or remove at least one key", ErrInvalidRequest)
+	}
+
+	defer s.lockPayment(ctx, id)()
+
+	var p *domain.Payment
+	err = retryOnConflict(ctx, "update_metadata", func(ctx context.Context) error {
+		p, err = s.repo.FindByID(domain.WithConsistentRead(ctx), id)
+		if err != nil {
+			return fmt.Errorf("find payment: %w", err)
+		}
+		if err := p.CheckVersion(req.ExpectedVersion); err != nil {
+			return err
+		}
+		before := p.Version()
+		if err := p.UpdateMetadata(req.Set, req.Remove); err != nil {
+			return &ValidationError{Fields: []FieldError{{Field: "metadata", Message: metadataMessage(err)}}}
+		}
+		if p.Version() == before {
+			return nil
+		}
+		if err := s.repo.Save(ctx, p); err != nil {
+			return fmt.Errorf("save payment: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return PaymentDetails{}, err
+	}
+
+	s.log.InfoContext(ctx, "payment metadata updated",
+		"payment_id", p.ID().String(),
+		"correlation_id", p.CorrelationID(),
+		"keys", len(p.Metadata()),
+	)
+	return toPaymentDetails(p), nil
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// Metrics holds the business collectors of PaymentService, counted in the
+// service so every transport reports the same numbers
+type Metrics struct {
+	initiatedTotal     *prometheus.CounterVec
+	amountCentsTotal   *prometheus.CounterVec
+	transitionedTotal  *prometheus.CounterVec
+	replaysTotal       *prometheus.CounterVec
+	initiationDuration *prometheus.HistogramVec
+}
+
+// DefaultMetrics is registered on the default registry and used when
+// NewPaymentService is given nil
+var DefaultMetrics = NewMetrics(prometheus.DefaultRegisterer)
+
+// NewMetrics registers the business collectors on reg. Collectors already on
+// reg are reused, so several services can share one registry.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	return &Metrics{
+		initiatedTotal: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gopay_service",
+			Name:      "payments_initiated_total",
+			Help:      "Payments created, replays excluded, partitioned by currency.",
+		}, []string{"currency"})),
+
+		amountCentsTotal: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gopay_service",
+			Name:      "payment_amount_cents_sum",
+			Help:      "Amount of the payments created in minor units, partitioned by currency.",
+		}, []string{"currency"})),
+
+		transitionedTotal: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gopay_service",
+			Name:      "payments_transitioned_total",
+			Help:      "Saved payment status changes, partitioned by old and new status.",
+		}, []string{"from", "to"})),
+
+		replaysTotal: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gopay_service",
+			Name:      "idempotent_replays_total",
+			Help:      "InitiatePayment calls answered with an earlier response, partitioned by where it was found.",
+		}, []string{"source"})),
+
+		initiationDuration: register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "gopay_service",
+			Name:      "initiate_payment_duration_seconds",
+			Help:      "InitiatePayment latency including the gateway call, partitioned by outcome.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"outcome"})),
+	}
+}
+
+func (m *Metrics) initiated(p *domain.Payment) {
+	m.initiatedTotal.WithLabelValues(p.Amount().Currency()).Inc()
+	m.amountCentsTotal.WithLabelValues(p.Amount().Currency()).Add(float64(p.Amount().Amount()))
+}
+
+// transitioned is called once the new status is saved
+func (m *Metrics) transitioned(from, to domain.PaymentStatus) {
+	if from != to {
+		m.transitionedTotal.WithLabelValues(string(from), string(to)).Inc()
+	}
+}
+
+func (m *Metrics) replayed(source string) {
+	m.replaysTotal.WithLabelValues(source).Inc()
+}
+
+// observeInitiation labels the call created, replayed, rejected for requests
+// that can never succeed as sent, or error
+func (m *Metrics) observeInitiation(start time.Time, replayed bool, err error) {
+	outcome := "created"
+	switch {
+	case errors.Is(err, ErrInvalidRequest),
+		errors.Is(err, ErrIdempotencyKeyReused),
+		errors.Is(err, ErrIdempotencyKeyInFlight):
+		outcome = "rejected"
+	case err != nil:
+		outcome = "error"
+	case replayed:
+		outcome = "replayed"
+	}
+	m.initiationDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+}
+
+// register returns the collector already registered under the same
+// descriptor instead of panicking on duplicate registration
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
@@ -0,0 +1,105 @@
+package app_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+)
+
+// series gathers g into name{label="value",...} -> value, a histogram
+// reports its sample count
+func series(t *testing.T, g prometheus.Gatherer) map[string]float64 {
+	t.Helper()
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetName()+"="+`"`+l.GetValue()+`"`)
+			}
+			name := f.GetName() + "{" + strings.Join(labels, ",") + "}"
+			switch {
+			case m.GetCounter() != nil:
+				got[name] = m.GetCounter().GetValue()
+			case m.GetHistogram() != nil:
+				got[name] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return got
+}
+
+// every InitiatePayment outcome lands in the business series, whichever
+// transport made the call
+func TestBusinessMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := app.NewMetrics(reg)
+	repo := memory.NewRepository()
+	kv := memory.NewKeyValueStore()
+	log := slog.New(slog.DiscardHandler)
+	svc := app.NewPaymentService(repo, kv, time.Hour, kv, mockprovider.New(time.Second), testLimits, metrics, log)
+	// shares the registry and the payments, without a cache a replay is
+	// found in the database
+	uncached := app.NewPaymentService(repo, app.NoIdempotencyCache{}, time.Hour, memory.NewKeyValueStore(),
+		mockprovider.New(time.Second), testLimits, app.NewMetrics(reg), log)
+	ctx := context.Background()
+
+	if _, err := svc.InitiatePayment(ctx, validRequest()); err != nil {
+		t.Fatal(err)
+	}
+	usd := validRequest()
+	usd.IdempotencyKey, usd.Currency, usd.AmountCents = "idem-2", "USD", 500
+	if _, err := svc.InitiatePayment(ctx, usd); err != nil {
+		t.Fatal(err)
+	}
+	if replay, err := svc.InitiatePayment(ctx, validRequest()); err != nil || replay.Source != app.SourceCache {
+		t.Fatalf("replay = %+v, %v, want it from the cache", replay, err)
+	}
+	if replay, err := uncached.InitiatePayment(ctx, validRequest()); err != nil || replay.Source != app.SourceDB {
+		t.Fatalf("replay = %+v, %v, want it from the database", replay, err)
+	}
+	invalid := validRequest()
+	invalid.IdempotencyKey, invalid.AmountCents = "idem-3", 0
+	if _, err := svc.InitiatePayment(ctx, invalid); err == nil {
+		t.Fatal("a payment of nothing was accepted")
+	}
+
+	want := map[string]float64{
+		`gopay_service_payments_initiated_total{currency="EUR"}`:                    1,
+		`gopay_service_payments_initiated_total{currency="USD"}`:                    1,
+		`gopay_service_payment_amount_cents_sum{currency="EUR"}`:                    1999,
+		`gopay_service_payment_amount_cents_sum{currency="USD"}`:                    500,
+		`gopay_service_payments_transitioned_total{from="PENDING",to="PROCESSING"}`: 2,
+		`gopay_service_idempotent_replays_total{source="cache"}`:                    1,
+		`gopay_service_idempotent_replays_total{source="db"}`:                       1,
+		`gopay_service_initiate_payment_duration_seconds{outcome="created"}`:        2,
+		`gopay_service_initiate_payment_duration_seconds{outcome="replayed"}`:       2,
+		`gopay_service_initiate_payment_duration_seconds{outcome="rejected"}`:       1,
+	}
+	if got := series(t, reg); !maps.Equal(got, want) {
+		t.Fatalf("series:\n%s\nwant:\n%s", dump(got), dump(want))
+	}
+}
+
+func dump(series map[string]float64) string {
+	var lines []string
+	for _, name := range slices.Sorted(maps.Keys(series)) {
+		lines = append(lines, fmt.Sprintf("%s %g", name, series[name]))
+	}
+	return strings.Join(lines, "\n")
+}
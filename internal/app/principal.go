@@ -0,0 +1,52 @@
+package app
+
+import (
+	"context"
+	"slices"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+const (
+	ScopePaymentsRead  = "payments:read"
+	ScopePaymentsWrite = "payments:write"
+	ScopeRefundsWrite  = "refunds:write"
+)
+
+// Principal is the authenticated caller of a request, whichever auth mode resolved it
+type Principal struct {
+	ID     string
+	Scopes []string
+	// MerchantID is the merchant an API key belongs to, empty for signed callers
+	MerchantID string
+	// AuthMethod records how the principal was resolved, e.g. "signature"
+	AuthMethod string
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+// WithPrincipal also makes the principal the actor of the transitions it
+// causes, and confines the reads of a merchant's principal to its payments
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	ctx = domain.WithActor(ctx, p.ID)
+	if p.MerchantID != "" {
+		ctx = domain.WithMerchant(ctx, p.MerchantID)
+	}
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns false when the request was not authenticated
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// merchantOf is the merchant a request acts for, empty for platform callers
+func merchantOf(ctx context.Context) string {
+	p, _ := PrincipalFromContext(ctx)
+	return p.MerchantID
+}
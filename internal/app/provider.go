@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrProviderDeclined is a capture or refund the gateway refused
+	ErrProviderDeclined = errors.New("declined by payment provider")
+
+	// ErrProviderUnavailable means the gateway did not answer in time or
+	// failed, the outcome of the call is unknown
+	ErrProviderUnavailable = errors.New("payment provider unavailable")
+)
+
+// ProviderRequest asks the gateway to authorize a payment. IdempotencyKey is
+// stable per payment so a retried call never charges twice.
+type ProviderRequest struct {
+	// PaymentID is stored with the gateway's payment and echoed in its
+	// webhooks, so a payment whose answer was lost can still be settled
+	PaymentID       string
+	CustomerID      string
+	AmountCents     int64
+	Currency        string
+	ClientReference string
+	IdempotencyKey  string
+	// Capture takes the funds right away, false only places a hold
+	Capture bool
+}
+
+// ProviderCaptureRequest is keyed by payment ID, a payment is captured once
+type ProviderCaptureRequest struct {
+	ProviderRef    string
+	AmountCents    int64
+	Currency       string
+	IdempotencyKey string
+}
+
+// ProviderRefundRequest is keyed by refund ID
+type ProviderRefundRequest struct {
+	ProviderRef    string
+	RefundID       string
+	AmountCents    int64
+	Currency       string
+	IdempotencyKey string
+}
+
+// ProviderResult is the gateway's answer. A decline is a result rather than
+// an error, errors mean the outcome is unknown.
+type ProviderResult struct {
+	// ProviderRef identifies the payment or refund at the gateway
+	ProviderRef   string
+	Declined      bool
+	DeclineReason string
+}
+
+// PaymentProvider is the port to a payment gateway
+type PaymentProvider interface {
+	Authorize(ctx context.Context, req ProviderRequest) (ProviderResult, error)
+	Capture(ctx context.Context, req ProviderCaptureRequest) (ProviderResult, error)
+	Refund(ctx context.Context, req ProviderRefundRequest) (ProviderResult, error)
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/logging"
+)
+
+type RefundPaymentRequest struct {
+	PaymentID      string
+	IdempotencyKey string
+	// zero refunds whatever is left of the payment
+	AmountCents int64
+	Reason      string
+}
+
+type RefundDetails struct {
+	RefundID    string
+	PaymentID   string
+	AmountCents int64
+	Currency    string
+	Status      string
+	Reason      string
+	// set when the gateway declined the refund
+	FailureReason string
+	CreatedAt     time.Time
+}
+
+// RefundPayment refunds part or all of a completed payment. Keys are scoped to
+// the payment, a retry with the same key replays the earlier refund.
+func (s *PaymentService) RefundPayment(ctx context.Context, req RefundPaymentRequest) (RefundDetails, error) {
+	id, err := domain.ParsePaymentID(req.PaymentID)
+	if err != nil {
+		return RefundDetails{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	ctx = logging.WithPaymentID(ctx, id.String())
+	switch {
+	case req.IdempotencyKey == "":
+		return RefundDetails{}, fmt.Errorf("%w: idempotency_key is required (use the Idempotency-Key header)", ErrInvalidRequest)
+	case req.AmountCents < 0:
+		return RefundDetails{}, fmt.Errorf("%w: amount_cents must be a positive integer", ErrInvalidRequest)
+	case len(req.Reason) > 255:
+		return RefundDetails{}, fmt.Errorf("%w: reason must be at most 255 characters", ErrInvalidRequest)
+	}
+
+	// the payment first, another merchant's is not found even on a replay
+	p, err := s.repo.FindByID(domain.WithConsistentRead(ctx), id)
+	if err != nil {
+		return RefundDetails{}, fmt.Errorf("find payment: %w", err)
+	}
+	existing, err := s.repo.FindRefundByIdempotencyKey(ctx, id, req.IdempotencyKey)
+	if err != nil {
+		return RefundDetails{}, fmt.Errorf("refund idempotency key lookup: %w", err)
+	}
+	if existing != nil {
+		return toRefundDetails(existing), nil
+	}
+
+	refunds, err := s.repo.ListRefunds(ctx, id)
+	if err != nil {
+		return RefundDetails{}, fmt.Errorf("list refunds: %w", err)
+	}
+	refunded, err := domain.RefundedAmount(p.Amount().Currency(), refunds)
+	if err != nil {
+		return RefundDetails{}, err
+	}
+
+	var amount domain.Money
+	if req.AmountCents == 0 {
+		if amount, err = domain.RefundableAmount(p.Amount(), refunded); err != nil {
+			return RefundDetails{}, err
+		}
+		if amount.IsZero() {
+			return RefundDetails{}, fmt.Errorf("%w: payment %s is fully refunded", domain.ErrOverRefund, id)
+		}
+	} else if amount, err = domain.NewMoney(req.AmountCents, p.Amount().Currency()); err != nil {
+		return RefundDetails{}, err
+	}
+
+	refund, err := domain.NewRefund(p, refunded, amount, req.Reason, req.IdempotencyKey)
+	if err != nil {
+		return RefundDetails{}, err
+	}
+
+	// SaveRefund re-checks the total with the payment row locked
+	if err := s.repo.SaveRefund(ctx, refund); err != nil {
+		if !errors.Is(err, domain.ErrVersionConflict) {
+			return RefundDetails{}, fmt.Errorf("save refund: %w", err)
+		}
+		// a concurrent retry with this key won the race
+		if current, findErr := s.repo.FindRefundByIdempotencyKey(ctx, id, req.IdempotencyKey); findErr == nil && current != nil {
+			return toRefundDetails(current), nil
+		}
+		return RefundDetails{}, err
+	}
+
+	s.log.InfoContext(ctx, "payment refunded",
+		"payment_id", p.ID().String(),
+		"refund_id", refund.ID(),
+		"correlation_id", p.CorrelationID(),
+		"amount", refund.Amount().String(),
+	)
+
+	if err := s.settleRefund(ctx, p, refund); err != nil {
+		s.log.WarnContext(ctx, "refund left pending", "refund_id", refund.ID(), "err", err)
+	}
+	return toRefundDetails(refund), nil
+}
+
+// settleRefund sends a saved refund to the gateway. The amount is reserved
+// before the call, so a concurrent refund can never push the total over.
+// An unreachable gateway leaves the refund PENDING.
+func (s *PaymentService) settleRefund(ctx context.Context, p *domain.Payment, refund *domain.Refund) error {
+	// payments that never reached the gateway have nothing to refund there
+	if p.ProviderRef() == "" {
+		return nil
+	}
+
+	result, err := s.provider.Refund(ctx, ProviderRefundRequest{
+		ProviderRef:    p.ProviderRef(),
+		RefundID:       refund.ID(),
+		AmountCents:    refund.Amount().Amount(),
+		Currency:       refund.Amount().Currency(),
+		IdempotencyKey: refund.ID(),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: refund: %w", ErrProviderUnavailable, err)
+	}
+
+	if result.Declined {
+		err = refund.Fail(declineReason(result))
+	} else {
+		err = refund.Succeed(result.ProviderRef)
+	}
+	if err != nil {
+		return err
+	}
+	return s.repo.UpdateRefund(ctx, refund)
+}
+
+// ListRefunds returns the refunds of a payment, oldest first
+func (s *PaymentService) ListRefunds(ctx context.Context, paymentID string) ([]RefundDetails, error) {
+	id, err := domain.ParsePaymentID(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	// unknown payments are a 404 rather than an empty list
+	if _, err := s.repo.FindByID(ctx, id); err != nil {
+		return nil, fmt.Errorf("find payment: %w", err)
+	}
+
+	refunds, err := s.repo.ListRefunds(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("list refunds: %w", err)
+	}
+
+	details := make([]RefundDetails, 0, len(refunds))
+	for _, r := range refunds {
+		details = append(details, toRefundDetails(r))
+	}
+	return details, nil
+}
+
+func toRefundDetails(r *domain.Refund) RefundDetails {
+	return RefundDetails{
+		RefundID:      r.ID(),
+		PaymentID:     r.PaymentID().String(),
+		AmountCents:   r.Amount().Amount(),
+		Currency:      r.Amount().Currency(),
+		Status:        string(r.Status()),
+		Reason:        r.Reason(),
+		FailureReason: r.FailureReason(),
+		CreatedAt:     r.CreatedAt(),
+	}
+}
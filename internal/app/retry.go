@@ -0,0 +1,56 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+var versionConflictRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gopay_service",
+	Subsystem: "payments",
+	Name:      "version_conflict_retries_total",
+	Help:      "Updates retried after losing an optimistic lock, partitioned by operation.",
+}, []string{"operation"})
+
+const (
+	// conflictAttempts includes the first try
+	conflictAttempts = 3
+	// conflictBackoff doubles per retry, each wait is jittered by up to half
+	conflictBackoff = 10 * time.Millisecond
+)
+
+// retryOnConflict runs attempt again while it fails with
+// domain.ErrVersionConflict. Every attempt must reload the payment and
+// re-apply its transition. A transition that became invalid on a retry is
+// reported as the conflict it was caused by. A domain.VersionConflictError
+// is the caller's expectation failing and is returned without a retry.
+func retryOnConflict(ctx context.Context, operation string, attempt func(ctx context.Context) error) error {
+	backoff := conflictBackoff
+	for i := 1; ; i++ {
+		err := attempt(ctx)
+		if i > 1 && errors.Is(err, domain.ErrInvalidTransition) {
+			return fmt.Errorf("%w: %w", domain.ErrVersionConflict, err)
+		}
+		var expected *domain.VersionConflictError
+		if !errors.Is(err, domain.ErrVersionConflict) || errors.As(err, &expected) || i == conflictAttempts {
+			return err
+		}
+
+		versionConflictRetriesTotal.WithLabelValues(operation).Inc()
+		wait := backoff/2 + rand.N(backoff/2+1)
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
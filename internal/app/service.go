@@ -6,159 +6,933 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/sync/singleflight"
+
 	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/logging"
 )
 
+var coalescedReadsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "gopay_service",
+	Subsystem: "payments",
+	Name:      "coalesced_reads_total",
+	Help:      "GetPayment calls answered by a load another concurrent call started.",
+})
+
+// ErrInvalidRequest wraps domain-level rejections of an otherwise well-formed request
+var ErrInvalidRequest = errors.New("invalid request")
+
+// ErrIdempotencyKeyInFlight is a request whose idempotency key another
+// request is still processing
+var ErrIdempotencyKeyInFlight = errors.New("a request with this idempotency key is in progress")
+
+// ErrIdempotencyKeyReused is a replayed idempotency key whose request differs
+// from the one that created the payment
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+
 type IdempotencyStore interface {
 	// Get returns (result, true, nil), ("", false, nil) if miss
 	Get(ctx context.Context, key string) (string, bool, error)
 	// Set stores result for key with a TTL
 	// Uses SET NX so the first writer wins in a race between two concurrent identical requests
 	Set(ctx context.Context, key string, result string, ttl time.Duration) error
+	// Reserve claims key for one in-flight request, acquired is false while
+	// another holds it. The ttl frees keys of processes that died holding them.
+	Reserve(ctx context.Context, key string, ttl time.Duration) (acquired bool, err error)
+	// Release gives up a reservation before its ttl
+	Release(ctx context.Context, key string) error
+	// Delete evicts cached responses, a corrupt one among them
+	CacheEvicter
 }
 
-// OutboxWriter appends domain events to the transactional outbox
-type OutboxWriter interface {
-	Write(ctx context.Context, aggregateID string, eventType string, payload []byte) error
+// NoIdempotencyCache is the IdempotencyStore of deployments without redis.
+// Every lookup misses, so replays are answered from the payment row and
+// concurrent duplicates are settled by its unique idempotency key.
+type NoIdempotencyCache struct{}
+
+func (NoIdempotencyCache) Get(context.Context, string) (string, bool, error) { return "", false, nil }
+
+func (NoIdempotencyCache) Set(context.Context, string, string, time.Duration) error { return nil }
+
+func (NoIdempotencyCache) Reserve(context.Context, string, time.Duration) (bool, error) {
+	return true, nil
 }
 
+func (NoIdempotencyCache) Release(context.Context, string) error { return nil }
+
+func (NoIdempotencyCache) Delete(context.Context, ...string) error { return nil }
+
 type InitiatePaymentRequest struct {
 	OrderID        string
 	CustomerID     string
 	AmountCents    int64
 	Currency       string
 	IdempotencyKey string
+	// optional merchant reference forwarded to the PSP
+	ClientReference string
+	// adopted from the caller when present, generated otherwise
+	CorrelationID string
+	// "manual" holds the payment AUTHORIZED until CapturePayment, empty means automatic
+	CaptureMethod string
+	// optional merchant key/value data, see domain.ValidateMetadata
+	Metadata map[string]string
 }
 
 type InitiatePaymentResponse struct {
-	PaymentID string
-	Status    string
+	PaymentID     string
+	Status        string
+	CorrelationID string
+
+	// Replayed is set when an earlier request with the same key created the
+	// payment, Source tells where its response was found. Neither is cached.
+	Replayed bool   `json:"-"`
+	Source   string `json:"-"`
 }
 
-func (r InitiatePaymentRequest) Validate() error {
-	switch {
-	case r.OrderID == "":
-		return errors.New("order_id is required")
-	case r.CustomerID == "":
-		return errors.New("customer_id is required")
-	case r.AmountCents <= 0:
-		return errors.New("amount_cents must be a positive integer")
-	case r.Currency == "":
-		return errors.New("currency is required")
-	case r.IdempotencyKey == "":
-		return errors.New("idempotency_key is required (use the Idempotency-Key header)")
-	default:
-		return nil
-	}
+// where an InitiatePayment response came from
+const (
+	SourceFresh = "fresh"
+	SourceCache = "cache"
+	SourceDB    = "db"
+)
+
+// PaymentDetails is the full read representation of a payment
+type PaymentDetails struct {
+	PaymentID       string
+	OrderID         string
+	CustomerID      string
+	AmountCents     int64
+	Currency        string
+	Status          string
+	ProviderRef     string
+	FailureReason   string
+	ClientReference string
+	CorrelationID   string
+	Metadata        map[string]string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Version         int
 }
 
-const idempotencyTTL = 24 * time.Hour
+const (
+	// reservationTTL outlives a normal InitiatePayment including a slow gateway
+	reservationTTL = 30 * time.Second
+	// how long a concurrent duplicate waits for the first request's response
+	reservationWait = 500 * time.Millisecond
+	reservationPoll = 50 * time.Millisecond
+)
 
 type PaymentService struct {
 	repo       domain.Repository
 	idempotent IdempotencyStore
-	outbox     OutboxWriter
-	log        *slog.Logger
+	// how long responses are replayed from the cache
+	idempotencyTTL time.Duration
+	// nil leaves concurrent updates to the version check
+	locker   PaymentLocker
+	provider PaymentProvider
+	limits   RequestLimits
+	metrics  *Metrics
+	log      *slog.Logger
+
+	// reads coalesces concurrent identical GetPayment loads
+	reads singleflight.Group
 }
 
 func NewPaymentService(
 	repo domain.Repository,
 	idempotent IdempotencyStore,
-	outbox OutboxWriter,
+	idempotencyTTL time.Duration,
+	locker PaymentLocker,
+	provider PaymentProvider,
+	limits RequestLimits,
+	metrics *Metrics,
 	log *slog.Logger,
 ) *PaymentService {
+	if metrics == nil {
+		metrics = DefaultMetrics
+	}
 	return &PaymentService{
-		repo:       repo,
-		idempotent: idempotent,
-		outbox:     outbox,
-		log:        log,
+		repo:           repo,
+		idempotent:     idempotent,
+		idempotencyTTL: idempotencyTTL,
+		locker:         locker,
+		limits:         limits,
+		metrics:        metrics,
+		provider:       provider,
+		log:            log,
 	}
 }
 
 func (s *PaymentService) InitiatePayment(ctx context.Context, req InitiatePaymentRequest) (InitiatePaymentResponse, error) {
-	if cached, ok, err := s.idempotent.Get(ctx, req.IdempotencyKey); err != nil {
-		s.log.WarnContext(ctx, "idempotency cache unavailable, DB check",
+	start := time.Now()
+	resp, replayed, err := s.initiatePayment(ctx, req)
+	s.metrics.observeInitiation(start, replayed, err)
+	return resp, err
+}
+
+// initiatePayment reports whether resp replays an earlier request
+func (s *PaymentService) initiatePayment(ctx context.Context, req InitiatePaymentRequest) (InitiatePaymentResponse, bool, error) {
+	// built first so a replay can be compared with the request it replays
+	payment, err := newPayment(merchantOf(ctx), req, s.limits)
+	if err != nil {
+		return InitiatePaymentResponse{}, false, err
+	}
+	// the cache is shared by every merchant, each chooses its own keys
+	key := domain.ScopedIdempotencyKey(payment.MerchantID(), req.IdempotencyKey)
+
+	if resp, ok, err := s.replayFromCache(ctx, key, payment); ok || err != nil {
+		return resp, true, err
+	}
+
+	// the reservation closes the gap between the lookup below and the
+	// insert, a concurrent duplicate waits for this request's response
+	acquired, err := s.idempotent.Reserve(ctx, key, reservationTTL)
+	switch {
+	case err != nil:
+		// the unique index on idempotency_key still rejects a duplicate insert
+		s.log.WarnContext(ctx, "idempotency reservation unavailable, continuing without",
 			"err", err,
 			"idempotency_key", req.IdempotencyKey)
-	} else if ok {
-		var resp InitiatePaymentResponse
-		if err := json.Unmarshal([]byte(cached), &resp); err != nil {
-			s.log.WarnContext(ctx, "corrupt idempotency cache entry, evicting", "err", err)
-		} else {
-			s.log.InfoContext(ctx, "idempotent replay from cache",
-				"payment_id", resp.PaymentID,
-				"idempotency_key", req.IdempotencyKey,
-			)
-			return resp, nil
-		}
+	case !acquired:
+		resp, err := s.awaitInFlight(ctx, key, payment)
+		return resp, true, err
+	default:
+		defer func() {
+			if err := s.idempotent.Release(context.WithoutCancel(ctx), key); err != nil {
+				s.log.WarnContext(ctx, "cannot release idempotency reservation, it expires on its own",
+					"err", err,
+					"idempotency_key", req.IdempotencyKey)
+			}
+		}()
 	}
 
-	existing, err := s.repo.FindByIdempotencyKey(req.IdempotencyKey)
+	// a replica lagging behind the first request would let a retry insert again
+	existing, err := s.repo.FindByIdempotencyKey(domain.WithConsistentRead(ctx), payment.MerchantID(), req.IdempotencyKey)
 	if err != nil {
-		return InitiatePaymentResponse{}, fmt.Errorf("idempotency key lookup: %w", err)
+		return InitiatePaymentResponse{}, false, fmt.Errorf("idempotency key lookup: %w", err)
 	}
 	if existing != nil {
-		resp := InitiatePaymentResponse{
-			PaymentID: existing.ID().String(),
-			Status:    string(existing.Status()),
+		// the cache entry may have expired while the payment row remains
+		resp, err := s.replayExisting(ctx, key, existing, payment)
+		return resp, true, err
+	}
+
+	// Save writes the pending events to the outbox in the same transaction
+	if err := s.repo.Save(ctx, payment); err != nil {
+		// a concurrent request with the same key won the insert
+		var dup *domain.DuplicateIdempotencyKeyError
+		if errors.As(err, &dup) {
+			s.log.InfoContext(ctx, "lost idempotency key race, replaying the stored payment",
+				"payment_id", dup.Existing.ID().String(),
+				"idempotency_key", req.IdempotencyKey)
+			resp, err := s.replayExisting(ctx, key, dup.Existing, payment)
+			return resp, true, err
 		}
+		return InitiatePaymentResponse{}, false, fmt.Errorf("save payment: %w", err)
+	}
+	s.metrics.initiated(payment)
+	ctx = logging.WithPaymentID(ctx, payment.ID().String())
 
-		// re-populate the cache for future requests to skip db next time
-		s.cache(ctx, req.IdempotencyKey, resp)
-		return resp, nil
+	// the payment exists from here on, a gateway problem leaves it pending
+	// until the gateway's webhook reports the outcome
+	if err := s.authorize(ctx, payment); err != nil {
+		s.log.WarnContext(ctx, "payment left unauthorized",
+			"payment_id", payment.ID().String(),
+			"status", string(payment.Status()),
+			"err", err,
+		)
 	}
 
-	amount, err := domain.NewMoney(req.AmountCents, req.Currency)
+	// cache result
+	resp := InitiatePaymentResponse{
+		PaymentID:     payment.ID().String(),
+		Status:        string(payment.Status()),
+		CorrelationID: payment.CorrelationID(),
+	}
+	s.cache(ctx, key, payment, resp)
+	resp.Source = SourceFresh
+
+	s.log.InfoContext(ctx, "payment initiated",
+		"payment_id", payment.ID().String(),
+		"correlation_id", payment.CorrelationID(),
+		"order_id", req.OrderID,
+		"customer_id", req.CustomerID,
+		"amount", payment.Amount().String(),
+	)
+
+	return resp, false, nil
+}
+
+// replayExisting answers a request with the payment already stored under its
+// key and re-populates the cache so the next replay skips the database
+func (s *PaymentService) replayExisting(ctx context.Context, key string, existing, incoming *domain.Payment) (InitiatePaymentResponse, error) {
+	if !s.sameRequest(existing.RequestHash(), existing.ClientReference(), incoming) {
+		return InitiatePaymentResponse{}, fmt.Errorf("%w: payment %s", ErrIdempotencyKeyReused, existing.ID())
+	}
+	resp := InitiatePaymentResponse{
+		PaymentID:     existing.ID().String(),
+		Status:        string(existing.Status()),
+		CorrelationID: existing.CorrelationID(),
+	}
+	s.metrics.replayed(SourceDB)
+	s.cache(ctx, key, existing, resp)
+
+	resp.Replayed, resp.Source = true, SourceDB
+	return resp, nil
+}
+
+// replayFromCache returns ok when the cache holds the response for key
+func (s *PaymentService) replayFromCache(ctx context.Context, key string, incoming *domain.Payment) (InitiatePaymentResponse, bool, error) {
+	cached, ok, err := s.idempotent.Get(ctx, key)
+	if err != nil {
+		s.log.WarnContext(ctx, "idempotency cache unavailable, DB check",
+			"err", err,
+			"idempotency_key", key)
+		return InitiatePaymentResponse{}, false, nil
+	}
+	if !ok {
+		return InitiatePaymentResponse{}, false, nil
+	}
+
+	var entry cachedInitiation
+	if err := json.Unmarshal([]byte(cached), &entry); err != nil {
+		s.log.WarnContext(ctx, "corrupt idempotency cache entry, evicting",
+			"err", err,
+			"idempotency_key", key)
+		// Set keeps the first writer, the entry would shadow the fresh response
+		if err := s.idempotent.Delete(ctx, key); err != nil {
+			s.log.WarnContext(ctx, "cannot evict corrupt idempotency cache entry, it expires on its own",
+				"err", err,
+				"idempotency_key", key)
+		}
+		return InitiatePaymentResponse{}, false, nil
+	}
+	if !s.sameRequest(entry.RequestHash, entry.ClientReference, incoming) {
+		return InitiatePaymentResponse{}, false, fmt.Errorf("%w: payment %s", ErrIdempotencyKeyReused, entry.PaymentID)
+	}
+
+	s.metrics.replayed(SourceCache)
+	s.log.InfoContext(ctx, "idempotent replay from cache",
+		"payment_id", entry.PaymentID,
+		"idempotency_key", key,
+	)
+	resp := entry.InitiatePaymentResponse
+	resp.Replayed, resp.Source = true, SourceCache
+	return resp, true, nil
+}
+
+// awaitInFlight polls the cache briefly for the response of the request
+// holding the reservation, the caller retries if it does not show up
+func (s *PaymentService) awaitInFlight(ctx context.Context, key string, incoming *domain.Payment) (InitiatePaymentResponse, error) {
+	ticker := time.NewTicker(reservationPoll)
+	defer ticker.Stop()
+	deadline := time.After(reservationWait)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return InitiatePaymentResponse{}, ctx.Err()
+		case <-deadline:
+			return InitiatePaymentResponse{}, ErrIdempotencyKeyInFlight
+		case <-ticker.C:
+		}
+
+		if resp, ok, err := s.replayFromCache(ctx, key, incoming); ok || err != nil {
+			return resp, err
+		}
+	}
+}
+
+// authorize sends a freshly saved payment to the gateway. Automatic payments
+// move to PROCESSING, manual ones record the hold and stay AUTHORIZED, and a
+// decline fails the payment. When the gateway's answer is lost, because the
+// call failed or timed out or the save after it did, the payment keeps its
+// status and no provider reference. The gateway may still have charged it,
+// its webhook finds the payment by our id and settles it.
+func (s *PaymentService) authorize(ctx context.Context, p *domain.Payment) error {
+	result, err := s.provider.Authorize(ctx, ProviderRequest{
+		PaymentID:       p.ID().String(),
+		CustomerID:      p.CustomerID(),
+		AmountCents:     p.Amount().Amount(),
+		Currency:        p.Amount().Currency(),
+		ClientReference: p.ClientReference(),
+		IdempotencyKey:  p.ID().String(),
+		Capture:         p.Status() != domain.StatusAuthorized,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: authorize: %w", ErrProviderUnavailable, err)
+	}
+
+	// the aggregate is only changed once the save can follow
+	next := *p
+	switch {
+	case result.Declined:
+		err = next.Fail(declineReason(result))
+	case next.Status() == domain.StatusAuthorized:
+		err = next.Authorize(result.ProviderRef)
+	default:
+		err = next.MarkProcessing(result.ProviderRef)
+	}
 	if err != nil {
-		return InitiatePaymentResponse{}, fmt.Errorf("invalid amount: %w", err)
+		return err
+	}
+
+	if err := s.repo.Save(ctx, &next); err != nil {
+		s.log.ErrorContext(ctx, "gateway answered but the payment was not saved",
+			"payment_id", p.ID().String(),
+			"provider_ref", result.ProviderRef,
+			"declined", result.Declined,
+			"err", err,
+		)
+		return fmt.Errorf("save authorization: %w", err)
 	}
+	s.metrics.transitioned(p.Status(), next.Status())
+	*p = next
 
-	payment, err := domain.New(req.OrderID, req.CustomerID, amount, req.IdempotencyKey)
+	if result.Declined {
+		s.log.InfoContext(ctx, "payment declined",
+			"payment_id", p.ID().String(),
+			"correlation_id", p.CorrelationID(),
+			"reason", result.DeclineReason,
+		)
+	}
+	return nil
+}
+
+// declineReason falls back to a generic reason for gateways that give none
+func declineReason(r ProviderResult) string {
+	if r.DeclineReason == "" {
+		return "declined"
+	}
+	return r.DeclineReason
+}
+
+// GetPayment shares one repository load between concurrent callers asking for
+// the same payment as the same principal
+func (s *PaymentService) GetPayment(ctx context.Context, paymentID string) (PaymentDetails, error) {
+	id, err := domain.ParsePaymentID(paymentID)
 	if err != nil {
-		return InitiatePaymentResponse{}, fmt.Errorf("create payment: %w", err)
+		return PaymentDetails{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	// the principal is part of the key so callers never share across tenants
+	principal := "anonymous"
+	if p, ok := PrincipalFromContext(ctx); ok {
+		principal = p.ID
 	}
 
-	if err := s.repo.Save(payment); err != nil {
-		return InitiatePaymentResponse{}, fmt.Errorf("save payment: %w", err)
+	// the load outlives a caller that hangs up, the others may still be waiting
+	loadCtx := context.WithoutCancel(ctx)
+	ch := s.reads.DoChan(principal+"/"+id.String(), func() (any, error) {
+		return s.repo.FindByID(loadCtx, id)
+	})
+
+	select {
+	case <-ctx.Done():
+		return PaymentDetails{}, ctx.Err()
+	case res := <-ch:
+		if res.Shared {
+			coalescedReadsTotal.Inc()
+		}
+		if res.Err != nil {
+			return PaymentDetails{}, fmt.Errorf("find payment: %w", res.Err)
+		}
+		return toPaymentDetails(res.Val.(*domain.Payment)), nil
 	}
+}
 
-	for _, evt := range payment.PopEvents() {
-		payload, err := json.Marshal(evt)
+// GetPaymentFresh always loads from the repository, for callers that must not
+// be handed a result another request is already waiting on
+func (s *PaymentService) GetPaymentFresh(ctx context.Context, paymentID string) (PaymentDetails, error) {
+	id, err := domain.ParsePaymentID(paymentID)
+	if err != nil {
+		return PaymentDetails{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	p, err := s.repo.FindByID(domain.WithConsistentRead(ctx), id)
+	if err != nil {
+		return PaymentDetails{}, fmt.Errorf("find payment: %w", err)
+	}
+	return toPaymentDetails(p), nil
+}
+
+// FindByCorrelationID lists every payment sharing a correlation id
+func (s *PaymentService) FindByCorrelationID(ctx context.Context, correlationID string) ([]PaymentDetails, error) {
+	if correlationID == "" {
+		return nil, fmt.Errorf("%w: correlation_id is required", ErrInvalidRequest)
+	}
+
+	payments, err := s.repo.FindByCorrelationID(ctx, correlationID)
+	if err != nil {
+		return nil, fmt.Errorf("find payments by correlation id: %w", err)
+	}
+
+	details := make([]PaymentDetails, 0, len(payments))
+	for _, p := range payments {
+		details = append(details, toPaymentDetails(p))
+	}
+	return details, nil
+}
+
+// FindByOrderID lists every attempt at paying an order, newest first. An
+// unknown order is an empty list.
+func (s *PaymentService) FindByOrderID(ctx context.Context, orderID string) ([]PaymentDetails, error) {
+	if orderID == "" {
+		return nil, fmt.Errorf("%w: order_id is required", ErrInvalidRequest)
+	}
+
+	payments, err := s.repo.FindByOrderID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("find payments by order id: %w", err)
+	}
+
+	details := make([]PaymentDetails, 0, len(payments))
+	for _, p := range payments {
+		details = append(details, toPaymentDetails(p))
+	}
+	return details, nil
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+type ListPaymentsRequest struct {
+	CustomerID      string
+	Status          string
+	Currency        string
+	ClientReference string
+	CreatedAfter    time.Time
+	CreatedBefore   time.Time
+	// Cursor is the NextCursor of the previous page, empty for the first
+	Cursor string
+	// zero means the default page size, larger values are capped
+	Limit int
+}
+
+type ListPaymentsResponse struct {
+	Payments []PaymentDetails
+	// empty on the last page
+	NextCursor string
+}
+
+// ListPayments pages through payments newest first. A cursor that was not
+// issued by a previous page is domain.ErrInvalidCursor.
+func (s *PaymentService) ListPayments(ctx context.Context, req ListPaymentsRequest) (ListPaymentsResponse, error) {
+	f := domain.ListFilter{
+		CustomerID:      req.CustomerID,
+		Currency:        strings.ToUpper(strings.TrimSpace(req.Currency)),
+		ClientReference: req.ClientReference,
+		CreatedAfter:    req.CreatedAfter,
+		CreatedBefore:   req.CreatedBefore,
+		Limit:           min(req.Limit, maxListLimit),
+	}
+	if f.Limit <= 0 {
+		f.Limit = defaultListLimit
+	}
+
+	if req.Status != "" {
+		status, err := domain.ParsePaymentStatus(strings.ToUpper(req.Status))
 		if err != nil {
-			s.log.ErrorContext(ctx, "marshal event", "event_type", domain.EventType(evt), "err", err)
-			continue
+			return ListPaymentsResponse{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
 		}
-		if err := s.outbox.Write(ctx, payment.ID().String(), domain.EventType(evt), payload); err != nil {
-			s.log.ErrorContext(ctx, "write event", "event_type", domain.EventType(evt), "err", err)
+		f.Status = status
+	}
+	if f.Currency != "" && len(f.Currency) != 3 {
+		return ListPaymentsResponse{}, fmt.Errorf("%w: currency must be a 3-letter code, got %q", ErrInvalidRequest, req.Currency)
+	}
+	if err := domain.ValidateClientReference(f.ClientReference); err != nil {
+		return ListPaymentsResponse{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() && !f.CreatedAfter.Before(f.CreatedBefore) {
+		return ListPaymentsResponse{}, fmt.Errorf("%w: created_after must be before created_before", ErrInvalidRequest)
+	}
+
+	cursor, err := domain.DecodeCursor(req.Cursor)
+	if err != nil {
+		return ListPaymentsResponse{}, err
+	}
+	f.After = cursor
+
+	payments, next, err := s.repo.List(ctx, f)
+	if err != nil {
+		return ListPaymentsResponse{}, fmt.Errorf("list payments: %w", err)
+	}
+
+	resp := ListPaymentsResponse{Payments: make([]PaymentDetails, 0, len(payments)), NextCursor: next}
+	for _, p := range payments {
+		resp.Payments = append(resp.Payments, toPaymentDetails(p))
+	}
+	return resp, nil
+}
+
+func toPaymentDetails(p *domain.Payment) PaymentDetails {
+	return PaymentDetails{
+		PaymentID:       p.ID().String(),
+		OrderID:         p.OrderID(),
+		CustomerID:      p.CustomerID(),
+		AmountCents:     p.Amount().Amount(),
+		Currency:        p.Amount().Currency(),
+		Status:          string(p.Status()),
+		ProviderRef:     p.ProviderRef(),
+		FailureReason:   p.FailureReason(),
+		ClientReference: p.ClientReference(),
+		CorrelationID:   p.CorrelationID(),
+		Metadata:        p.Metadata(),
+		CreatedAt:       p.CreatedAt(),
+		UpdatedAt:       p.UpdatedAt(),
+		Version:         p.Version(),
+	}
+}
+
+type CapturePaymentResponse struct {
+	PaymentID string
+	Status    string
+}
+
+// CapturePayment takes the funds of a manual-capture payment. A retry with the
+// same idempotency key replays the earlier result, a different key is
+// domain.ErrAlreadyCaptured. A non-zero expectedVersion fails the capture
+// with a domain.VersionConflictError once the payment has moved on.
+//
+// The capture is saved before the gateway is asked, so a payment the gateway
+// captured is never left authorized by a failed save, and a transition that
+// wins the race is seen before any funds move. A cancel that slips in after
+// the save is refunded once the gateway reports the capture. When the gateway
+// does not answer the capture stays saved and a retry with the same key asks
+// again.
+func (s *PaymentService) CapturePayment(ctx context.Context, paymentID, idempotencyKey string, expectedVersion int) (CapturePaymentResponse, error) {
+	id, err := domain.ParsePaymentID(paymentID)
+	if err != nil {
+		return CapturePaymentResponse{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	ctx = logging.WithPaymentID(ctx, id.String())
+	if idempotencyKey == "" {
+		return CapturePaymentResponse{}, fmt.Errorf("%w: idempotency_key is required (use the Idempotency-Key header)", ErrInvalidRequest)
+	}
+
+	defer s.lockPayment(ctx, id)()
+
+	var (
+		p        *domain.Payment
+		replayed bool
+	)
+	err = retryOnConflict(ctx, "capture", func(ctx context.Context) error {
+		p, err = s.repo.FindByID(domain.WithConsistentRead(ctx), id)
+		if err != nil {
+			return fmt.Errorf("find payment: %w", err)
+		}
+		// also a concurrent retry of this same capture that won the race
+		if p.CaptureKey() == idempotencyKey {
+			replayed = true
+			return nil
+		}
+		if err := p.CheckVersion(expectedVersion); err != nil {
+			return err
+		}
+
+		from := p.Status()
+		if err := p.Capture(idempotencyKey); err != nil {
+			return err
+		}
+		if err := s.repo.Save(ctx, p); err != nil {
+			return fmt.Errorf("save payment: %w", err)
 		}
+		s.metrics.transitioned(from, p.Status())
+		return nil
+	})
+	if err != nil {
+		return CapturePaymentResponse{}, err
 	}
 
-	// cache result
-	resp := InitiatePaymentResponse{
-		PaymentID: payment.ID().String(),
-		Status:    string(payment.Status()),
+	// payments authorized before the gateway was wired have nothing to capture
+	// there, a replay asks again since the last answer may have been lost
+	if p.Status() == domain.StatusProcessing && p.ProviderRef() != "" {
+		if err := s.captureAtGateway(ctx, p); err != nil {
+			return CapturePaymentResponse{}, err
+		}
+	}
+	if replayed {
+		return captureResponse(p), nil
 	}
-	s.cache(ctx, req.IdempotencyKey, resp)
 
-	s.log.InfoContext(ctx, "payment initiated",
-		"payment_id", payment.ID().String(),
-		"order_id", req.OrderID,
-		"customer_id", req.CustomerID,
-		"amount", amount.String(),
+	s.log.InfoContext(ctx, "payment captured",
+		"payment_id", p.ID().String(),
+		"correlation_id", p.CorrelationID(),
+		"amount", p.Amount().String(),
 	)
+	return captureResponse(p), nil
+}
+
+// captureAtGateway takes the funds of a payment whose capture is saved. The
+// gateway capture is keyed by payment, asking twice captures once. A decline
+// fails the payment since its hold cannot be captured.
+func (s *PaymentService) captureAtGateway(ctx context.Context, p *domain.Payment) error {
+	result, err := s.provider.Capture(ctx, ProviderCaptureRequest{
+		ProviderRef:    p.ProviderRef(),
+		AmountCents:    p.Amount().Amount(),
+		Currency:       p.Amount().Currency(),
+		IdempotencyKey: p.ID().String(),
+	})
+	if err != nil {
+		s.log.WarnContext(ctx, "capture saved but the gateway did not answer",
+			"payment_id", p.ID().String(),
+			"provider_ref", p.ProviderRef(),
+			"err", err,
+		)
+		return fmt.Errorf("%w: capture: %w", ErrProviderUnavailable, err)
+	}
+	if !result.Declined {
+		return nil
+	}
+
+	reason := declineReason(result)
+	captureKey := p.CaptureKey()
+	err = retryOnConflict(ctx, "capture_declined", func(ctx context.Context) error {
+		current, err := s.repo.FindByID(domain.WithConsistentRead(ctx), p.ID())
+		if err != nil {
+			return fmt.Errorf("find payment: %w", err)
+		}
+		// the gateway's webhook may have failed it first
+		if current.Status() != domain.StatusProcessing || current.CaptureKey() != captureKey {
+			return nil
+		}
+
+		if err := current.Fail(reason); err != nil {
+			return err
+		}
+		if err := s.repo.Save(ctx, current); err != nil {
+			return fmt.Errorf("save payment: %w", err)
+		}
+		s.metrics.transitioned(domain.StatusProcessing, current.Status())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("fail declined capture: %w", err)
+	}
+	return fmt.Errorf("%w: %s", ErrProviderDeclined, reason)
+}
+
+func captureResponse(p *domain.Payment) CapturePaymentResponse {
+	return CapturePaymentResponse{PaymentID: p.ID().String(), Status: string(p.Status())}
+}
+
+// default reason recorded when the caller gives none
+const defaultCancelReason = "cancelled_by_merchant"
+
+type CancelPaymentResponse struct {
+	PaymentID string
+	Status    string
+}
+
+// CancelPayment abandons a pending, authorized or processing payment. The
+// payment.cancelled event is written in the same transaction as the status.
+// expectedVersion works as in CapturePayment.
+func (s *PaymentService) CancelPayment(ctx context.Context, paymentID, reason string, expectedVersion int) (CancelPaymentResponse, error) {
+	id, err := domain.ParsePaymentID(paymentID)
+	if err != nil {
+		return CancelPaymentResponse{}, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	ctx = logging.WithPaymentID(ctx, id.String())
+	if len(reason) > 255 {
+		return CancelPaymentResponse{}, fmt.Errorf("%w: reason must be at most 255 characters", ErrInvalidRequest)
+	}
+	if reason == "" {
+		reason = defaultCancelReason
+	}
+
+	defer s.lockPayment(ctx, id)()
+
+	// a concurrent transition is retried against the reloaded payment
+	var p *domain.Payment
+	err = retryOnConflict(ctx, "cancel", func(ctx context.Context) error {
+		p, err = s.repo.FindByID(domain.WithConsistentRead(ctx), id)
+		if err != nil {
+			return fmt.Errorf("find payment: %w", err)
+		}
+		if err := p.CheckVersion(expectedVersion); err != nil {
+			return err
+		}
+		from := p.Status()
+		if err := p.Cancel(reason); err != nil {
+			return err
+		}
+		if err := s.repo.Save(ctx, p); err != nil {
+			return fmt.Errorf("save payment: %w", err)
+		}
+		s.metrics.transitioned(from, p.Status())
+		return nil
+	})
+	if err != nil {
+		return CancelPaymentResponse{}, err
+	}
+
+	s.log.InfoContext(ctx, "payment cancelled",
+		"payment_id", p.ID().String(),
+		"correlation_id", p.CorrelationID(),
+		"reason", reason,
+	)
+	return CancelPaymentResponse{PaymentID: p.ID().String(), Status: string(p.Status())}, nil
+}
+
+// DryRunResponse describes what InitiatePayment would do without doing it
+type DryRunResponse struct {
+	// Normalized is the request as the domain would store it. Its
+	// CorrelationID is the one a real request would store, empty when it
+	// would generate one.
+	Normalized InitiatePaymentRequest
+	Warnings   []string
+}
+
+// DryRunInitiatePayment runs the same construction path as InitiatePayment
+// but never writes to postgres, redis or the outbox
+func (s *PaymentService) DryRunInitiatePayment(ctx context.Context, req InitiatePaymentRequest) (DryRunResponse, error) {
+	payment, err := newPayment(merchantOf(ctx), req, s.limits)
+	if err != nil {
+		return DryRunResponse{}, err
+	}
+
+	resp := DryRunResponse{
+		Normalized: InitiatePaymentRequest{
+			OrderID:         payment.OrderID(),
+			CustomerID:      payment.CustomerID(),
+			AmountCents:     payment.Amount().Amount(),
+			Currency:        payment.Amount().Currency(),
+			IdempotencyKey:  payment.IdempotencyKey(),
+			ClientReference: payment.ClientReference(),
+			// not the payment's, it generated its own for an empty one
+			CorrelationID: req.CorrelationID,
+			CaptureMethod: req.CaptureMethod,
+			Metadata:      payment.Metadata(),
+		},
+		Warnings: []string{},
+	}
+
+	// duplicate-order heuristic, a real request would still go ahead
+	attempts, err := s.repo.FindByOrderID(ctx, payment.OrderID())
+	if err != nil {
+		return DryRunResponse{}, fmt.Errorf("order lookup: %w", err)
+	}
+	for _, a := range attempts {
+		// the idempotency warnings below cover the payment a replay returns
+		if a.IdempotencyKey() == payment.IdempotencyKey() {
+			continue
+		}
+		switch a.Status() {
+		case domain.StatusFailed, domain.StatusCancelled, domain.StatusExpired:
+			// a retry after a dead attempt is the expected case
+			continue
+		}
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf(
+			"order already has payment %s in status %s, a real request would start another attempt", a.ID(), a.Status()))
+	}
+
+	// read-only idempotency check, cache first then the database
+	key := domain.ScopedIdempotencyKey(payment.MerchantID(), req.IdempotencyKey)
+	if cached, ok, err := s.idempotent.Get(ctx, key); err == nil && ok {
+		var entry cachedInitiation
+		if json.Unmarshal([]byte(cached), &entry) == nil && !s.sameRequest(entry.RequestHash, entry.ClientReference, payment) {
+			resp.Warnings = append(resp.Warnings, "idempotency key previously used with a different request, a real request would be rejected")
+		} else {
+			resp.Warnings = append(resp.Warnings, "idempotency key previously used, a real request would replay the earlier response")
+			if entry.CorrelationID != "" {
+				resp.Normalized.CorrelationID = entry.CorrelationID
+			}
+		}
+		return resp, nil
+	}
+
+	existing, err := s.repo.FindByIdempotencyKey(ctx, payment.MerchantID(), req.IdempotencyKey)
+	if err != nil {
+		return DryRunResponse{}, fmt.Errorf("idempotency key lookup: %w", err)
+	}
+	if existing != nil && !s.sameRequest(existing.RequestHash(), existing.ClientReference(), payment) {
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf(
+			"idempotency key previously used by payment %s with a different request, a real request would be rejected", existing.ID()))
+	} else if existing != nil {
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf(
+			"idempotency key previously used by payment %s, a real request would replay it", existing.ID()))
+		resp.Normalized.CorrelationID = existing.CorrelationID()
+	}
 
 	return resp, nil
 }
 
-func (s *PaymentService) cache(ctx context.Context, key string, resp InitiatePaymentResponse) {
-	data, err := json.Marshal(resp)
+// ValidateInitiatePayment reports every field InitiatePayment would reject
+// req for, under the service's limits
+func (s *PaymentService) ValidateInitiatePayment(req InitiatePaymentRequest) error {
+	return req.Normalize().Validate(s.limits)
+}
+
+// newPayment is the single construction path shared by real and dry-run requests
+func newPayment(merchantID string, req InitiatePaymentRequest, limits RequestLimits) (*domain.Payment, error) {
+	req = req.Normalize()
+	if err := req.Validate(limits); err != nil {
+		return nil, err
+	}
+
+	amount, err := domain.NewMoney(req.AmountCents, req.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid amount: %w", ErrInvalidRequest, err)
+	}
+
+	capture, err := domain.ParseCaptureMethod(req.CaptureMethod)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	payment, err := domain.New(merchantID, req.OrderID, req.CustomerID, amount, req.IdempotencyKey, req.ClientReference, req.CorrelationID, capture, req.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("%w: create payment: %w", ErrInvalidRequest, err)
+	}
+	return payment, nil
+}
+
+// cachedInitiation is the cached replay of InitiatePayment, entries written
+// before the request hash was cached decode with an empty one
+type cachedInitiation struct {
+	InitiatePaymentResponse
+	RequestHash     string `json:",omitempty"`
+	ClientReference string `json:",omitempty"`
+}
+
+// sameRequest reports whether incoming repeats the request stored under its
+// key. An unknown stored hash is a match, payments created before hashes were
+// recorded cannot be checked. With a non-semantic client reference the
+// incoming request is fingerprinted again with the stored reference.
+func (s *PaymentService) sameRequest(storedHash, storedRef string, incoming *domain.Payment) bool {
+	if storedHash == "" || storedHash == incoming.RequestHash() {
+		return true
+	}
+	if !s.limits.ClientReferenceNonSemantic {
+		return false
+	}
+	// incoming is freshly built, only manual capture starts it AUTHORIZED
+	capture := domain.CaptureAutomatic
+	if incoming.Status() == domain.StatusAuthorized {
+		capture = domain.CaptureManual
+	}
+	return storedHash == domain.RequestHash(incoming.OrderID(), incoming.CustomerID(), incoming.Amount(), storedRef, capture)
+}
+
+// cache stores resp for key with the fingerprint of p, the payment it answers for
+func (s *PaymentService) cache(ctx context.Context, key string, p *domain.Payment, resp InitiatePaymentResponse) {
+	data, err := json.Marshal(cachedInitiation{
+		InitiatePaymentResponse: resp,
+		RequestHash:             p.RequestHash(),
+		ClientReference:         p.ClientReference(),
+	})
 	if err != nil {
 		s.log.WarnContext(ctx, "cannot marshal idempotency response for caching", "err", err)
 		return
 	}
-	if err := s.idempotent.Set(ctx, key, string(data), idempotencyTTL); err != nil {
+	if err := s.idempotent.Set(ctx, key, string(data), s.idempotencyTTL); err != nil {
 		s.log.WarnContext(ctx, "failed to cache idempotency response", "err", err)
 	}
 }
@@ -0,0 +1,295 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+var settlementItemsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gopay_service",
+	Subsystem: "settlement",
+	Name:      "items_total",
+	Help:      "Settlement file rows ingested, partitioned by provider and match status.",
+}, []string{"provider", "status"})
+
+const (
+	SettlementIngesting = "INGESTING"
+	SettlementCompleted = "COMPLETED"
+	SettlementFailed    = "FAILED"
+)
+
+const (
+	ItemMatched        = "MATCHED"
+	ItemAmountMismatch = "AMOUNT_MISMATCH"
+	ItemUnknownRef     = "UNKNOWN_REF"
+)
+
+// rows matched and stored per round trip
+const settlementChunkSize = 500
+
+// SettlementRow is one line of a provider settlement file
+type SettlementRow struct {
+	Line        int
+	ProviderRef string
+	AmountCents int64
+	Currency    string
+}
+
+// SettlementItem is a row with its match outcome, PaymentID is empty for unknown refs
+type SettlementItem struct {
+	SettlementRow
+	PaymentID string
+	Status    string
+}
+
+// SettlementBatch is one ingested file and its match summary
+type SettlementBatch struct {
+	ID             string
+	Provider       string
+	FileHash       string
+	Status         string
+	Rows           int
+	Matched        int
+	AmountMismatch int
+	UnknownRef     int
+	LastError      string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// SettlementParser streams the rows of one provider's file format to fn
+type SettlementParser interface {
+	Parse(r io.Reader, fn func(SettlementRow) error) error
+}
+
+type SettlementStore interface {
+	// CreateSettlementBatch returns the existing batch and false when the
+	// provider already uploaded a file with this hash
+	CreateSettlementBatch(ctx context.Context, provider, fileHash string) (SettlementBatch, bool, error)
+	// GetSettlementBatch returns domain.ErrNotFound for unknown ids
+	GetSettlementBatch(ctx context.Context, id string) (SettlementBatch, error)
+	// FindByProviderRefs returns the payments carrying any of refs, keyed by ref
+	FindByProviderRefs(ctx context.Context, refs []string) (map[string]*domain.Payment, error)
+	// InsertSettlementItems skips lines already stored, so a retried file resumes
+	InsertSettlementItems(ctx context.Context, batchID string, items []SettlementItem) error
+	// CompleteSettlementBatch totals the items and writes the settlement.ingested
+	// event in one transaction
+	CompleteSettlementBatch(ctx context.Context, batchID string) (SettlementBatch, error)
+	FailSettlementBatch(ctx context.Context, batchID, lastError string) error
+}
+
+// SettlementService matches provider settlement files against payments
+type SettlementService struct {
+	store   SettlementStore
+	parsers map[string]SettlementParser
+	log     *slog.Logger
+}
+
+// NewSettlementService takes one parser per provider name
+func NewSettlementService(store SettlementStore, parsers map[string]SettlementParser, log *slog.Logger) *SettlementService {
+	return &SettlementService{store: store, parsers: parsers, log: log}
+}
+
+// Ingest spools the file to disk while hashing it, so a re-upload of the same
+// file returns the earlier batch and large files are never held in memory
+func (s *SettlementService) Ingest(ctx context.Context, provider string, file io.Reader) (SettlementBatch, error) {
+	parser, ok := s.parsers[provider]
+	if !ok {
+		return SettlementBatch{}, fmt.Errorf("%w: unknown settlement provider %q", ErrInvalidRequest, provider)
+	}
+
+	spool, err := os.CreateTemp("", "settlement-*.csv")
+	if err != nil {
+		return SettlementBatch{}, fmt.Errorf("spool settlement file: %w", err)
+	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(spool, hash), file); err != nil {
+		return SettlementBatch{}, fmt.Errorf("spool settlement file: %w", err)
+	}
+
+	batch, created, err := s.store.CreateSettlementBatch(ctx, provider, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return SettlementBatch{}, fmt.Errorf("create settlement batch: %w", err)
+	}
+	if !created && batch.Status == SettlementCompleted {
+		s.log.InfoContext(ctx, "settlement file already ingested", "batch_id", batch.ID, "provider", provider)
+		return batch, nil
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return SettlementBatch{}, fmt.Errorf("rewind settlement file: %w", err)
+	}
+
+	if err := s.match(ctx, batch.ID, parser, spool); err != nil {
+		if failErr := s.store.FailSettlementBatch(context.WithoutCancel(ctx), batch.ID, err.Error()); failErr != nil {
+			s.log.ErrorContext(ctx, "cannot mark settlement batch failed", "batch_id", batch.ID, "err", failErr)
+		}
+		return SettlementBatch{}, err
+	}
+
+	batch, err = s.store.CompleteSettlementBatch(ctx, batch.ID)
+	if err != nil {
+		return SettlementBatch{}, fmt.Errorf("complete settlement batch: %w", err)
+	}
+
+	settlementItemsTotal.WithLabelValues(provider, ItemMatched).Add(float64(batch.Matched))
+	settlementItemsTotal.WithLabelValues(provider, ItemAmountMismatch).Add(float64(batch.AmountMismatch))
+	settlementItemsTotal.WithLabelValues(provider, ItemUnknownRef).Add(float64(batch.UnknownRef))
+
+	s.log.InfoContext(ctx, "settlement file ingested",
+		"batch_id", batch.ID,
+		"provider", provider,
+		"rows", batch.Rows,
+		"matched", batch.Matched,
+		"amount_mismatch", batch.AmountMismatch,
+		"unknown_ref", batch.UnknownRef,
+	)
+	return batch, nil
+}
+
+func (s *SettlementService) Get(ctx context.Context, id string) (SettlementBatch, error) {
+	return s.store.GetSettlementBatch(ctx, id)
+}
+
+// match parses the file in chunks, matching each chunk with one lookup
+func (s *SettlementService) match(ctx context.Context, batchID string, parser SettlementParser, file io.Reader) error {
+	chunk := make([]SettlementRow, 0, settlementChunkSize)
+
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		refs := make([]string, 0, len(chunk))
+		for _, row := range chunk {
+			refs = append(refs, row.ProviderRef)
+		}
+
+		payments, err := s.store.FindByProviderRefs(ctx, refs)
+		if err != nil {
+			return fmt.Errorf("match settlement rows: %w", err)
+		}
+
+		items := make([]SettlementItem, 0, len(chunk))
+		for _, row := range chunk {
+			items = append(items, matchRow(row, payments[row.ProviderRef]))
+		}
+		if err := s.store.InsertSettlementItems(ctx, batchID, items); err != nil {
+			return fmt.Errorf("store settlement items: %w", err)
+		}
+
+		chunk = chunk[:0]
+		return nil
+	}
+
+	err := parser.Parse(file, func(row SettlementRow) error {
+		chunk = append(chunk, row)
+		if len(chunk) < settlementChunkSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+func matchRow(row SettlementRow, p *domain.Payment) SettlementItem {
+	item := SettlementItem{SettlementRow: row}
+	switch {
+	case p == nil:
+		item.Status = ItemUnknownRef
+	case p.Amount().Amount() != row.AmountCents || p.Amount().Currency() != row.Currency:
+		item.PaymentID, item.Status = p.ID().String(), ItemAmountMismatch
+	default:
+		item.PaymentID, item.Status = p.ID().String(), ItemMatched
+	}
+	return item
+}
+
+// CSVSettlementParser reads a headered CSV, columns are looked up by name so
+// providers that add or reorder columns only need different names
+type CSVSettlementParser struct {
+	RefColumn      string
+	AmountColumn   string
+	CurrencyColumn string
+}
+
+// NewGenericSettlementParser reads provider_ref, amount_cents and currency columns
+func NewGenericSettlementParser() CSVSettlementParser {
+	return CSVSettlementParser{
+		RefColumn:      "provider_ref",
+		AmountColumn:   "amount_cents",
+		CurrencyColumn: "currency",
+	}
+}
+
+func (p CSVSettlementParser) Parse(r io.Reader, fn func(SettlementRow) error) error {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("%w: read settlement header: %w", ErrInvalidRequest, err)
+	}
+
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	refIdx, okRef := cols[strings.ToLower(p.RefColumn)]
+	amountIdx, okAmount := cols[strings.ToLower(p.AmountColumn)]
+	currencyIdx, okCurrency := cols[strings.ToLower(p.CurrencyColumn)]
+	if !okRef || !okAmount || !okCurrency {
+		return fmt.Errorf("%w: settlement header must contain %s, %s and %s",
+			ErrInvalidRequest, p.RefColumn, p.AmountColumn, p.CurrencyColumn)
+	}
+
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: settlement line %d: %w", ErrInvalidRequest, line, err)
+		}
+
+		amount, err := strconv.ParseInt(strings.TrimSpace(record[amountIdx]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: settlement line %d: invalid amount %q", ErrInvalidRequest, line, record[amountIdx])
+		}
+
+		row := SettlementRow{
+			Line:        line,
+			ProviderRef: strings.TrimSpace(record[refIdx]),
+			AmountCents: amount,
+			Currency:    strings.ToUpper(strings.TrimSpace(record[currencyIdx])),
+		}
+		if row.ProviderRef == "" {
+			return fmt.Errorf("%w: settlement line %d: empty provider reference", ErrInvalidRequest, line)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
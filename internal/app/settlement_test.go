@@ -0,0 +1,217 @@
+package app_test
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/domain/domaintest"
+)
+
+// settlementStore keeps batches and items in memory, matching against a
+// fixed set of payments
+type settlementStore struct {
+	mu       sync.Mutex
+	payments map[string]*domain.Payment
+	batches  map[string]app.SettlementBatch
+	items    map[string][]app.SettlementItem
+}
+
+func newSettlementStore(payments ...*domain.Payment) *settlementStore {
+	s := &settlementStore{
+		payments: map[string]*domain.Payment{},
+		batches:  map[string]app.SettlementBatch{},
+		items:    map[string][]app.SettlementItem{},
+	}
+	for _, p := range payments {
+		s.payments[p.ProviderRef()] = p
+	}
+	return s
+}
+
+func (s *settlementStore) CreateSettlementBatch(_ context.Context, provider, fileHash string) (app.SettlementBatch, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.batches {
+		if b.Provider == provider && b.FileHash == fileHash {
+			return b, false, nil
+		}
+	}
+	b := app.SettlementBatch{ID: fileHash[:12], Provider: provider, FileHash: fileHash, Status: app.SettlementIngesting}
+	s.batches[b.ID] = b
+	return b, true, nil
+}
+
+func (s *settlementStore) GetSettlementBatch(_ context.Context, id string) (app.SettlementBatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.batches[id]
+	if !ok {
+		return app.SettlementBatch{}, domain.ErrNotFound
+	}
+	return b, nil
+}
+
+func (s *settlementStore) FindByProviderRefs(_ context.Context, refs []string) (map[string]*domain.Payment, error) {
+	found := map[string]*domain.Payment{}
+	for _, ref := range refs {
+		if p, ok := s.payments[ref]; ok {
+			found[ref] = p
+		}
+	}
+	return found, nil
+}
+
+func (s *settlementStore) InsertSettlementItems(_ context.Context, batchID string, items []app.SettlementItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[batchID] = append(s.items[batchID], items...)
+	return nil
+}
+
+func (s *settlementStore) CompleteSettlementBatch(_ context.Context, batchID string) (app.SettlementBatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.batches[batchID]
+	b.Status, b.Rows = app.SettlementCompleted, len(s.items[batchID])
+	for _, item := range s.items[batchID] {
+		switch item.Status {
+		case app.ItemMatched:
+			b.Matched++
+		case app.ItemAmountMismatch:
+			b.AmountMismatch++
+		case app.ItemUnknownRef:
+			b.UnknownRef++
+		}
+	}
+	s.batches[batchID] = b
+	return b, nil
+}
+
+func (s *settlementStore) FailSettlementBatch(_ context.Context, batchID, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.batches[batchID]
+	b.Status, b.LastError = app.SettlementFailed, lastError
+	s.batches[batchID] = b
+	return nil
+}
+
+func settled(id, ref string, cents int64, currency string) *domain.Payment {
+	pid, err := domain.ParsePaymentID(id)
+	if err != nil {
+		panic(err)
+	}
+	return domaintest.NewPaymentBuilder().
+		WithID(pid).
+		WithProviderRef(ref).
+		WithAmount(cents, currency).
+		WithStatus(domain.StatusCompleted).
+		Build()
+}
+
+// every file in testdata/settlement holds one mismatch class, mixed.csv all
+// of them behind reordered and extra columns
+func TestSettlementClassification(t *testing.T) {
+	const (
+		eurID = "00000000-0000-4000-8000-0000000000e1"
+		usdID = "00000000-0000-4000-8000-0000000000e2"
+		jpyID = "00000000-0000-4000-8000-0000000000e3"
+	)
+	payments := []*domain.Payment{
+		settled(eurID, "prov_eur", 1999, "EUR"),
+		settled(usdID, "prov_usd", 4500, "USD"),
+		settled(jpyID, "prov_jpy", 120000, "JPY"),
+	}
+
+	type line struct {
+		ref       string
+		paymentID string
+		status    string
+	}
+	tests := []struct {
+		file  string
+		lines []line
+	}{
+		{file: "matched.csv", lines: []line{
+			{"prov_eur", eurID, app.ItemMatched},
+			{"prov_usd", usdID, app.ItemMatched},
+			{"prov_jpy", jpyID, app.ItemMatched},
+		}},
+		{file: "amount_mismatch.csv", lines: []line{
+			{"prov_eur", eurID, app.ItemAmountMismatch},
+			// the amount agrees, the currency does not
+			{"prov_usd", usdID, app.ItemAmountMismatch},
+			{"prov_jpy", jpyID, app.ItemAmountMismatch},
+		}},
+		{file: "unknown_ref.csv", lines: []line{
+			{"prov_missing", "", app.ItemUnknownRef},
+			{"mock_unknown", "", app.ItemUnknownRef},
+		}},
+		{file: "mixed.csv", lines: []line{
+			{"prov_eur", eurID, app.ItemMatched},
+			{"prov_usd", usdID, app.ItemAmountMismatch},
+			{"prov_gone", "", app.ItemUnknownRef},
+			{"prov_jpy", jpyID, app.ItemMatched},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", "settlement", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = f.Close() }()
+
+			store := newSettlementStore(payments...)
+			svc := app.NewSettlementService(store, map[string]app.SettlementParser{
+				"generic": app.NewGenericSettlementParser(),
+			}, slog.New(slog.DiscardHandler))
+
+			batch, err := svc.Ingest(context.Background(), "generic", f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []line
+			for _, item := range store.items[batch.ID] {
+				got = append(got, line{item.ProviderRef, item.PaymentID, item.Status})
+			}
+			if !slices.Equal(got, tt.lines) {
+				t.Fatalf("classified %v, want %v", got, tt.lines)
+			}
+			for i, item := range store.items[batch.ID] {
+				// line 1 is the header
+				if item.Line != i+2 {
+					t.Fatalf("item %d is line %d, want %d", i, item.Line, i+2)
+				}
+			}
+
+			want := map[string]int{}
+			for _, l := range tt.lines {
+				want[l.status]++
+			}
+			totals := map[string]int{}
+			for status, n := range map[string]int{
+				app.ItemMatched:        batch.Matched,
+				app.ItemAmountMismatch: batch.AmountMismatch,
+				app.ItemUnknownRef:     batch.UnknownRef,
+			} {
+				if n > 0 {
+					totals[status] = n
+				}
+			}
+			if batch.Status != app.SettlementCompleted || batch.Rows != len(tt.lines) || !maps.Equal(totals, want) {
+				t.Fatalf("batch = %+v, want COMPLETED with %d rows and totals %v", batch, len(tt.lines), want)
+			}
+		})
+	}
+}
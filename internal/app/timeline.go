@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// ProviderActor records transitions caused by provider events
+const ProviderActor = "provider"
+
+// TimelineEntry is one status transition of a payment
+type TimelineEntry struct {
+	// empty for the status the payment was created in
+	FromStatus string
+	ToStatus   string
+	Reason     string
+	// principal id, ProviderActor or domain.SystemActor
+	Actor      string
+	OccurredAt time.Time
+}
+
+// Timeline returns the recorded status transitions of a payment, oldest first
+func (s *PaymentService) Timeline(ctx context.Context, paymentID string) ([]TimelineEntry, error) {
+	id, err := domain.ParsePaymentID(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	// unknown payments are a 404 rather than an empty timeline
+	if _, err := s.repo.FindByID(ctx, id); err != nil {
+		return nil, fmt.Errorf("find payment: %w", err)
+	}
+
+	changes, err := s.repo.StatusHistory(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("status history: %w", err)
+	}
+
+	entries := make([]TimelineEntry, 0, len(changes))
+	for _, c := range changes {
+		entries = append(entries, TimelineEntry{
+			FromStatus: string(c.From),
+			ToStatus:   string(c.To),
+			Reason:     c.Reason,
+			Actor:      c.Actor,
+			OccurredAt: c.OccurredAt,
+		})
+	}
+	return entries, nil
+}
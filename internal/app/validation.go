@@ -0,0 +1,125 @@
+package app
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// maxIDLength matches the VARCHAR(255) id columns of the payments table
+const maxIDLength = 255
+
+// FieldError is one rejected field of a request
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError lists every rejected field of a request at once, it
+// matches ErrInvalidRequest
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+" "+f.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() error { return ErrInvalidRequest }
+
+func (e *ValidationError) add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// orNil keeps a typed nil out of error returns
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// RequestLimits bounds what InitiatePayment accepts beyond the domain rules
+type RequestLimits struct {
+	// restricts the domain currency registry, empty accepts every code in it
+	Currencies []string
+	// zero accepts any positive amount
+	MaxAmountCents int64
+	// ClientReferenceNonSemantic lets a retry under the same idempotency key
+	// carry a different client_reference, it replays the stored payment with
+	// its original reference. Stored fingerprints keep the reference, so
+	// turning this off again restores the strict check.
+	ClientReferenceNonSemantic bool
+}
+
+// Normalize trims the identifiers and upper-cases the currency, the form
+// Validate checks and the payment is stored in
+func (r InitiatePaymentRequest) Normalize() InitiatePaymentRequest {
+	r.OrderID = strings.TrimSpace(r.OrderID)
+	r.CustomerID = strings.TrimSpace(r.CustomerID)
+	r.Currency = strings.ToUpper(strings.TrimSpace(r.Currency))
+	return r
+}
+
+// Validate reports every problem of a normalized request as a
+// *ValidationError. domain.NewMoney and domain.New still check what they
+// need on their own.
+func (r InitiatePaymentRequest) Validate(limits RequestLimits) error {
+	verr := &ValidationError{}
+
+	requiredID := func(field, value string) {
+		switch {
+		case value == "":
+			verr.add(field, "is required")
+		case len(value) > maxIDLength:
+			verr.add(field, fmt.Sprintf("must be at most %d characters", maxIDLength))
+		}
+	}
+	requiredID("order_id", r.OrderID)
+	requiredID("customer_id", r.CustomerID)
+
+	switch {
+	case r.AmountCents <= 0:
+		verr.add("amount_cents", "must be a positive integer")
+	case limits.MaxAmountCents > 0 && r.AmountCents > limits.MaxAmountCents:
+		verr.add("amount_cents", fmt.Sprintf("must be at most %d", limits.MaxAmountCents))
+	}
+
+	switch {
+	case r.Currency == "":
+		verr.add("currency", "is required")
+	case !domain.IsCurrency(r.Currency):
+		verr.add("currency", fmt.Sprintf("%q is not an ISO 4217 currency", r.Currency))
+	case len(limits.Currencies) > 0 && !slices.Contains(limits.Currencies, r.Currency):
+		verr.add("currency", fmt.Sprintf("%q is not an accepted currency", r.Currency))
+	}
+
+	switch {
+	case r.IdempotencyKey == "":
+		verr.add("idempotency_key", "is required (use the Idempotency-Key header)")
+	case len(r.IdempotencyKey) > maxIDLength:
+		verr.add("idempotency_key", fmt.Sprintf("must be at most %d characters", maxIDLength))
+	}
+
+	if _, err := domain.ParseCaptureMethod(r.CaptureMethod); err != nil {
+		verr.add("capture_method", err.Error())
+	}
+	if err := domain.ValidateClientReference(r.ClientReference); err != nil {
+		verr.add("client_reference", err.Error())
+	}
+	if err := domain.ValidateMetadata(r.Metadata); err != nil {
+		verr.add("metadata", metadataMessage(err))
+	}
+	return verr.orNil()
+}
+
+// metadataMessage drops the sentinel's text, the field name already says it
+func metadataMessage(err error) string {
+	return strings.TrimPrefix(err.Error(), domain.ErrInvalidMetadata.Error()+": ")
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/logging"
+)
+
+var providerEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gopay_service",
+	Subsystem: "provider",
+	Name:      "webhook_events_total",
+	Help:      "Provider webhook events, partitioned by outcome.",
+}, []string{"outcome"})
+
+// ErrUnknownProviderRef is an event for a payment this service does not hold
+var ErrUnknownProviderRef = errors.New("unknown provider reference")
+
+type ProviderEventType string
+
+const (
+	ProviderPaymentSucceeded ProviderEventType = "payment.succeeded"
+	ProviderPaymentFailed    ProviderEventType = "payment.failed"
+)
+
+// ProviderEvent is a gateway's asynchronous verdict on a payment
+type ProviderEvent struct {
+	ID   string
+	Type ProviderEventType
+	// PaymentID is our id as sent with the authorization, it finds payments
+	// whose provider reference was never recorded
+	PaymentID     string
+	ProviderRef   string
+	FailureReason string
+}
+
+// ApplyProviderEvent completes or fails the payment the event refers to.
+// Redeliveries find the payment already in the target state and change
+// nothing. A success for a payment that already failed, was cancelled or
+// expired means the gateway took funds this service gave up on, they are
+// refunded. Other events that can no longer apply are logged and dropped
+// since retrying cannot help.
+func (s *PaymentService) ApplyProviderEvent(ctx context.Context, evt ProviderEvent) error {
+	ctx = domain.WithActor(ctx, ProviderActor)
+	target := domain.StatusCompleted
+	if evt.Type == ProviderPaymentFailed {
+		target = domain.StatusFailed
+	}
+
+	p, err := s.findProviderEventPayment(ctx, evt)
+	if errors.Is(err, domain.ErrNotFound) {
+		providerEventsTotal.WithLabelValues("unknown_ref").Inc()
+		return fmt.Errorf("%w: %s", ErrUnknownProviderRef, evt.ProviderRef)
+	}
+	if err != nil {
+		return fmt.Errorf("find payment: %w", err)
+	}
+	id := p.ID()
+	ctx = logging.WithPaymentID(ctx, id.String())
+
+	defer s.lockPayment(ctx, id)()
+
+	// a concurrent transition is retried, the reload then sees a redelivery
+	// as a duplicate and a conflicting transition as out of order
+	var outcome string
+	err = retryOnConflict(ctx, "provider_event", func(ctx context.Context) error {
+		var err error
+		p, err = s.repo.FindByID(domain.WithConsistentRead(ctx), id)
+		if err != nil {
+			return fmt.Errorf("find payment: %w", err)
+		}
+
+		if p.Status() == target {
+			outcome = "duplicate"
+			return nil
+		}
+
+		from := p.Status()
+		if evt.Type == ProviderPaymentFailed {
+			reason := evt.FailureReason
+			if reason == "" {
+				reason = "declined"
+			}
+			err = p.Fail(reason)
+		} else {
+			// the answer to the authorization was lost, the event carries it
+			if from == domain.StatusPending {
+				if err := p.MarkProcessing(evt.ProviderRef); err != nil {
+					return err
+				}
+				if err := s.repo.Save(ctx, p); err != nil {
+					return fmt.Errorf("save payment: %w", err)
+				}
+				s.metrics.transitioned(from, p.Status())
+				from = p.Status()
+			}
+			err = p.Complete()
+		}
+		if errors.Is(err, domain.ErrInvalidTransition) && evt.Type == ProviderPaymentSucceeded && abandoned(p.Status()) {
+			err := s.refundAbandoned(ctx, p, evt.ProviderRef)
+			switch {
+			case errors.Is(err, ErrProviderDeclined):
+				// redelivering the event cannot change the gateway's mind
+				outcome = "refund_declined"
+				return nil
+			case err != nil:
+				return err
+			}
+			outcome = "refunded"
+			return nil
+		}
+		if errors.Is(err, domain.ErrInvalidTransition) {
+			outcome = "out_of_order"
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.repo.Save(ctx, p); err != nil {
+			return fmt.Errorf("save payment: %w", err)
+		}
+		s.metrics.transitioned(from, p.Status())
+		outcome = "applied"
+		return nil
+	})
+	if outcome != "" {
+		providerEventsTotal.WithLabelValues(outcome).Inc()
+	}
+	if err != nil {
+		return err
+	}
+
+	switch outcome {
+	case "refunded":
+		s.log.WarnContext(ctx, "refunded a charge for an abandoned payment",
+			"event_id", evt.ID,
+			"payment_id", p.ID().String(),
+			"correlation_id", p.CorrelationID(),
+			"provider_ref", evt.ProviderRef,
+			"status", string(p.Status()),
+		)
+	case "refund_declined":
+		s.log.ErrorContext(ctx, "gateway declined refunding an abandoned payment, settle it by hand",
+			"event_id", evt.ID,
+			"payment_id", p.ID().String(),
+			"provider_ref", evt.ProviderRef,
+			"amount_cents", p.Amount().Amount(),
+			"status", string(p.Status()),
+		)
+	case "out_of_order":
+		s.log.WarnContext(ctx, "provider event does not apply to payment",
+			"event_id", evt.ID,
+			"event_type", string(evt.Type),
+			"payment_id", p.ID().String(),
+			"status", string(p.Status()),
+		)
+	case "applied":
+		s.log.InfoContext(ctx, "provider event applied",
+			"event_id", evt.ID,
+			"event_type", string(evt.Type),
+			"payment_id", p.ID().String(),
+			"correlation_id", p.CorrelationID(),
+			"status", string(p.Status()),
+		)
+	}
+	return nil
+}
+
+// findProviderEventPayment looks the payment up by provider reference and
+// falls back to our id for payments that never recorded one
+func (s *PaymentService) findProviderEventPayment(ctx context.Context, evt ProviderEvent) (*domain.Payment, error) {
+	p, err := s.repo.FindByProviderRef(ctx, evt.ProviderRef)
+	if !errors.Is(err, domain.ErrNotFound) || evt.PaymentID == "" {
+		return p, err
+	}
+
+	id, err := domain.ParsePaymentID(evt.PaymentID)
+	if err != nil {
+		return nil, domain.ErrNotFound
+	}
+	p, err = s.repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// a payment with another reference is not the one the gateway means
+	if p.ProviderRef() != "" {
+		return nil, domain.ErrNotFound
+	}
+	return p, nil
+}
+
+// abandoned statuses end a payment without taking its funds
+func abandoned(status domain.PaymentStatus) bool {
+	switch status {
+	case domain.StatusFailed, domain.StatusCancelled, domain.StatusExpired:
+		return true
+	default:
+		return false
+	}
+}
+
+// refundAbandoned gives back the funds the gateway took for a payment that
+// ended without them, typically one that expired or was cancelled while the
+// answer to its authorization was lost. The refund is keyed by payment so a
+// redelivered event does not refund twice.
+func (s *PaymentService) refundAbandoned(ctx context.Context, p *domain.Payment, providerRef string) error {
+	key := "abandoned-" + p.ID().String()
+	result, err := s.provider.Refund(ctx, ProviderRefundRequest{
+		ProviderRef:    providerRef,
+		RefundID:       key,
+		AmountCents:    p.Amount().Amount(),
+		Currency:       p.Amount().Currency(),
+		IdempotencyKey: key,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: refund abandoned payment: %w", ErrProviderUnavailable, err)
+	}
+	if result.Declined {
+		return fmt.Errorf("%w: %s", ErrProviderDeclined, declineReason(result))
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// recordingProvider is the mock gateway that keeps the refunds it is asked
+// for and grants them whatever the amount
+type recordingProvider struct {
+	*mockprovider.Provider
+
+	mu      sync.Mutex
+	refunds []app.ProviderRefundRequest
+}
+
+func (p *recordingProvider) Refund(_ context.Context, req app.ProviderRefundRequest) (app.ProviderResult, error) {
+	p.mu.Lock()
+	p.refunds = append(p.refunds, req)
+	p.mu.Unlock()
+	return app.ProviderResult{ProviderRef: "mock_re_" + req.RefundID}, nil
+}
+
+// failingSaves fails every save of a payment that is no longer pending
+type failingSaves struct {
+	domain.Repository
+}
+
+func (r failingSaves) Save(ctx context.Context, p *domain.Payment) error {
+	if p.Status() != domain.StatusPending {
+		return errors.New("connection reset")
+	}
+	return r.Repository.Save(ctx, p)
+}
+
+func newGatewayService(repo domain.Repository, provider app.PaymentProvider) *app.PaymentService {
+	kv := memory.NewKeyValueStore()
+	return app.NewPaymentService(repo, kv, time.Hour, kv, provider, testLimits, nil, slog.New(slog.DiscardHandler))
+}
+
+func succeeded(paymentID string) app.ProviderEvent {
+	return app.ProviderEvent{
+		ID:          "evt-1",
+		Type:        app.ProviderPaymentSucceeded,
+		PaymentID:   paymentID,
+		ProviderRef: "mock_" + paymentID,
+	}
+}
+
+// a payment whose authorization answer was lost is found by our id and
+// settled by the gateway's webhook
+func TestProviderEventSettlesALostAuthorization(t *testing.T) {
+	timedOut := validRequest()
+	timedOut.AmountCents = mockprovider.TimeoutAmount
+
+	tests := []struct {
+		name string
+		repo func(domain.Repository) domain.Repository
+		req  app.InitiatePaymentRequest
+	}{
+		{name: "gateway timed out", repo: func(r domain.Repository) domain.Repository { return r }, req: timedOut},
+		{name: "save after the charge failed", repo: func(r domain.Repository) domain.Repository { return failingSaves{r} }, req: validRequest()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := memory.NewRepository()
+			svc := newGatewayService(tt.repo(repo), mockprovider.New(time.Millisecond))
+
+			resp, err := svc.InitiatePayment(ctx, tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != string(domain.StatusPending) {
+				t.Fatalf("status = %s, want the payment left pending", resp.Status)
+			}
+
+			svc = newGatewayService(repo, mockprovider.New(time.Millisecond))
+			evt := succeeded(resp.PaymentID)
+			for range 2 {
+				if err := svc.ApplyProviderEvent(ctx, evt); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := svc.GetPayment(ctx, resp.PaymentID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != string(domain.StatusCompleted) || got.ProviderRef != evt.ProviderRef {
+				t.Fatalf("payment is %s with reference %q, want COMPLETED with %q", got.Status, got.ProviderRef, evt.ProviderRef)
+			}
+		})
+	}
+}
+
+func TestProviderEventForUnknownPayment(t *testing.T) {
+	ctx := context.Background()
+	svc := newGatewayService(memory.NewRepository(), mockprovider.New(time.Millisecond))
+
+	resp, err := svc.InitiatePayment(ctx, validRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		evt  app.ProviderEvent
+	}{
+		{name: "no payment id", evt: app.ProviderEvent{Type: app.ProviderPaymentSucceeded, ProviderRef: "pi_unknown"}},
+		{name: "payment id not ours", evt: succeeded(domain.NewPaymentID().String())},
+		{name: "payment id malformed", evt: app.ProviderEvent{Type: app.ProviderPaymentSucceeded, PaymentID: "nope", ProviderRef: "pi_unknown"}},
+		// the payment recorded another reference, the event is about something else
+		{name: "payment id with another reference", evt: app.ProviderEvent{Type: app.ProviderPaymentSucceeded, PaymentID: resp.PaymentID, ProviderRef: "pi_other"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := svc.ApplyProviderEvent(ctx, tt.evt); !errors.Is(err, app.ErrUnknownProviderRef) {
+				t.Fatalf("err = %v, want ErrUnknownProviderRef", err)
+			}
+		})
+	}
+}
+
+// the sweeper cannot tell a lost answer from an abandoned payment, a charge
+// reported after the expiry is given back
+func TestProviderEventRefundsAnExpiredPayment(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepository()
+	provider := &recordingProvider{Provider: mockprovider.New(time.Millisecond)}
+	svc := newGatewayService(repo, provider)
+
+	req := validRequest()
+	req.AmountCents = mockprovider.TimeoutAmount
+	resp, err := svc.InitiatePayment(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sweeper := app.NewExpirySweeper(repo, app.ExpiryConfig{After: -time.Minute, Interval: time.Minute, BatchSize: 10}, nil, slog.New(slog.DiscardHandler))
+	if n, err := sweeper.Sweep(ctx); err != nil || n != 1 {
+		t.Fatalf("expired %d, err %v, want the payment expired", n, err)
+	}
+
+	evt := succeeded(resp.PaymentID)
+	for range 2 {
+		if err := svc.ApplyProviderEvent(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := svc.GetPayment(ctx, resp.PaymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != string(domain.StatusExpired) {
+		t.Fatalf("status = %s, want EXPIRED", got.Status)
+	}
+	if len(provider.refunds) != 2 {
+		t.Fatalf("asked for %d refunds, want one per delivery", len(provider.refunds))
+	}
+	for _, r := range provider.refunds {
+		if r.IdempotencyKey != provider.refunds[0].IdempotencyKey || r.ProviderRef != evt.ProviderRef || r.AmountCents != req.AmountCents {
+			t.Fatalf("refunds %+v, want the full amount of %s under one key", provider.refunds, evt.ProviderRef)
+		}
+	}
+}
@@ -2,20 +2,96 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/logging"
 )
 
 type Config struct {
 	Env string `envconfig:"ENV" default:"development"`
 
-	HTTP     HttpConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
+	// in-memory storage, no postgres or redis. Set by the --local flag.
+	Local bool `envconfig:"LOCAL_MODE" default:"false"`
+
+	Log         LogConfig
+	HTTP        HTTPConfig
+	GRPC        GRPCConfig
+	CORS        CORSConfig
+	Database    DatabaseConfig
+	Redis       RedisConfig
+	Idempotency IdempotencyConfig
+	Payments    PaymentsConfig
+	Admin       AdminConfig
+	Privacy     PrivacyConfig
+	Health      HealthConfig
+	Auth        AuthConfig
+	Backfill    BackfillConfig
+	Outbox      OutboxConfig
+	Kafka       KafkaConfig
+	NATS        NATSConfig
+	Provider    ProviderConfig
+	Webhooks    WebhookConfig
+}
+
+type LogConfig struct {
+	// debug, info, warn or error, empty is info in production and debug
+	// elsewhere. Changeable at runtime through /v1/admin/log-level.
+	Level string `envconfig:"LOG_LEVEL" default:""`
+
+	// json or text, empty is json in production and text elsewhere.
+	Format string `envconfig:"LOG_FORMAT" default:""`
+}
+
+func (c LogConfig) validate() error {
+	if c.Level != "" {
+		if _, err := logging.ParseLevel(c.Level); err != nil {
+			return fmt.Errorf("LOG_LEVEL: %w", err)
+		}
+	}
+	switch c.Format {
+	case "", LogFormatJSON, LogFormatText:
+		return nil
+	}
+	return fmt.Errorf("LOG_FORMAT must be %s or %s, got %q", LogFormatJSON, LogFormatText, c.Format)
 }
 
-type HttpConfig struct {
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// LogLevel is LOG_LEVEL, or the default for the environment when unset
+func (c *Config) LogLevel() slog.Level {
+	if level, err := logging.ParseLevel(c.Log.Level); err == nil {
+		return level
+	}
+	if c.IsProd() {
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug
+}
+
+// LogFormat is LOG_FORMAT, or the default for the environment when unset
+func (c *Config) LogFormat() string {
+	switch {
+	case c.Log.Format != "":
+		return c.Log.Format
+	case c.IsProd():
+		return LogFormatJSON
+	default:
+		return LogFormatText
+	}
+}
+
+type HTTPConfig struct {
 	Addr string `envconfig:"HTTP_ADDR" default:":8080"`
 
 	ReadTimeout time.Duration `envconfig:"HTTP_READ_TIMEOUT" default:"5s"`
@@ -25,14 +101,132 @@ type HttpConfig struct {
 	IdleTimeout time.Duration `envconfig:"HTTP_IDLE_TIMEOUT" default:"120s"`
 
 	ShutdownTimeout time.Duration `envconfig:"HTTP_SHUTDOWN_TIMEOUT" default:"30s"`
+
+	// bounds how long a client may take to send headers, guards against slowloris.
+	ReadHeaderTimeout time.Duration `envconfig:"HTTP_READ_HEADER_TIMEOUT" default:"2s"`
+
+	MaxHeaderBytes int `envconfig:"HTTP_MAX_HEADER_BYTES" default:"65536"`
+
+	// largest JSON request body, larger ones are answered with 413.
+	MaxBodyBytes int64 `envconfig:"HTTP_MAX_BODY_BYTES" default:"65536"`
+
+	// accept HTTP/2 without TLS (h2c), the ingress talks HTTP/2 to pods in cleartext.
+	H2CEnabled bool `envconfig:"HTTP_H2C_ENABLED" default:"false"`
+
+	MaxConcurrentStreams int `envconfig:"HTTP2_MAX_CONCURRENT_STREAMS" default:"250"`
+
+	// close idle keep-alive connections as soon as shutdown starts.
+	DisableKeepAlivesOnShutdown bool `envconfig:"HTTP_DISABLE_KEEPALIVES_ON_SHUTDOWN" default:"true"`
+
+	// readiness fails this long before the server stops accepting, so load
+	// balancers take the pod out before its listener goes away. Counts
+	// against HTTP_SHUTDOWN_TIMEOUT.
+	PreStopDelay time.Duration `envconfig:"HTTP_PRESTOP_DELAY" default:"5s"`
+
+	// separate listener for /metrics so it stays off the public address,
+	// /metrics is served on HTTP_ADDR when empty.
+	MetricsAddr string `envconfig:"METRICS_ADDR" default:""`
+
+	// handler deadline on the API routes, answered with 503 TIMEOUT. 0 leaves
+	// handlers to HTTP_WRITE_TIMEOUT. Admin routes are not bounded.
+	RequestTimeout time.Duration `envconfig:"HTTP_REQUEST_TIMEOUT" default:"2s"`
+
+	// handler deadline on /v1/payments, 0 uses HTTP_REQUEST_TIMEOUT.
+	PaymentsTimeout time.Duration `envconfig:"HTTP_PAYMENTS_TIMEOUT" default:"5s"`
+
+	// gzip JSON and text responses of at least HTTP_COMPRESSION_MIN_BYTES
+	// for clients that accept it, never /metrics.
+	CompressionEnabled  bool `envconfig:"HTTP_COMPRESSION_ENABLED" default:"true"`
+	CompressionMinBytes int  `envconfig:"HTTP_COMPRESSION_MIN_BYTES" default:"1024"`
+
+	// path prefixes logged at debug unless they fail and left out of the
+	// request metrics, for probes and scrapes.
+	QuietPaths []string `envconfig:"HTTP_QUIET_PATHS" default:"/healthz/,/metrics"`
+
+	// pprof and runtime stats under /debug/ on the METRICS_ADDR listener,
+	// never on the public address.
+	DebugEndpointsEnabled bool `envconfig:"DEBUG_ENDPOINTS_ENABLED" default:"false"`
+}
+
+func (c HTTPConfig) validate() error {
+	switch {
+	case c.Addr == "":
+		return fmt.Errorf("HTTP_ADDR must not be empty")
+	case c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0:
+		return fmt.Errorf("HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT and HTTP_IDLE_TIMEOUT must not be negative, got %s, %s and %s",
+			c.ReadTimeout, c.WriteTimeout, c.IdleTimeout)
+	case c.ShutdownTimeout <= 0:
+		return fmt.Errorf("HTTP_SHUTDOWN_TIMEOUT must be positive, got %s", c.ShutdownTimeout)
+	case c.PreStopDelay < 0 || c.PreStopDelay >= c.ShutdownTimeout:
+		return fmt.Errorf("HTTP_PRESTOP_DELAY (%s) must not be negative and must be below HTTP_SHUTDOWN_TIMEOUT (%s)", c.PreStopDelay, c.ShutdownTimeout)
+	case c.ReadHeaderTimeout <= 0:
+		return fmt.Errorf("HTTP_READ_HEADER_TIMEOUT must be positive, got %s", c.ReadHeaderTimeout)
+	case c.ReadTimeout > 0 && c.ReadHeaderTimeout > c.ReadTimeout:
+		return fmt.Errorf("HTTP_READ_HEADER_TIMEOUT (%s) must not exceed HTTP_READ_TIMEOUT (%s)", c.ReadHeaderTimeout, c.ReadTimeout)
+	case c.MaxHeaderBytes < 4096 || c.MaxHeaderBytes > 1<<20:
+		return fmt.Errorf("HTTP_MAX_HEADER_BYTES must be between 4096 and 1048576, got %d", c.MaxHeaderBytes)
+	case c.MaxBodyBytes < 1024 || c.MaxBodyBytes > 10<<20:
+		return fmt.Errorf("HTTP_MAX_BODY_BYTES must be between 1024 and 10485760, got %d", c.MaxBodyBytes)
+	case c.MaxConcurrentStreams < 1 || c.MaxConcurrentStreams > 1000:
+		return fmt.Errorf("HTTP2_MAX_CONCURRENT_STREAMS must be between 1 and 1000, got %d", c.MaxConcurrentStreams)
+	case c.CompressionMinBytes < 1:
+		return fmt.Errorf("HTTP_COMPRESSION_MIN_BYTES must be positive, got %d", c.CompressionMinBytes)
+	case c.RequestTimeout < 0 || c.PaymentsTimeout < 0:
+		return fmt.Errorf("HTTP_REQUEST_TIMEOUT and HTTP_PAYMENTS_TIMEOUT must not be negative, got %s and %s", c.RequestTimeout, c.PaymentsTimeout)
+	case c.WriteTimeout > 0 && (c.RequestTimeout >= c.WriteTimeout || c.PaymentsTimeout >= c.WriteTimeout):
+		// the connection would be cut before the 503 is written
+		return fmt.Errorf("HTTP_REQUEST_TIMEOUT (%s) and HTTP_PAYMENTS_TIMEOUT (%s) must be below HTTP_WRITE_TIMEOUT (%s)", c.RequestTimeout, c.PaymentsTimeout, c.WriteTimeout)
+	case slices.ContainsFunc(c.QuietPaths, func(p string) bool { return !strings.HasPrefix(p, "/") }):
+		return fmt.Errorf("HTTP_QUIET_PATHS entries must start with /, got %v", c.QuietPaths)
+	case c.MetricsAddr != "" && c.MetricsAddr == c.Addr:
+		return fmt.Errorf("METRICS_ADDR must differ from HTTP_ADDR, leave it empty to serve /metrics on HTTP_ADDR")
+	case c.DebugEndpointsEnabled && c.MetricsAddr == "":
+		return fmt.Errorf("DEBUG_ENDPOINTS_ENABLED needs METRICS_ADDR so the debug routes stay off HTTP_ADDR")
+	default:
+		return nil
+	}
+}
+
+type GRPCConfig struct {
+	// listener for the gRPC PaymentService, empty serves HTTP only.
+	Addr string `envconfig:"GRPC_ADDR" default:""`
+}
+
+func (c GRPCConfig) validate(http HTTPConfig) error {
+	if c.Addr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("GRPC_ADDR must be host:port, got %q", c.Addr)
+	}
+	if c.Addr == http.Addr || c.Addr == http.MetricsAddr {
+		return fmt.Errorf("GRPC_ADDR must differ from HTTP_ADDR and METRICS_ADDR, got %q", c.Addr)
+	}
+	return nil
 }
 
+const (
+	MigrateAuto = "auto"
+	MigrateSkip = "skip"
+	MigrateOnly = "only"
+)
+
 type DatabaseConfig struct {
 	// postgreSQL connection string.
-	DSN string `envconfig:"DATABASE_DSN" required:"true"`
+	// required unless running in local mode.
+	DSN string `envconfig:"DATABASE_DSN"`
+
+	// optional replica for lookups and listings that tolerate lag, empty
+	// sends every query to DSN. It shares the pool sizing below.
+	ReadDSN string `envconfig:"DATABASE_READ_DSN"`
+
+	// where golang-migrate looks for SQL files, e.g. file://migrations while
+	// editing them. Empty uses the migrations embedded in the binary.
+	MigrationsPath string `envconfig:"DATABASE_MIGRATIONS_PATH" default:""`
 
-	// where golang-migrate looks for SQL files.
-	MigrationsPath string `envconfig:"DATABASE_MIGRATIONS_PATH" default:"file://migrations"`
+	// auto migrates on start, skip leaves it to a separate job and only
+	// migrates and exits (also --migrate-only).
+	MigrateMode string `envconfig:"MIGRATE_MODE" default:"auto"`
 
 	MaxConns int32 `envconfig:"DATABASE_MAX_CONNS" default:"20"`
 
@@ -41,15 +235,595 @@ type DatabaseConfig struct {
 	MaxConnLifeTime time.Duration `envconfig:"DATABASE_MAX_CONN_LIFETIME" default:"1h"`
 	MaxConnIdleTime time.Duration `envconfig:"DATABASE_MAX_CONN_IDLE" default:"30m"`
 	HealthPeriod    time.Duration `envconfig:"DATABASE_HEALTH_PERIOD" default:"1m"`
+
+	// upper bounds for a single read and for a write statement or transaction,
+	// keep them under HTTP_WRITE_TIMEOUT. zero leaves only the request deadline.
+	QueryTimeout time.Duration `envconfig:"DATABASE_QUERY_TIMEOUT" default:"2s"`
+	WriteTimeout time.Duration `envconfig:"DATABASE_WRITE_TIMEOUT" default:"5s"`
+
+	// statements running longer are logged at warn level, zero disables.
+	SlowQueryThreshold time.Duration `envconfig:"DATABASE_SLOW_QUERY_THRESHOLD" default:"500ms"`
+
+	// reported to postgres so pg_stat_activity can be filtered to this service.
+	ApplicationName string `envconfig:"DATABASE_APPLICATION_NAME" default:"gopay-service"`
+
+	// transaction watchdog, a zero TxCancelAfter only logs and never cancels.
+	WatchdogInterval time.Duration `envconfig:"DATABASE_WATCHDOG_INTERVAL" default:"15s"`
+	TxWarnAfter      time.Duration `envconfig:"DATABASE_TX_WARN_AFTER" default:"30s"`
+	TxCancelAfter    time.Duration `envconfig:"DATABASE_TX_CANCEL_AFTER" default:"0s"`
+	IdleInTxAfter    time.Duration `envconfig:"DATABASE_IDLE_IN_TX_AFTER" default:"1m"`
+
+	// failover detection, this many connection resets within the window reset
+	// the pool and fail readiness for the cooldown.
+	FailoverThreshold int           `envconfig:"DATABASE_FAILOVER_THRESHOLD" default:"5"`
+	FailoverWindow    time.Duration `envconfig:"DATABASE_FAILOVER_WINDOW" default:"10s"`
+	FailoverCooldown  time.Duration `envconfig:"DATABASE_FAILOVER_COOLDOWN" default:"15s"`
+}
+
+// validate skips the DSN in local mode, where postgres is not used
+func (c DatabaseConfig) validate(local bool) error {
+	switch {
+	case !local && c.DSN == "":
+		return fmt.Errorf("required key DATABASE_DSN missing value")
+	case !local && !validDSN(c.DSN):
+		return fmt.Errorf("DATABASE_DSN must be a postgres:// URL or key=value connection string")
+	case !local && c.ReadDSN != "" && !validDSN(c.ReadDSN):
+		return fmt.Errorf("DATABASE_READ_DSN must be a postgres:// URL or key=value connection string")
+	case !slices.Contains([]string{MigrateAuto, MigrateSkip, MigrateOnly}, c.MigrateMode):
+		return fmt.Errorf("MIGRATE_MODE must be auto, skip or only, got %q", c.MigrateMode)
+	case local && c.MigrateMode == MigrateOnly:
+		return fmt.Errorf("MIGRATE_MODE=only needs postgres, it cannot run in local mode")
+	case c.MaxConns < 1:
+		return fmt.Errorf("DATABASE_MAX_CONNS must be positive, got %d", c.MaxConns)
+	case c.MinConns < 0 || c.MinConns > c.MaxConns:
+		return fmt.Errorf("DATABASE_MIN_CONNS must be between 0 and DATABASE_MAX_CONNS (%d), got %d", c.MaxConns, c.MinConns)
+	case c.MaxConnLifeTime < 0 || c.MaxConnIdleTime < 0:
+		return fmt.Errorf("DATABASE_MAX_CONN_LIFETIME and DATABASE_MAX_CONN_IDLE must not be negative, got %s and %s",
+			c.MaxConnLifeTime, c.MaxConnIdleTime)
+	case c.QueryTimeout < 0 || c.WriteTimeout < 0:
+		return fmt.Errorf("DATABASE_QUERY_TIMEOUT and DATABASE_WRITE_TIMEOUT must not be negative, got %s and %s", c.QueryTimeout, c.WriteTimeout)
+	case c.SlowQueryThreshold < 0:
+		return fmt.Errorf("DATABASE_SLOW_QUERY_THRESHOLD must not be negative, got %s", c.SlowQueryThreshold)
+	case c.HealthPeriod <= 0:
+		return fmt.Errorf("DATABASE_HEALTH_PERIOD must be positive, got %s", c.HealthPeriod)
+	case c.WatchdogInterval <= 0 || c.TxWarnAfter <= 0 || c.IdleInTxAfter <= 0:
+		return fmt.Errorf("DATABASE_WATCHDOG_INTERVAL, DATABASE_TX_WARN_AFTER and DATABASE_IDLE_IN_TX_AFTER must be positive, got %s, %s and %s",
+			c.WatchdogInterval, c.TxWarnAfter, c.IdleInTxAfter)
+	case c.TxCancelAfter < 0:
+		return fmt.Errorf("DATABASE_TX_CANCEL_AFTER must not be negative, got %s", c.TxCancelAfter)
+	case c.TxCancelAfter > 0 && c.TxCancelAfter < c.TxWarnAfter:
+		return fmt.Errorf("DATABASE_TX_CANCEL_AFTER (%s) must not be shorter than DATABASE_TX_WARN_AFTER (%s)", c.TxCancelAfter, c.TxWarnAfter)
+	case c.FailoverThreshold < 1:
+		return fmt.Errorf("DATABASE_FAILOVER_THRESHOLD must be positive, got %d", c.FailoverThreshold)
+	case c.FailoverWindow <= 0 || c.FailoverCooldown < 0:
+		return fmt.Errorf("DATABASE_FAILOVER_WINDOW must be positive and DATABASE_FAILOVER_COOLDOWN not negative, got %s and %s",
+			c.FailoverWindow, c.FailoverCooldown)
+	default:
+		return nil
+	}
+}
+
+// validDSN accepts the two forms pgx parses, a postgres URL or key=value pairs
+func validDSN(dsn string) bool {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		return err == nil && u.Host != ""
+	}
+	return strings.Contains(dsn, "=")
 }
 
 type RedisConfig struct {
-	// host:port, "localhost:6379" for dev, cluster endpoint for prod.
-	Addr     string `envconfig:"REDIS_ADDR" default:"localhost:6379"`
-	Password string `envconfig:"REDIS_PASSWORD" default:""`
-	DB       int    `envconfig:"REDIS_DB" default:"0"`
+	// false runs without redis, idempotency is then answered from postgres
+	// alone and request signing is unavailable
+	Enabled bool `envconfig:"REDIS_ENABLED" default:"true"`
+
+	// single, sentinel or cluster
+	Mode string `envconfig:"REDIS_MODE" default:"single"`
+
+	// host:port of the server in single mode
+	Addr string `envconfig:"REDIS_ADDR" default:"localhost:6379"`
+	// sentinels or cluster seed nodes, REDIS_ADDR alone when empty
+	Addrs []string `envconfig:"REDIS_ADDRS" default:""`
+	// the master the sentinels monitor
+	MasterName string `envconfig:"REDIS_MASTER_NAME" default:""`
+	Password   string `envconfig:"REDIS_PASSWORD" default:""`
+	DB         int    `envconfig:"REDIS_DB" default:"0"`
+
+	TLSEnabled bool   `envconfig:"REDIS_TLS_ENABLED" default:"false"`
+	TLSCAFile  string `envconfig:"REDIS_TLS_CA_FILE" default:""`
+	// skips certificate verification, development only
+	TLSInsecureSkipVerify bool `envconfig:"REDIS_TLS_INSECURE_SKIP_VERIFY" default:"false"`
+
+	PoolSize     int           `envconfig:"REDIS_POOL_SIZE" default:"20"`
+	MinIdleConns int           `envconfig:"REDIS_MIN_IDLE_CONNS" default:"5"`
+	MaxRetries   int           `envconfig:"REDIS_MAX_RETRIES" default:"3"`
+	DialTimeout  time.Duration `envconfig:"REDIS_DIAL_TIMEOUT" default:"2s"`
+	ReadTimeout  time.Duration `envconfig:"REDIS_READ_TIMEOUT" default:"500ms"`
+	WriteTimeout time.Duration `envconfig:"REDIS_WRITE_TIMEOUT" default:"500ms"`
 
 	Namespace string `envconfig:"REDIS_NAMESPACE" default:"payment-service"`
+
+	// consecutive failures after which idempotency calls skip redis for
+	// BreakerCooldown, 0 disables the breaker
+	BreakerThreshold int           `envconfig:"REDIS_BREAKER_THRESHOLD" default:"5"`
+	BreakerCooldown  time.Duration `envconfig:"REDIS_BREAKER_COOLDOWN" default:"10s"`
+
+	// idempotency responses kept in process to serve replays during a redis
+	// outage, 0 disables the local cache
+	LocalCacheSize int           `envconfig:"REDIS_LOCAL_CACHE_SIZE" default:"10000"`
+	LocalCacheTTL  time.Duration `envconfig:"REDIS_LOCAL_CACHE_TTL" default:"10m"`
+}
+
+// Addresses is REDIS_ADDRS, or REDIS_ADDR when no list is given
+func (c RedisConfig) Addresses() []string {
+	if len(c.Addrs) > 0 {
+		return c.Addrs
+	}
+	return []string{c.Addr}
+}
+
+func (c RedisConfig) validate(prod bool) error {
+	addrs := c.Addresses()
+	switch {
+	case !slices.Contains([]string{"single", "sentinel", "cluster"}, c.Mode):
+		return fmt.Errorf("REDIS_MODE must be single, sentinel or cluster, got %q", c.Mode)
+	case slices.Contains(addrs, ""):
+		return fmt.Errorf("REDIS_ADDR and REDIS_ADDRS must not hold empty addresses")
+	case c.Mode == "single" && len(addrs) != 1:
+		return fmt.Errorf("REDIS_MODE=single takes one address, got %d", len(addrs))
+	case c.Mode == "sentinel" && c.MasterName == "":
+		return fmt.Errorf("REDIS_MASTER_NAME is required with REDIS_MODE=sentinel")
+	case c.Mode == "cluster" && c.DB != 0:
+		return fmt.Errorf("REDIS_DB must be 0 with REDIS_MODE=cluster, got %d", c.DB)
+	case c.Mode == "cluster" && prod && len(addrs) == 1 && isLocalhost(addrs[0]):
+		return fmt.Errorf("REDIS_MODE=cluster with only %s is not allowed with ENV=production", addrs[0])
+	case c.TLSInsecureSkipVerify && prod:
+		return fmt.Errorf("REDIS_TLS_INSECURE_SKIP_VERIFY is not allowed with ENV=production")
+	case c.DB < 0:
+		return fmt.Errorf("REDIS_DB must not be negative, got %d", c.DB)
+	case c.PoolSize <= 0:
+		return fmt.Errorf("REDIS_POOL_SIZE must be positive, got %d", c.PoolSize)
+	case c.MinIdleConns < 0 || c.MinIdleConns > c.PoolSize:
+		return fmt.Errorf("REDIS_MIN_IDLE_CONNS must be between 0 and REDIS_POOL_SIZE (%d), got %d", c.PoolSize, c.MinIdleConns)
+	case c.MaxRetries < 0:
+		return fmt.Errorf("REDIS_MAX_RETRIES must not be negative, got %d", c.MaxRetries)
+	case c.DialTimeout <= 0 || c.ReadTimeout <= 0 || c.WriteTimeout <= 0:
+		return fmt.Errorf("REDIS_DIAL_TIMEOUT, REDIS_READ_TIMEOUT and REDIS_WRITE_TIMEOUT must be positive")
+	case c.BreakerThreshold < 0:
+		return fmt.Errorf("REDIS_BREAKER_THRESHOLD must not be negative, got %d", c.BreakerThreshold)
+	case c.BreakerThreshold > 0 && c.BreakerCooldown <= 0:
+		return fmt.Errorf("REDIS_BREAKER_COOLDOWN must be positive, got %s", c.BreakerCooldown)
+	case c.LocalCacheSize < 0:
+		return fmt.Errorf("REDIS_LOCAL_CACHE_SIZE must not be negative, got %d", c.LocalCacheSize)
+	case c.LocalCacheSize > 0 && c.LocalCacheTTL <= 0:
+		return fmt.Errorf("REDIS_LOCAL_CACHE_TTL must be positive, got %s", c.LocalCacheTTL)
+	default:
+		return nil
+	}
+}
+
+func isLocalhost(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return host == "localhost" || net.ParseIP(host).IsLoopback()
+}
+
+type IdempotencyConfig struct {
+	// how long a response is replayed from the cache, the payment row keeps
+	// answering replays after that
+	TTL time.Duration `envconfig:"IDEMPOTENCY_TTL" default:"24h"`
+}
+
+func (c IdempotencyConfig) validate() error {
+	if c.TTL < time.Minute {
+		return fmt.Errorf("IDEMPOTENCY_TTL must be at least 1m, got %s", c.TTL)
+	}
+	return nil
+}
+
+type PaymentsConfig struct {
+	// ISO 4217 codes InitiatePayment accepts, a subset of the domain
+	// currency registry. Every code in the registry when empty.
+	AllowedCurrencies []string `envconfig:"PAYMENT_ALLOWED_CURRENCIES" default:""`
+	// largest amount_cents InitiatePayment accepts
+	MaxAmountCents int64 `envconfig:"PAYMENT_MAX_AMOUNT_CENTS" default:"100000000"`
+	// leaves client_reference out of the idempotency fingerprint, see
+	// app.RequestLimits
+	ClientReferenceNonSemantic bool `envconfig:"PAYMENT_CLIENT_REFERENCE_NON_SEMANTIC" default:"false"`
+
+	// PENDING payments older than this are expired by a sweeper, zero disables.
+	ExpireAfter         time.Duration `envconfig:"PAYMENT_EXPIRE_AFTER" default:"30m"`
+	ExpirySweepInterval time.Duration `envconfig:"PAYMENT_EXPIRY_SWEEP_INTERVAL" default:"1m"`
+	ExpiryBatchSize     int           `envconfig:"PAYMENT_EXPIRY_BATCH_SIZE" default:"100"`
+}
+
+func (c PaymentsConfig) validate() error {
+	for _, code := range c.AllowedCurrencies {
+		if !domain.IsCurrency(code) {
+			return fmt.Errorf("PAYMENT_ALLOWED_CURRENCIES must list upper-case ISO 4217 codes, got %q", code)
+		}
+	}
+	if c.MaxAmountCents <= 0 {
+		return fmt.Errorf("PAYMENT_MAX_AMOUNT_CENTS must be positive, got %d", c.MaxAmountCents)
+	}
+	if c.ExpireAfter < 0 {
+		return fmt.Errorf("PAYMENT_EXPIRE_AFTER must not be negative, got %s", c.ExpireAfter)
+	}
+	if c.ExpireAfter > 0 && (c.ExpirySweepInterval <= 0 || c.ExpiryBatchSize < 1) {
+		return fmt.Errorf("PAYMENT_EXPIRY_SWEEP_INTERVAL and PAYMENT_EXPIRY_BATCH_SIZE must be positive, got %s and %d",
+			c.ExpirySweepInterval, c.ExpiryBatchSize)
+	}
+	return nil
+}
+
+type HealthConfig struct {
+	// readiness checks that only degrade the pod instead of failing it,
+	// everything else is critical. Known checks: postgres, redis, outbox.
+	DegradedDependencies []string `envconfig:"HEALTH_DEGRADED_DEPENDENCIES" default:"redis,outbox"`
+
+	// each readiness check gets this long, checks run side by side.
+	CheckTimeout time.Duration `envconfig:"HEALTH_CHECK_TIMEOUT" default:"1s"`
+
+	// readiness answers from the last run for this long so frequent probes
+	// do not reach the databases, 0 runs the checks on every probe.
+	CacheTTL time.Duration `envconfig:"HEALTH_CACHE_TTL" default:"2s"`
+}
+
+func (c HealthConfig) validate() error {
+	switch {
+	case c.CheckTimeout <= 0 || c.CheckTimeout > 10*time.Second:
+		return fmt.Errorf("HEALTH_CHECK_TIMEOUT must be positive and at most 10s, got %s", c.CheckTimeout)
+	case c.CacheTTL < 0:
+		return fmt.Errorf("HEALTH_CACHE_TTL must not be negative, got %s", c.CacheTTL)
+	}
+	return nil
+}
+
+// IsDegradedOnly reports whether a failing dependency should only degrade readiness
+func (c HealthConfig) IsDegradedOnly(dependency string) bool {
+	return slices.Contains(c.DegradedDependencies, dependency)
+}
+
+type AuthConfig struct {
+	// trusted internal callers as caller:secret pairs, signed-request auth is
+	// required on the API once any caller is configured.
+	SigningCallers map[string]string `envconfig:"SIGNING_CALLERS" default:""`
+
+	// scopes granted to every signed caller.
+	SigningScopes []string `envconfig:"SIGNING_SCOPES" default:"payments:read,payments:write,refunds:write"`
+
+	// accepted clock skew for X-Signature-Timestamp, doubles as the replay window.
+	SigningWindow time.Duration `envconfig:"SIGNING_WINDOW" default:"5m"`
+
+	// API keys stored in postgres authenticate merchants, keys are issued
+	// through /v1/admin/api-keys
+	APIKeysEnabled bool `envconfig:"API_KEYS_ENABLED" default:"false"`
+}
+
+func (c AuthConfig) validate() error {
+	for caller, secret := range c.SigningCallers {
+		if len(secret) < 32 {
+			return fmt.Errorf("SIGNING_CALLERS secret for %q must be at least 32 bytes", caller)
+		}
+	}
+	if c.SigningWindow <= 0 {
+		return fmt.Errorf("SIGNING_WINDOW must be positive, got %s", c.SigningWindow)
+	}
+	return nil
+}
+
+type BackfillConfig struct {
+	BatchSize int `envconfig:"BACKFILL_BATCH_SIZE" default:"500"`
+
+	// outbox events written per second, keeps the relay from being flooded.
+	Rate int `envconfig:"BACKFILL_RATE" default:"200"`
+}
+
+func (c BackfillConfig) validate() error {
+	if c.BatchSize < 1 || c.Rate < 1 {
+		return fmt.Errorf("BACKFILL_BATCH_SIZE and BACKFILL_RATE must be positive, got %d and %d", c.BatchSize, c.Rate)
+	}
+	return nil
+}
+
+type OutboxConfig struct {
+	// polls outbox_events and publishes them, ignored in local mode.
+	RelayEnabled bool `envconfig:"OUTBOX_RELAY_ENABLED" default:"false"`
+
+	// broker the relay publishes to: kafka or nats.
+	Publisher string `envconfig:"OUTBOX_PUBLISHER" default:"kafka"`
+
+	BatchSize    int           `envconfig:"OUTBOX_BATCH_SIZE" default:"100"`
+	PollInterval time.Duration `envconfig:"OUTBOX_POLL_INTERVAL" default:"1s"`
+
+	// failed batches are retried with exponential backoff up to this.
+	MaxBackoff time.Duration `envconfig:"OUTBOX_MAX_BACKOFF" default:"30s"`
+
+	// LISTEN for new events so the relay publishes them without waiting for
+	// the next poll. Off behind a transaction-pooling PgBouncer.
+	NotifyEnabled bool `envconfig:"OUTBOX_NOTIFY_ENABLED" default:"true"`
+
+	// an event the broker keeps rejecting is parked after this many attempts
+	// so it stops holding back the rest of the stream.
+	MaxAttempts int `envconfig:"OUTBOX_MAX_ATTEMPTS" default:"10"`
+
+	// how often the backlog gauges are refreshed, with or without the relay.
+	MonitorInterval time.Duration `envconfig:"OUTBOX_MONITOR_INTERVAL" default:"15s"`
+
+	// the outbox readiness check fails once the oldest unpublished event is
+	// older than this, 0 disables the check.
+	LagThreshold time.Duration `envconfig:"OUTBOX_LAG_THRESHOLD" default:"5m"`
+
+	// published events are deleted once older than this, 0 keeps them forever.
+	// Must outlast the webhook fan-out, which reads published events too.
+	Retention time.Duration `envconfig:"OUTBOX_RETENTION" default:"168h"`
+
+	RetentionInterval  time.Duration `envconfig:"OUTBOX_RETENTION_INTERVAL" default:"1h"`
+	RetentionBatchSize int           `envconfig:"OUTBOX_RETENTION_BATCH_SIZE" default:"5000"`
+	// sleep between retention batches so the purge never hogs the table.
+	RetentionPause time.Duration `envconfig:"OUTBOX_RETENTION_PAUSE" default:"100ms"`
+}
+
+func (c OutboxConfig) validateRetention() error {
+	switch {
+	case c.Retention < 0:
+		return fmt.Errorf("OUTBOX_RETENTION must not be negative, got %s", c.Retention)
+	case c.Retention == 0:
+		return nil
+	case c.Retention < time.Hour:
+		return fmt.Errorf("OUTBOX_RETENTION must be at least 1h, got %s", c.Retention)
+	case c.RetentionInterval <= 0:
+		return fmt.Errorf("OUTBOX_RETENTION_INTERVAL must be positive, got %s", c.RetentionInterval)
+	case c.RetentionBatchSize < 1 || c.RetentionBatchSize > 100000:
+		return fmt.Errorf("OUTBOX_RETENTION_BATCH_SIZE must be between 1 and 100000, got %d", c.RetentionBatchSize)
+	case c.RetentionPause < 0:
+		return fmt.Errorf("OUTBOX_RETENTION_PAUSE must not be negative, got %s", c.RetentionPause)
+	default:
+		return nil
+	}
+}
+
+func (c OutboxConfig) validateMonitor() error {
+	switch {
+	case c.MonitorInterval <= 0:
+		return fmt.Errorf("OUTBOX_MONITOR_INTERVAL must be positive, got %s", c.MonitorInterval)
+	case c.LagThreshold < 0:
+		return fmt.Errorf("OUTBOX_LAG_THRESHOLD must not be negative, got %s", c.LagThreshold)
+	case c.LagThreshold > 0 && c.LagThreshold < c.MonitorInterval:
+		return fmt.Errorf("OUTBOX_LAG_THRESHOLD (%s) must not be shorter than OUTBOX_MONITOR_INTERVAL (%s)", c.LagThreshold, c.MonitorInterval)
+	default:
+		return nil
+	}
+}
+
+func (c OutboxConfig) validate() error {
+	switch {
+	case c.BatchSize < 1 || c.BatchSize > 10000:
+		return fmt.Errorf("OUTBOX_BATCH_SIZE must be between 1 and 10000, got %d", c.BatchSize)
+	case c.PollInterval <= 0:
+		return fmt.Errorf("OUTBOX_POLL_INTERVAL must be positive, got %s", c.PollInterval)
+	case c.MaxBackoff < c.PollInterval:
+		return fmt.Errorf("OUTBOX_MAX_BACKOFF (%s) must not be shorter than OUTBOX_POLL_INTERVAL (%s)", c.MaxBackoff, c.PollInterval)
+	case c.MaxAttempts < 1:
+		return fmt.Errorf("OUTBOX_MAX_ATTEMPTS must be positive, got %d", c.MaxAttempts)
+	case c.Publisher != "kafka" && c.Publisher != "nats":
+		return fmt.Errorf("OUTBOX_PUBLISHER must be kafka or nats, got %q", c.Publisher)
+	default:
+		return nil
+	}
+}
+
+type KafkaConfig struct {
+	// seed brokers as host:port, e.g. kafka-0:9092,kafka-1:9092.
+	Brokers []string `envconfig:"KAFKA_BROKERS" default:""`
+
+	Topic string `envconfig:"KAFKA_TOPIC" default:"gopay.payment-events"`
+
+	// bounds the delivery of a single event, retries included.
+	PublishTimeout time.Duration `envconfig:"KAFKA_PUBLISH_TIMEOUT" default:"10s"`
+}
+
+func (c KafkaConfig) validate() error {
+	switch {
+	case len(c.Brokers) == 0:
+		return fmt.Errorf("KAFKA_BROKERS is required when the outbox relay is enabled")
+	case c.Topic == "":
+		return fmt.Errorf("KAFKA_TOPIC must not be empty")
+	case c.PublishTimeout <= 0:
+		return fmt.Errorf("KAFKA_PUBLISH_TIMEOUT must be positive, got %s", c.PublishTimeout)
+	}
+	for _, broker := range c.Brokers {
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			return fmt.Errorf("KAFKA_BROKERS must be host:port addresses, got %q", broker)
+		}
+	}
+	return nil
+}
+
+type NATSConfig struct {
+	URL string `envconfig:"NATS_URL" default:""`
+
+	// must be bound to a JetStream stream.
+	Subject string `envconfig:"NATS_SUBJECT" default:"gopay.payment-events"`
+}
+
+func (c NATSConfig) validate() error {
+	switch {
+	case c.URL == "":
+		return fmt.Errorf("NATS_URL is required when OUTBOX_PUBLISHER=nats")
+	case c.Subject == "":
+		return fmt.Errorf("NATS_SUBJECT must not be empty")
+	default:
+		return nil
+	}
+}
+
+type ProviderConfig struct {
+	// payment gateway adapter: mock or stripe, mock is refused in production.
+	Name string `envconfig:"PAYMENT_PROVIDER" default:"mock"`
+
+	// how long the mock gateway stalls before failing the 999 cent amount.
+	MockTimeout time.Duration `envconfig:"PROVIDER_MOCK_TIMEOUT" default:"3s"`
+
+	// verifies provider webhooks, the webhook route is off when empty.
+	WebhookSecret string `envconfig:"PROVIDER_WEBHOOK_SECRET" default:""`
+
+	// webhooks signed longer ago than this are rejected as replays.
+	WebhookTolerance time.Duration `envconfig:"PROVIDER_WEBHOOK_TOLERANCE" default:"5m"`
+
+	Stripe StripeConfig
+}
+
+func (c ProviderConfig) validate(prod bool) error {
+	if c.WebhookTolerance <= 0 {
+		return fmt.Errorf("PROVIDER_WEBHOOK_TOLERANCE must be positive, got %s", c.WebhookTolerance)
+	}
+	switch c.Name {
+	case "mock":
+		if prod {
+			// the mock approves every card, production must name a real gateway
+			return fmt.Errorf("PAYMENT_PROVIDER=mock is not allowed with ENV=production")
+		}
+		if c.MockTimeout <= 0 {
+			return fmt.Errorf("PROVIDER_MOCK_TIMEOUT must be positive, got %s", c.MockTimeout)
+		}
+		return nil
+	case "stripe":
+		return c.Stripe.validate()
+	default:
+		return fmt.Errorf("PAYMENT_PROVIDER must be mock or stripe, got %q", c.Name)
+	}
+}
+
+type StripeConfig struct {
+	// secret key, sk_test_ keys work against stripe-mock.
+	APIKey string `envconfig:"STRIPE_API_KEY" default:""`
+
+	// http://localhost:12111 for stripe-mock.
+	BaseURL string `envconfig:"STRIPE_BASE_URL" default:"https://api.stripe.com"`
+
+	// bounds a single API request, retries get their own.
+	Timeout time.Duration `envconfig:"STRIPE_TIMEOUT" default:"5s"`
+
+	// retries for rate limits, conflicts and server errors.
+	MaxRetries int `envconfig:"STRIPE_MAX_RETRIES" default:"2"`
+}
+
+func (c StripeConfig) validate() error {
+	switch {
+	case c.APIKey == "":
+		return fmt.Errorf("STRIPE_API_KEY is required when PAYMENT_PROVIDER=stripe")
+	case c.Timeout <= 0:
+		return fmt.Errorf("STRIPE_TIMEOUT must be positive, got %s", c.Timeout)
+	case c.MaxRetries < 0 || c.MaxRetries > 10:
+		return fmt.Errorf("STRIPE_MAX_RETRIES must be between 0 and 10, got %d", c.MaxRetries)
+	}
+	if u, err := url.Parse(c.BaseURL); err != nil || u.Host == "" {
+		return fmt.Errorf("STRIPE_BASE_URL must be an absolute URL, got %q", c.BaseURL)
+	}
+	return nil
+}
+
+type WebhookConfig struct {
+	// sends merchant webhooks from the outbox, ignored in local mode.
+	DeliveryEnabled bool `envconfig:"WEBHOOK_DELIVERY_ENABLED" default:"true"`
+
+	BatchSize    int           `envconfig:"WEBHOOK_BATCH_SIZE" default:"50"`
+	PollInterval time.Duration `envconfig:"WEBHOOK_POLL_INTERVAL" default:"1s"`
+
+	// bounds a single POST to a merchant endpoint.
+	Timeout time.Duration `envconfig:"WEBHOOK_TIMEOUT" default:"10s"`
+
+	// a delivery is marked FAILED after this many attempts.
+	MaxAttempts int           `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"12"`
+	MinBackoff  time.Duration `envconfig:"WEBHOOK_MIN_BACKOFF" default:"30s"`
+	MaxBackoff  time.Duration `envconfig:"WEBHOOK_MAX_BACKOFF" default:"6h"`
+
+	// accept http:// endpoint URLs, for local receivers only.
+	AllowHTTP bool `envconfig:"WEBHOOK_ALLOW_HTTP" default:"false"`
+}
+
+func (c WebhookConfig) validate() error {
+	switch {
+	case c.BatchSize < 1 || c.BatchSize > 1000:
+		return fmt.Errorf("WEBHOOK_BATCH_SIZE must be between 1 and 1000, got %d", c.BatchSize)
+	case c.PollInterval <= 0 || c.Timeout <= 0:
+		return fmt.Errorf("WEBHOOK_POLL_INTERVAL and WEBHOOK_TIMEOUT must be positive, got %s and %s", c.PollInterval, c.Timeout)
+	case c.MaxAttempts < 1:
+		return fmt.Errorf("WEBHOOK_MAX_ATTEMPTS must be positive, got %d", c.MaxAttempts)
+	case c.MinBackoff <= 0 || c.MaxBackoff < c.MinBackoff:
+		return fmt.Errorf("WEBHOOK_MIN_BACKOFF must be positive and not exceed WEBHOOK_MAX_BACKOFF, got %s and %s", c.MinBackoff, c.MaxBackoff)
+	default:
+		return nil
+	}
+}
+
+type CORSConfig struct {
+	// origins browsers may call the API from, e.g. https://checkout.example.com
+	// or https://*.example.com for its subdomains. Empty disables CORS.
+	AllowedOrigins []string `envconfig:"CORS_ALLOWED_ORIGINS" default:""`
+
+	AllowedMethods []string `envconfig:"CORS_ALLOWED_METHODS" default:"GET,POST,PATCH,DELETE"`
+
+	AllowedHeaders []string `envconfig:"CORS_ALLOWED_HEADERS" default:"Content-Type,Idempotency-Key,X-API-Key,X-Correlation-Id,If-Match,If-None-Match"`
+
+	// how long browsers cache a preflight answer.
+	MaxAge time.Duration `envconfig:"CORS_MAX_AGE" default:"10m"`
+}
+
+func (c CORSConfig) validate(prod bool) error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if prod {
+				return fmt.Errorf("CORS_ALLOWED_ORIGINS must list origins in production, not *")
+			}
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("CORS_ALLOWED_ORIGINS entries must be scheme://host[:port] with an optional *. before the host, got %q", origin)
+		}
+	}
+	switch {
+	case len(c.AllowedOrigins) > 0 && len(c.AllowedMethods) == 0:
+		return fmt.Errorf("CORS_ALLOWED_METHODS must not be empty when CORS is enabled")
+	case c.MaxAge < 0 || c.MaxAge > 24*time.Hour:
+		return fmt.Errorf("CORS_MAX_AGE must be between 0 and 24h, got %s", c.MaxAge)
+	}
+	return nil
+}
+
+type AdminConfig struct {
+	// bearer token for /v1/admin routes, admin routes are not mounted when empty.
+	Token string `envconfig:"ADMIN_TOKEN" default:""`
+}
+
+type PrivacyConfig struct {
+	// HMAC key for customer pseudonyms, erasure is disabled when empty.
+	// Rotating it changes the pseudonym of future erasures only.
+	ErasureKey string `envconfig:"ERASURE_KEY" default:""`
+
+	ErasureBatchSize int `envconfig:"ERASURE_BATCH_SIZE" default:"500"`
+	// metadata keys holding personal data, erasure removes them from the
+	// payments and from the events stored for them
+	PIIMetadataKeys []string `envconfig:"ERASURE_PII_METADATA_KEYS" default:""`
+}
+
+func (c PrivacyConfig) validate() error {
+	switch {
+	case c.ErasureKey != "" && len(c.ErasureKey) < 32:
+		return fmt.Errorf("ERASURE_KEY must be at least 32 bytes, got %d", len(c.ErasureKey))
+	case c.ErasureBatchSize < 1:
+		return fmt.Errorf("ERASURE_BATCH_SIZE must be positive, got %d", c.ErasureBatchSize)
+	}
+	for _, key := range c.PIIMetadataKeys {
+		if err := domain.ValidateMetadata(domain.Metadata{key: ""}); err != nil {
+			return fmt.Errorf("ERASURE_PII_METADATA_KEYS: %w", err)
+		}
+	}
+	return nil
 }
 
 func Load() (*Config, error) {
@@ -58,9 +832,109 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("parse environment config: %w", err)
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
+// Validate rejects settings that would only fail later at request time
+func (c *Config) Validate() error {
+	if c.Local && c.IsProd() {
+		return fmt.Errorf("local mode is not allowed with ENV=production")
+	}
+
+	if err := c.CORS.validate(c.IsProd()); err != nil {
+		return fmt.Errorf("invalid cors config: %w", err)
+	}
+	if err := c.Log.validate(); err != nil {
+		return fmt.Errorf("invalid log config: %w", err)
+	}
+	if err := c.HTTP.validate(); err != nil {
+		return fmt.Errorf("invalid http config: %w", err)
+	}
+	if err := c.GRPC.validate(c.HTTP); err != nil {
+		return fmt.Errorf("invalid grpc config: %w", err)
+	}
+	if err := c.Database.validate(c.Local); err != nil {
+		return fmt.Errorf("invalid database config: %w", err)
+	}
+	if c.HTTP.WriteTimeout > 0 && c.Database.QueryTimeout > c.HTTP.WriteTimeout {
+		return fmt.Errorf("DATABASE_QUERY_TIMEOUT (%s) must not exceed HTTP_WRITE_TIMEOUT (%s)", c.Database.QueryTimeout, c.HTTP.WriteTimeout)
+	}
+	if c.HTTP.WriteTimeout > 0 && c.Database.WriteTimeout > c.HTTP.WriteTimeout {
+		return fmt.Errorf("DATABASE_WRITE_TIMEOUT (%s) must not exceed HTTP_WRITE_TIMEOUT (%s)", c.Database.WriteTimeout, c.HTTP.WriteTimeout)
+	}
+	if !c.Local && c.Redis.Enabled {
+		if err := c.Redis.validate(c.IsProd()); err != nil {
+			return fmt.Errorf("invalid redis config: %w", err)
+		}
+	}
+	if !c.Local && !c.Redis.Enabled && len(c.Auth.SigningCallers) > 0 {
+		return fmt.Errorf("SIGNING_CALLERS needs redis for replay protection, set REDIS_ENABLED=true")
+	}
+	if err := c.Idempotency.validate(); err != nil {
+		return fmt.Errorf("invalid idempotency config: %w", err)
+	}
+	if err := c.Payments.validate(); err != nil {
+		return fmt.Errorf("invalid payments config: %w", err)
+	}
+	if err := c.Privacy.validate(); err != nil {
+		return fmt.Errorf("invalid privacy config: %w", err)
+	}
+	if err := c.Auth.validate(); err != nil {
+		return fmt.Errorf("invalid auth config: %w", err)
+	}
+	if c.GRPC.Addr != "" && !c.Auth.APIKeysEnabled {
+		// gRPC callers cannot sign, without keys they would all be turned away
+		return fmt.Errorf("GRPC_ADDR needs API_KEYS_ENABLED, gRPC calls authenticate with API keys only")
+	}
+	if c.Auth.APIKeysEnabled && (c.Local || c.Admin.Token == "") {
+		return fmt.Errorf("API_KEYS_ENABLED needs postgres and an ADMIN_TOKEN to issue keys")
+	}
+	if err := c.Health.validate(); err != nil {
+		return fmt.Errorf("invalid health config: %w", err)
+	}
+	if err := c.Backfill.validate(); err != nil {
+		return fmt.Errorf("invalid backfill config: %w", err)
+	}
+	if c.Webhooks.AllowHTTP && c.IsProd() {
+		return fmt.Errorf("WEBHOOK_ALLOW_HTTP is not allowed with ENV=production")
+	}
+	if c.Webhooks.DeliveryEnabled && !c.Local {
+		if err := c.Webhooks.validate(); err != nil {
+			return fmt.Errorf("invalid webhook config: %w", err)
+		}
+	}
+	if err := c.Provider.validate(c.IsProd()); err != nil {
+		return fmt.Errorf("invalid provider config: %w", err)
+	}
+	if !c.Local {
+		if err := c.Outbox.validateMonitor(); err != nil {
+			return fmt.Errorf("invalid outbox config: %w", err)
+		}
+		if err := c.Outbox.validateRetention(); err != nil {
+			return fmt.Errorf("invalid outbox config: %w", err)
+		}
+	}
+	if c.Outbox.RelayEnabled && !c.Local {
+		if err := c.Outbox.validate(); err != nil {
+			return fmt.Errorf("invalid outbox config: %w", err)
+		}
+		switch c.Outbox.Publisher {
+		case "kafka":
+			if err := c.Kafka.validate(); err != nil {
+				return fmt.Errorf("invalid kafka config: %w", err)
+			}
+		case "nats":
+			if err := c.NATS.validate(); err != nil {
+				return fmt.Errorf("invalid nats config: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
 func (c *Config) IsProd() bool {
 	return c.Env == "production"
 }
@@ -0,0 +1,13 @@
+package domain
+
+import "time"
+
+// CustomerErased is emitted once a customer's identifiers were replaced by a pseudonym
+type CustomerErased struct {
+	Pseudonym      string
+	PaymentsErased int
+	OccurredAt     time.Time
+}
+
+func (e CustomerErased) eventType() string     { return "customer.erased" }
+func (e CustomerErased) occurredAt() time.Time { return e.OccurredAt }
@@ -0,0 +1,238 @@
+// Package domaintest fabricates valid payment aggregates for tests.
+package domaintest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+// FixedTime is the default clock for built aggregates so output is deterministic
+var FixedTime = time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// FixedID is the default id of built aggregates, tests building several
+// payments set their own with WithID
+var FixedID = mustParseID("00000000-0000-4000-8000-000000000001")
+
+func mustParseID(s string) domain.PaymentID {
+	id, err := domain.ParsePaymentID(s)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// PaymentBuilder builds a *domain.Payment through domain.Reconstitute,
+// every field has a sensible default so callers only set what they assert on
+type PaymentBuilder struct {
+	id             domain.PaymentID
+	merchantID     string
+	orderID        string
+	customerID     string
+	amountCents    int64
+	currency       string
+	status         domain.PaymentStatus
+	providerRef    string
+	failureReason  string
+	idempotencyKey string
+	clientRef      string
+	correlationID  string
+	captureMethod  domain.CaptureMethod
+	captureKey     string
+	metadata       domain.Metadata
+	createdAt      time.Time
+	updatedAt      time.Time
+	version        int
+}
+
+func NewPaymentBuilder() *PaymentBuilder {
+	return &PaymentBuilder{
+		id:             FixedID,
+		orderID:        "order-1",
+		customerID:     "customer-1",
+		amountCents:    1999,
+		currency:       "EUR",
+		status:         domain.StatusPending,
+		idempotencyKey: "idem-1",
+		correlationID:  "corr-1",
+		captureMethod:  domain.CaptureAutomatic,
+		createdAt:      FixedTime,
+		updatedAt:      FixedTime,
+		version:        1,
+	}
+}
+
+func (b *PaymentBuilder) WithID(id domain.PaymentID) *PaymentBuilder {
+	b.id = id
+	return b
+}
+
+func (b *PaymentBuilder) WithMerchantID(merchantID string) *PaymentBuilder {
+	b.merchantID = merchantID
+	return b
+}
+
+func (b *PaymentBuilder) WithOrderID(orderID string) *PaymentBuilder {
+	b.orderID = orderID
+	return b
+}
+
+func (b *PaymentBuilder) WithCustomerID(customerID string) *PaymentBuilder {
+	b.customerID = customerID
+	return b
+}
+
+func (b *PaymentBuilder) WithAmount(cents int64, currency string) *PaymentBuilder {
+	b.amountCents = cents
+	b.currency = currency
+	return b
+}
+
+func (b *PaymentBuilder) WithStatus(status domain.PaymentStatus) *PaymentBuilder {
+	b.status = status
+	return b
+}
+
+func (b *PaymentBuilder) WithProviderRef(ref string) *PaymentBuilder {
+	b.providerRef = ref
+	return b
+}
+
+func (b *PaymentBuilder) WithFailureReason(reason string) *PaymentBuilder {
+	b.failureReason = reason
+	return b
+}
+
+func (b *PaymentBuilder) WithIdempotencyKey(key string) *PaymentBuilder {
+	b.idempotencyKey = key
+	return b
+}
+
+func (b *PaymentBuilder) WithVersion(version int) *PaymentBuilder {
+	b.version = version
+	return b
+}
+
+func (b *PaymentBuilder) WithClientReference(ref string) *PaymentBuilder {
+	b.clientRef = ref
+	return b
+}
+
+func (b *PaymentBuilder) WithCorrelationID(id string) *PaymentBuilder {
+	b.correlationID = id
+	return b
+}
+
+// WithCaptureMethod only affects BuildNew, Build takes the status as given
+func (b *PaymentBuilder) WithCaptureMethod(m domain.CaptureMethod) *PaymentBuilder {
+	b.captureMethod = m
+	return b
+}
+
+func (b *PaymentBuilder) WithCaptureKey(key string) *PaymentBuilder {
+	b.captureKey = key
+	return b
+}
+
+func (b *PaymentBuilder) WithMetadata(m domain.Metadata) *PaymentBuilder {
+	b.metadata = m
+	return b
+}
+
+// WithClock sets both created and updated timestamps
+func (b *PaymentBuilder) WithClock(t time.Time) *PaymentBuilder {
+	b.createdAt = t.UTC()
+	b.updatedAt = t.UTC()
+	return b
+}
+
+// Build panics on an invalid amount, tests should fail loudly on bad fixtures
+func (b *PaymentBuilder) Build() *domain.Payment {
+	amount, err := domain.NewMoney(b.amountCents, b.currency)
+	if err != nil {
+		panic(fmt.Sprintf("domaintest: invalid fixture amount: %v", err))
+	}
+
+	return domain.Reconstitute(
+		b.id, b.merchantID, b.orderID, b.customerID, amount,
+		b.status,
+		b.providerRef, b.failureReason, b.idempotencyKey, b.clientRef, b.correlationID, b.captureKey,
+		domain.RequestHash(b.orderID, b.customerID, amount, b.clientRef, b.captureMethod),
+		b.metadata, b.createdAt, b.updatedAt, b.version,
+	)
+}
+
+// BuildNew creates the payment through domain.NewAt at the builder's clock,
+// so its PaymentInitiated event is still pending. The id, status and version
+// are assigned by domain.NewAt.
+func (b *PaymentBuilder) BuildNew() *domain.Payment {
+	amount, err := domain.NewMoney(b.amountCents, b.currency)
+	if err != nil {
+		panic(fmt.Sprintf("domaintest: invalid fixture amount: %v", err))
+	}
+
+	p, err := domain.NewAt(b.createdAt, b.merchantID, b.orderID, b.customerID, amount, b.idempotencyKey, b.clientRef, b.correlationID, b.captureMethod, b.metadata)
+	if err != nil {
+		panic(fmt.Sprintf("domaintest: invalid fixture payment: %v", err))
+	}
+	return p
+}
+
+// golden aggregates, one per status
+
+func Pending() *domain.Payment {
+	return NewPaymentBuilder().Build()
+}
+
+// Authorized is a manual-capture payment whose hold the gateway recorded,
+// created at version 1 and bumped by Authorize
+func Authorized() *domain.Payment {
+	return NewPaymentBuilder().
+		WithCaptureMethod(domain.CaptureManual).
+		WithStatus(domain.StatusAuthorized).
+		WithProviderRef("prov_123").
+		WithVersion(2).
+		Build()
+}
+
+func Processing() *domain.Payment {
+	return NewPaymentBuilder().
+		WithStatus(domain.StatusProcessing).
+		WithProviderRef("prov_123").
+		WithVersion(2).
+		Build()
+}
+
+func Completed() *domain.Payment {
+	return NewPaymentBuilder().
+		WithStatus(domain.StatusCompleted).
+		WithProviderRef("prov_123").
+		WithVersion(3).
+		Build()
+}
+
+func Failed() *domain.Payment {
+	return NewPaymentBuilder().
+		WithStatus(domain.StatusFailed).
+		WithProviderRef("prov_123").
+		WithFailureReason("card_declined").
+		WithVersion(3).
+		Build()
+}
+
+func Cancelled() *domain.Payment {
+	return NewPaymentBuilder().
+		WithStatus(domain.StatusCancelled).
+		WithFailureReason("cancelled_by_merchant").
+		WithVersion(2).
+		Build()
+}
+
+func Expired() *domain.Payment {
+	return NewPaymentBuilder().
+		WithStatus(domain.StatusExpired).
+		WithFailureReason("expired before completion").
+		WithVersion(2).
+		Build()
+}
@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventSchemaVersion is bumped when an event payload changes incompatibly,
+// consumers branch on it instead of guessing from the fields
+const EventSchemaVersion = 1
+
+// Envelope is the stable wire format of every outbox event, the payload
+// is the event itself
+type Envelope struct {
+	EventID       string          `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	SchemaVersion int             `json:"schema_version"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	AggregateID   string          `json:"aggregate_id"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// NewEnvelope wraps e for the aggregate it belongs to under a new event id
+func NewEnvelope(aggregateID string, e Event) (Envelope, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("marshal event %s: %w", e.eventType(), err)
+	}
+	return Envelope{
+		EventID:       uuid.NewString(),
+		EventType:     e.eventType(),
+		SchemaVersion: EventSchemaVersion,
+		OccurredAt:    e.occurredAt().UTC(),
+		AggregateID:   aggregateID,
+		Payload:       payload,
+	}, nil
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// SystemActor is the actor of transitions nobody asked for, e.g. a sweeper
+const SystemActor = "system"
+
+// StatusChange is one entry of a payment's status history
+type StatusChange struct {
+	PaymentID string
+	// empty for the status the payment was created in
+	From       PaymentStatus
+	To         PaymentStatus
+	Reason     string
+	Actor      string
+	OccurredAt time.Time
+}
+
+// StatusChanges picks the status transitions out of a payment's pending
+// events in the order they happened, so repositories record the history
+// without comparing stored and new state
+func StatusChanges(events []Event, actor string) []StatusChange {
+	var changes []StatusChange
+	for _, e := range events {
+		c := StatusChange{Actor: actor, OccurredAt: e.occurredAt()}
+		switch e := e.(type) {
+		case PaymentInitiated:
+			c.PaymentID, c.To = e.PaymentID, StatusPending
+			if e.CaptureMethod == CaptureManual {
+				c.To = StatusAuthorized
+			}
+		case PaymentCaptured:
+			c.PaymentID, c.From, c.To = e.PaymentID, e.from, StatusProcessing
+		case PaymentProcessing:
+			c.PaymentID, c.From, c.To = e.PaymentID, e.from, StatusProcessing
+		case PaymentCompleted:
+			c.PaymentID, c.From, c.To = e.PaymentID, e.from, StatusCompleted
+		case PaymentFailed:
+			c.PaymentID, c.From, c.To, c.Reason = e.PaymentID, e.from, StatusFailed, e.Reason
+		case PaymentCancelled:
+			c.PaymentID, c.From, c.To, c.Reason = e.PaymentID, e.from, StatusCancelled, e.Reason
+		case PaymentExpired:
+			c.PaymentID, c.From, c.To, c.Reason = e.PaymentID, e.from, StatusExpired, expiredReason
+		default:
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+type actorKey struct{}
+
+// WithActor names who causes the transitions saved under ctx
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns SystemActor when no actor was set
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+type StatusHistoryRepository interface {
+	// StatusHistory returns the recorded transitions of a payment, oldest
+	// first. Transitions from before the history was kept are missing.
+	StatusHistory(ctx context.Context, id PaymentID) ([]StatusChange, error)
+}
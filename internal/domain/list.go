@@ -0,0 +1,83 @@
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor means a pagination cursor was not issued by List
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+// ListFilter narrows List, zero fields do not filter
+type ListFilter struct {
+	CustomerID string
+	Status     PaymentStatus
+	// ClientReference matches the merchant's reference exactly
+	ClientReference string
+	Currency        string
+	CreatedAfter    time.Time
+	CreatedBefore   time.Time
+
+	// After continues from the cursor of a previous page
+	After Cursor
+	Limit int
+}
+
+// Cursor is the keyset position of the last payment on a page, pages are
+// ordered by (created_at, id) descending so ties on created_at are stable
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+func (c Cursor) IsZero() bool { return c.ID == "" }
+
+// NewCursor returns the cursor that continues after p
+func NewCursor(p *Payment) Cursor {
+	return Cursor{CreatedAt: p.CreatedAt(), ID: p.ID().String()}
+}
+
+// Encode is opaque to callers, only DecodeCursor reads it back
+func (c Cursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor treats an empty string as the first page
+func DecodeCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: not base64", ErrInvalidCursor)
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return Cursor{}, fmt.Errorf("%w: malformed", ErrInvalidCursor)
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: bad timestamp", ErrInvalidCursor)
+	}
+	if _, err := ParsePaymentID(id); err != nil {
+		return Cursor{}, fmt.Errorf("%w: bad payment id", ErrInvalidCursor)
+	}
+	return Cursor{CreatedAt: ts, ID: id}, nil
+}
+
+// Precedes reports whether the cursor position comes before p in list order
+func (c Cursor) Precedes(p *Payment) bool {
+	if c.IsZero() {
+		return true
+	}
+	if !p.CreatedAt().Equal(c.CreatedAt) {
+		return p.CreatedAt().Before(c.CreatedAt)
+	}
+	return p.ID().String() < c.ID
+}
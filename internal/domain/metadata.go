@@ -0,0 +1,114 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// limits on merchant metadata, lengths count characters, not bytes
+const (
+	MaxMetadataKeys        = 20
+	MaxMetadataKeyLength   = 40
+	MaxMetadataValueLength = 500
+)
+
+// reservedMetadataPrefix is kept for keys the service may set itself
+const reservedMetadataPrefix = "gopay_"
+
+// reservedMetadataKeys name fields the payment already has, metadata must
+// not be mistaken for them by consumers merging the two
+var reservedMetadataKeys = map[string]bool{
+	"id":              true,
+	"payment_id":      true,
+	"order_id":        true,
+	"customer_id":     true,
+	"amount":          true,
+	"currency":        true,
+	"status":          true,
+	"idempotency_key": true,
+	"correlation_id":  true,
+}
+
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// Metadata is free-form key/value data a merchant attaches to a payment
+type Metadata map[string]string
+
+// ValidateMetadata checks the keys and values of m and the number of keys
+func ValidateMetadata(m Metadata) error {
+	if len(m) > MaxMetadataKeys {
+		return fmt.Errorf("%w: at most %d keys, got %d", ErrInvalidMetadata, MaxMetadataKeys, len(m))
+	}
+	for k, v := range m {
+		if err := validateMetadataKey(k); err != nil {
+			return err
+		}
+		if !utf8.ValidString(v) {
+			return fmt.Errorf("%w: value of %q is not valid UTF-8", ErrInvalidMetadata, k)
+		}
+		// postgres text and jsonb cannot hold NUL
+		if strings.ContainsRune(v, 0) {
+			return fmt.Errorf("%w: value of %q must not contain NUL", ErrInvalidMetadata, k)
+		}
+		if n := utf8.RuneCountInString(v); n > MaxMetadataValueLength {
+			return fmt.Errorf("%w: value of %q must be at most %d characters, got %d", ErrInvalidMetadata, k, MaxMetadataValueLength, n)
+		}
+	}
+	return nil
+}
+
+func validateMetadataKey(k string) error {
+	switch {
+	case k == "" || strings.TrimSpace(k) != k:
+		return fmt.Errorf("%w: key %q must be non-empty without surrounding spaces", ErrInvalidMetadata, k)
+	case !utf8.ValidString(k):
+		return fmt.Errorf("%w: key %q is not valid UTF-8", ErrInvalidMetadata, k)
+	case utf8.RuneCountInString(k) > MaxMetadataKeyLength:
+		return fmt.Errorf("%w: key %q must be at most %d characters", ErrInvalidMetadata, k, MaxMetadataKeyLength)
+	case strings.IndexFunc(k, unicode.IsControl) >= 0:
+		return fmt.Errorf("%w: key %q must not contain control characters", ErrInvalidMetadata, k)
+	case reservedMetadataKeys[strings.ToLower(k)] || strings.HasPrefix(strings.ToLower(k), reservedMetadataPrefix):
+		return fmt.Errorf("%w: key %q is reserved", ErrInvalidMetadata, k)
+	}
+	return nil
+}
+
+// Metadata returns a copy, changes go through UpdateMetadata
+func (p *Payment) Metadata() Metadata { return maps.Clone(p.metadata) }
+
+// UpdateMetadata sets the keys of set and deletes the keys in remove. The
+// result is validated as a whole, a failed update leaves the payment as it
+// was. An update that changes nothing bumps no version.
+func (p *Payment) UpdateMetadata(set Metadata, remove []string) error {
+	next := maps.Clone(p.metadata)
+	if next == nil {
+		next = Metadata{}
+	}
+	for _, k := range remove {
+		delete(next, k)
+	}
+	maps.Copy(next, set)
+	if err := ValidateMetadata(next); err != nil {
+		return err
+	}
+	if maps.Equal(next, p.metadata) {
+		return nil
+	}
+
+	p.metadata = next
+	p.updatedAt = time.Now().UTC()
+	p.version++
+	p.events = append(p.events, PaymentMetadataUpdated{
+		PaymentID:       p.id.String(),
+		CorrelationID:   p.correlationID,
+		ClientReference: p.clientReference,
+		Metadata:        maps.Clone(next),
+		OccurredAt:      p.updatedAt,
+	})
+	return nil
+}
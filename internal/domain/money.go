@@ -0,0 +1,215 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrCurrencyMismatch is arithmetic on amounts of different currencies
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+
+	// ErrNegativeMoney is a subtraction that would go below zero
+	ErrNegativeMoney = errors.New("amount would be negative")
+
+	// ErrMoneyOverflow is a sum beyond what an int64 of minor units holds
+	ErrMoneyOverflow = errors.New("amount overflows")
+)
+
+// currencyMinorUnits maps the active ISO 4217 codes to the number of
+// decimal places of their minor unit
+var currencyMinorUnits = map[string]int{
+	"AED": 2, "AFN": 2, "ALL": 2, "AMD": 2, "ANG": 2, "AOA": 2, "ARS": 2, "AUD": 2, "AWG": 2, "AZN": 2,
+	"BAM": 2, "BBD": 2, "BDT": 2, "BGN": 2, "BHD": 3, "BIF": 0, "BMD": 2, "BND": 2, "BOB": 2, "BRL": 2,
+	"BSD": 2, "BTN": 2, "BWP": 2, "BYN": 2, "BZD": 2, "CAD": 2, "CDF": 2, "CHF": 2, "CLP": 0, "CNY": 2,
+	"COP": 2, "CRC": 2, "CUP": 2, "CVE": 2, "CZK": 2, "DJF": 0, "DKK": 2, "DOP": 2, "DZD": 2, "EGP": 2,
+	"ERN": 2, "ETB": 2, "EUR": 2, "FJD": 2, "FKP": 2, "GBP": 2, "GEL": 2, "GHS": 2, "GIP": 2, "GMD": 2,
+	"GNF": 0, "GTQ": 2, "GYD": 2, "HKD": 2, "HNL": 2, "HTG": 2, "HUF": 2, "IDR": 2, "ILS": 2, "INR": 2,
+	"IQD": 3, "IRR": 2, "ISK": 0, "JMD": 2, "JOD": 3, "JPY": 0, "KES": 2, "KGS": 2, "KHR": 2, "KMF": 0,
+	"KPW": 2, "KRW": 0, "KWD": 3, "KYD": 2, "KZT": 2, "LAK": 2, "LBP": 2, "LKR": 2, "LRD": 2, "LSL": 2,
+	"LYD": 3, "MAD": 2, "MDL": 2, "MGA": 2, "MKD": 2, "MMK": 2, "MNT": 2, "MOP": 2, "MRU": 2, "MUR": 2,
+	"MVR": 2, "MWK": 2, "MXN": 2, "MYR": 2, "MZN": 2, "NAD": 2, "NGN": 2, "NIO": 2, "NOK": 2, "NPR": 2,
+	"NZD": 2, "OMR": 3, "PAB": 2, "PEN": 2, "PGK": 2, "PHP": 2, "PKR": 2, "PLN": 2, "PYG": 0, "QAR": 2,
+	"RON": 2, "RSD": 2, "RUB": 2, "RWF": 0, "SAR": 2, "SBD": 2, "SCR": 2, "SDG": 2, "SEK": 2, "SGD": 2,
+	"SHP": 2, "SLE": 2, "SOS": 2, "SRD": 2, "SSP": 2, "STN": 2, "SVC": 2, "SYP": 2, "SZL": 2, "THB": 2,
+	"TJS": 2, "TMT": 2, "TND": 3, "TOP": 2, "TRY": 2, "TTD": 2, "TWD": 2, "TZS": 2, "UAH": 2, "UGX": 0,
+	"USD": 2, "UYU": 2, "UZS": 2, "VES": 2, "VND": 0, "VUV": 0, "WST": 2, "XAF": 0, "XCD": 2, "XOF": 0,
+	"XPF": 0, "YER": 2, "ZAR": 2, "ZMW": 2, "ZWG": 2,
+}
+
+// IsCurrency reports whether code is an active ISO 4217 code
+func IsCurrency(code string) bool {
+	_, ok := currencyMinorUnits[code]
+	return ok
+}
+
+// MinorUnits returns the decimal places of the currency's minor unit
+func MinorUnits(code string) (int, bool) {
+	n, ok := currencyMinorUnits[code]
+	return n, ok
+}
+
+// Currencies returns every known ISO 4217 code, sorted
+func Currencies() []string {
+	codes := make([]string, 0, len(currencyMinorUnits))
+	for code := range currencyMinorUnits {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// Money is an amount in the minor unit of an ISO 4217 currency. It is
+// immutable, arithmetic returns a new value and never goes below zero.
+type Money struct {
+	amount   int64
+	currency string
+}
+
+func NewMoney(amount int64, currency string) (Money, error) {
+	if amount <= 0 {
+		return Money{}, fmt.Errorf("amount must be positive, got %d", amount)
+	}
+	c := strings.ToUpper(strings.TrimSpace(currency))
+	if !IsCurrency(c) {
+		return Money{}, fmt.Errorf("currency must be an ISO 4217 code, got %q", c)
+	}
+	return Money{amount: amount, currency: c}, nil
+}
+
+// ZeroMoney is the starting point of a sum, NewMoney only takes positive amounts
+func ZeroMoney(currency string) (Money, error) {
+	c := strings.ToUpper(strings.TrimSpace(currency))
+	if !IsCurrency(c) {
+		return Money{}, fmt.Errorf("currency must be an ISO 4217 code, got %q", c)
+	}
+	return Money{currency: c}, nil
+}
+
+func (m Money) Amount() int64    { return m.amount }
+func (m Money) Currency() string { return m.currency }
+func (m Money) String() string   { return m.Format() }
+
+// Format prints the amount in major units, e.g. "10.00 USD" or "1000 JPY"
+func (m Money) Format() string {
+	digits := currencyMinorUnits[m.currency]
+	if digits == 0 {
+		return strconv.FormatInt(m.amount, 10) + " " + m.currency
+	}
+
+	scale := int64(1)
+	for range digits {
+		scale *= 10
+	}
+	return fmt.Sprintf("%d.%0*d %s", m.amount/scale, digits, m.amount%scale, m.currency)
+}
+
+// MajorUnits is the amount in major units for display and reporting, sums
+// of money stay in minor units
+func (m Money) MajorUnits() float64 {
+	digits := currencyMinorUnits[m.currency]
+	scale := 1.0
+	for range digits {
+		scale *= 10
+	}
+	return float64(m.amount) / scale
+}
+
+func (m Money) IsZero() bool { return m.amount == 0 }
+
+func (m Money) sameCurrency(o Money) error {
+	if m.currency != o.currency {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency, o.currency)
+	}
+	return nil
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	if m.amount > math.MaxInt64-o.amount {
+		return Money{}, fmt.Errorf("%w: %s + %s", ErrMoneyOverflow, m, o)
+	}
+	return Money{amount: m.amount + o.amount, currency: m.currency}, nil
+}
+
+// Sub returns m - o, ErrNegativeMoney when o is larger
+func (m Money) Sub(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	if o.amount > m.amount {
+		return Money{}, fmt.Errorf("%w: %s - %s", ErrNegativeMoney, m, o)
+	}
+	return Money{amount: m.amount - o.amount, currency: m.currency}, nil
+}
+
+// Compare returns -1, 0 or +1 as m is less than, equal to or greater than o
+func (m Money) Compare(o Money) (int, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.amount < o.amount:
+		return -1, nil
+	case m.amount > o.amount:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Allocate splits m by ratios without losing or creating a minor unit. The
+// cents left after the proportional shares go one each to the largest
+// remainders, earlier ratios first on a tie. It returns nil when ratios is
+// empty, has a negative entry or sums to zero or beyond an int64.
+func (m Money) Allocate(ratios []int) []Money {
+	var total uint64
+	for _, r := range ratios {
+		if r < 0 || uint64(r) > math.MaxInt64-total {
+			return nil
+		}
+		total += uint64(r)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	shares := make([]Money, len(ratios))
+	remainders := make([]uint64, len(ratios))
+	left := m.amount
+	for i, r := range ratios {
+		// amount*r/total never exceeds amount, only the product needs 128 bits
+		hi, lo := bits.Mul64(uint64(m.amount), uint64(r))
+		share, rem := bits.Div64(hi, lo, total)
+		shares[i] = Money{amount: int64(share), currency: m.currency}
+		remainders[i] = rem
+		left -= int64(share)
+	}
+
+	order := make([]int, len(ratios))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		switch {
+		case remainders[a] > remainders[b]:
+			return -1
+		case remainders[a] < remainders[b]:
+			return 1
+		default:
+			return 0
+		}
+	})
+	// left is below len(ratios), every share was rounded down by under a cent
+	for _, i := range order[:left] {
+		shares[i].amount++
+	}
+	return shares
+}
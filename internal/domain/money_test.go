@@ -0,0 +1,211 @@
+package domain_test
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+)
+
+func money(t *testing.T, amount int64, currency string) domain.Money {
+	t.Helper()
+	if amount == 0 {
+		m, err := domain.ZeroMoney(currency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	m, err := domain.NewMoney(amount, currency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMoneyAdd(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    int64
+		bCur    string
+		want    int64
+		wantErr error
+	}{
+		{name: "sum", a: 1000, b: 999, bCur: "EUR", want: 1999},
+		{name: "zero", a: 0, b: 250, bCur: "EUR", want: 250},
+		{name: "up to max int64", a: math.MaxInt64 - 1, b: 1, bCur: "EUR", want: math.MaxInt64},
+		{name: "one past max int64", a: math.MaxInt64, b: 1, bCur: "EUR", wantErr: domain.ErrMoneyOverflow},
+		{name: "max plus max", a: math.MaxInt64, b: math.MaxInt64, bCur: "EUR", wantErr: domain.ErrMoneyOverflow},
+		{name: "currency mismatch", a: 1000, b: 1000, bCur: "USD", wantErr: domain.ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := money(t, tt.a, "EUR"), money(t, tt.b, tt.bCur)
+			got, err := a.Add(b)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Amount() != tt.want || got.Currency() != "EUR" {
+				t.Fatalf("got %d %s, want %d EUR", got.Amount(), got.Currency(), tt.want)
+			}
+			if a.Amount() != tt.a || b.Amount() != tt.b {
+				t.Fatal("Add changed an operand")
+			}
+		})
+	}
+}
+
+func TestMoneySub(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    int64
+		bCur    string
+		want    int64
+		wantErr error
+	}{
+		{name: "difference", a: 1999, b: 999, bCur: "EUR", want: 1000},
+		{name: "down to zero", a: 1000, b: 1000, bCur: "EUR", want: 0},
+		{name: "zero minus zero", a: 0, b: 0, bCur: "EUR", want: 0},
+		{name: "max int64 minus itself", a: math.MaxInt64, b: math.MaxInt64, bCur: "EUR", want: 0},
+		{name: "below zero", a: 999, b: 1000, bCur: "EUR", wantErr: domain.ErrNegativeMoney},
+		{name: "zero minus max int64", a: 0, b: math.MaxInt64, bCur: "EUR", wantErr: domain.ErrNegativeMoney},
+		{name: "currency mismatch", a: 1000, b: 1, bCur: "JPY", wantErr: domain.ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := money(t, tt.a, "EUR"), money(t, tt.b, tt.bCur)
+			got, err := a.Sub(b)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Amount() != tt.want || got.IsZero() != (tt.want == 0) {
+				t.Fatalf("got %d, want %d", got.Amount(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyCompare(t *testing.T) {
+	tests := []struct {
+		a, b int64
+		want int
+	}{
+		{1, 2, -1},
+		{2, 2, 0},
+		{math.MaxInt64, 1, 1},
+		{0, math.MaxInt64, -1},
+	}
+	for _, tt := range tests {
+		got, err := money(t, tt.a, "GBP").Compare(money(t, tt.b, "GBP"))
+		if err != nil || got != tt.want {
+			t.Errorf("Compare(%d, %d) = %d, %v, want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+	if _, err := money(t, 1, "GBP").Compare(money(t, 1, "EUR")); !errors.Is(err, domain.ErrCurrencyMismatch) {
+		t.Errorf("err = %v, want ErrCurrencyMismatch", err)
+	}
+}
+
+func TestMoneyAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int64
+		ratios []int
+		want   []int64
+	}{
+		{name: "even split", amount: 100, ratios: []int{1, 1}, want: []int64{50, 50}},
+		{name: "thirds", amount: 100, ratios: []int{1, 1, 1}, want: []int64{34, 33, 33}},
+		{name: "largest remainder wins", amount: 6, ratios: []int{3, 7}, want: []int64{2, 4}},
+		{name: "tie goes to the earlier ratio", amount: 1, ratios: []int{1, 1, 1}, want: []int64{1, 0, 0}},
+		{name: "zero ratio gets nothing", amount: 10, ratios: []int{0, 1, 1}, want: []int64{0, 5, 5}},
+		{name: "single ratio takes all", amount: 1999, ratios: []int{42}, want: []int64{1999}},
+		{name: "fewer cents than ratios", amount: 2, ratios: []int{1, 1, 1, 1}, want: []int64{1, 1, 0, 0}},
+		{name: "zero amount", amount: 0, ratios: []int{1, 2}, want: []int64{0, 0}},
+		{name: "max int64 halves", amount: math.MaxInt64, ratios: []int{1, 1}, want: []int64{math.MaxInt64/2 + 1, math.MaxInt64 / 2}},
+		{name: "max int64 by large ratios", amount: math.MaxInt64, ratios: []int{math.MaxInt32, math.MaxInt32, 1},
+			want: []int64{4611686017353646079, 4611686017353646079, 2147483649}},
+		{name: "max ratio", amount: 10, ratios: []int{math.MaxInt64}, want: []int64{10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares := money(t, tt.amount, "EUR").Allocate(tt.ratios)
+			got := make([]int64, len(shares))
+			for i, s := range shares {
+				got[i] = s.Amount()
+				if s.Currency() != "EUR" {
+					t.Fatalf("share %d is in %s", i, s.Currency())
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Allocate(%d, %v) = %v, want %v", tt.amount, tt.ratios, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyAllocateRejects(t *testing.T) {
+	m := money(t, 100, "EUR")
+	for name, ratios := range map[string][]int{
+		"no ratios":           nil,
+		"negative ratio":      {1, -1},
+		"zero total":          {0, 0},
+		"total beyond int64":  {math.MaxInt64, 1},
+		"max ratios overflow": {math.MaxInt64, math.MaxInt64},
+	} {
+		if shares := m.Allocate(ratios); shares != nil {
+			t.Errorf("%s: Allocate(%v) = %v, want nil", name, ratios, shares)
+		}
+	}
+}
+
+// every split keeps every cent, whatever the amount and ratios
+func TestMoneyAllocateConservesCents(t *testing.T) {
+	amounts := []int64{0, 1, 2, 3, 7, 99, 100, 1001, 123456789, math.MaxInt64 / 3, math.MaxInt64 - 1, math.MaxInt64}
+	ratioSets := [][]int{
+		{1}, {1, 1}, {1, 2}, {2, 1}, {1, 1, 1}, {3, 3, 3, 1}, {0, 1}, {7, 11, 13, 17, 19},
+		{1, 0, 0, 0, 0, 0, 0, 1}, {100, 1}, {math.MaxInt32, 1}, {math.MaxInt64 / 2, math.MaxInt64 / 2},
+	}
+
+	for _, amount := range amounts {
+		for _, ratios := range ratioSets {
+			shares := money(t, amount, "JPY").Allocate(ratios)
+			if len(shares) != len(ratios) {
+				t.Fatalf("Allocate(%d, %v) returned %d shares", amount, ratios, len(shares))
+			}
+
+			sum := money(t, 0, "JPY")
+			for i, s := range shares {
+				if s.Amount() < 0 {
+					t.Fatalf("Allocate(%d, %v) share %d is negative", amount, ratios, i)
+				}
+				if ratios[i] == 0 && !s.IsZero() {
+					t.Fatalf("Allocate(%d, %v) gave %d to a zero ratio", amount, ratios, s.Amount())
+				}
+				var err error
+				if sum, err = sum.Add(s); err != nil {
+					t.Fatalf("Allocate(%d, %v): shares overflow: %v", amount, ratios, err)
+				}
+			}
+			if sum.Amount() != amount {
+				t.Fatalf("Allocate(%d, %v) shares sum to %d", amount, ratios, sum.Amount())
+			}
+		}
+	}
+}
@@ -1,8 +1,14 @@
 package domain
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"maps"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,9 +20,46 @@ var (
 
 	ErrVersionConflict = errors.New("payment version conflict")
 
+	// ErrVersionInvariant means an aggregate reached Save with a version that
+	// no load-and-transition sequence could produce, a bug rather than a race
+	ErrVersionInvariant = errors.New("payment version invariant violated")
+
 	ErrInvalidTransition = errors.New("invalid payment status transition")
+
+	// ErrAlreadyCaptured is a capture retried under a different idempotency key
+	ErrAlreadyCaptured = errors.New("payment already captured")
+
+	// ErrDuplicateIdempotencyKey is a new payment whose idempotency key another
+	// payment already holds, returned as a DuplicateIdempotencyKeyError
+	ErrDuplicateIdempotencyKey = errors.New("duplicate idempotency key")
 )
 
+// DuplicateIdempotencyKeyError carries the payment that won the key, so a
+// lost insert race can be answered as a replay
+type DuplicateIdempotencyKeyError struct {
+	Existing *Payment
+}
+
+func (e *DuplicateIdempotencyKeyError) Error() string {
+	return fmt.Sprintf("%s: held by payment %s", ErrDuplicateIdempotencyKey, e.Existing.ID())
+}
+
+func (e *DuplicateIdempotencyKeyError) Unwrap() error { return ErrDuplicateIdempotencyKey }
+
+// VersionConflictError is a change the caller made conditional on a version
+// the payment no longer has. Reloading cannot resolve it, the caller has to
+// look at the current payment first.
+type VersionConflictError struct {
+	Expected int
+	Current  int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s: expected version %d, current version is %d", ErrVersionConflict, e.Expected, e.Current)
+}
+
+func (e *VersionConflictError) Unwrap() error { return ErrVersionConflict }
+
 type PaymentID struct{ value string }
 
 func NewPaymentID() PaymentID { return PaymentID{value: uuid.New().String()} }
@@ -30,69 +73,256 @@ func ParsePaymentID(s string) (PaymentID, error) {
 
 func (id PaymentID) String() string { return id.value }
 
-type Money struct {
-	amount   int64
-	currency string
-}
+// merchant supplied reference echoed to the PSP, immutable after creation
+var clientReferencePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
 
-func NewMoney(amount int64, currency string) (Money, error) {
-	if amount <= 0 {
-		return Money{}, fmt.Errorf("amount must be positive, got %d", amount)
-	}
-	c := strings.ToUpper(strings.TrimSpace(currency))
-	if len(c) != 3 {
-		return Money{}, fmt.Errorf("currency must be a 3-letter, got %q", c)
+// ValidateClientReference accepts an empty reference as "not supplied"
+func ValidateClientReference(ref string) error {
+	if ref == "" || clientReferencePattern.MatchString(ref) {
+		return nil
 	}
-	return Money{amount: amount, currency: c}, nil
+	return fmt.Errorf("client_reference must be at most 64 characters of letters, digits, dash or underscore, got %q", ref)
 }
 
-func (m Money) Amount() int64    { return m.amount }
-func (m Money) Currency() string { return m.currency }
-func (m Money) String() string   { return fmt.Sprintf("%d %s", m.amount, m.currency) }
+// correlation ids are adopted from upstream tracing headers, so the
+// alphabet is wider than client_reference
+var correlationIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// ValidateCorrelationID accepts an empty id as "generate one"
+func ValidateCorrelationID(id string) error {
+	if id == "" || correlationIDPattern.MatchString(id) {
+		return nil
+	}
+	return fmt.Errorf("correlation_id must be at most 128 characters of letters, digits, dot, colon, dash or underscore, got %q", id)
+}
 
 type PaymentStatus string
 
 const (
 	StatusPending    PaymentStatus = "PENDING"
+	StatusAuthorized PaymentStatus = "AUTHORIZED"
 	StatusProcessing PaymentStatus = "PROCESSING"
 	StatusCompleted  PaymentStatus = "COMPLETED"
 	StatusFailed     PaymentStatus = "FAILED"
+	StatusCancelled  PaymentStatus = "CANCELLED"
+	// a pending payment nobody moved on within the expiry window
+	StatusExpired PaymentStatus = "EXPIRED"
 )
 
+// ParsePaymentStatus accepts the upper-case status names
+func ParsePaymentStatus(s string) (PaymentStatus, error) {
+	switch st := PaymentStatus(s); st {
+	case StatusPending, StatusAuthorized, StatusProcessing, StatusCompleted, StatusFailed, StatusCancelled, StatusExpired:
+		return st, nil
+	default:
+		return "", fmt.Errorf("unknown payment status %q", s)
+	}
+}
+
+// transitions lists the statuses reachable from each status,
+// COMPLETED, FAILED, CANCELLED and EXPIRED are terminal
+var transitions = map[PaymentStatus][]PaymentStatus{
+	StatusPending:    {StatusProcessing, StatusFailed, StatusCancelled, StatusExpired},
+	StatusAuthorized: {StatusProcessing, StatusFailed, StatusCancelled},
+	StatusProcessing: {StatusCompleted, StatusFailed, StatusCancelled},
+}
+
+// CaptureMethod decides whether funds are taken right away or held for a capture call
+type CaptureMethod string
+
+const (
+	CaptureAutomatic CaptureMethod = "automatic"
+	// manual payments start AUTHORIZED and wait for Capture
+	CaptureManual CaptureMethod = "manual"
+)
+
+// ParseCaptureMethod treats an empty method as automatic
+func ParseCaptureMethod(s string) (CaptureMethod, error) {
+	switch CaptureMethod(s) {
+	case "", CaptureAutomatic:
+		return CaptureAutomatic, nil
+	case CaptureManual:
+		return CaptureManual, nil
+	default:
+		return "", fmt.Errorf("capture_method must be automatic or manual, got %q", s)
+	}
+}
+
+// CanTransition reports whether a payment may move from one status to another
+func CanTransition(from, to PaymentStatus) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
 type Event interface {
 	eventType() string
+	occurredAt() time.Time
 }
 
 type PaymentInitiated struct {
-	PaymentID  string
-	OrderID    string
-	Amount     int64
-	Currency   string
-	OccurredAt time.Time
+	PaymentID       string
+	OrderID         string
+	ClientReference string
+	CorrelationID   string
+	CaptureMethod   CaptureMethod
+	Amount          int64
+	Currency        string
+	Metadata        Metadata
+	OccurredAt      time.Time
+}
+
+func (e PaymentInitiated) eventType() string     { return "payment.initiated" }
+func (e PaymentInitiated) occurredAt() time.Time { return e.OccurredAt }
+
+type PaymentCaptured struct {
+	PaymentID       string
+	CorrelationID   string
+	ClientReference string
+	Amount          int64
+	Currency        string
+	OccurredAt      time.Time
+
+	// the status before, kept off the wire
+	from PaymentStatus
 }
 
-func (e PaymentInitiated) eventType() string { return "payment.initiated" }
+func (e PaymentCaptured) eventType() string     { return "payment.captured" }
+func (e PaymentCaptured) occurredAt() time.Time { return e.OccurredAt }
+
+type PaymentAuthorized struct {
+	PaymentID       string
+	CorrelationID   string
+	ClientReference string
+	ProviderRef     string
+	OccurredAt      time.Time
+}
+
+func (e PaymentAuthorized) eventType() string     { return "payment.authorized" }
+func (e PaymentAuthorized) occurredAt() time.Time { return e.OccurredAt }
+
+type PaymentProcessing struct {
+	PaymentID       string
+	CorrelationID   string
+	ClientReference string
+	ProviderRef     string
+	OccurredAt      time.Time
+
+	// the status before, kept off the wire
+	from PaymentStatus
+}
+
+func (e PaymentProcessing) eventType() string     { return "payment.processing" }
+func (e PaymentProcessing) occurredAt() time.Time { return e.OccurredAt }
+
+type PaymentCompleted struct {
+	PaymentID       string
+	CorrelationID   string
+	ClientReference string
+	ProviderRef     string
+	Amount          int64
+	Currency        string
+	OccurredAt      time.Time
+
+	// the status before, kept off the wire
+	from PaymentStatus
+}
+
+func (e PaymentCompleted) eventType() string     { return "payment.completed" }
+func (e PaymentCompleted) occurredAt() time.Time { return e.OccurredAt }
+
+type PaymentFailed struct {
+	PaymentID       string
+	CorrelationID   string
+	ClientReference string
+	ProviderRef     string
+	Reason          string
+	OccurredAt      time.Time
+
+	// the status before, kept off the wire
+	from PaymentStatus
+}
+
+func (e PaymentFailed) eventType() string     { return "payment.failed" }
+func (e PaymentFailed) occurredAt() time.Time { return e.OccurredAt }
+
+type PaymentCancelled struct {
+	PaymentID       string
+	CorrelationID   string
+	ClientReference string
+	ProviderRef     string
+	Reason          string
+	OccurredAt      time.Time
+
+	// the status before, kept off the wire
+	from PaymentStatus
+}
+
+func (e PaymentCancelled) eventType() string     { return "payment.cancelled" }
+func (e PaymentCancelled) occurredAt() time.Time { return e.OccurredAt }
+
+type PaymentExpired struct {
+	PaymentID       string
+	CorrelationID   string
+	ClientReference string
+	OccurredAt      time.Time
+
+	// the status before, kept off the wire
+	from PaymentStatus
+}
+
+func (e PaymentExpired) eventType() string     { return "payment.expired" }
+func (e PaymentExpired) occurredAt() time.Time { return e.OccurredAt }
+
+// PaymentMetadataUpdated carries the metadata after the update
+type PaymentMetadataUpdated struct {
+	PaymentID       string
+	CorrelationID   string
+	ClientReference string
+	Metadata        Metadata
+	OccurredAt      time.Time
+}
+
+func (e PaymentMetadataUpdated) eventType() string     { return "payment.metadata_updated" }
+func (e PaymentMetadataUpdated) occurredAt() time.Time { return e.OccurredAt }
 
 func EventType(e Event) string { return e.eventType() }
 
 type Payment struct {
-	id             PaymentID
-	orderID        string
-	customerID     string
-	amount         Money
-	status         PaymentStatus
-	providerRef    string // gateway transaction id, set when processing
-	failureReason  string
-	idempotencyKey string // deduplication key
-	createdAt      time.Time
-	updatedAt      time.Time
+	id              PaymentID
+	merchantID      string // owner, empty for payments of platform callers
+	orderID         string
+	customerID      string
+	amount          Money
+	status          PaymentStatus
+	providerRef     string // gateway transaction id, set when processing
+	failureReason   string // why the payment failed or was cancelled
+	idempotencyKey  string // deduplication key
+	clientReference string // merchant reference, optional
+	correlationID   string // joins logs, events and provider calls for this payment
+	captureKey      string // idempotency key of the capture call, manual capture only
+	requestHash     string // fingerprint of the creating request, see RequestHash
+	metadata        Metadata
+	createdAt       time.Time
+	updatedAt       time.Time
 
 	version int
 
 	events []Event
 }
 
-func New(orderID, customerID string, amount Money, idempotencyKey string) (*Payment, error) {
+// New creates a pending payment, or an authorized one awaiting capture for
+// CaptureManual. An empty merchantID is a payment of a platform caller, an
+// empty correlationID gets a fresh one, metadata may be nil.
+func New(merchantID, orderID, customerID string, amount Money, idempotencyKey, clientReference, correlationID string, capture CaptureMethod, metadata Metadata) (*Payment, error) {
+	return NewAt(time.Now(), merchantID, orderID, customerID, amount, idempotencyKey, clientReference, correlationID, capture, metadata)
+}
+
+// NewAt is New with the creation time given, for fixtures and imports
+func NewAt(at time.Time, merchantID, orderID, customerID string, amount Money, idempotencyKey, clientReference, correlationID string, capture CaptureMethod, metadata Metadata) (*Payment, error) {
 	if strings.TrimSpace(orderID) == "" {
 		return nil, errors.New("orderID is required")
 	}
@@ -102,42 +332,268 @@ func New(orderID, customerID string, amount Money, idempotencyKey string) (*Paym
 	if strings.TrimSpace(idempotencyKey) == "" {
 		return nil, errors.New("idempotencyKey is required")
 	}
+	if err := ValidateClientReference(clientReference); err != nil {
+		return nil, err
+	}
+	if err := ValidateCorrelationID(correlationID); err != nil {
+		return nil, err
+	}
+	if err := ValidateMetadata(metadata); err != nil {
+		return nil, err
+	}
 
-	now := time.Now().UTC()
+	if correlationID == "" {
+		correlationID = uuid.New().String()
+	}
+
+	status := StatusPending
+	switch capture {
+	case CaptureAutomatic:
+	case CaptureManual:
+		status = StatusAuthorized
+	default:
+		return nil, fmt.Errorf("unknown capture method %q", capture)
+	}
+
+	now := at.UTC()
 	p := &Payment{
-		id:             NewPaymentID(),
-		orderID:        orderID,
-		customerID:     customerID,
-		amount:         amount,
-		status:         StatusPending,
-		idempotencyKey: idempotencyKey,
-		createdAt:      now,
-		updatedAt:      now,
-		version:        1,
+		id:              NewPaymentID(),
+		merchantID:      merchantID,
+		orderID:         orderID,
+		customerID:      customerID,
+		amount:          amount,
+		status:          status,
+		idempotencyKey:  idempotencyKey,
+		clientReference: clientReference,
+		correlationID:   correlationID,
+		requestHash:     RequestHash(orderID, customerID, amount, clientReference, capture),
+		metadata:        maps.Clone(metadata),
+		createdAt:       now,
+		updatedAt:       now,
+		version:         1,
 	}
 
 	p.events = append(p.events, PaymentInitiated{
-		PaymentID:  p.id.String(),
-		OrderID:    orderID,
-		Amount:     amount.Amount(),
-		Currency:   amount.Currency(),
-		OccurredAt: p.createdAt,
+		PaymentID:       p.id.String(),
+		OrderID:         orderID,
+		ClientReference: clientReference,
+		CorrelationID:   correlationID,
+		CaptureMethod:   capture,
+		Amount:          amount.Amount(),
+		Currency:        amount.Currency(),
+		Metadata:        maps.Clone(metadata),
+		OccurredAt:      p.createdAt,
 	})
 
 	return p, nil
 }
 
-func (p *Payment) ID() PaymentID          { return p.id }
-func (p *Payment) OrderID() string        { return p.orderID }
-func (p *Payment) CustomerID() string     { return p.customerID }
-func (p *Payment) Amount() Money          { return p.amount }
-func (p *Payment) Status() PaymentStatus  { return p.status }
-func (p *Payment) ProviderRef() string    { return p.providerRef }
-func (p *Payment) FailureReason() string  { return p.failureReason }
-func (p *Payment) IdempotencyKey() string { return p.idempotencyKey }
-func (p *Payment) CreatedAt() time.Time   { return p.createdAt }
-func (p *Payment) UpdatedAt() time.Time   { return p.updatedAt }
-func (p *Payment) Version() int           { return p.version }
+func (p *Payment) ID() PaymentID           { return p.id }
+func (p *Payment) MerchantID() string      { return p.merchantID }
+func (p *Payment) OrderID() string         { return p.orderID }
+func (p *Payment) CustomerID() string      { return p.customerID }
+func (p *Payment) Amount() Money           { return p.amount }
+func (p *Payment) Status() PaymentStatus   { return p.status }
+func (p *Payment) ProviderRef() string     { return p.providerRef }
+func (p *Payment) FailureReason() string   { return p.failureReason }
+func (p *Payment) IdempotencyKey() string  { return p.idempotencyKey }
+func (p *Payment) ClientReference() string { return p.clientReference }
+func (p *Payment) CorrelationID() string   { return p.correlationID }
+func (p *Payment) CaptureKey() string      { return p.captureKey }
+func (p *Payment) RequestHash() string     { return p.requestHash }
+func (p *Payment) CreatedAt() time.Time    { return p.createdAt }
+func (p *Payment) UpdatedAt() time.Time    { return p.updatedAt }
+func (p *Payment) Version() int            { return p.version }
+
+// CheckVersion returns a VersionConflictError unless the payment is at
+// expected, 0 expects nothing
+func (p *Payment) CheckVersion(expected int) error {
+	if expected != 0 && expected != p.version {
+		return &VersionConflictError{Expected: expected, Current: p.version}
+	}
+	return nil
+}
+
+// Capture takes the funds of an authorized payment, key is the idempotency
+// key of the capture request and is kept so callers can recognise retries
+func (p *Payment) Capture(key string) error {
+	if strings.TrimSpace(key) == "" {
+		return errors.New("capture idempotency key is required")
+	}
+	if p.captureKey != "" {
+		return ErrAlreadyCaptured
+	}
+	if p.status != StatusAuthorized {
+		return fmt.Errorf("%w: only %s payments can be captured, payment is %s", ErrInvalidTransition, StatusAuthorized, p.status)
+	}
+	from, err := p.transition(StatusProcessing)
+	if err != nil {
+		return err
+	}
+
+	p.captureKey = key
+	p.events = append(p.events, PaymentCaptured{
+		PaymentID:       p.id.String(),
+		from:            from,
+		CorrelationID:   p.correlationID,
+		ClientReference: p.clientReference,
+		Amount:          p.amount.Amount(),
+		Currency:        p.amount.Currency(),
+		OccurredAt:      p.updatedAt,
+	})
+	return nil
+}
+
+// Authorize records the gateway's hold on a manual-capture payment, the
+// status stays AUTHORIZED until Capture
+func (p *Payment) Authorize(providerRef string) error {
+	if strings.TrimSpace(providerRef) == "" {
+		return errors.New("providerRef is required")
+	}
+	if p.status != StatusAuthorized || p.providerRef != "" {
+		return fmt.Errorf("%w: only %s payments without a provider reference can record an authorization, payment is %s",
+			ErrInvalidTransition, StatusAuthorized, p.status)
+	}
+
+	p.providerRef = providerRef
+	p.updatedAt = time.Now().UTC()
+	p.version++
+	p.events = append(p.events, PaymentAuthorized{
+		PaymentID:       p.id.String(),
+		CorrelationID:   p.correlationID,
+		ClientReference: p.clientReference,
+		ProviderRef:     providerRef,
+		OccurredAt:      p.updatedAt,
+	})
+	return nil
+}
+
+// MarkProcessing records that the gateway accepted the payment
+func (p *Payment) MarkProcessing(providerRef string) error {
+	if strings.TrimSpace(providerRef) == "" {
+		return errors.New("providerRef is required")
+	}
+	if p.status == StatusAuthorized {
+		return fmt.Errorf("%w: authorized payments move to %s through Capture", ErrInvalidTransition, StatusProcessing)
+	}
+	from, err := p.transition(StatusProcessing)
+	if err != nil {
+		return err
+	}
+
+	p.providerRef = providerRef
+	p.events = append(p.events, PaymentProcessing{
+		PaymentID:       p.id.String(),
+		from:            from,
+		CorrelationID:   p.correlationID,
+		ClientReference: p.clientReference,
+		ProviderRef:     providerRef,
+		OccurredAt:      p.updatedAt,
+	})
+	return nil
+}
+
+// Complete settles a processing payment
+func (p *Payment) Complete() error {
+	from, err := p.transition(StatusCompleted)
+	if err != nil {
+		return err
+	}
+
+	p.events = append(p.events, PaymentCompleted{
+		PaymentID:       p.id.String(),
+		from:            from,
+		CorrelationID:   p.correlationID,
+		ClientReference: p.clientReference,
+		ProviderRef:     p.providerRef,
+		Amount:          p.amount.Amount(),
+		Currency:        p.amount.Currency(),
+		OccurredAt:      p.updatedAt,
+	})
+	return nil
+}
+
+// Fail marks a pending or processing payment as failed
+func (p *Payment) Fail(reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return errors.New("reason is required")
+	}
+	from, err := p.transition(StatusFailed)
+	if err != nil {
+		return err
+	}
+
+	p.failureReason = reason
+	p.events = append(p.events, PaymentFailed{
+		PaymentID:       p.id.String(),
+		from:            from,
+		CorrelationID:   p.correlationID,
+		ClientReference: p.clientReference,
+		ProviderRef:     p.providerRef,
+		Reason:          reason,
+		OccurredAt:      p.updatedAt,
+	})
+	return nil
+}
+
+// Cancel abandons a payment that has not completed, the reason is kept
+// in FailureReason
+func (p *Payment) Cancel(reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return errors.New("reason is required")
+	}
+	from, err := p.transition(StatusCancelled)
+	if err != nil {
+		return err
+	}
+
+	p.failureReason = reason
+	p.events = append(p.events, PaymentCancelled{
+		PaymentID:       p.id.String(),
+		from:            from,
+		CorrelationID:   p.correlationID,
+		ClientReference: p.clientReference,
+		ProviderRef:     p.providerRef,
+		Reason:          reason,
+		OccurredAt:      p.updatedAt,
+	})
+	return nil
+}
+
+// expiredReason is the FailureReason of expired payments
+const expiredReason = "expired before completion"
+
+// Expire ends a pending payment that outlived the expiry window
+func (p *Payment) Expire() error {
+	from, err := p.transition(StatusExpired)
+	if err != nil {
+		return err
+	}
+
+	p.failureReason = expiredReason
+	p.events = append(p.events, PaymentExpired{
+		PaymentID:       p.id.String(),
+		from:            from,
+		CorrelationID:   p.correlationID,
+		ClientReference: p.clientReference,
+		OccurredAt:      p.updatedAt,
+	})
+	return nil
+}
+
+// transition moves the payment to status and bumps its version, it
+// returns the previous status for the event the caller appends
+func (p *Payment) transition(to PaymentStatus) (PaymentStatus, error) {
+	from := p.status
+	if !CanTransition(from, to) {
+		return "", fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+
+	p.status = to
+	p.updatedAt = time.Now().UTC()
+	p.version++
+	return from, nil
+}
 
 func (p *Payment) PopEvents() []Event {
 	events := p.events
@@ -147,32 +603,120 @@ func (p *Payment) PopEvents() []Event {
 
 func Reconstitute(
 	id PaymentID,
-	orderID, customerID string,
+	merchantID, orderID, customerID string,
 	amount Money,
 	status PaymentStatus,
-	providerRef, failureReason, idempotencyKey string,
+	providerRef, failureReason, idempotencyKey, clientReference, correlationID, captureKey, requestHash string,
+	metadata Metadata,
 	createdAt, updatedAt time.Time,
 	version int,
 ) *Payment {
 	return &Payment{
-		id:             id,
-		orderID:        orderID,
-		customerID:     customerID,
-		amount:         amount,
-		status:         status,
-		providerRef:    providerRef,
-		failureReason:  failureReason,
-		idempotencyKey: idempotencyKey,
-		createdAt:      createdAt,
-		updatedAt:      updatedAt,
-		version:        version,
+		id:              id,
+		merchantID:      merchantID,
+		orderID:         orderID,
+		customerID:      customerID,
+		amount:          amount,
+		status:          status,
+		providerRef:     providerRef,
+		failureReason:   failureReason,
+		idempotencyKey:  idempotencyKey,
+		clientReference: clientReference,
+		correlationID:   correlationID,
+		captureKey:      captureKey,
+		requestHash:     requestHash,
+		metadata:        metadata,
+		createdAt:       createdAt,
+		updatedAt:       updatedAt,
+		version:         version,
 	}
 }
 
+// RequestHash fingerprints what a payment was created from, a reused
+// idempotency key with a different fingerprint is a different request.
+// The correlation id and metadata are left out, they may change between
+// retries.
+func RequestHash(orderID, customerID string, amount Money, clientReference string, capture CaptureMethod) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		orderID,
+		customerID,
+		strconv.FormatInt(amount.Amount(), 10),
+		amount.Currency(),
+		clientReference,
+		string(capture),
+	}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+type consistentReadKey struct{}
+
+// WithConsistentRead makes reads under ctx see every committed write, for
+// repositories that otherwise may answer from a lagging replica
+func WithConsistentRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, consistentReadKey{}, true)
+}
+
+func IsConsistentRead(ctx context.Context) bool {
+	v, _ := ctx.Value(consistentReadKey{}).(bool)
+	return v
+}
+
+type merchantKey struct{}
+
+// WithMerchant confines the reads under ctx to the payments of merchantID,
+// another merchant's payment is ErrNotFound
+func WithMerchant(ctx context.Context, merchantID string) context.Context {
+	return context.WithValue(ctx, merchantKey{}, merchantID)
+}
+
+// MerchantFromContext returns false when reads under ctx see every payment
+func MerchantFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(merchantKey{}).(string)
+	return id, ok
+}
+
+// ScopedIdempotencyKey namespaces key by the merchant that chose it, for
+// caches shared by every merchant. The length prefix keeps two merchants
+// from ever producing the same string.
+func ScopedIdempotencyKey(merchantID, key string) string {
+	return strconv.Itoa(len(merchantID)) + ":" + merchantID + ":" + key
+}
+
 type Repository interface {
-	// Save inserts a new Payment or updates an existing one - upsert
-	Save(p *Payment) error
+	// Save inserts a new Payment or updates an existing one - upsert,
+	// and drains its pending events into the outbox in the same transaction.
+	// It is the only writer of payment events.
+	Save(ctx context.Context, p *Payment) error
+
+	// FindByIdempotencyKey, FindByID and List may read from a replica
+	// unless ctx carries WithConsistentRead. FindByID, FindByCorrelationID,
+	// FindByOrderID and List only see the payments of the merchant ctx
+	// carries WithMerchant.
+
+	// FindByIdempotencyKey looks up a payment by the key its merchant chose,
+	// merchantID is empty for platform callers
+	FindByIdempotencyKey(ctx context.Context, merchantID, key string) (*Payment, error)
+
+	// FindByID returns ErrNotFound when no payment has the given id
+	FindByID(ctx context.Context, id PaymentID) (*Payment, error)
+
+	// FindByCorrelationID returns every payment sharing the correlation id, newest first
+	FindByCorrelationID(ctx context.Context, correlationID string) ([]*Payment, error)
+
+	// FindByOrderID returns every attempt at paying the order, newest first
+	FindByOrderID(ctx context.Context, orderID string) ([]*Payment, error)
+
+	// FindExpiredPending returns up to limit PENDING payments created before
+	// olderThan, oldest first. It reads the primary, callers expire them.
+	FindExpiredPending(ctx context.Context, olderThan time.Time, limit int) ([]*Payment, error)
+
+	// FindByProviderRef returns ErrNotFound when no payment carries ref
+	FindByProviderRef(ctx context.Context, ref string) (*Payment, error)
+
+	// List returns one page of payments matching f, newest first. nextCursor
+	// is empty on the last page.
+	List(ctx context.Context, f ListFilter) (items []*Payment, nextCursor string, err error)
 
-	// FindByIdempotencyKey looks up a payment by its idempotency key
-	FindByIdempotencyKey(key string) (*Payment, error)
+	RefundRepository
+	StatusHistoryRepository
 }
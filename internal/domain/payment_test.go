@@ -0,0 +1,229 @@
+package domain_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ademajagon/gopay-service/internal/domain"
+	"github.com/ademajagon/gopay-service/internal/domain/domaintest"
+)
+
+var goldens = map[domain.PaymentStatus]func() *domain.Payment{
+	domain.StatusPending:    domaintest.Pending,
+	domain.StatusAuthorized: domaintest.Authorized,
+	domain.StatusProcessing: domaintest.Processing,
+	domain.StatusCompleted:  domaintest.Completed,
+	domain.StatusFailed:     domaintest.Failed,
+	domain.StatusCancelled:  domaintest.Cancelled,
+	domain.StatusExpired:    domaintest.Expired,
+}
+
+var statuses = []domain.PaymentStatus{
+	domain.StatusPending,
+	domain.StatusAuthorized,
+	domain.StatusProcessing,
+	domain.StatusCompleted,
+	domain.StatusFailed,
+	domain.StatusCancelled,
+	domain.StatusExpired,
+}
+
+func TestCanTransition(t *testing.T) {
+	allowed := map[[2]domain.PaymentStatus]bool{
+		{domain.StatusPending, domain.StatusProcessing}:    true,
+		{domain.StatusPending, domain.StatusFailed}:        true,
+		{domain.StatusPending, domain.StatusCancelled}:     true,
+		{domain.StatusPending, domain.StatusExpired}:       true,
+		{domain.StatusAuthorized, domain.StatusProcessing}: true,
+		{domain.StatusAuthorized, domain.StatusFailed}:     true,
+		{domain.StatusAuthorized, domain.StatusCancelled}:  true,
+		{domain.StatusProcessing, domain.StatusCompleted}:  true,
+		{domain.StatusProcessing, domain.StatusFailed}:     true,
+		{domain.StatusProcessing, domain.StatusCancelled}:  true,
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]domain.PaymentStatus{from, to}]
+			if got := domain.CanTransition(from, to); got != want {
+				t.Errorf("CanTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	actions := []struct {
+		name      string
+		to        domain.PaymentStatus
+		eventType string
+		from      []domain.PaymentStatus
+		apply     func(*domain.Payment) error
+	}{
+		{
+			name: "MarkProcessing", to: domain.StatusProcessing, eventType: "payment.processing",
+			from:  []domain.PaymentStatus{domain.StatusPending},
+			apply: func(p *domain.Payment) error { return p.MarkProcessing("prov_456") },
+		},
+		{
+			name: "Capture", to: domain.StatusProcessing, eventType: "payment.captured",
+			from:  []domain.PaymentStatus{domain.StatusAuthorized},
+			apply: func(p *domain.Payment) error { return p.Capture("capture-1") },
+		},
+		{
+			name: "Complete", to: domain.StatusCompleted, eventType: "payment.completed",
+			from:  []domain.PaymentStatus{domain.StatusProcessing},
+			apply: func(p *domain.Payment) error { return p.Complete() },
+		},
+		{
+			name: "Fail", to: domain.StatusFailed, eventType: "payment.failed",
+			from:  []domain.PaymentStatus{domain.StatusPending, domain.StatusAuthorized, domain.StatusProcessing},
+			apply: func(p *domain.Payment) error { return p.Fail("card_declined") },
+		},
+		{
+			name: "Cancel", to: domain.StatusCancelled, eventType: "payment.cancelled",
+			from:  []domain.PaymentStatus{domain.StatusPending, domain.StatusAuthorized, domain.StatusProcessing},
+			apply: func(p *domain.Payment) error { return p.Cancel("cancelled_by_merchant") },
+		},
+		{
+			name: "Expire", to: domain.StatusExpired, eventType: "payment.expired",
+			from:  []domain.PaymentStatus{domain.StatusPending},
+			apply: func(p *domain.Payment) error { return p.Expire() },
+		},
+	}
+
+	for _, action := range actions {
+		for _, from := range statuses {
+			legal := false
+			for _, s := range action.from {
+				legal = legal || s == from
+			}
+
+			t.Run(fmt.Sprintf("%s from %s", action.name, from), func(t *testing.T) {
+				p := goldens[from]()
+				version := p.Version()
+
+				err := action.apply(p)
+				events := p.PopEvents()
+
+				if !legal {
+					if !errors.Is(err, domain.ErrInvalidTransition) {
+						t.Fatalf("err = %v, want ErrInvalidTransition", err)
+					}
+					if p.Status() != from || p.Version() != version || len(events) != 0 {
+						t.Fatalf("rejected transition changed the payment: status %s, version %d, %d events", p.Status(), p.Version(), len(events))
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				if p.Status() != action.to {
+					t.Errorf("status = %s, want %s", p.Status(), action.to)
+				}
+				if p.Version() != version+1 {
+					t.Errorf("version = %d, want %d", p.Version(), version+1)
+				}
+				if !p.UpdatedAt().After(domaintest.FixedTime) {
+					t.Errorf("updated_at = %s, not moved past the fixture clock", p.UpdatedAt())
+				}
+				if len(events) != 1 || domain.EventType(events[0]) != action.eventType {
+					t.Fatalf("events = %v, want one %s", events, action.eventType)
+				}
+			})
+		}
+	}
+}
+
+func TestCaptureTwice(t *testing.T) {
+	p := domaintest.Authorized()
+	if err := p.Capture("capture-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Capture("capture-2"); !errors.Is(err, domain.ErrAlreadyCaptured) {
+		t.Fatalf("err = %v, want ErrAlreadyCaptured", err)
+	}
+	if p.CaptureKey() != "capture-1" {
+		t.Fatalf("capture key = %q, want the first one", p.CaptureKey())
+	}
+}
+
+func TestBuildNew(t *testing.T) {
+	p := domaintest.NewPaymentBuilder().WithCaptureMethod(domain.CaptureManual).BuildNew()
+
+	if p.Status() != domain.StatusAuthorized || p.Version() != 1 {
+		t.Fatalf("status %s version %d, want AUTHORIZED at 1", p.Status(), p.Version())
+	}
+	if !p.CreatedAt().Equal(domaintest.FixedTime) {
+		t.Fatalf("created_at = %s, want the fixture clock", p.CreatedAt())
+	}
+	events := p.PopEvents()
+	if len(events) != 1 || domain.EventType(events[0]) != "payment.initiated" {
+		t.Fatalf("events = %v, want one payment.initiated", events)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	p := domaintest.NewPaymentBuilder().WithCaptureMethod(domain.CaptureManual).BuildNew()
+	p.PopEvents()
+
+	if err := p.Authorize("prov_123"); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status() != domain.StatusAuthorized || p.Version() != domaintest.Authorized().Version() {
+		t.Fatalf("status %s version %d, want the Authorized golden", p.Status(), p.Version())
+	}
+	if err := p.Authorize("prov_456"); !errors.Is(err, domain.ErrInvalidTransition) {
+		t.Fatalf("second authorization: err = %v, want ErrInvalidTransition", err)
+	}
+}
+
+// every event of a payment repeats its client reference, consumers match
+// events to merchant records without loading the payment
+func TestEventsCarryTheClientReference(t *testing.T) {
+	lifecycles := map[string]struct {
+		capture domain.CaptureMethod
+		steps   []func(*domain.Payment) error
+	}{
+		"manual capture": {capture: domain.CaptureManual, steps: []func(*domain.Payment) error{
+			func(p *domain.Payment) error { return p.Authorize("prov_1") },
+			func(p *domain.Payment) error { return p.UpdateMetadata(domain.Metadata{"cart_id": "c-1"}, nil) },
+			func(p *domain.Payment) error { return p.Capture("capture-1") },
+			(*domain.Payment).Complete,
+		}},
+		"failed": {capture: domain.CaptureAutomatic, steps: []func(*domain.Payment) error{
+			func(p *domain.Payment) error { return p.MarkProcessing("prov_1") },
+			func(p *domain.Payment) error { return p.Fail("card_declined") },
+		}},
+		"cancelled": {capture: domain.CaptureAutomatic, steps: []func(*domain.Payment) error{
+			func(p *domain.Payment) error { return p.Cancel("cancelled_by_merchant") },
+		}},
+		"expired": {capture: domain.CaptureAutomatic, steps: []func(*domain.Payment) error{
+			(*domain.Payment).Expire,
+		}},
+	}
+
+	for name, lc := range lifecycles {
+		t.Run(name, func(t *testing.T) {
+			p := domaintest.NewPaymentBuilder().WithClientReference("inv-42").WithCaptureMethod(lc.capture).BuildNew()
+			for _, step := range lc.steps {
+				if err := step(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			events := p.PopEvents()
+			if len(events) != len(lc.steps)+1 {
+				t.Fatalf("%d events, want %d", len(events), len(lc.steps)+1)
+			}
+			for _, e := range events {
+				ref := reflect.ValueOf(e).FieldByName("ClientReference")
+				if !ref.IsValid() || ref.String() != "inv-42" {
+					t.Errorf("%s: client reference %v, want inv-42", domain.EventType(e), ref)
+				}
+			}
+		})
+	}
+}
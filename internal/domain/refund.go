@@ -0,0 +1,243 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrOverRefund means the refunds of a payment would exceed its amount
+	ErrOverRefund = errors.New("refund exceeds the refundable amount")
+
+	// ErrNotRefundable means the payment is not COMPLETED
+	ErrNotRefundable = errors.New("payment is not refundable")
+)
+
+type RefundStatus string
+
+const (
+	RefundStatusPending   RefundStatus = "PENDING"
+	RefundStatusSucceeded RefundStatus = "SUCCEEDED"
+	RefundStatusFailed    RefundStatus = "FAILED"
+)
+
+// Refund returns part or all of a completed payment to the customer
+type Refund struct {
+	id             string
+	paymentID      PaymentID
+	amount         Money
+	status         RefundStatus
+	reason         string
+	idempotencyKey string
+	providerRef    string
+	failureReason  string
+	createdAt      time.Time
+
+	events []Event
+}
+
+type RefundCreated struct {
+	RefundID      string
+	PaymentID     string
+	CorrelationID string
+	Amount        int64
+	Currency      string
+	Reason        string
+	OccurredAt    time.Time
+}
+
+func (e RefundCreated) eventType() string     { return "refund.created" }
+func (e RefundCreated) occurredAt() time.Time { return e.OccurredAt }
+
+type RefundSucceeded struct {
+	RefundID    string
+	PaymentID   string
+	ProviderRef string
+	OccurredAt  time.Time
+}
+
+func (e RefundSucceeded) eventType() string     { return "refund.succeeded" }
+func (e RefundSucceeded) occurredAt() time.Time { return e.OccurredAt }
+
+type RefundFailed struct {
+	RefundID   string
+	PaymentID  string
+	Reason     string
+	OccurredAt time.Time
+}
+
+func (e RefundFailed) eventType() string     { return "refund.failed" }
+func (e RefundFailed) occurredAt() time.Time { return e.OccurredAt }
+
+// NewRefund checks amount against what is left of p after alreadyRefunded,
+// the sum of its refunds that have not failed
+func NewRefund(p *Payment, alreadyRefunded Money, amount Money, reason, idempotencyKey string) (*Refund, error) {
+	if strings.TrimSpace(idempotencyKey) == "" {
+		return nil, errors.New("idempotencyKey is required")
+	}
+	if p.Status() != StatusCompleted {
+		return nil, fmt.Errorf("%w: payment is %s, only %s payments can be refunded", ErrNotRefundable, p.Status(), StatusCompleted)
+	}
+	if err := CheckRefundable(p.Amount(), alreadyRefunded, amount); err != nil {
+		return nil, err
+	}
+
+	r := &Refund{
+		id:             uuid.New().String(),
+		paymentID:      p.ID(),
+		amount:         amount,
+		status:         RefundStatusPending,
+		reason:         reason,
+		idempotencyKey: idempotencyKey,
+		createdAt:      time.Now().UTC(),
+	}
+
+	r.events = append(r.events, RefundCreated{
+		RefundID:      r.id,
+		PaymentID:     p.ID().String(),
+		CorrelationID: p.CorrelationID(),
+		Amount:        amount.Amount(),
+		Currency:      amount.Currency(),
+		Reason:        reason,
+		OccurredAt:    r.createdAt,
+	})
+	return r, nil
+}
+
+func ReconstituteRefund(
+	id string,
+	paymentID PaymentID,
+	amount Money,
+	status RefundStatus,
+	reason, idempotencyKey, providerRef, failureReason string,
+	createdAt time.Time,
+) *Refund {
+	return &Refund{
+		id:             id,
+		paymentID:      paymentID,
+		amount:         amount,
+		status:         status,
+		reason:         reason,
+		idempotencyKey: idempotencyKey,
+		providerRef:    providerRef,
+		failureReason:  failureReason,
+		createdAt:      createdAt,
+	}
+}
+
+// Succeed records that the gateway returned the funds
+func (r *Refund) Succeed(providerRef string) error {
+	if r.status != RefundStatusPending {
+		return fmt.Errorf("%w: refund is %s", ErrInvalidTransition, r.status)
+	}
+
+	r.status = RefundStatusSucceeded
+	r.providerRef = providerRef
+	r.events = append(r.events, RefundSucceeded{
+		RefundID:    r.id,
+		PaymentID:   r.paymentID.String(),
+		ProviderRef: providerRef,
+		OccurredAt:  time.Now().UTC(),
+	})
+	return nil
+}
+
+// Fail records a declined refund, its amount no longer counts as refunded
+func (r *Refund) Fail(reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return errors.New("reason is required")
+	}
+	if r.status != RefundStatusPending {
+		return fmt.Errorf("%w: refund is %s", ErrInvalidTransition, r.status)
+	}
+
+	r.status = RefundStatusFailed
+	r.failureReason = reason
+	r.events = append(r.events, RefundFailed{
+		RefundID:   r.id,
+		PaymentID:  r.paymentID.String(),
+		Reason:     reason,
+		OccurredAt: time.Now().UTC(),
+	})
+	return nil
+}
+
+func (r *Refund) ID() string              { return r.id }
+func (r *Refund) PaymentID() PaymentID    { return r.paymentID }
+func (r *Refund) Amount() Money           { return r.amount }
+func (r *Refund) Status() RefundStatus    { return r.status }
+func (r *Refund) Reason() string          { return r.reason }
+func (r *Refund) IdempotencyKey() string  { return r.idempotencyKey }
+func (r *Refund) ProviderRef() string     { return r.providerRef }
+func (r *Refund) FailureReason() string   { return r.failureReason }
+func (r *Refund) CreatedAt() time.Time    { return r.createdAt }
+func (r *Refund) PopEvents() []Event      { e := r.events; r.events = nil; return e }
+func (r *Refund) countsTowardTotal() bool { return r.status != RefundStatusFailed }
+
+// RefundedAmount sums the refunds that still hold part of the payment
+func RefundedAmount(currency string, refunds []*Refund) (Money, error) {
+	total, err := ZeroMoney(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	for _, r := range refunds {
+		if !r.countsTowardTotal() {
+			continue
+		}
+		if total, err = total.Add(r.amount); err != nil {
+			return Money{}, fmt.Errorf("sum refunds: %w", err)
+		}
+	}
+	return total, nil
+}
+
+// RefundableAmount is what is left of paid after alreadyRefunded, zero when
+// nothing is
+func RefundableAmount(paid, alreadyRefunded Money) (Money, error) {
+	remaining, err := paid.Sub(alreadyRefunded)
+	if errors.Is(err, ErrNegativeMoney) {
+		// refunds already exceed the payment, nothing more goes out
+		return ZeroMoney(paid.Currency())
+	}
+	return remaining, err
+}
+
+// CheckRefundable returns ErrOverRefund when amount exceeds what is left of
+// paid after alreadyRefunded
+func CheckRefundable(paid, alreadyRefunded, amount Money) error {
+	remaining, err := RefundableAmount(paid, alreadyRefunded)
+	if err != nil {
+		return err
+	}
+	cmp, err := amount.Compare(remaining)
+	if err != nil {
+		return fmt.Errorf("refund does not match the payment: %w", err)
+	}
+	if cmp > 0 {
+		return fmt.Errorf("%w: %s requested, %s remaining", ErrOverRefund, amount, remaining)
+	}
+	return nil
+}
+
+type RefundRepository interface {
+	// SaveRefund re-checks the refundable amount with the payment locked and
+	// writes the refund and its events in one transaction. It returns
+	// ErrOverRefund when a concurrent refund took the remaining amount and
+	// ErrVersionConflict when the idempotency key is already used.
+	SaveRefund(ctx context.Context, r *Refund) error
+
+	// UpdateRefund stores the outcome of a pending refund and its events, it
+	// returns ErrVersionConflict when the refund is no longer pending
+	UpdateRefund(ctx context.Context, r *Refund) error
+
+	// FindRefundByIdempotencyKey returns nil, nil when the key is unused
+	FindRefundByIdempotencyKey(ctx context.Context, paymentID PaymentID, key string) (*Refund, error)
+
+	// ListRefunds returns the refunds of a payment, oldest first
+	ListRefunds(ctx context.Context, paymentID PaymentID) ([]*Refund, error)
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// SettlementIngested is emitted once every row of a provider settlement file was matched
+type SettlementIngested struct {
+	BatchID        string
+	Provider       string
+	FileHash       string
+	Rows           int
+	Matched        int
+	AmountMismatch int
+	UnknownRef     int
+	OccurredAt     time.Time
+}
+
+func (e SettlementIngested) eventType() string     { return "settlement.ingested" }
+func (e SettlementIngested) occurredAt() time.Time { return e.OccurredAt }
@@ -0,0 +1,131 @@
+// Package logging carries request-scoped identifiers in the context and adds
+// them to every record logged with that context
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// attribute keys added from the context
+const (
+	RequestIDKey = "request_id"
+	TraceIDKey   = "trace_id"
+	SpanIDKey    = "span_id"
+	PaymentIDKey = "payment_id"
+)
+
+type fieldsKey struct{}
+
+// fields is immutable once stored, With* copy before changing
+type fields struct {
+	requestID string
+	traceID   string
+	spanID    string
+	paymentID string
+}
+
+func fromContext(ctx context.Context) fields {
+	f, _ := ctx.Value(fieldsKey{}).(fields)
+	return f
+}
+
+// WithRequestID stores the ID of the request being served
+func WithRequestID(ctx context.Context, id string) context.Context {
+	f := fromContext(ctx)
+	f.requestID = id
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// WithTrace stores the trace and span the work belongs to
+func WithTrace(ctx context.Context, traceID, spanID string) context.Context {
+	f := fromContext(ctx)
+	f.traceID, f.spanID = traceID, spanID
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// WithPaymentID stores the payment being worked on, call it once the ID is
+// known so deeper layers log it without passing it along
+func WithPaymentID(ctx context.Context, id string) context.Context {
+	f := fromContext(ctx)
+	f.paymentID = id
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// contextHandler adds the identifiers in the context to each record. An
+// attribute the caller already set wins over the context. Under WithGroup
+// the identifiers land in the group like any other record attribute.
+type contextHandler struct {
+	inner slog.Handler
+	// keys added through WithAttrs outside any group
+	preset  []string
+	grouped bool
+}
+
+// NewContextHandler wraps inner, which may be any handler, e.g. a JSON or
+// text handler
+func NewContextHandler(inner slog.Handler) slog.Handler {
+	return &contextHandler{inner: inner}
+}
+
+func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	f := fromContext(ctx)
+	if f == (fields{}) {
+		return h.inner.Handle(ctx, r)
+	}
+
+	set := make(map[string]bool, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		set[a.Key] = true
+		return true
+	})
+	add := func(key, value string) {
+		if value != "" && !set[key] && !slices.Contains(h.preset, key) {
+			r.AddAttrs(slog.String(key, value))
+		}
+	}
+	add(RequestIDKey, f.requestID)
+	add(TraceIDKey, f.traceID)
+	add(SpanIDKey, f.spanID)
+	add(PaymentIDKey, f.paymentID)
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	preset := slices.Clone(h.preset)
+	if !h.grouped {
+		for _, a := range attrs {
+			preset = append(preset, a.Key)
+		}
+	}
+	return &contextHandler{inner: h.inner.WithAttrs(attrs), preset: preset, grouped: h.grouped}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &contextHandler{inner: h.inner.WithGroup(name), preset: h.preset, grouped: true}
+}
+
+// ParseLevel accepts debug, info, warn and error in any case
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, want debug, info, warn or error", s)
+}
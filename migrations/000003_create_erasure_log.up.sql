@@ -0,0 +1,10 @@
+CREATE TABLE erasure_log (
+    id              UUID         PRIMARY KEY DEFAULT gen_random_uuid(),
+    -- never the original identifier, only its pseudonym
+    pseudonym       VARCHAR(255) NOT NULL,
+    payments_erased INT          NOT NULL,
+    erased_at       TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_erasure_log_pseudonym
+    ON erasure_log (pseudonym);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_payments_client_reference;
+ALTER TABLE payments DROP COLUMN IF EXISTS client_reference;
@@ -0,0 +1,6 @@
+ALTER TABLE payments
+    ADD COLUMN client_reference VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_payments_client_reference
+    ON payments (client_reference)
+    WHERE client_reference <> '';
@@ -0,0 +1,18 @@
+CREATE TABLE outbox_backfill_runs (
+    id                UUID         PRIMARY KEY DEFAULT gen_random_uuid(),
+    range_from        TIMESTAMPTZ  NOT NULL,
+    range_to          TIMESTAMPTZ  NOT NULL,
+    -- keyset checkpoint, the last payment written by a committed batch
+    cursor_created_at TIMESTAMPTZ,
+    cursor_id         UUID,
+    events_written    INT          NOT NULL DEFAULT 0,
+    status            VARCHAR(20)  NOT NULL DEFAULT 'QUEUED'
+        CHECK (status IN ('QUEUED', 'RUNNING', 'COMPLETED', 'FAILED')),
+    last_error        TEXT         NOT NULL DEFAULT '',
+    created_at        TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+-- keyset scan for the backfill
+CREATE INDEX idx_payments_created_at_id
+    ON payments (created_at, id);
@@ -0,0 +1,8 @@
+ALTER TABLE payments
+    ADD COLUMN correlation_id VARCHAR(128) NOT NULL DEFAULT '';
+
+-- existing payments correlate on their own id
+UPDATE payments SET correlation_id = id::text WHERE correlation_id = '';
+
+CREATE INDEX idx_payments_correlation_id
+    ON payments (correlation_id, created_at DESC);
@@ -0,0 +1 @@
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_version_positive;
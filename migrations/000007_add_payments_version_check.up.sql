@@ -0,0 +1,2 @@
+ALTER TABLE payments
+    ADD CONSTRAINT payments_version_positive CHECK (version >= 1);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_payments_provider_ref;
+DROP TABLE IF EXISTS settlement_items;
+DROP TABLE IF EXISTS settlement_batches;
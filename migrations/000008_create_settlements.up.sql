@@ -0,0 +1,40 @@
+CREATE TABLE settlement_batches (
+    id              UUID         PRIMARY KEY DEFAULT gen_random_uuid(),
+    provider        VARCHAR(64)  NOT NULL,
+    -- sha256 of the uploaded file, re-uploads of the same file reuse the batch
+    file_hash       CHAR(64)     NOT NULL,
+    status          VARCHAR(20)  NOT NULL DEFAULT 'INGESTING'
+        CHECK (status IN ('INGESTING', 'COMPLETED', 'FAILED')),
+    rows            INT          NOT NULL DEFAULT 0,
+    matched         INT          NOT NULL DEFAULT 0,
+    amount_mismatch INT          NOT NULL DEFAULT 0,
+    unknown_ref     INT          NOT NULL DEFAULT 0,
+    last_error      TEXT         NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_settlement_batches_file
+    ON settlement_batches (provider, file_hash);
+
+CREATE TABLE settlement_items (
+    batch_id     UUID         NOT NULL REFERENCES settlement_batches (id) ON DELETE CASCADE,
+    line         INT          NOT NULL,
+    provider_ref TEXT         NOT NULL,
+    amount_cents BIGINT       NOT NULL,
+    currency     CHAR(3)      NOT NULL,
+    payment_id   UUID,
+    status       VARCHAR(20)  NOT NULL
+        CHECK (status IN ('MATCHED', 'AMOUNT_MISMATCH', 'UNKNOWN_REF')),
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (batch_id, line)
+);
+
+-- mismatches are what finance needs to look at
+CREATE INDEX idx_settlement_items_attention
+    ON settlement_items (created_at DESC)
+    WHERE status <> 'MATCHED';
+
+CREATE INDEX idx_payments_provider_ref
+    ON payments (provider_ref)
+    WHERE provider_ref <> '';
@@ -0,0 +1,6 @@
+ALTER TABLE payments DROP COLUMN IF EXISTS capture_key;
+
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments
+    ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('PENDING', 'PROCESSING', 'COMPLETED', 'FAILED'));
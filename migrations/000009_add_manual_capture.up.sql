@@ -0,0 +1,8 @@
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments
+    ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('PENDING', 'AUTHORIZED', 'PROCESSING', 'COMPLETED', 'FAILED'));
+
+-- idempotency key of the capture call, empty until captured
+ALTER TABLE payments
+    ADD COLUMN capture_key TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,23 @@
+CREATE TABLE refunds (
+    id              UUID         PRIMARY KEY,
+    payment_id      UUID         NOT NULL REFERENCES payments (id),
+    amount_cents    BIGINT       NOT NULL CHECK (amount_cents > 0),
+    currency        CHAR(3)      NOT NULL,
+    status          VARCHAR(20)  NOT NULL
+        CHECK (status IN ('PENDING', 'SUCCEEDED', 'FAILED')),
+    reason          TEXT         NOT NULL DEFAULT '',
+    idempotency_key TEXT         NOT NULL,
+    created_at      TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+-- keys are scoped to the payment, the same key may refund two payments
+CREATE UNIQUE INDEX idx_refunds_idempotency_key
+    ON refunds (payment_id, idempotency_key);
+
+CREATE INDEX idx_refunds_payment_id
+    ON refunds (payment_id, created_at);
+
+CREATE TRIGGER refunds_set_updated_at
+    BEFORE UPDATE ON refunds
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
@@ -0,0 +1,3 @@
+-- checkout reconciles by order, one order may have several attempts
+CREATE INDEX idx_payments_order_id
+    ON payments (order_id, created_at DESC);
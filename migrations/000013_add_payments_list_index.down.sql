@@ -0,0 +1 @@
+-- no-op: idx_payments_created_at_id belongs to 000005
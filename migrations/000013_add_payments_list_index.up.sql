@@ -0,0 +1,3 @@
+-- no-op: keyset pagination on (created_at, id) is served by
+-- idx_payments_created_at_id from 000005, scanned backwards for
+-- ORDER BY created_at DESC, id DESC
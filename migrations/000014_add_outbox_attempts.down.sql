@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_outbox_parked;
+DROP INDEX IF EXISTS idx_outbox_pending;
+
+ALTER TABLE outbox_events
+    DROP COLUMN IF EXISTS parked_at,
+    DROP COLUMN IF EXISTS last_error,
+    DROP COLUMN IF EXISTS attempts;
+
+CREATE INDEX idx_outbox_pending
+    ON outbox_events (created_at ASC)
+    WHERE published_at IS NULL;
@@ -0,0 +1,14 @@
+ALTER TABLE outbox_events
+    ADD COLUMN attempts   INT         NOT NULL DEFAULT 0,
+    ADD COLUMN last_error TEXT        NOT NULL DEFAULT '',
+    -- set once the broker rejected the event OUTBOX_MAX_ATTEMPTS times
+    ADD COLUMN parked_at  TIMESTAMPTZ;
+
+DROP INDEX IF EXISTS idx_outbox_pending;
+CREATE INDEX idx_outbox_pending
+    ON outbox_events (created_at ASC)
+    WHERE published_at IS NULL AND parked_at IS NULL;
+
+CREATE INDEX idx_outbox_parked
+    ON outbox_events (parked_at DESC)
+    WHERE parked_at IS NOT NULL;
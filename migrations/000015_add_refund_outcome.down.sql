@@ -0,0 +1,3 @@
+ALTER TABLE refunds
+    DROP COLUMN IF EXISTS failure_reason,
+    DROP COLUMN IF EXISTS provider_ref;
@@ -0,0 +1,3 @@
+ALTER TABLE refunds
+    ADD COLUMN provider_ref   TEXT NOT NULL DEFAULT '',
+    ADD COLUMN failure_reason TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_outbox_webhook_fanout;
+ALTER TABLE outbox_events
+    DROP COLUMN IF EXISTS webhooks_fanned_out_at;
+
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_endpoints;
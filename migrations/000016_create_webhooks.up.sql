@@ -0,0 +1,54 @@
+CREATE TABLE webhook_endpoints (
+    id          UUID         PRIMARY KEY DEFAULT gen_random_uuid(),
+    url         TEXT         NOT NULL,
+    -- HMAC key for X-Gopay-Signature, shown to the merchant once at creation
+    secret      TEXT         NOT NULL,
+    event_types TEXT[]       NOT NULL,
+    enabled     BOOLEAN      NOT NULL DEFAULT TRUE,
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE webhook_deliveries (
+    id               UUID         PRIMARY KEY DEFAULT gen_random_uuid(),
+    endpoint_id      UUID         NOT NULL REFERENCES webhook_endpoints (id) ON DELETE CASCADE,
+    event_id         UUID         NOT NULL,
+    event_type       VARCHAR(255) NOT NULL,
+    payload          JSONB        NOT NULL,
+    status           VARCHAR(20)  NOT NULL DEFAULT 'PENDING'
+        CHECK (status IN ('PENDING', 'DELIVERED', 'FAILED')),
+    attempts         INT          NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    last_status_code INT          NOT NULL DEFAULT 0,
+    last_error       TEXT         NOT NULL DEFAULT '',
+    delivered_at     TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+-- an outbox event fans out to an endpoint once
+CREATE UNIQUE INDEX idx_webhook_deliveries_event
+    ON webhook_deliveries (endpoint_id, event_id);
+
+CREATE INDEX idx_webhook_deliveries_due
+    ON webhook_deliveries (next_attempt_at)
+    WHERE status = 'PENDING';
+
+CREATE INDEX idx_webhook_deliveries_failed
+    ON webhook_deliveries (endpoint_id, updated_at DESC)
+    WHERE status = 'FAILED';
+
+-- webhooks consume the outbox independently of the relay, so they keep
+-- their own marker. Events written before webhooks existed are not sent.
+-- Only the event types merchants can subscribe to are ever marked.
+ALTER TABLE outbox_events
+    ADD COLUMN webhooks_fanned_out_at TIMESTAMPTZ;
+
+UPDATE outbox_events
+SET webhooks_fanned_out_at = created_at
+WHERE event_type IN ('payment.completed', 'payment.failed');
+
+CREATE INDEX idx_outbox_webhook_fanout
+    ON outbox_events (created_at ASC)
+    WHERE webhooks_fanned_out_at IS NULL
+      AND event_type IN ('payment.completed', 'payment.failed');
@@ -0,0 +1,4 @@
+-- fingerprint of the creating request, a replayed idempotency key must match
+-- it. Empty for payments created before it was recorded, those always match.
+ALTER TABLE payments
+    ADD COLUMN request_hash TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,16 @@
+CREATE TABLE api_keys (
+    id          UUID          PRIMARY KEY DEFAULT gen_random_uuid(),
+    merchant_id VARCHAR(255)  NOT NULL,
+    name        VARCHAR(255)  NOT NULL DEFAULT '',
+    -- the public start of the key, looked up on every request and shown in listings
+    prefix      VARCHAR(32)   NOT NULL,
+    -- hex sha256 of the whole key, the key itself is only shown at creation
+    key_hash    CHAR(64)      NOT NULL,
+    scopes      TEXT[]        NOT NULL,
+    revoked_at  TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ   NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_api_keys_prefix ON api_keys (prefix);
+
+CREATE INDEX idx_api_keys_merchant ON api_keys (merchant_id, created_at);
@@ -0,0 +1,14 @@
+CREATE TABLE payment_status_history (
+    id          BIGSERIAL     PRIMARY KEY,
+    payment_id  UUID          NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    -- NULL for the status the payment was created in
+    from_status VARCHAR(20),
+    to_status   VARCHAR(20)   NOT NULL,
+    reason      TEXT          NOT NULL DEFAULT '',
+    -- the authenticated caller, 'provider' or 'system'
+    actor       VARCHAR(255)  NOT NULL,
+    occurred_at TIMESTAMPTZ   NOT NULL
+);
+
+CREATE INDEX idx_payment_status_history_payment
+    ON payment_status_history (payment_id, occurred_at, id);
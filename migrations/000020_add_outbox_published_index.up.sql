@@ -0,0 +1,4 @@
+-- the retention job deletes published events oldest first
+CREATE INDEX idx_outbox_published
+    ON outbox_events (published_at)
+    WHERE published_at IS NOT NULL;
@@ -0,0 +1,3 @@
+-- merchant key/value data, validated by the service
+ALTER TABLE payments
+    ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}'::jsonb;
@@ -0,0 +1,3 @@
+-- erasure scrubs the stored events of a customer's payments by aggregate
+CREATE INDEX idx_outbox_aggregate
+    ON outbox_events (aggregate_id);
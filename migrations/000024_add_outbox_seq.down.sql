@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_outbox_webhook_fanout;
+CREATE INDEX idx_outbox_webhook_fanout
+    ON outbox_events (created_at ASC)
+    WHERE webhooks_fanned_out_at IS NULL
+      AND event_type IN ('payment.completed', 'payment.failed');
+
+DROP INDEX IF EXISTS idx_outbox_pending_seq;
+
+-- drops outbox_events_seq with it
+ALTER TABLE outbox_events
+    DROP COLUMN IF EXISTS seq;
@@ -0,0 +1,32 @@
+-- the events of one transaction share created_at, seq keeps the order they
+-- were written in and is what the relay and the webhook fan-out follow
+CREATE SEQUENCE outbox_events_seq;
+
+ALTER TABLE outbox_events
+    ADD COLUMN seq BIGINT;
+
+-- existing rows keep the order they were published in so far
+UPDATE outbox_events o
+SET seq = ordered.seq
+FROM (
+    SELECT id, row_number() OVER (ORDER BY created_at, id) AS seq
+    FROM outbox_events
+) ordered
+WHERE o.id = ordered.id;
+
+SELECT setval('outbox_events_seq', COALESCE(MAX(seq), 0) + 1, false) FROM outbox_events;
+
+ALTER TABLE outbox_events
+    ALTER COLUMN seq SET DEFAULT nextval('outbox_events_seq'),
+    ALTER COLUMN seq SET NOT NULL;
+ALTER SEQUENCE outbox_events_seq OWNED BY outbox_events.seq;
+
+CREATE INDEX idx_outbox_pending_seq
+    ON outbox_events (seq)
+    WHERE published_at IS NULL AND parked_at IS NULL;
+
+DROP INDEX IF EXISTS idx_outbox_webhook_fanout;
+CREATE INDEX idx_outbox_webhook_fanout
+    ON outbox_events (seq)
+    WHERE webhooks_fanned_out_at IS NULL
+      AND event_type IN ('payment.completed', 'payment.failed');
@@ -0,0 +1,4 @@
+-- the relay looks for older pending events of an aggregate before claiming one
+CREATE INDEX idx_outbox_pending_aggregate
+    ON outbox_events (aggregate_id, seq)
+    WHERE published_at IS NULL AND parked_at IS NULL;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_payments_merchant_created_at_id;
+DROP INDEX IF EXISTS idx_payments_merchant_idempotency_key;
+-- fails while two merchants share an idempotency key
+CREATE UNIQUE INDEX idx_payments_idempotency_key
+    ON payments (idempotency_key);
+ALTER TABLE payments DROP COLUMN IF EXISTS merchant_id;
@@ -0,0 +1,13 @@
+-- the merchant whose API key created the payment, empty for platform callers
+-- and for payments created before keys had merchants
+ALTER TABLE payments
+    ADD COLUMN merchant_id VARCHAR(255) NOT NULL DEFAULT '';
+
+-- idempotency keys are chosen by each merchant, two may pick the same one
+DROP INDEX IF EXISTS idx_payments_idempotency_key;
+CREATE UNIQUE INDEX idx_payments_merchant_idempotency_key
+    ON payments (merchant_id, idempotency_key);
+
+-- a merchant's listing, newest first, pages on (created_at, id)
+CREATE INDEX idx_payments_merchant_created_at_id
+    ON payments (merchant_id, created_at, id);
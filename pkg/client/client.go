@@ -0,0 +1,205 @@
+// Package client is the Go client of the gopay payments API. It sets
+// idempotency keys, retries what is safe to retry and turns error responses
+// into *Error values that match the Err* sentinels with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is used when Config.MaxRetries is zero
+	DefaultMaxRetries = 3
+	// DefaultTimeout bounds one attempt of a client built without HTTPClient
+	DefaultTimeout = 10 * time.Second
+
+	// backoff doubles from retryBaseDelay and caps at retryMaxDelay, a
+	// Retry-After beyond retryMaxWait is not worth waiting for
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+	retryMaxWait   = 30 * time.Second
+
+	userAgent = "gopay-go-client"
+)
+
+type Config struct {
+	// BaseURL is the service root, e.g. https://payments.internal
+	BaseURL string
+	// APIKey is sent as a bearer token, empty sends none
+	APIKey string
+	// HTTPClient carries the requests, e.g. with instrumentation or mTLS
+	HTTPClient *http.Client
+	// MaxRetries counts retries after the first attempt, negative disables
+	// them
+	MaxRetries int
+}
+
+// Client calls the payments API, it is safe for concurrent use
+type Client struct {
+	base       string
+	apiKey     string
+	http       *http.Client
+	maxRetries int
+}
+
+func New(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(cfg.BaseURL, "/"))
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("invalid gopay base url %q", cfg.BaseURL)
+	}
+
+	c := &Client{
+		base:       base.String(),
+		apiKey:     cfg.APIKey,
+		http:       cfg.HTTPClient,
+		maxRetries: cfg.MaxRetries,
+	}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: DefaultTimeout}
+	}
+	switch {
+	case c.maxRetries == 0:
+		c.maxRetries = DefaultMaxRetries
+	case c.maxRetries < 0:
+		c.maxRetries = 0
+	}
+	return c, nil
+}
+
+// request is one API call, sent again as is on a retry
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   any
+	// retry is set for calls the server handles idempotently, reads and
+	// writes under an Idempotency-Key
+	retry bool
+}
+
+// do sends req until it succeeds, fails for good or runs out of retries and
+// decodes a successful response into out
+func (c *Client) do(ctx context.Context, req request, out any) (*http.Response, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, fmt.Errorf("encode gopay request: %w", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req, body, out)
+		if err == nil || !req.retry || attempt >= c.maxRetries || ctx.Err() != nil {
+			return resp, err
+		}
+		wait, ok := retryDelay(err, attempt)
+		if !ok {
+			return resp, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request, body []byte, out any) (*http.Response, error) {
+	target := c.base + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, reader)
+	if err != nil {
+		return nil, fmt.Errorf("build gopay request: %w", err)
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("User-Agent", userAgent)
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("gopay %s %s: %w", req.method, req.path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return resp, fmt.Errorf("read gopay response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return resp, newError(resp, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp, fmt.Errorf("decode gopay response: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+// retryDelay reports whether err is worth another attempt and how long to
+// wait first. Transport errors, rate limits, server errors and conflicts the
+// server marked with Retry-After are retried.
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		return backoff(attempt), true
+	}
+	if !apiErr.Temporary() {
+		return 0, false
+	}
+	if apiErr.RetryAfter > retryMaxWait {
+		return 0, false
+	}
+	return max(apiErr.RetryAfter, backoff(attempt)), true
+}
+
+// backoff picks a random delay between half and all of the doubled base so
+// clients failing together do not retry together
+func backoff(attempt int) time.Duration {
+	d := min(retryBaseDelay<<attempt, retryMaxDelay)
+	return d/2 + rand.N(d/2+1)
+}
+
+// parseRetryAfter reads delay seconds or an HTTP date, 0 when absent
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
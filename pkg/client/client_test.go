@@ -0,0 +1,266 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ademajagon/gopay-service/internal/adapters/httpserver"
+	"github.com/ademajagon/gopay-service/internal/adapters/memory"
+	"github.com/ademajagon/gopay-service/internal/adapters/mockprovider"
+	"github.com/ademajagon/gopay-service/internal/app"
+	"github.com/ademajagon/gopay-service/pkg/client"
+)
+
+// newAPI serves the real handler on the memory adapters, wrap sits in front
+// of it to break things on the way
+func newAPI(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+	log := slog.New(slog.DiscardHandler)
+	kv := memory.NewKeyValueStore()
+	svc := app.NewPaymentService(memory.NewRepository(), kv, time.Hour, kv, mockprovider.New(time.Second),
+		app.RequestLimits{Currencies: []string{"EUR", "USD"}, MaxAmountCents: 100000}, nil, log)
+	server := httpserver.NewServer(httpserver.ServerConfig{Metrics: httpserver.NewMetrics(prometheus.NewRegistry())},
+		httpserver.NewHandler(svc, httpserver.AdminServices{}, nil, 0, log), nil, log)
+
+	h := server.Handler()
+	if wrap != nil {
+		h = wrap(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newClient(t *testing.T, srv *httptest.Server) *client.Client {
+	t.Helper()
+	c, err := client.New(client.Config{BaseURL: srv.URL, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func payment(orderID string) client.InitiatePaymentRequest {
+	return client.InitiatePaymentRequest{
+		OrderID:     orderID,
+		CustomerID:  "cus-1",
+		AmountCents: 1999,
+		Currency:    "EUR",
+	}
+}
+
+func TestPaymentLifecycle(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, newAPI(t, nil))
+
+	created, err := c.InitiatePayment(ctx, payment("order-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.PaymentID == "" || created.IdempotencyKey == "" || created.Replayed {
+		t.Fatalf("created = %+v", created)
+	}
+
+	req := payment("order-1")
+	req.IdempotencyKey = created.IdempotencyKey
+	again, err := c.InitiatePayment(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.PaymentID != created.PaymentID || !again.Replayed {
+		t.Fatalf("retry = %+v, want a replay of %s", again, created.PaymentID)
+	}
+
+	got, err := c.GetPayment(ctx, created.PaymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.OrderID != "order-1" || got.AmountCents != 1999 || got.Status != created.Status {
+		t.Fatalf("payment = %+v", got)
+	}
+
+	manual := payment("order-2")
+	manual.CaptureMethod = "manual"
+	held, err := c.InitiatePayment(ctx, manual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := c.ListPayments(ctx, client.ListPaymentsRequest{CustomerID: "cus-1", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, p := range page.Payments {
+		listed = append(listed, p.PaymentID)
+	}
+	if !slices.Equal(listed, []string{held.PaymentID, created.PaymentID}) {
+		t.Fatalf("listed %v, want newest first", listed)
+	}
+
+	captured, err := c.CapturePayment(ctx, held.PaymentID, client.CapturePaymentRequest{IdempotencyKey: "cap-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if captured.Status != "PROCESSING" {
+		t.Fatalf("captured = %+v", captured)
+	}
+	if _, err := c.CapturePayment(ctx, held.PaymentID, client.CapturePaymentRequest{}); !errors.Is(err, client.ErrConflict) {
+		t.Fatalf("capture under another key: err = %v, want ErrConflict", err)
+	}
+
+	cancelled, err := c.CancelPayment(ctx, created.PaymentID, client.CancelPaymentRequest{Reason: "customer_request"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != "CANCELLED" {
+		t.Fatalf("cancelled = %+v", cancelled)
+	}
+	if _, err := c.CancelPayment(ctx, created.PaymentID, client.CancelPaymentRequest{}); !errors.Is(err, client.ErrUnprocessable) {
+		t.Fatalf("second cancel: err = %v, want ErrUnprocessable", err)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, newAPI(t, nil))
+
+	_, err := c.InitiatePayment(ctx, client.InitiatePaymentRequest{CustomerID: "cus-1", AmountCents: -5, Currency: "EUR"})
+	var apiErr *client.Error
+	if !errors.Is(err, client.ErrValidation) || !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want ErrValidation", err)
+	}
+	var fields []string
+	for _, f := range apiErr.Fields {
+		fields = append(fields, f.Field)
+	}
+	if !slices.Contains(fields, "order_id") || !slices.Contains(fields, "amount_cents") || apiErr.RequestID == "" {
+		t.Fatalf("error = %+v, want order_id and amount_cents with the request id", apiErr)
+	}
+
+	if _, err := c.GetPayment(ctx, "0b7f8a43-0a0e-4a8e-9a43-6b1c1d9f0e11"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
+
+// loseResponses lets the first n requests reach the handler and answers them
+// with a 502, as a proxy that lost the upstream response would
+func loseResponses(n int, keys *[]string) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			*keys = append(*keys, r.Header.Get("Idempotency-Key"))
+			lose := len(*keys) <= n
+			mu.Unlock()
+
+			if !lose {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(httptest.NewRecorder(), r)
+			w.WriteHeader(http.StatusBadGateway)
+		})
+	}
+}
+
+// a retried create reuses its key, the payment exists once
+func TestRetriesReuseTheIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	var keys []string
+	c := newClient(t, newAPI(t, loseResponses(2, &keys)))
+
+	created, err := c.InitiatePayment(ctx, payment("order-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[0] != created.IdempotencyKey || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Fatalf("sent keys %v, want %s three times", keys, created.IdempotencyKey)
+	}
+	if !created.Replayed {
+		t.Fatal("the last attempt created the payment again instead of replaying it")
+	}
+
+	page, err := c.ListPayments(ctx, client.ListPaymentsRequest{CustomerID: "cus-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Payments) != 1 {
+		t.Fatalf("%d payments, want 1", len(page.Payments))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts []time.Time
+	)
+	limited := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			attempts = append(attempts, time.Now())
+			first := len(attempts) == 1
+			mu.Unlock()
+			if first {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	c := newClient(t, newAPI(t, limited))
+
+	if _, err := c.InitiatePayment(context.Background(), payment("order-1")); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != 2 || attempts[1].Sub(attempts[0]) < time.Second {
+		t.Fatalf("%d attempts, want the second one at least a second after the first", len(attempts))
+	}
+}
+
+func TestCancellationStopsRetries(t *testing.T) {
+	down := func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+	}
+	srv := newAPI(t, down)
+	c, err := client.New(client.Config{BaseURL: srv.URL, HTTPClient: srv.Client(), MaxRetries: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err = c.GetPayment(ctx, "0b7f8a43-0a0e-4a8e-9a43-6b1c1d9f0e11")
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, client.ErrUnavailable) {
+		t.Fatalf("err = %v, want the last failure joined with the deadline", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("gave up after %s, want at the deadline", elapsed)
+	}
+}
+
+// writes without an idempotency key are never sent twice
+func TestCancelIsNotRetried(t *testing.T) {
+	var keys []string
+	srv := newAPI(t, loseResponses(1, &keys))
+	c := newClient(t, srv)
+
+	_, err := c.CancelPayment(context.Background(), "0b7f8a43-0a0e-4a8e-9a43-6b1c1d9f0e11", client.CancelPaymentRequest{})
+	if !errors.Is(err, client.ErrUnavailable) || len(keys) != 1 {
+		t.Fatalf("err = %v after %d attempts, want one unavailable attempt", err, len(keys))
+	}
+}
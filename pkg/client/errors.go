@@ -0,0 +1,122 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sentinels matched by *Error through errors.Is
+var (
+	// ErrValidation is a rejected request, Error.Fields names the fields
+	ErrValidation = errors.New("gopay: validation failed")
+	// ErrUnauthorized is a missing, unknown or insufficient API key
+	ErrUnauthorized = errors.New("gopay: unauthorized")
+	ErrNotFound     = errors.New("gopay: not found")
+	// ErrConflict is a concurrent change, a request with the same
+	// idempotency key in flight or a payment captured under another key
+	ErrConflict = errors.New("gopay: conflict")
+	// ErrPreconditionFailed is a write whose expected version is no longer
+	// current, Error.CurrentVersion holds the stored one
+	ErrPreconditionFailed = errors.New("gopay: precondition failed")
+	// ErrUnprocessable is a well-formed request the payment's state, the
+	// provider or an earlier use of the idempotency key rules out
+	ErrUnprocessable = errors.New("gopay: unprocessable")
+	ErrRateLimited   = errors.New("gopay: rate limited")
+	// ErrUnavailable is a server side failure, including timeouts
+	ErrUnavailable = errors.New("gopay: unavailable")
+)
+
+// Error is an error response of the API
+type Error struct {
+	Status int
+	// Code is the machine-readable error, e.g. VALIDATION_ERROR
+	Code    string
+	Message string
+	// RequestID identifies the request to the service's operators
+	RequestID string
+	// Fields lists every rejected field of a validation error
+	Fields []FieldError
+	// CurrentVersion is set on ErrPreconditionFailed
+	CurrentVersion int
+	// RetryAfter is the wait the server asked for, 0 when it gave none
+	RetryAfter time.Duration
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("gopay %d", e.Status)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	for _, f := range e.Fields {
+		msg += fmt.Sprintf("; %s %s", f.Field, f.Message)
+	}
+	return msg
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrValidation:
+		return e.Status == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrConflict:
+		return e.Status == http.StatusConflict
+	case ErrPreconditionFailed:
+		return e.Status == http.StatusPreconditionFailed
+	case ErrUnprocessable:
+		return e.Status == http.StatusUnprocessableEntity
+	case ErrRateLimited:
+		return e.Status == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.Status >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// Temporary reports whether sending the same request again may succeed. A
+// conflict is only worth retrying when the server said when to.
+func (e *Error) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests ||
+		e.Status >= http.StatusInternalServerError ||
+		(e.Status == http.StatusConflict && e.RetryAfter > 0)
+}
+
+// newError decodes the error body, a proxy in front of the service may
+// answer with something other than JSON
+func newError(resp *http.Response, body []byte) *Error {
+	var envelope struct {
+		Error          string       `json:"error"`
+		Code           string       `json:"code"`
+		RequestID      string       `json:"request_id"`
+		Fields         []FieldError `json:"fields"`
+		CurrentVersion int          `json:"current_version"`
+	}
+	e := &Error{
+		Status:     resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		e.Message = strings.TrimSpace(string(body))
+		return e
+	}
+	e.Code = envelope.Code
+	e.Message = envelope.Error
+	e.RequestID = envelope.RequestID
+	e.Fields = envelope.Fields
+	e.CurrentVersion = envelope.CurrentVersion
+	return e
+}
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	headerIdempotencyKey      = "Idempotency-Key"
+	headerIdempotencyReplayed = "Idempotency-Replayed"
+	headerCorrelationID       = "X-Correlation-Id"
+)
+
+type InitiatePaymentRequest struct {
+	OrderID     string `json:"order_id"`
+	CustomerID  string `json:"customer_id"`
+	AmountCents int64  `json:"amount_cents"`
+	// Currency is an ISO 4217 code, e.g. EUR
+	Currency string `json:"currency"`
+	// IdempotencyKey identifies the payment across retries, including the
+	// caller's own. Empty generates one per call.
+	IdempotencyKey  string `json:"-"`
+	ClientReference string `json:"client_reference,omitempty"`
+	// CaptureMethod is automatic by default, manual waits for CapturePayment
+	CaptureMethod string            `json:"capture_method,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	// CorrelationID ties the payment to the caller's own flow, empty lets the
+	// service pick one
+	CorrelationID string `json:"-"`
+}
+
+type InitiatePaymentResponse struct {
+	PaymentID     string `json:"payment_id"`
+	Status        string `json:"status"`
+	CorrelationID string `json:"correlation_id"`
+	// IdempotencyKey is the key the payment was created under
+	IdempotencyKey string `json:"-"`
+	// Replayed is set when the key had already created the payment
+	Replayed bool `json:"-"`
+}
+
+type Payment struct {
+	PaymentID       string            `json:"payment_id"`
+	OrderID         string            `json:"order_id"`
+	CustomerID      string            `json:"customer_id"`
+	AmountCents     int64             `json:"amount_cents"`
+	Currency        string            `json:"currency"`
+	Status          string            `json:"status"`
+	ProviderRef     string            `json:"provider_ref"`
+	FailureReason   string            `json:"failure_reason"`
+	ClientReference string            `json:"client_reference,omitempty"`
+	CorrelationID   string            `json:"correlation_id"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	// Version grows with every change, pass it as ExpectedVersion to make
+	// a capture or cancel conditional on it
+	Version int `json:"version"`
+}
+
+// ListPaymentsRequest filters the listing, zero values filter nothing
+type ListPaymentsRequest struct {
+	CustomerID      string
+	Status          string
+	Currency        string
+	ClientReference string
+	CreatedAfter    time.Time
+	CreatedBefore   time.Time
+	Limit           int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+}
+
+type PaymentPage struct {
+	Payments   []Payment `json:"payments"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	HasMore    bool      `json:"has_more"`
+}
+
+type CapturePaymentRequest struct {
+	// IdempotencyKey makes a retried capture replay the first one. Empty
+	// generates one per call.
+	IdempotencyKey string `json:"-"`
+	// ExpectedVersion fails the capture with ErrPreconditionFailed once the
+	// payment moved past it, 0 captures whatever version is stored
+	ExpectedVersion int `json:"expected_version,omitempty"`
+}
+
+type CancelPaymentRequest struct {
+	// Reason is recorded on the payment, empty records the default
+	Reason string `json:"reason,omitempty"`
+	// ExpectedVersion works as in CapturePaymentRequest
+	ExpectedVersion int `json:"expected_version,omitempty"`
+}
+
+// PaymentResult is the state a capture or cancel left the payment in
+type PaymentResult struct {
+	PaymentID string `json:"payment_id"`
+	Status    string `json:"status"`
+}
+
+// InitiatePayment creates a payment. Retries reuse the idempotency key, so
+// the payment is created once however many attempts reach the service.
+func (c *Client) InitiatePayment(ctx context.Context, req InitiatePaymentRequest) (InitiatePaymentResponse, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	header := http.Header{}
+	header.Set(headerIdempotencyKey, key)
+	if req.CorrelationID != "" {
+		header.Set(headerCorrelationID, req.CorrelationID)
+	}
+
+	var out InitiatePaymentResponse
+	resp, err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/v1/payments",
+		header: header,
+		body:   req,
+		retry:  true,
+	}, &out)
+	if err != nil {
+		return InitiatePaymentResponse{}, err
+	}
+	out.IdempotencyKey = key
+	out.Replayed = resp.Header.Get(headerIdempotencyReplayed) == "true"
+	return out, nil
+}
+
+func (c *Client) GetPayment(ctx context.Context, paymentID string) (Payment, error) {
+	var out Payment
+	_, err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/v1/payments/" + url.PathEscape(paymentID),
+		retry:  true,
+	}, &out)
+	return out, err
+}
+
+// ListPayments returns one page, newest first
+func (c *Client) ListPayments(ctx context.Context, req ListPaymentsRequest) (PaymentPage, error) {
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"customer_id":      req.CustomerID,
+		"status":           req.Status,
+		"currency":         req.Currency,
+		"client_reference": req.ClientReference,
+		"cursor":           req.Cursor,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if !req.CreatedAfter.IsZero() {
+		query.Set("created_after", req.CreatedAfter.Format(time.RFC3339))
+	}
+	if !req.CreatedBefore.IsZero() {
+		query.Set("created_before", req.CreatedBefore.Format(time.RFC3339))
+	}
+	if req.Limit > 0 {
+		query.Set("limit", strconv.Itoa(req.Limit))
+	}
+
+	var out PaymentPage
+	_, err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/v1/payments",
+		query:  query,
+		retry:  true,
+	}, &out)
+	return out, err
+}
+
+// CapturePayment takes the funds of a payment initiated with the manual
+// capture method
+func (c *Client) CapturePayment(ctx context.Context, paymentID string, req CapturePaymentRequest) (PaymentResult, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	header := http.Header{}
+	header.Set(headerIdempotencyKey, key)
+
+	var out PaymentResult
+	_, err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/v1/payments/" + url.PathEscape(paymentID) + "/capture",
+		header: header,
+		body:   req,
+		retry:  true,
+	}, &out)
+	return out, err
+}
+
+// CancelPayment abandons a payment that has not been captured. Cancel takes
+// no idempotency key, so it is not retried, a retry after a lost response
+// would fail as an invalid transition.
+func (c *Client) CancelPayment(ctx context.Context, paymentID string, req CancelPaymentRequest) (PaymentResult, error) {
+	var out PaymentResult
+	_, err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/v1/payments/" + url.PathEscape(paymentID) + "/cancel",
+		body:   req,
+	}, &out)
+	return out, err
+}